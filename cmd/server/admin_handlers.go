@@ -32,20 +32,26 @@ type adminUserPayload struct {
 }
 
 type adminUserResponse struct {
-	ID          int64  `json:"id"`
-	Username    string `json:"username"`
-	Role        string `json:"role"`
-	Protected   bool   `json:"protected"`
-	ContactName string `json:"contactName"`
-	Phone       string `json:"phone"`
-	Email       string `json:"email"`
-	CreatedAt   string `json:"createdAt"`
-	UpdatedAt   string `json:"updatedAt"`
+	ID          int64   `json:"id"`
+	Username    string  `json:"username"`
+	Role        string  `json:"role"`
+	Protected   bool    `json:"protected"`
+	ContactName string  `json:"contactName"`
+	Phone       string  `json:"phone"`
+	Email       string  `json:"email"`
+	Balance     int64   `json:"balanceCents"`
+	GroupIDs    []int64 `json:"groupIds"`
+	CreatedAt   string  `json:"createdAt"`
+	UpdatedAt   string  `json:"updatedAt"`
 }
 
 type settingsPayload struct {
-	RetentionDays *int64 `json:"retentionDays"`
-	SaveHistory   *bool  `json:"saveHistory"`
+	RetentionDays    *int64  `json:"retentionDays"`
+	SaveHistory      *bool   `json:"saveHistory"`
+	HoldTimeoutHours *int64  `json:"holdTimeoutHours"`
+	BillingEnabled   *bool   `json:"billingEnabled"`
+	PrinterRegistry  *string `json:"printerRegistry"`
+	QuotaPeriod      *string `json:"quotaPeriod"`
 }
 
 func adminListUsersHandler(w http.ResponseWriter, r *http.Request) {
@@ -55,7 +61,16 @@ func adminListUsersHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return err
 		}
+		memberships, err := store.ListGroupMemberships(r.Context(), tx)
+		if err != nil {
+			return err
+		}
 		resp = mapAdminUsers(users)
+		for i := range resp {
+			if ids, ok := memberships[resp[i].ID]; ok {
+				resp[i].GroupIDs = ids
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -233,6 +248,10 @@ func adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 func adminGetSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	var retention int64
 	var saveHistory int64
+	var holdTimeout int64
+	var billing int64
+	var registry string
+	var quotaPeriodSetting string
 	err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
 		val, err := store.GetSettingInt(r.Context(), tx, store.SettingRetentionDays, 0)
 		if err != nil {
@@ -244,6 +263,26 @@ func adminGetSettingsHandler(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 		saveHistory = sh
+		ht, err := store.GetSettingInt(r.Context(), tx, store.SettingHoldTimeoutHours, 24)
+		if err != nil {
+			return err
+		}
+		holdTimeout = ht
+		bl, err := store.GetSettingInt(r.Context(), tx, store.SettingBillingEnabled, 0)
+		if err != nil {
+			return err
+		}
+		billing = bl
+		pr, err := store.GetSettingString(r.Context(), tx, store.SettingPrinterRegistry, store.PrinterRegistryAuto)
+		if err != nil {
+			return err
+		}
+		registry = normalizePrinterRegistryMode(pr)
+		qp, err := store.GetSettingString(r.Context(), tx, store.SettingQuotaPeriod, "")
+		if err != nil {
+			return err
+		}
+		quotaPeriodSetting = normalizeQuotaPeriod(qp)
 		return nil
 	})
 	if err != nil {
@@ -251,8 +290,12 @@ func adminGetSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	writeJSON(w, map[string]interface{}{
-		"retentionDays": retention,
-		"saveHistory":   saveHistory != 0,
+		"retentionDays":    retention,
+		"saveHistory":      saveHistory != 0,
+		"holdTimeoutHours": holdTimeout,
+		"billingEnabled":   billing != 0,
+		"printerRegistry":  registry,
+		"quotaPeriod":      quotaPeriodSetting,
 	})
 }
 
@@ -280,6 +323,39 @@ func adminUpdateSettingsHandler(w http.ResponseWriter, r *http.Request) {
 				return err
 			}
 		}
+		if payload.HoldTimeoutHours != nil {
+			if *payload.HoldTimeoutHours < 0 {
+				return errors.New("invalid holdTimeoutHours")
+			}
+			if err := store.SetSettingInt(r.Context(), tx, store.SettingHoldTimeoutHours, *payload.HoldTimeoutHours); err != nil {
+				return err
+			}
+		}
+		if payload.BillingEnabled != nil {
+			var v int64
+			if *payload.BillingEnabled {
+				v = 1
+			}
+			if err := store.SetSettingInt(r.Context(), tx, store.SettingBillingEnabled, v); err != nil {
+				return err
+			}
+		}
+		if payload.PrinterRegistry != nil {
+			if normalizePrinterRegistryMode(*payload.PrinterRegistry) != *payload.PrinterRegistry {
+				return errors.New("invalid printerRegistry")
+			}
+			if err := store.SetSettingString(r.Context(), tx, store.SettingPrinterRegistry, *payload.PrinterRegistry); err != nil {
+				return err
+			}
+		}
+		if payload.QuotaPeriod != nil {
+			if *payload.QuotaPeriod != "" && normalizeQuotaPeriod(*payload.QuotaPeriod) == "" {
+				return errors.New("invalid quotaPeriod")
+			}
+			if err := store.SetSettingString(r.Context(), tx, store.SettingQuotaPeriod, *payload.QuotaPeriod); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -334,6 +410,8 @@ func mapAdminUser(user store.User) adminUserResponse {
 		ContactName: user.ContactName,
 		Phone:       user.Phone,
 		Email:       user.Email,
+		Balance:     user.BalanceCents,
+		GroupIDs:    []int64{},
 		CreatedAt:   user.CreatedAt,
 		UpdatedAt:   user.UpdatedAt,
 	}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// ── 按页计费 ──────────────────────────────────────────────────────────────────
+//
+// 管理员在设置里开启计费后，打印与重打在提交到 CUPS 之前按价目表计算费用并从用户余额
+// 扣款（与打印记录在同一事务里写入），余额不足直接拒绝（402）。作业被取消、中止或提交
+// 失败时由 store 在状态更新里自动按流水退款；定时打印改份数时多退少补。
+//
+// 费用 = 单价 × 面数，面数 = ⌈实际打印页数 / N-up⌉ × 份数，实际打印页数已扣除
+// page-ranges 与奇偶页筛选。单价按纸张尺寸、纸张类型、彩色/黑白、单/双面匹配价目表，
+// 没有匹配规则的组合不收费。
+
+// billingEnabled 读取计费开关，读取失败时按关闭处理。
+func billingEnabled(ctx context.Context) bool {
+	enabled := false
+	_ = appStore.WithTx(ctx, true, func(tx *sql.Tx) error {
+		v, err := store.GetSettingInt(ctx, tx, store.SettingBillingEnabled, 0)
+		if err != nil {
+			return err
+		}
+		enabled = v != 0
+		return nil
+	})
+	return enabled
+}
+
+// billableImpressions 返回本次打印的计费面数。
+func billableImpressions(opts ipp.PrintJobOptions) int64 {
+	pages := int64(ipp.SelectedPages(opts, opts.Pages))
+	nup := int64(max(opts.NumberUp, 1))
+	copies := int64(max(opts.Copies, 1))
+	return (pages + nup - 1) / nup * copies
+}
+
+// quotePrint 按当前价目表计算 opts 的费用（分）。
+func quotePrint(ctx context.Context, tx *sql.Tx, opts ipp.PrintJobOptions) (int64, error) {
+	prices, err := store.ListPrintPrices(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	unit, _ := store.LookupPrintPrice(prices, opts.PaperSize, opts.PaperType, opts.IsColor, opts.IsDuplex)
+	return unit * billableImpressions(opts), nil
+}
+
+// chargePrintRecord 为刚插入的打印记录计费扣款，返回费用与扣款后的余额。
+// 余额不足时返回 402 的 printDocumentError，调用方回滚事务即可连同记录一起撤销。
+func chargePrintRecord(ctx context.Context, tx *sql.Tx, userID, recordID int64, opts ipp.PrintJobOptions) (cost, balance int64, err error) {
+	if cost, err = quotePrint(ctx, tx, opts); err != nil {
+		return 0, 0, err
+	}
+	err = store.ChargePrint(ctx, tx, userID, recordID, cost)
+	if errors.Is(err, store.ErrInsufficientBalance) {
+		user, _ := store.GetUserByID(ctx, tx, userID)
+		return 0, 0, &printDocumentError{http.StatusPaymentRequired, fmt.Sprintf(
+			"insufficient balance: this job costs %s, balance is %s", formatCents(cost), formatCents(user.BalanceCents))}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	user, err := store.GetUserByID(ctx, tx, userID)
+	return cost, user.BalanceCents, err
+}
+
+// printRecordError 把建记录事务的错误转换成返回给前端的错误：计费拒绝原样返回，其余为 500。
+func printRecordError(err error) error {
+	var de *printDocumentError
+	if errors.As(err, &de) {
+		return de
+	}
+	return &printDocumentError{http.StatusInternalServerError, "failed to create print record"}
+}
+
+func formatCents(c int64) string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+type printPricePayload struct {
+	PaperSize  string `json:"paperSize"`
+	PaperType  string `json:"paperType"`
+	IsColor    bool   `json:"isColor"`
+	IsDuplex   bool   `json:"isDuplex"`
+	PriceCents int64  `json:"priceCents"`
+}
+
+type ledgerEntryResponse struct {
+	ID           int64  `json:"id"`
+	Kind         string `json:"kind"`
+	AmountCents  int64  `json:"amountCents"`
+	BalanceAfter int64  `json:"balanceAfterCents"`
+	PrintJobID   int64  `json:"printJobId,omitempty"`
+	OperatorID   int64  `json:"operatorId,omitempty"`
+	Note         string `json:"note"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+func mapLedgerEntries(entries []store.LedgerEntry) []ledgerEntryResponse {
+	resp := make([]ledgerEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		resp = append(resp, ledgerEntryResponse{
+			ID:           e.ID,
+			Kind:         e.Kind,
+			AmountCents:  e.AmountCents,
+			BalanceAfter: e.BalanceAfter,
+			PrintJobID:   e.PrintJobID.Int64,
+			OperatorID:   e.OperatorID.Int64,
+			Note:         e.Note,
+			CreatedAt:    e.CreatedAt,
+		})
+	}
+	return resp
+}
+
+// maxLedgerEntries 是流水接口单次返回的最大条数。
+const maxLedgerEntries = 200
+
+func ledgerLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxLedgerEntries {
+		return maxLedgerEntries
+	}
+	return limit
+}
+
+// GET /api/me/ledger — 当前用户的余额与最近流水。
+func myLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var balance int64
+	var entries []store.LedgerEntry
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		user, err := store.GetUserByID(r.Context(), tx, sess.UserID)
+		if err != nil {
+			return err
+		}
+		balance = user.BalanceCents
+		entries, err = store.ListLedger(r.Context(), tx, sess.UserID, ledgerLimit(r))
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load ledger")
+		return
+	}
+	writeJSON(w, map[string]any{"balanceCents": balance, "entries": mapLedgerEntries(entries)})
+}
+
+// GET /api/admin/prices — 价目表。
+func adminListPricesHandler(w http.ResponseWriter, r *http.Request) {
+	var prices []store.PrintPrice
+	err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		prices, err = store.ListPrintPrices(r.Context(), tx)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load prices")
+		return
+	}
+	resp := make([]printPricePayload, 0, len(prices))
+	for _, p := range prices {
+		resp = append(resp, printPricePayload{
+			PaperSize:  p.PaperSize,
+			PaperType:  p.PaperType,
+			IsColor:    p.IsColor,
+			IsDuplex:   p.IsDuplex,
+			PriceCents: p.PriceCents,
+		})
+	}
+	writeJSON(w, resp)
+}
+
+// PUT /api/admin/prices — 整体替换价目表。
+func adminUpdatePricesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload []printPricePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	prices := make([]store.PrintPrice, 0, len(payload))
+	seen := map[store.PrintPrice]bool{}
+	for _, p := range payload {
+		if p.PriceCents < 0 {
+			writeJSONError(w, http.StatusBadRequest, "price must not be negative")
+			return
+		}
+		price := store.PrintPrice{
+			PaperSize: strings.TrimSpace(p.PaperSize),
+			PaperType: strings.TrimSpace(p.PaperType),
+			IsColor:   p.IsColor,
+			IsDuplex:  p.IsDuplex,
+		}
+		if seen[price] {
+			writeJSONError(w, http.StatusBadRequest, "duplicate price rule")
+			return
+		}
+		seen[price] = true
+		price.PriceCents = p.PriceCents
+		prices = append(prices, price)
+	}
+	err := appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		return store.ReplacePrintPrices(r.Context(), tx, prices)
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to save prices")
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+type balanceAdjustPayload struct {
+	AmountCents int64  `json:"amountCents"`
+	Note        string `json:"note"`
+}
+
+// POST /api/admin/users/{id}/balance — 充值（amountCents 为负表示扣减，不能扣成负数）。
+func adminAdjustBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var payload balanceAdjustPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.AmountCents == 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	sess, _ := auth.GetSession(r)
+	var balance int64
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		var err error
+		balance, err = store.AdjustBalance(r.Context(), tx, id, payload.AmountCents, store.LedgerTopUp, 0, sess.UserID, strings.TrimSpace(payload.Note))
+		return err
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "user not found")
+	case errors.Is(err, store.ErrInsufficientBalance):
+		writeJSONError(w, http.StatusBadRequest, "balance cannot go negative")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to adjust balance")
+	default:
+		writeJSON(w, map[string]any{"ok": true, "balanceCents": balance})
+	}
+}
+
+// GET /api/admin/users/{id}/ledger — 指定用户的余额流水。
+func adminUserLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var entries []store.LedgerEntry
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		entries, err = store.ListLedger(r.Context(), tx, id, ledgerLimit(r))
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load ledger")
+		return
+	}
+	writeJSON(w, mapLedgerEntries(entries))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"cups-web/internal/ipp"
+)
+
+func TestBillableImpressions(t *testing.T) {
+	tests := []struct {
+		opts ipp.PrintJobOptions
+		want int64
+	}{
+		{ipp.PrintJobOptions{Pages: 5}, 5},
+		{ipp.PrintJobOptions{Pages: 5, Copies: 3}, 15},
+		{ipp.PrintJobOptions{Pages: 5, NumberUp: 2, Copies: 2}, 6}, // ⌈5/2⌉ × 2
+		{ipp.PrintJobOptions{Pages: 10, PageRange: "1-4", NumberUp: 4}, 1},
+		{ipp.PrintJobOptions{Pages: 9, PageSet: "odd"}, 5},
+		{ipp.PrintJobOptions{Pages: 0, Copies: 2}, 0},
+	}
+	for _, tt := range tests {
+		if got := billableImpressions(tt.opts); got != tt.want {
+			t.Errorf("billableImpressions(%+v) = %d, want %d", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCents(t *testing.T) {
+	for in, want := range map[int64]string{0: "0.00", 5: "0.05", 1234: "12.34", -250: "-2.50"} {
+		if got := formatCents(in); got != want {
+			t.Errorf("formatCents(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── 转换结果缓存 ───────────────────────────────────────────────────────────────
+//
+// 同一个 Office / OFD 文件通常要转换三次：/api/estimate 数页数、/api/convert 预览、
+// /api/print 打印，每次都是一整轮 LibreOffice 或 Java 进程。这里按内容寻址缓存
+// 转换结果：key = sha256(转换器 + 选项 + 输入文件内容)，值是磁盘上的 PDF。
+// 总大小超过上限时按最近使用时间（LRU）淘汰，重启后从目录 mtime 恢复顺序。
+//
+// 只缓存外部进程转换；图片、文本在进程内生成 PDF，开销远小于算一次哈希后的拷贝。
+
+const defaultConvertCacheMB = 512
+
+type convertCacheEntry struct {
+	key  string
+	size int64
+	refs int // 正在被取出（链接或拷贝）的次数，大于 0 时不淘汰
+}
+
+type convertCache struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	size     int64
+	lru      *list.List // 队头是最近使用的 *convertCacheEntry
+	entries  map[string]*list.Element
+	inflight map[string]chan struct{} // 同一 key 正在转换时，后来者等它完成
+}
+
+var (
+	convertCacheOnce sync.Once
+	convertCacheInst *convertCache
+)
+
+// sharedConvertCache 返回进程内共享的缓存，CONVERT_CACHE_MB=0 时返回 nil（不缓存）。
+func sharedConvertCache() *convertCache {
+	convertCacheOnce.Do(func() {
+		mb := defaultConvertCacheMB
+		if v := strings.TrimSpace(os.Getenv("CONVERT_CACHE_MB")); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Printf("invalid CONVERT_CACHE_MB %q, using %d", v, defaultConvertCacheMB)
+			} else {
+				mb = n
+			}
+		}
+		if mb == 0 {
+			return
+		}
+		dir := strings.TrimSpace(os.Getenv("CONVERT_CACHE_DIR"))
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "cups-web-convert-cache")
+		}
+		c, err := newConvertCache(dir, int64(mb)<<20)
+		if err != nil {
+			log.Printf("[convert-cache] disabled: %v", err)
+			return
+		}
+		convertCacheInst = c
+	})
+	return convertCacheInst
+}
+
+// newConvertCache 打开（必要时创建）缓存目录，并按文件 mtime 重建 LRU 索引。
+func newConvertCache(dir string, maxBytes int64) (*convertCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &convertCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]chan struct{}{},
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		key   string
+		size  int64
+		mtime time.Time
+	}
+	var files []found
+	for _, e := range dirEntries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// 上次写到一半就退出留下的残片。
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(name, ".pdf") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, found{strings.TrimSuffix(name, ".pdf"), info.Size(), info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b found) int { return a.mtime.Compare(b.mtime) })
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushFront(&convertCacheEntry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *convertCache) path(key string) string {
+	return filepath.Join(c.dir, key+".pdf")
+}
+
+// convertCacheKey 计算 sha256(converter \x00 输入内容)，converter 里应带上影响输出的选项。
+func convertCacheKey(converter string, inputPath string) (string, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	io.WriteString(h, converter)
+	h.Write([]byte{0})
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachedConvert 是转换函数的缓存包装，返回值约定与转换函数相同：PDF 路径、清理函数、错误。
+// 命中时把缓存文件链接（跨文件系统则拷贝）到新的临时目录，调用方照常 cleanup，
+// 之后被淘汰也不影响已经拿到的文件。c 为 nil 或算不出哈希时直接转换。
+func (c *convertCache) convert(ctx context.Context, converter string, inputPath string, fn func() (string, func(), error)) (string, func(), error) {
+	if c == nil {
+		return fn()
+	}
+	key, err := convertCacheKey(converter, inputPath)
+	if err != nil {
+		return fn()
+	}
+	outName := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)) + ".pdf"
+
+	for {
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			// 锁内只定位并钉住条目，大文件的拷贝在锁外做，不挡其他查询；钉住期间不会被淘汰。
+			c.lru.MoveToFront(el)
+			entry := el.Value.(*convertCacheEntry)
+			entry.refs++
+			c.mu.Unlock()
+			out, cleanup, err := c.checkout(key, outName)
+			c.mu.Lock()
+			entry.refs--
+			if err != nil && c.entries[key] == el {
+				c.removeLocked(key)
+			}
+			c.mu.Unlock()
+			if err == nil {
+				return out, cleanup, nil
+			}
+			log.Printf("[convert-cache] read %s failed: %v", key, err)
+			continue
+		}
+		if ch, ok := c.inflight[key]; ok {
+			c.mu.Unlock()
+			select {
+			case <-ch:
+				// 对方完成（成功则下一轮命中，失败则由本次重新转换）。
+				continue
+			case <-ctx.Done():
+				return "", nil, ctx.Err()
+			}
+		}
+		ch := make(chan struct{})
+		c.inflight[key] = ch
+		c.mu.Unlock()
+
+		out, cleanup, err := fn()
+		if err == nil {
+			c.store(key, out)
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		close(ch)
+		c.mu.Unlock()
+		return out, cleanup, err
+	}
+}
+
+// checkout 把缓存项放到一个新临时目录里交给调用方，并刷新 mtime 供重启后恢复 LRU 顺序。
+// 不持锁调用，调用方须先钉住条目（refs++）。
+func (c *convertCache) checkout(key string, outName string) (string, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "convert-cache-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(tmpDir) }
+	src := c.path(key)
+	dst := filepath.Join(tmpDir, outName)
+	if err := os.Link(src, dst); err != nil {
+		if err := copyFile(src, dst); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	now := time.Now()
+	_ = os.Chtimes(src, now, now)
+	return dst, cleanup, nil
+}
+
+// store 把新转换出的 PDF 拷进缓存（先写 .tmp 再 rename，避免读到半个文件），然后按需淘汰。
+func (c *convertCache) store(key string, outPath string) {
+	info, err := os.Stat(outPath)
+	if err != nil || info.Size() > c.maxBytes {
+		return
+	}
+	tmp := c.path(key) + ".tmp"
+	if err := copyFile(outPath, tmp); err != nil {
+		_ = os.Remove(tmp)
+		log.Printf("[convert-cache] store %s failed: %v", key, err)
+		return
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		_ = os.Remove(tmp)
+		log.Printf("[convert-cache] store %s failed: %v", key, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*convertCacheEntry).size
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&convertCacheEntry{key: key, size: info.Size()})
+	c.size += info.Size()
+	c.evictLocked()
+}
+
+func (c *convertCache) removeLocked(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.size -= el.Value.(*convertCacheEntry).size
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	_ = os.Remove(c.path(key))
+}
+
+// evictLocked 从最久未用的一端删除，直到总大小不超过上限。正在被取出的条目跳过，
+// 等下次写入时再淘汰。
+func (c *convertCache) evictLocked() {
+	for el := c.lru.Back(); el != nil && c.size > c.maxBytes; {
+		prev := el.Prev()
+		if entry := el.Value.(*convertCacheEntry); entry.refs == 0 {
+			c.removeLocked(entry.key)
+		}
+		el = prev
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	c, err := newConvertCache(cacheDir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputDir := t.TempDir()
+	writeInput := func(name, content string) string {
+		p := filepath.Join(inputDir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	calls := 0
+	// 假转换器：输出 10 字节，内容取自输入，便于校验命中时拿到的是同一份结果。
+	fake := func(in string) func() (string, func(), error) {
+		return func() (string, func(), error) {
+			calls++
+			dir, _ := os.MkdirTemp("", "fake-convert-")
+			data, _ := os.ReadFile(in)
+			out := filepath.Join(dir, "out.pdf")
+			os.WriteFile(out, append(data, make([]byte, 10-len(data))...), 0o644)
+			return out, func() { os.RemoveAll(dir) }, nil
+		}
+	}
+	convert := func(converter, in string) []byte {
+		t.Helper()
+		out, cleanup, err := c.convert(context.Background(), converter, in, fake(in))
+		if err != nil {
+			t.Fatalf("convert %s: %v", in, err)
+		}
+		defer cleanup()
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	a := writeInput("a.docx", "aaa")
+	convert("lo", a)
+	// 内容相同、文件名不同，照样命中。
+	if got := convert("lo", writeInput("copy-of-a.docx", "aaa")); string(got[:3]) != "aaa" || calls != 1 {
+		t.Fatalf("hit: got %q, calls = %d", got, calls)
+	}
+	// 转换器或选项不同不能共用结果。
+	if convert("ofd", a); calls != 2 {
+		t.Fatalf("different converter should miss, calls = %d", calls)
+	}
+
+	// 上限 20 字节只容得下两项：再加一项会淘汰最久未用的 ofd(a)，lo(a) 刚被访问过，得以保留。
+	convert("lo", a)
+	b := writeInput("b.docx", "bbb")
+	convert("lo", b)
+	if calls != 3 || c.size != 20 || len(c.entries) != 2 {
+		t.Fatalf("after eviction: calls = %d, size = %d, entries = %d", calls, c.size, len(c.entries))
+	}
+	if convert("lo", a); calls != 3 {
+		t.Errorf("recently used entry was evicted, calls = %d", calls)
+	}
+	if convert("ofd", a); calls != 4 {
+		t.Errorf("least recently used entry was not evicted, calls = %d", calls)
+	}
+
+	// 重启后从磁盘恢复索引。
+	c2, err := newConvertCache(cacheDir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c2.entries) != 2 || c2.size != 20 {
+		t.Errorf("reloaded entries = %d, size = %d", len(c2.entries), c2.size)
+	}
+
+	// nil 缓存直接转换。
+	var nilCache *convertCache
+	if _, cleanup, err := nilCache.convert(context.Background(), "lo", a, fake(a)); err != nil || calls != 5 {
+		t.Errorf("nil cache: err = %v, calls = %d", err, calls)
+	} else {
+		cleanup()
+	}
+
+	// 正在被取出的条目（refs > 0）不会被淘汰，淘汰顺延到下一个最久未用的。
+	loA, _ := convertCacheKey("lo", a)
+	ofdA, _ := convertCacheKey("ofd", a)
+	pinned := c.entries[loA].Value.(*convertCacheEntry)
+	pinned.refs++
+	convert("lo", writeInput("c.docx", "ccc"))
+	pinned.refs--
+	if _, ok := c.entries[loA]; !ok {
+		t.Error("pinned entry was evicted")
+	}
+	if _, ok := c.entries[ofdA]; ok || c.size != 20 {
+		t.Errorf("unpinned entry kept = %v, size = %d", ok, c.size)
+	}
+	if _, err := os.Stat(c.path(loA)); err != nil {
+		t.Errorf("pinned file: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -50,9 +51,15 @@ func runLibreOfficeConvert(ctx context.Context, inputPath string, filter string,
 		args = append(args, "--infilter="+infilter)
 	}
 	args = append(args, "--outdir", tmpDir, inputPath)
-	cmd := exec.CommandContext(ctx, "libreoffice", args...)
-	cmd.Env = append(os.Environ(), "LANG=zh_CN.UTF-8", "LC_ALL=zh_CN.UTF-8")
-	if out, err := cmd.CombinedOutput(); err != nil {
+	out, err := runConverterCmd(ctx, converterLibreOffice, func(ctx context.Context, workDir string) *exec.Cmd {
+		// 每次调用使用独立的用户配置目录：共用默认 profile 时，并发的第二个实例会
+		// 连到第一个实例上、或因 profile 锁直接失败退出。
+		profile := (&url.URL{Scheme: "file", Path: filepath.Join(workDir, "profile")}).String()
+		cmd := exec.CommandContext(ctx, "libreoffice", append([]string{"-env:UserInstallation=" + profile}, args...)...)
+		cmd.Env = append(os.Environ(), "LANG=zh_CN.UTF-8", "LC_ALL=zh_CN.UTF-8")
+		return cmd
+	})
+	if err != nil {
 		cleanup()
 		return "", nil, fmt.Errorf("conversion failed: %w - %s", err, string(out))
 	}
@@ -73,8 +80,19 @@ func runLibreOfficeConvert(ctx context.Context, inputPath string, filter string,
 }
 
 // convertOfficeToPDF 将 Office 文档（.doc/.docx/.xls/.xlsx/.ppt/.pptx）转成 PDF。
+// 结果按内容缓存，估价、预览与打印同一文件只转换一次。启用了常驻进程池时优先交给池，
+// 池不可用时回退到一次性 CLI；池内转换失败直接返回，同一个坏文档不再转第二遍。
 func convertOfficeToPDF(ctx context.Context, inputPath string) (string, func(), error) {
-	return runLibreOfficeConvert(ctx, inputPath, "pdf", "")
+	return sharedConvertCache().convert(ctx, "libreoffice:pdf", inputPath, func() (string, func(), error) {
+		outPath, cleanup, err := libreOfficePool.convert(ctx, inputPath)
+		if err == nil {
+			return outPath, cleanup, nil
+		}
+		if !errors.Is(err, errLOPoolUnavailable) {
+			return "", nil, err
+		}
+		return runLibreOfficeConvert(ctx, inputPath, "pdf", "")
+	})
 }
 
 // convertPDFViaLibreOffice 通过 LibreOffice 重新导出 PDF，用作 Ghostscript 不可用时的兜底。
@@ -82,10 +100,23 @@ func convertOfficeToPDF(ctx context.Context, inputPath string) (string, func(),
 // 而不是走 Draw 默认路径——Writer 导入器对中文字体的映射更准确，能正确处理 GBK 编码
 // CID 字体的 PDF，避免 Ghostscript 10.x pdfwrite 破坏文本编码导致的中文乱码问题。
 func convertPDFViaLibreOffice(ctx context.Context, inputPath string) (string, func(), error) {
-	return runLibreOfficeConvert(ctx, inputPath, "pdf", "writer_pdf_import")
+	return sharedConvertCache().convert(ctx, "libreoffice:pdf:writer_pdf_import", inputPath, func() (string, func(), error) {
+		return runLibreOfficeConvert(ctx, inputPath, "pdf", "writer_pdf_import")
+	})
 }
 
+// convertOFDToPDF 调用 OFD 转换 jar 把 .ofd 转成 PDF，结果同样按内容缓存。
 func convertOFDToPDF(ctx context.Context, inputPath string) (string, func(), error) {
+	jarPath := os.Getenv("OFD_CONVERTER_JAR")
+	if jarPath == "" {
+		jarPath = "/ofd-converter.jar"
+	}
+	return sharedConvertCache().convert(ctx, "ofd:"+jarPath, inputPath, func() (string, func(), error) {
+		return runOFDConvert(ctx, jarPath, inputPath)
+	})
+}
+
+func runOFDConvert(ctx context.Context, jarPath string, inputPath string) (string, func(), error) {
 	tmpDir, err := os.MkdirTemp("", "convert-ofd-")
 	if err != nil {
 		return "", nil, err
@@ -94,14 +125,12 @@ func convertOFDToPDF(ctx context.Context, inputPath string) (string, func(), err
 
 	outPath := filepath.Join(tmpDir, "output.pdf")
 
-	jarPath := os.Getenv("OFD_CONVERTER_JAR")
-	if jarPath == "" {
-		jarPath = "/ofd-converter.jar"
-	}
-
-	cmd := exec.CommandContext(ctx, "java", "-Xmx512m", "-jar", jarPath, inputPath, outPath)
-	cmd.Env = append(os.Environ(), "LANG=zh_CN.UTF-8", "LC_ALL=zh_CN.UTF-8")
-	if out, err := cmd.CombinedOutput(); err != nil {
+	out, err := runConverterCmd(ctx, converterOFD, func(ctx context.Context, workDir string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "java", "-Xmx512m", "-Djava.io.tmpdir="+workDir, "-jar", jarPath, inputPath, outPath)
+		cmd.Env = append(os.Environ(), "LANG=zh_CN.UTF-8", "LC_ALL=zh_CN.UTF-8")
+		return cmd
+	})
+	if err != nil {
 		cleanup()
 		return "", nil, fmt.Errorf("OFD to PDF conversion failed: %w - %s", err, string(out))
 	}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── 外部转换器的受限执行 ───────────────────────────────────────────────────────
+//
+// libreoffice、java、gs 都以服务进程的全部权限运行，而 exec.CommandContext 超时只杀
+// 直接子进程，soffice.bin 这类孙进程会残留。runConverterCmd 统一负责：
+//
+//   - 每次调用独立的进程组，取消/超时杀整组，正常退出后也顺手收掉残留的孙进程；
+//   - 通过 ulimit 限制内存（数据段）、CPU 时间与单文件大小，防止单个坏文档拖垮主机；
+//   - 每次调用一个专属临时目录（TMPDIR 指向它），结束即删；
+//   - 分开收集 stdout/stderr，失败时返回带退出码、信号与 stderr 末尾的 *converterError。
+//
+// 进程组与 rlimit 只在 unix 上生效（converter_sandbox_unix.go），其他平台只做超时与临时目录。
+
+// 单次外部转换的运行时限，从拿到名额开始计。
+var converterRunTimeouts = map[string]time.Duration{
+	converterLibreOffice: 60 * time.Second,
+	converterOFD:         60 * time.Second,
+	converterGhostscript: 90 * time.Second,
+}
+
+// 进程被取消后，等它（以及可能还占着输出管道的孙进程）退出的最长时间。
+const converterWaitDelay = 5 * time.Second
+
+// converterRlimits 为 0 的字段表示不限制。
+type converterRlimits struct {
+	MemoryMB   int // RLIMIT_DATA：JVM 预留的大块 PROT_NONE 地址空间不计入，比 RLIMIT_AS 合适
+	CPUSeconds int
+	FileSizeMB int
+}
+
+var defaultConverterRlimits = map[string]converterRlimits{
+	converterLibreOffice: {MemoryMB: 2048, CPUSeconds: 300, FileSizeMB: 512},
+	converterOFD:         {MemoryMB: 1536, CPUSeconds: 300, FileSizeMB: 512},
+	converterGhostscript: {MemoryMB: 1024, CPUSeconds: 300, FileSizeMB: 512},
+}
+
+var (
+	converterMemoryOnce     sync.Once
+	converterMemoryOverride = -1 // -1 表示沿用各工具默认值
+)
+
+// converterRlimitsFor 返回 tool 的资源上限，CONVERTER_MEMORY_MB 统一覆盖内存上限（0 为不限）。
+func converterRlimitsFor(tool string) converterRlimits {
+	converterMemoryOnce.Do(func() {
+		if v := strings.TrimSpace(os.Getenv("CONVERTER_MEMORY_MB")); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				converterMemoryOverride = n
+			} else {
+				log.Printf("invalid CONVERTER_MEMORY_MB %q ignored", v)
+			}
+		}
+	})
+	lim := defaultConverterRlimits[tool]
+	if converterMemoryOverride >= 0 {
+		lim.MemoryMB = converterMemoryOverride
+	}
+	return lim
+}
+
+// ulimitScript 生成在 exec 目标程序之前执行的 ulimit 前缀。设置失败（如超过硬上限）
+// 时忽略，不因为限额设不上就拒绝转换。-f 的单位是 512 字节块（POSIX）。
+func ulimitScript(lim converterRlimits) string {
+	var b strings.Builder
+	if lim.MemoryMB > 0 {
+		fmt.Fprintf(&b, "ulimit -d %d 2>/dev/null; ", lim.MemoryMB*1024)
+	}
+	if lim.CPUSeconds > 0 {
+		fmt.Fprintf(&b, "ulimit -t %d 2>/dev/null; ", lim.CPUSeconds)
+	}
+	if lim.FileSizeMB > 0 {
+		fmt.Fprintf(&b, "ulimit -f %d 2>/dev/null; ", lim.FileSizeMB*2048)
+	}
+	return b.String()
+}
+
+// 失败诊断里保留的 stderr 末尾行数。
+const converterStderrTailLines = 20
+
+// converterError 是外部转换失败的结构化描述，同时记入转换器统计的 lastError。
+type converterError struct {
+	Tool     string   `json:"tool"`
+	ExitCode int      `json:"exitCode"` // -1 表示被信号终止或没能启动
+	Signal   string   `json:"signal,omitempty"`
+	TimedOut bool     `json:"timedOut,omitempty"`
+	Elapsed  string   `json:"elapsed"`
+	Stderr   []string `json:"stderr,omitempty"`
+	At       string   `json:"at"`
+	err      error
+}
+
+func (e *converterError) Error() string {
+	msg := e.Tool + ": " + e.err.Error()
+	if e.TimedOut {
+		msg += " (timed out)"
+	}
+	return msg
+}
+
+func (e *converterError) Unwrap() error { return e.err }
+
+func stderrTail(stderr []byte) []string {
+	var lines []string
+	for line := range strings.SplitSeq(string(stderr), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > converterStderrTailLines {
+		lines = lines[len(lines)-converterStderrTailLines:]
+	}
+	return lines
+}
+
+// runConverterCmd 在 tool 的并发名额内执行 build 出的命令，返回 stdout 与 stderr 拼接后的输出。
+// 排队只受 ctx 约束；拿到名额后才开始计 converterRunTimeouts，build 收到的 ctx 带着这个期限，
+// 应传给 exec.CommandContext。这样排在后面的大文件不会还没轮到就超时。
+// workDir 是本次调用专属的临时目录，结束后删除，可用来放 profile 等中间文件。
+func runConverterCmd(ctx context.Context, tool string, build func(ctx context.Context, workDir string) *exec.Cmd) ([]byte, error) {
+	release, err := sharedConverterScheduler().acquire(ctx, tool)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return execConverterCmd(ctx, tool, build)
+}
+
+// execConverterCmd 同 runConverterCmd，但调用方已经持有 tool 的名额（常驻进程池先占名额
+// 再等空闲实例）。
+func execConverterCmd(ctx context.Context, tool string, build func(ctx context.Context, workDir string) *exec.Cmd) ([]byte, error) {
+	s := sharedConverterScheduler()
+	workDir, err := os.MkdirTemp("", "conv-"+tool+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if d, ok := converterRunTimeouts[tool]; ok {
+		runCtx, cancel = context.WithTimeout(ctx, d)
+	}
+	defer cancel()
+
+	cmd := build(runCtx, workDir)
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "TMPDIR="+workDir, "TMP="+workDir, "TEMP="+workDir)
+	sandboxCommand(cmd, converterRlimitsFor(tool))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	killProcessGroup(cmd)
+	elapsed := time.Since(start)
+	out := append(stdout.Bytes(), stderr.Bytes()...)
+	if err != nil {
+		ce := &converterError{
+			Tool:     tool,
+			ExitCode: -1,
+			TimedOut: errors.Is(runCtx.Err(), context.DeadlineExceeded),
+			Elapsed:  elapsed.Round(time.Millisecond).String(),
+			Stderr:   stderrTail(stderr.Bytes()),
+			At:       time.Now().Format(time.RFC3339),
+			err:      err,
+		}
+		if cmd.ProcessState != nil {
+			ce.ExitCode = cmd.ProcessState.ExitCode()
+			ce.Signal = exitSignal(cmd.ProcessState)
+		}
+		log.Printf("[converter] tool=%s exit=%d signal=%q timed_out=%v elapsed=%s stderr_tail=%q",
+			tool, ce.ExitCode, ce.Signal, ce.TimedOut, ce.Elapsed, ce.Stderr)
+		err = ce
+	}
+	s.recordRun(tool, elapsed, err)
+	return out, err
+}
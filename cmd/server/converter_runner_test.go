@@ -0,0 +1,93 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUlimitScript(t *testing.T) {
+	if got := ulimitScript(converterRlimits{}); got != "" {
+		t.Errorf("no limits = %q", got)
+	}
+	got := ulimitScript(converterRlimits{MemoryMB: 2, CPUSeconds: 3, FileSizeMB: 1})
+	for _, want := range []string{"ulimit -d 2048", "ulimit -t 3", "ulimit -f 2048"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("script %q missing %q", got, want)
+		}
+	}
+}
+
+// processGone 在 pid 不存在或已成僵尸（容器里 PID 1 未必收尸）时返回 true。
+func processGone(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestRunConverterCmdKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var workDir string
+	_, err := runConverterCmd(ctx, "test", func(ctx context.Context, dir string) *exec.Cmd {
+		workDir = dir
+		// 孙进程 sleep 与直接子进程 sh 都得被杀掉。
+		return exec.CommandContext(ctx, "sh", "-c", `sleep 30 & echo $! > "$1"; wait`, "sh", pidFile)
+	})
+	var ce *converterError
+	if !errors.As(err, &ce) || ce.Signal != "killed" {
+		t.Fatalf("err = %#v, want converterError killed by signal", err)
+	}
+	data, readErr := os.ReadFile(pidFile)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	waitFor(t, func() bool { return processGone(pid) })
+	if _, statErr := os.Stat(workDir); !os.IsNotExist(statErr) {
+		t.Errorf("work dir %s not removed", workDir)
+	}
+}
+
+func TestRunConverterCmdStructuredError(t *testing.T) {
+	out, err := runConverterCmd(context.Background(), "test", func(ctx context.Context, dir string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", `echo "tmp=$TMPDIR"; echo first >&2; echo last >&2; exit 3`)
+	})
+	var ce *converterError
+	if !errors.As(err, &ce) {
+		t.Fatalf("err = %v, want *converterError", err)
+	}
+	if ce.ExitCode != 3 || ce.TimedOut || strings.Join(ce.Stderr, "|") != "first|last" {
+		t.Errorf("converterError = %+v", ce)
+	}
+	if !strings.Contains(string(out), "tmp="+os.TempDir()) || !strings.Contains(string(out), "conv-test-") {
+		t.Errorf("TMPDIR not pointed at the per-call work dir, output = %q", out)
+	}
+}
+
+func TestRunConverterCmdAppliesRlimits(t *testing.T) {
+	defaultConverterRlimits["test-limited"] = converterRlimits{FileSizeMB: 1}
+	t.Cleanup(func() { delete(defaultConverterRlimits, "test-limited") })
+
+	out, err := runConverterCmd(context.Background(), "test-limited", func(ctx context.Context, dir string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", "-c", "ulimit -f")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "2048" {
+		t.Errorf("ulimit -f inside converter = %q, want 2048", got)
+	}
+}
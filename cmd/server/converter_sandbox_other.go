@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// 非 unix 平台没有进程组与 ulimit，只保留取消后的等待上限。
+func sandboxCommand(cmd *exec.Cmd, lim converterRlimits) {
+	cmd.WaitDelay = converterWaitDelay
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return nil
+}
+
+func exitSignal(ps *os.ProcessState) string {
+	return ""
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// sandboxCommand 让 cmd 在独立进程组里运行，并在 exec 目标程序前套上 ulimit。
+func sandboxCommand(cmd *exec.Cmd, lim converterRlimits) {
+	if script := ulimitScript(lim); script != "" {
+		cmd.Args = append([]string{"sh", "-c", script + `exec "$@"`, "sh", cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = "/bin/sh"
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// 只有 CommandContext 创建的命令才能设 Cancel（常驻进程用 exec.Command，由调用方自己停）。
+	if cmd.Cancel != nil {
+		cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	}
+	cmd.WaitDelay = converterWaitDelay
+}
+
+// killProcessGroup 向 cmd 所在进程组发 SIGKILL，组已经不存在时不算错误。
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+func exitSignal(ps *os.ProcessState) string {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal().String()
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── 外部转换器调度 ─────────────────────────────────────────────────────────────
+//
+// LibreOffice、OFD（每个 JVM -Xmx512m）和 Ghostscript 都是重量级子进程，一个班级
+// 同时上传就能把小主机的内存吃光。所有外部转换都经 runConverterCmd（converter_runner.go）
+// 执行：每种工具有独立的并发上限，超出的调用按 FIFO 排队，等待期间请求/任务取消即退出队列。
+// 排队位置通过 context 里的观察者回传（异步打印任务据此显示「等待转换（第 N 位）」），
+// 运行统计由 GET /api/admin/converters 查看。
+
+const (
+	converterLibreOffice = "libreoffice"
+	converterOFD         = "ofd"
+	converterGhostscript = "gs"
+)
+
+// 默认并发上限，可用 CONVERTER_LIMITS="libreoffice=1,ofd=1,gs=2" 覆盖。
+var defaultConverterLimits = map[string]int{
+	converterLibreOffice: 2,
+	converterOFD:         1,
+	converterGhostscript: 2,
+}
+
+type converterWaiter struct {
+	ready    chan struct{} // 被 close 表示已分到名额
+	observer func(pos int)
+}
+
+type converterStats struct {
+	Tool      string `json:"tool"`
+	Limit     int    `json:"limit"`
+	Running   int    `json:"running"`
+	Waiting   int    `json:"waiting"`
+	Started   int64  `json:"started"`
+	Succeeded int64  `json:"succeeded"`
+	Failed    int64  `json:"failed"`
+	Canceled  int64  `json:"canceled"` // 排队期间放弃的调用
+	AvgWaitMs int64  `json:"avgWaitMs"`
+	MaxWaitMs int64  `json:"maxWaitMs"`
+	AvgRunMs  int64  `json:"avgRunMs"`
+	MaxRunMs  int64  `json:"maxRunMs"`
+	// 最近一次失败的退出码、信号与 stderr 末尾，排查转换问题用。
+	LastError *converterError `json:"lastError,omitempty"`
+}
+
+type converterSlot struct {
+	limit   int
+	running int
+	waiters *list.List // *converterWaiter，队头先拿到名额
+
+	started, succeeded, failed, canceled int64
+	totalWait, maxWait                   time.Duration
+	totalRun, maxRun                     time.Duration
+	lastError                            *converterError
+}
+
+type converterScheduler struct {
+	mu    sync.Mutex
+	slots map[string]*converterSlot
+}
+
+var (
+	converterSchedOnce sync.Once
+	converterSchedInst *converterScheduler
+)
+
+func sharedConverterScheduler() *converterScheduler {
+	converterSchedOnce.Do(func() {
+		converterSchedInst = newConverterScheduler(parseConverterLimits(os.Getenv("CONVERTER_LIMITS")))
+	})
+	return converterSchedInst
+}
+
+// parseConverterLimits 在默认值基础上应用 "tool=n,..." 覆盖，非法项记日志后忽略。
+func parseConverterLimits(v string) map[string]int {
+	limits := make(map[string]int, len(defaultConverterLimits))
+	for tool, n := range defaultConverterLimits {
+		limits[tool] = n
+	}
+	for item := range strings.SplitSeq(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tool, value, _ := strings.Cut(item, "=")
+		tool = strings.TrimSpace(tool)
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if _, known := defaultConverterLimits[tool]; !known || err != nil || n < 1 {
+			log.Printf("[converter] invalid CONVERTER_LIMITS item %q ignored", item)
+			continue
+		}
+		limits[tool] = n
+	}
+	return limits
+}
+
+func newConverterScheduler(limits map[string]int) *converterScheduler {
+	s := &converterScheduler{slots: map[string]*converterSlot{}}
+	for tool, n := range limits {
+		s.slots[tool] = &converterSlot{limit: n, waiters: list.New()}
+	}
+	return s
+}
+
+type converterObserverKey struct{}
+
+// withConverterQueueObserver 让 ctx 下的转换调用在排队时回报位置：pos 从 1 开始，
+// 0 表示已轮到（或不再排队）。
+func withConverterQueueObserver(ctx context.Context, fn func(pos int)) context.Context {
+	return context.WithValue(ctx, converterObserverKey{}, fn)
+}
+
+// notifyPositionsLocked 在队列变化后把最新位置告诉每个等待者。
+func (slot *converterSlot) notifyPositionsLocked() {
+	pos := 1
+	for e := slot.waiters.Front(); e != nil; e = e.Next() {
+		if w := e.Value.(*converterWaiter); w.observer != nil {
+			w.observer(pos)
+		}
+		pos++
+	}
+}
+
+// acquire 申请 tool 的一个名额，返回释放函数。未登记的工具不限流。
+func (s *converterScheduler) acquire(ctx context.Context, tool string) (func(), error) {
+	s.mu.Lock()
+	slot, ok := s.slots[tool]
+	if !ok {
+		s.mu.Unlock()
+		return func() {}, nil
+	}
+	start := time.Now()
+	if slot.running < slot.limit && slot.waiters.Len() == 0 {
+		slot.running++
+		slot.recordStartLocked(0)
+		s.mu.Unlock()
+		return s.releaseFunc(slot), nil
+	}
+	observer, _ := ctx.Value(converterObserverKey{}).(func(pos int))
+	w := &converterWaiter{ready: make(chan struct{}), observer: observer}
+	el := slot.waiters.PushBack(w)
+	if observer != nil {
+		observer(slot.waiters.Len())
+	}
+	log.Printf("[converter] %s busy (%d/%d running), queued at position %d", tool, slot.running, slot.limit, slot.waiters.Len())
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		s.mu.Lock()
+		slot.recordStartLocked(time.Since(start))
+		s.mu.Unlock()
+		if observer != nil {
+			observer(0)
+		}
+		return s.releaseFunc(slot), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// 取消与分到名额同时发生：名额已转给我们，原样交还。
+			s.releaseLocked(slot)
+		default:
+			slot.waiters.Remove(el)
+			slot.notifyPositionsLocked()
+		}
+		slot.canceled++
+		s.mu.Unlock()
+		if observer != nil {
+			observer(0)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (slot *converterSlot) recordStartLocked(wait time.Duration) {
+	slot.started++
+	slot.totalWait += wait
+	slot.maxWait = max(slot.maxWait, wait)
+}
+
+func (s *converterScheduler) releaseFunc(slot *converterSlot) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.releaseLocked(slot)
+			s.mu.Unlock()
+		})
+	}
+}
+
+// releaseLocked 有人排队时把名额直接转给队头，running 不变；否则归还名额。
+func (s *converterScheduler) releaseLocked(slot *converterSlot) {
+	if front := slot.waiters.Front(); front != nil {
+		slot.waiters.Remove(front)
+		close(front.Value.(*converterWaiter).ready)
+		slot.notifyPositionsLocked()
+		return
+	}
+	slot.running--
+}
+
+func (s *converterScheduler) recordRun(tool string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot, ok := s.slots[tool]
+	if !ok {
+		return
+	}
+	if err != nil {
+		slot.failed++
+		var ce *converterError
+		if errors.As(err, &ce) {
+			slot.lastError = ce
+		}
+	} else {
+		slot.succeeded++
+	}
+	slot.totalRun += d
+	slot.maxRun = max(slot.maxRun, d)
+}
+
+func (s *converterScheduler) stats() []converterStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]converterStats, 0, len(s.slots))
+	for _, tool := range slices.Sorted(maps.Keys(s.slots)) {
+		slot := s.slots[tool]
+		st := converterStats{
+			Tool:      tool,
+			Limit:     slot.limit,
+			Running:   slot.running,
+			Waiting:   slot.waiters.Len(),
+			Started:   slot.started,
+			Succeeded: slot.succeeded,
+			Failed:    slot.failed,
+			Canceled:  slot.canceled,
+			MaxWaitMs: slot.maxWait.Milliseconds(),
+			MaxRunMs:  slot.maxRun.Milliseconds(),
+			LastError: slot.lastError,
+		}
+		if slot.started > 0 {
+			st.AvgWaitMs = (slot.totalWait / time.Duration(slot.started)).Milliseconds()
+		}
+		if done := slot.succeeded + slot.failed; done > 0 {
+			st.AvgRunMs = (slot.totalRun / time.Duration(done)).Milliseconds()
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// GET /api/admin/converters — 各外部转换器的并发上限、运行/排队数与累计耗时统计，
+// 启用了 LibreOffice 常驻进程池时附带存活实例数与重启次数。
+func adminConvertersHandler(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{"converters": sharedConverterScheduler().stats()}
+	if p := libreOfficePool; p != nil {
+		resp["libreofficePool"] = map[string]any{
+			"workers":  cap(p.idle),
+			"alive":    p.alive.Load(),
+			"idle":     len(p.idle),
+			"restarts": p.restarts.Load(),
+		}
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseConverterLimits(t *testing.T) {
+	got := parseConverterLimits(" libreoffice=1, gs=0, java=3, ofd=x ")
+	if got[converterLibreOffice] != 1 || got[converterGhostscript] != defaultConverterLimits[converterGhostscript] ||
+		got[converterOFD] != defaultConverterLimits[converterOFD] || len(got) != len(defaultConverterLimits) {
+		t.Errorf("parseConverterLimits = %v", got)
+	}
+}
+
+func TestConverterSchedulerQueue(t *testing.T) {
+	s := newConverterScheduler(map[string]int{"lo": 1})
+
+	release1, err := s.acquire(context.Background(), "lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 名额用完后依次排队，位置随前面的人离开而前移。
+	var mu sync.Mutex
+	positions := map[string][]int{}
+	observe := func(name string) context.Context {
+		return withConverterQueueObserver(context.Background(), func(pos int) {
+			mu.Lock()
+			positions[name] = append(positions[name], pos)
+			mu.Unlock()
+		})
+	}
+	acquired := make(chan string, 2)
+	go func() {
+		release, err := s.acquire(observe("second"), "lo")
+		if err == nil {
+			acquired <- "second"
+			release()
+		}
+	}()
+	waitFor(t, func() bool { return s.stats()[0].Waiting == 1 })
+
+	cancelCtx, cancel := context.WithCancel(observe("canceled"))
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := s.acquire(cancelCtx, "lo")
+		canceled <- err
+	}()
+	waitFor(t, func() bool { return s.stats()[0].Waiting == 2 })
+
+	thirdCtx := observe("third")
+	go func() {
+		release, err := s.acquire(thirdCtx, "lo")
+		if err == nil {
+			acquired <- "third"
+			release()
+		}
+	}()
+	waitFor(t, func() bool { return s.stats()[0].Waiting == 3 })
+
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled acquire err = %v", err)
+	}
+
+	release1()
+	release1() // 重复释放无副作用
+	for _, want := range []string{"second", "third"} {
+		select {
+		case got := <-acquired:
+			if got != want {
+				t.Fatalf("acquired %s, want %s (FIFO)", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s never acquired", want)
+		}
+	}
+
+	waitFor(t, func() bool { return s.stats()[0].Running == 0 })
+	st := s.stats()[0]
+	if st.Waiting != 0 || st.Started != 3 || st.Canceled != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// third 先排第 3，canceled 离开后前移到第 2，second 拿到名额后到第 1，最后轮到。
+	if got := positions["third"]; len(got) < 4 || got[0] != 3 || got[len(got)-1] != 0 {
+		t.Errorf("third positions = %v", got)
+	}
+	if got := positions["canceled"]; len(got) == 0 || got[0] != 2 || got[len(got)-1] != 0 {
+		t.Errorf("canceled positions = %v", got)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"cups-web/internal/ipp"
+)
+
+// 多 CUPS 服务器：CUPS_HOSTS 以逗号分隔配置多台 CUPS，每项写成「标签=地址」，
+// 例如 `一楼=cups-1f.local,二楼=https://cups-2f.local`。地址与 CUPS_HOST 的写法
+// 相同，可带 user:password@ 指定该服务器单独的认证账号；省略标签时以主机名作标签。
+// 未配置 CUPS_HOSTS 时退回单台 CUPS_HOST，标签为空，行为与以前一致。
+//
+// 打印机 URI 本身带着所属服务器的地址，打印、查询属性等按 URI 访问的请求天然
+// 落到正确的服务器；按队列名访问的接口（队列、释放页）用 host 参数指定标签。
+
+type cupsServerConfig struct {
+	Label string
+	Host  string // CUPS_HOST 形式：host、host:port 或完整 URL
+}
+
+// cupsHostStatus 是 /api/printers 返回的各服务器可达情况。
+type cupsHostStatus struct {
+	Label     string `json:"label"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+	Printers  int    `json:"printers"`
+}
+
+var (
+	cupsServersOnce sync.Once
+	cupsServerList  []cupsServerConfig
+)
+
+// cupsServers 返回配置的全部 CUPS 服务器，至少一台。
+func cupsServers() []cupsServerConfig {
+	cupsServersOnce.Do(func() {
+		cupsServerList = parseCUPSHosts(os.Getenv("CUPS_HOSTS"))
+		if len(cupsServerList) == 0 {
+			cupsServerList = []cupsServerConfig{{Host: cupsHost()}}
+		}
+	})
+	return cupsServerList
+}
+
+func parseCUPSHosts(v string) []cupsServerConfig {
+	var servers []cupsServerConfig
+	seen := map[string]bool{}
+	for item := range strings.SplitSeq(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		label, host, ok := strings.Cut(item, "=")
+		if !ok {
+			label, host = "", item
+		}
+		label, host = strings.TrimSpace(label), strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if label == "" {
+			label = ipp.CUPSHostname(host)
+		}
+		if seen[label] {
+			log.Printf("[cups] duplicate CUPS_HOSTS label %q ignored", label)
+			continue
+		}
+		seen[label] = true
+		servers = append(servers, cupsServerConfig{Label: label, Host: host})
+	}
+	return servers
+}
+
+// serverAddress 返回 host:port，用于把打印机 URI 对应到服务器。
+func serverAddress(host string) string {
+	root, err := ipp.ServerURI(host)
+	if err != nil {
+		return ""
+	}
+	u, err := url.Parse(root)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// uriServerAddress 返回打印机 URI 的 host:port。未写端口时按协议取默认端口：
+// ipp/ipps 为 631，http 为 80，https 为 443。
+func uriServerAddress(printerURI string) string {
+	u, err := url.Parse(printerURI)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		switch strings.ToLower(u.Scheme) {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		default:
+			port = "631"
+		}
+	}
+	return strings.ToLower(net.JoinHostPort(u.Hostname(), port))
+}
+
+// cupsServerForURI 按地址找出打印机 URI 所属的已配置服务器。
+func cupsServerForURI(printerURI string) (cupsServerConfig, bool) {
+	addr := uriServerAddress(printerURI)
+	if addr == "" {
+		return cupsServerConfig{}, false
+	}
+	for _, srv := range cupsServers() {
+		if serverAddress(srv.Host) == addr {
+			return srv, true
+		}
+	}
+	return cupsServerConfig{}, false
+}
+
+// listAllPrinters 并发查询全部服务器并按配置顺序合并。某台服务器不可达时只记入
+// 状态，不影响其他服务器的打印机。
+func listAllPrinters() ([]ipp.Printer, []cupsHostStatus) {
+	servers := cupsServers()
+	results := make([][]ipp.Printer, len(servers))
+	statuses := make([]cupsHostStatus, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Go(func() {
+			statuses[i] = cupsHostStatus{Label: srv.Label}
+			printers, err := ipp.ListPrinters(srv.Host)
+			if err != nil {
+				log.Printf("[printers] list on %q failed: %v", srv.Label, err)
+				statuses[i].Error = "unreachable"
+				return
+			}
+			for j := range printers {
+				printers[j].Host = srv.Label
+			}
+			results[i] = printers
+			statuses[i].Available = true
+			statuses[i].Printers = len(printers)
+		})
+	}
+	wg.Wait()
+
+	all := []ipp.Printer{}
+	for _, printers := range results {
+		all = append(all, printers...)
+	}
+	return all, statuses
+}
+
+var errCUPSHostNotFound = errors.New("cups host not found")
+
+func cupsServerByLabel(label string) (cupsServerConfig, error) {
+	for _, srv := range cupsServers() {
+		if srv.Label == label {
+			return srv, nil
+		}
+	}
+	return cupsServerConfig{}, errCUPSHostNotFound
+}
@@ -976,6 +976,8 @@ func adminSetupPrinterHandler(w http.ResponseWriter, r *http.Request) {
 		for _, w := range warnings {
 			fmt.Fprintf(logBuf, "⚠ %s\n", w)
 		}
+		// 同 URI 重建的队列能力可能已变，不等事件或 TTL。
+		invalidatePrinterCapabilities("")
 
 		result := map[string]any{
 			"printerName":     printerName,
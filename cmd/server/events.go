@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// 事件推送：每台 CUPS 服务器一个 ipp.Notifier，订阅打印机事件和本服务提交的作业事件，
+// 通过 Get-Notifications 拉取后经 SSE（GET /api/events）扇出给浏览器。
+// 打印机事件（缺纸、暂停、上下线）发给能在打印机列表里看到该打印机的用户；作业
+// 事件只发给作业所属用户与管理员。前端连上 SSE 后即可停掉打印机状态与队列的定时轮询。
+
+const (
+	eventClientBuffer = 32
+	eventHeartbeat    = 25 * time.Second
+	// 终态事件丢失（订阅创建失败、CUPS 重启）时 jobs 里的条目不会被删除，
+	// 超过作业跟踪期限的条目在下次登记时顺带清理。
+	eventWatchedJobMaxAge = jobTrackMaxAge
+)
+
+type eventClient struct {
+	userID  int64
+	isAdmin bool
+	ch      chan hostEvent
+}
+
+// hostEvent 是推给浏览器的事件，Host 为事件来源服务器的标签（单服务器时为空）。
+type hostEvent struct {
+	ipp.Event
+	Host string `json:"host,omitempty"`
+}
+
+// jobKey 标识一个作业：不同 CUPS 服务器的 job-id 各自编号，会重复。
+type jobKey struct {
+	host  string
+	jobID int
+}
+
+type watchedJob struct {
+	recordID int64
+	userID   int64
+	since    time.Time
+}
+
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[*eventClient]struct{}
+	jobs    map[jobKey]watchedJob // 服务器 + CUPS job-id -> 所属记录
+}
+
+var (
+	events    = &eventHub{clients: make(map[*eventClient]struct{}), jobs: make(map[jobKey]watchedJob)}
+	notifiers = map[string]*ipp.Notifier{} // 服务器标签 -> Notifier，启动后只读
+)
+
+func (h *eventHub) subscribe(userID int64, isAdmin bool) *eventClient {
+	c := &eventClient{userID: userID, isAdmin: isAdmin, ch: make(chan hostEvent, eventClientBuffer)}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+func (h *eventHub) unsubscribe(c *eventClient) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) watch(key jobKey, recordID, userID int64) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, job := range h.jobs {
+		if now.Sub(job.since) > eventWatchedJobMaxAge {
+			delete(h.jobs, id)
+		}
+	}
+	h.jobs[key] = watchedJob{recordID: recordID, userID: userID, since: now}
+}
+
+// publish 把事件投递给有权看到它的连接。慢连接的缓冲满了就丢弃该条事件，
+// 不阻塞 Notifier：前端收到下一条事件时会整体刷新，丢一条无伤大雅。
+func (h *eventHub) publish(ev hostEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var owner watchedJob
+	if ev.IsJobEvent() {
+		key := jobKey{host: ev.Host, jobID: ev.JobID}
+		var ok bool
+		owner, ok = h.jobs[key]
+		if !ok {
+			return
+		}
+		if ipp.IsTerminalJobState(ev.JobState) {
+			delete(h.jobs, key)
+		}
+	}
+	for c := range h.clients {
+		if ev.IsJobEvent() && !c.isAdmin && c.userID != owner.userID {
+			continue
+		}
+		select {
+		case c.ch <- ev:
+		default:
+		}
+	}
+}
+
+// watchJob 让 Notifier 订阅刚提交成功的作业。job 为 SendPrintJob 的返回值。
+// 打印机不属于任何已配置的服务器（用户手填的地址）时不订阅，由作业跟踪器轮询兜底。
+func watchJob(printerURI, job string, recordID, userID int64) {
+	jobID := ipp.ParseJobID(job)
+	if jobID == 0 || recordID <= 0 {
+		return
+	}
+	srv, ok := cupsServerForURI(printerURI)
+	if !ok {
+		return
+	}
+	n := notifiers[srv.Label]
+	if n == nil {
+		return
+	}
+	events.watch(jobKey{host: srv.Label, jobID: jobID}, recordID, userID)
+	go n.WatchJob(printerURI, jobID)
+}
+
+func startEventNotifier(s *store.Store) {
+	for _, srv := range cupsServers() {
+		serverURI, err := ipp.ServerURI(srv.Host)
+		if err != nil {
+			log.Printf("[events] notifier for %q disabled: %v", srv.Label, err)
+			continue
+		}
+		n := &ipp.Notifier{
+			ServerURI: serverURI,
+			OnEvent: func(e ipp.Event) {
+				ev := hostEvent{Event: e, Host: srv.Label}
+				switch e.Event {
+				case "printer-config-changed", "printer-added", "printer-deleted":
+					invalidatePrinterCapabilities("")
+				}
+				if ev.IsJobEvent() && ipp.IsTerminalJobState(ev.JobState) {
+					// 终态事件到达时立即回写记录，不必等作业跟踪器的下一轮轮询。
+					events.mu.Lock()
+					owner, ok := events.jobs[jobKey{host: srv.Label, jobID: ev.JobID}]
+					events.mu.Unlock()
+					if ok {
+						refreshWatchedJob(s, owner.recordID, ev.JobID)
+					}
+				}
+				events.publish(ev)
+			},
+		}
+		notifiers[srv.Label] = n
+		go n.Run(context.Background())
+	}
+}
+
+func refreshWatchedJob(s *store.Store, recordID int64, jobID int) {
+	ctx := context.Background()
+	var rec store.PrintRecord
+	err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		rec, err = store.GetPrintRecordByID(ctx, tx, recordID)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	refreshJobState(ctx, rec, jobID)
+}
+
+// GET /api/events — SSE 事件流。事件名为 printer 或 job，data 为 ipp.Event 的 JSON，
+// 另带 host 字段标明来源服务器。
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	rc := http.NewResponseController(w)
+	// 长连接不受全局 WriteTimeout 约束。
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	client := events.subscribe(sess.UserID, sess.Role == store.RoleAdmin)
+	defer events.unsubscribe(client)
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case ev := <-client.ch:
+			if !ev.IsJobEvent() {
+				// 每条打印机事件都按当前登记表过滤，管理员改了授权立即生效。
+				reg, err := loadPrinterRegistry(r.Context(), sess.UserID)
+				if err != nil || !reg.allowsPrinterEvent(sess, ev.Host, ev.PrinterName) {
+					continue
+				}
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			kind := "printer"
+			if ev.IsJobEvent() {
+				kind = "job"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
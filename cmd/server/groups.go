@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/store"
+)
+
+// ── 用户组 ────────────────────────────────────────────────────────────────────
+//
+// 用户组（部门、班级）让管理员按组而不是逐个用户配置：配额、打印策略与打印机授权
+// 都可以以组为对象（对象值为组 ID），打印记录可按组筛选。配额按用户 > 组 > 角色
+// 的顺序取规则；策略与授权只要命中用户所在的任意一个组即生效。
+
+var errUnknownGroup = errors.New("unknown group")
+
+// checkGroupTarget 校验以组为对象的规则里的组 ID。
+func checkGroupTarget(ctx context.Context, tx *sql.Tx, target string) error {
+	id, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return errUnknownGroup
+	}
+	if _, err := store.GetUserGroup(ctx, tx, id); err != nil {
+		return errUnknownGroup
+	}
+	return nil
+}
+
+// targetNames 返回用户 ID、组 ID 到名称的映射，用于在规则列表里展示对象名称。
+type targetNames struct {
+	users, groups map[string]string
+}
+
+func loadTargetNames(ctx context.Context, tx *sql.Tx) (targetNames, error) {
+	names := targetNames{users: map[string]string{}, groups: map[string]string{}}
+	users, err := store.ListUsers(ctx, tx)
+	if err != nil {
+		return names, err
+	}
+	for _, u := range users {
+		names.users[strconv.FormatInt(u.ID, 10)] = u.Username
+	}
+	groups, err := store.ListUserGroups(ctx, tx)
+	if err != nil {
+		return names, err
+	}
+	for _, g := range groups {
+		names.groups[strconv.FormatInt(g.ID, 10)] = g.Name
+	}
+	return names, nil
+}
+
+// name 返回用户或组对象的名称，其余类型返回空字符串。配额、策略与打印机授权的
+// 对象类型取值相同（user / group / role），这里统一按配额的常量匹配。
+func (n targetNames) name(targetType, target string) string {
+	switch targetType {
+	case store.QuotaTargetUser:
+		return n.users[target]
+	case store.QuotaTargetGroup:
+		return n.groups[target]
+	}
+	return ""
+}
+
+type userGroupPayload struct {
+	ID          int64  `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MemberCount int    `json:"memberCount"`
+	CreatedAt   string `json:"createdAt,omitempty"`
+}
+
+func mapUserGroup(g store.UserGroup) userGroupPayload {
+	return userGroupPayload{
+		ID:          g.ID,
+		Name:        g.Name,
+		Description: g.Description,
+		MemberCount: g.MemberCount,
+		CreatedAt:   g.CreatedAt,
+	}
+}
+
+var errGroupNameTaken = errors.New("group name already exists")
+
+// GET /api/admin/groups
+func adminListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	var groups []store.UserGroup
+	err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		groups, err = store.ListUserGroups(r.Context(), tx)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load groups")
+		return
+	}
+	resp := make([]userGroupPayload, 0, len(groups))
+	for _, g := range groups {
+		resp = append(resp, mapUserGroup(g))
+	}
+	writeJSON(w, resp)
+}
+
+// POST /api/admin/groups
+func adminCreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var payload userGroupPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	group := store.UserGroup{Name: strings.TrimSpace(payload.Name), Description: strings.TrimSpace(payload.Description)}
+	if group.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "group name required")
+		return
+	}
+	err := appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		if _, err := store.GetUserGroupByName(r.Context(), tx, group.Name); err == nil {
+			return errGroupNameTaken
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		_, err := store.CreateUserGroup(r.Context(), tx, &group)
+		return err
+	})
+	switch {
+	case errors.Is(err, errGroupNameTaken):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to create group")
+	default:
+		writeJSON(w, mapUserGroup(group))
+	}
+}
+
+// PUT /api/admin/groups/{id}
+func adminUpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var payload userGroupPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	group := store.UserGroup{ID: id, Name: strings.TrimSpace(payload.Name), Description: strings.TrimSpace(payload.Description)}
+	if group.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "group name required")
+		return
+	}
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		if other, err := store.GetUserGroupByName(r.Context(), tx, group.Name); err == nil && other.ID != id {
+			return errGroupNameTaken
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return store.UpdateUserGroup(r.Context(), tx, group)
+	})
+	switch {
+	case errors.Is(err, errGroupNameTaken):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "group not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to update group")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
+
+// DELETE /api/admin/groups/{id} — 同时删除以该组为对象的配额、策略与打印机授权。
+func adminDeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	sess, _ := auth.GetSession(r)
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		return store.DeleteUserGroup(r.Context(), tx, id, sess.UserID)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "group not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete group")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
+
+type groupMemberResponse struct {
+	UserID   int64  `json:"userId"`
+	Username string `json:"username"`
+}
+
+// GET /api/admin/groups/{id}/members
+func adminGroupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var members []store.GroupMember
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		if _, err := store.GetUserGroup(r.Context(), tx, id); err != nil {
+			return err
+		}
+		members, err = store.ListGroupMembers(r.Context(), tx, id)
+		return err
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "group not found")
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to load members")
+		return
+	}
+	resp := make([]groupMemberResponse, 0, len(members))
+	for _, m := range members {
+		resp = append(resp, groupMemberResponse{UserID: m.UserID, Username: m.Username})
+	}
+	writeJSON(w, resp)
+}
+
+// groupMembersPayload 按用户 ID 或登录名指定成员，批量导入时用登录名更方便。
+type groupMembersPayload struct {
+	UserIDs   []int64  `json:"userIds"`
+	Usernames []string `json:"usernames"`
+}
+
+// resolveMembers 把 ID 与登录名统一解析为用户 ID，返回找不到的登录名。
+func (p groupMembersPayload) resolveMembers(ctx context.Context, tx *sql.Tx) (ids []int64, unknown []string, err error) {
+	for _, id := range p.UserIDs {
+		if _, err := store.GetUserByID(ctx, tx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				unknown = append(unknown, strconv.FormatInt(id, 10))
+				continue
+			}
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+	}
+	for _, name := range p.Usernames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		u, err := store.GetUserByUsername(ctx, tx, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			unknown = append(unknown, name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, u.ID)
+	}
+	return ids, unknown, nil
+}
+
+// PUT /api/admin/groups/{id}/members — 整体替换成员；POST 为追加成员。
+// 有找不到的用户时整体不生效，返回 400 与 unknown 列表。
+func adminSetGroupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var payload groupMembersPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	replace := r.Method == http.MethodPut
+	var unknown []string
+	var group store.UserGroup
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		var err error
+		if _, err = store.GetUserGroup(r.Context(), tx, id); err != nil {
+			return err
+		}
+		var ids []int64
+		ids, unknown, err = payload.resolveMembers(r.Context(), tx)
+		if err != nil {
+			return err
+		}
+		if len(unknown) > 0 {
+			return errUnknownMembers
+		}
+		if replace {
+			err = store.SetGroupMembers(r.Context(), tx, id, ids)
+		} else {
+			err = store.AddGroupMembers(r.Context(), tx, id, ids)
+		}
+		if err != nil {
+			return err
+		}
+		group, err = store.GetUserGroup(r.Context(), tx, id)
+		return err
+	})
+	switch {
+	case errors.Is(err, errUnknownMembers):
+		writeJSONStatus(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "unknown": unknown})
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "group not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to update members")
+	default:
+		writeJSON(w, map[string]any{"ok": true, "memberCount": group.MemberCount})
+	}
+}
+
+var errUnknownMembers = errors.New("unknown users")
+
+// DELETE /api/admin/groups/{id}/members/{userId}
+func adminRemoveGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	userID, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		return store.RemoveGroupMember(r.Context(), tx, id, userID)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "member not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to remove member")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
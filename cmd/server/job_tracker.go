@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// 作业状态跟踪：SendPrintJob 拿到 job-id 只代表 CUPS 接收了作业，之后仍可能
+// 卡纸 abort、被管理员取消。这里定期对 submitted/held 状态的记录查 Get-Job-Attributes，
+// 把真实 job-state 回写到 print_jobs，作业进入终态后才落 printed/failed/cancelled。
+const (
+	jobTrackInterval = 20 * time.Second
+	// 超过这个时长仍未进入终态的记录不再轮询：CUPS 默认只保留有限的作业历史，
+	// 远古记录大概率已查不到，继续轮询只是白白打 IPP 请求。
+	jobTrackMaxAge = 7 * 24 * time.Hour
+)
+
+func startJobTracker(s *store.Store) {
+	poller := &ipp.JobPoller{
+		Interval: jobTrackInterval,
+		Pending: func(ctx context.Context) ([]ipp.TrackedJob, error) {
+			return listTrackedJobs(ctx, s, time.Now())
+		},
+		Update: func(ctx context.Context, job ipp.TrackedJob, st *ipp.JobStatus, lookupErr error) error {
+			upd := jobStateUpdate(st, time.Now())
+			return s.WithTx(ctx, false, func(tx *sql.Tx) error {
+				return store.UpdatePrintJobState(ctx, tx, job.Key, upd)
+			})
+		},
+	}
+	go poller.Run(context.Background())
+}
+
+func listTrackedJobs(ctx context.Context, s *store.Store, now time.Time) ([]ipp.TrackedJob, error) {
+	since := now.Add(-jobTrackMaxAge).UTC().Format(time.RFC3339)
+	var jobs []ipp.TrackedJob
+	err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+		records, err := store.ListSubmittedPrintRecords(ctx, tx, since)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			jobID := ipp.ParseJobID(rec.JobID.String)
+			if jobID == 0 {
+				continue
+			}
+			jobs = append(jobs, ipp.TrackedJob{
+				Key:        rec.ID,
+				PrinterURI: rec.PrinterURI,
+				JobID:      jobID,
+				Username:   rec.Username,
+			})
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// refreshJobState 立即查询一次作业状态并回写记录，用于取消失败等需要马上
+// 拿到真实状态、等不及下一轮轮询的场景。查询失败时静默放弃，交给跟踪器兜底。
+func refreshJobState(ctx context.Context, rec store.PrintRecord, jobID int) {
+	st, err := ipp.GetJobAttributes(rec.PrinterURI, jobID, rec.Username)
+	if err != nil && !ipp.IsNotFound(err) {
+		return
+	}
+	_ = appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+		return store.UpdatePrintJobState(ctx, tx, rec.ID, jobStateUpdate(st, time.Now()))
+	})
+}
+
+// jobStateUpdate 把一次 Get-Job-Attributes 观测结果转换成记录更新。st 为 nil
+// 表示 CUPS 已查不到该作业，此时记录标为 unknown，不再继续轮询。
+func jobStateUpdate(st *ipp.JobStatus, now time.Time) store.JobStateUpdate {
+	if st == nil {
+		return store.JobStateUpdate{Status: store.PrintStatusUnknown, JobState: "unknown"}
+	}
+	upd := store.JobStateUpdate{
+		Status:               printStatusForJobState(st.State),
+		JobState:             st.State,
+		JobStateReasons:      strings.Join(st.StateReasons, ","),
+		ImpressionsCompleted: st.ImpressionsCompleted,
+	}
+	if st.Terminal() {
+		completed := st.CompletedAt
+		if completed.IsZero() {
+			completed = now
+		}
+		upd.CompletedAt = completed.UTC().Format(time.RFC3339)
+	}
+	return upd
+}
+
+// printStatusForJobState 把 IPP job-state 映射为记录 status。held 返回空串保持
+// 原 status；其余非终态映射为 submitted，这样在 CUPS 侧被直接释放的安全打印
+// 作业也会从 held 推进到 submitted。
+func printStatusForJobState(state string) string {
+	switch state {
+	case ipp.JobStateCompleted:
+		return store.PrintStatusPrinted
+	case ipp.JobStateAborted:
+		return store.PrintStatusFailed
+	case ipp.JobStateCanceled:
+		return store.PrintStatusCancelled
+	case ipp.JobStateHeld:
+		return ""
+	default:
+		return store.PrintStatusSubmitted
+	}
+}
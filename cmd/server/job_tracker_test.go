@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+func TestPrintStatusForJobState(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{ipp.JobStateCompleted, store.PrintStatusPrinted},
+		{ipp.JobStateAborted, store.PrintStatusFailed},
+		{ipp.JobStateCanceled, store.PrintStatusCancelled},
+		// 挂起中的安全打印作业保持 held，不能被推进成 submitted。
+		{ipp.JobStateHeld, ""},
+		// 在 CUPS 侧被直接释放的作业进入 pending/processing，记录应推进到 submitted。
+		{ipp.JobStatePending, store.PrintStatusSubmitted},
+		{ipp.JobStateProcessing, store.PrintStatusSubmitted},
+		{ipp.JobStateStopped, store.PrintStatusSubmitted},
+	}
+	for _, tt := range tests {
+		if got := printStatusForJobState(tt.state); got != tt.want {
+			t.Errorf("printStatusForJobState(%q) = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestJobStateUpdate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	if upd := jobStateUpdate(nil, now); upd.Status != store.PrintStatusUnknown {
+		t.Errorf("nil status should mark record unknown, got %+v", upd)
+	}
+
+	upd := jobStateUpdate(&ipp.JobStatus{State: ipp.JobStateCompleted, StateReasons: []string{"job-completed-successfully"}}, now)
+	if upd.Status != store.PrintStatusPrinted || upd.CompletedAt != "2024-05-01T08:00:00Z" {
+		t.Errorf("completed without date-time-at-completed should fall back to now, got %+v", upd)
+	}
+
+	upd = jobStateUpdate(&ipp.JobStatus{State: ipp.JobStateProcessing, ImpressionsCompleted: 2}, now)
+	if upd.CompletedAt != "" || upd.ImpressionsCompleted != 2 {
+		t.Errorf("processing job must not get a completion time, got %+v", upd)
+	}
+}
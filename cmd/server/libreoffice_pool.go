@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ── 常驻 LibreOffice 转换进程池 ─────────────────────────────────────────────────
+//
+// 每次 `libreoffice --headless --convert-to` 都要冷启动整个 office，ARM 小主机上
+// 要好几秒。设置 LIBREOFFICE_WORKERS>0 后，启动时拉起对应数量的 unoserver（各自
+// 独立的端口与 user profile），转换时用 unoconvert 把文档交给空闲的常驻实例。
+//
+//   - 转换超时或转换后实例已不响应（崩溃、卡死）时在后台重启，进程意外退出同样会被发现
+//     并重启；文档本身转换失败而实例完好时照常放回，不重启；
+//   - 每个实例转换满 LIBREOFFICE_WORKER_MAX_JOBS 次后回收重启，避免内存越涨越多；
+//   - 池未启用、unoserver 未安装、没有存活实例或等不到空闲实例时，convertOfficeToPDF
+//     回退到一次性 CLI。池内转换失败不再回退，避免同一个坏文档转两遍。
+//
+// 池内转换先占转换器调度里 libreoffice 的名额再取空闲实例，并发上限与排队统计保持一致，
+// 排队期间也不会白占实例。
+
+const (
+	defaultLOWorkerMaxJobs = 200
+	// 等待 unoserver 开始监听的最长时间，首次启动要初始化 profile，比较慢。
+	loWorkerStartTimeout = 60 * time.Second
+	// 实例启动失败后的重试间隔。
+	loWorkerRetryInterval = 30 * time.Second
+	// 停止实例时先发中断让 unoserver 带走 soffice，超过这个时间再强杀。
+	loWorkerStopGrace = 10 * time.Second
+	// 拿到调度名额后等空闲实例的最长时间，超过就回退到一次性 CLI（例如实例都在重启）。
+	loWorkerIdleWait = 10 * time.Second
+)
+
+var errLOPoolUnavailable = errors.New("libreoffice worker pool unavailable")
+
+type loWorker struct {
+	id         int
+	port       int // unoserver 的 XML-RPC 端口，unoconvert 连这里
+	unoPort    int
+	profileDir string
+	cmd        *exec.Cmd
+	exited     chan struct{} // 进程退出后被 close
+	jobs       int
+}
+
+type loPool struct {
+	maxJobs  int
+	idleWait time.Duration // 拿到名额后等空闲实例的上限，见 loWorkerIdleWait
+	idle     chan *loWorker
+	alive    atomic.Int32 // 已启动且未被回收的实例数
+	restarts atomic.Int64
+}
+
+// libreOfficePool 在 startLibreOfficePool 之后只读；nil 表示未启用。
+var libreOfficePool *loPool
+
+func envNonNegativeInt(name string, def int) int {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("invalid %s %q, using %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// startLibreOfficePool 按 LIBREOFFICE_WORKERS 启动进程池，实例在后台逐个就绪。
+func startLibreOfficePool() {
+	n := envNonNegativeInt("LIBREOFFICE_WORKERS", 0)
+	if n == 0 {
+		return
+	}
+	for _, bin := range []string{"unoserver", "unoconvert"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			log.Printf("[lo-pool] %s not found, LIBREOFFICE_WORKERS ignored, using one-shot conversion", bin)
+			return
+		}
+	}
+	maxJobs := envNonNegativeInt("LIBREOFFICE_WORKER_MAX_JOBS", defaultLOWorkerMaxJobs)
+	if maxJobs == 0 {
+		maxJobs = defaultLOWorkerMaxJobs
+	}
+	p := &loPool{maxJobs: maxJobs, idleWait: loWorkerIdleWait, idle: make(chan *loWorker, n)}
+	for i := range n {
+		go p.bringUp(&loWorker{id: i + 1})
+	}
+	libreOfficePool = p
+	log.Printf("[lo-pool] starting %d libreoffice workers (recycle after %d conversions)", n, maxJobs)
+}
+
+// bringUp 反复尝试启动 w，成功后放回空闲队列。
+func (p *loPool) bringUp(w *loWorker) {
+	for {
+		err := w.start()
+		if err == nil {
+			p.alive.Add(1)
+			p.idle <- w
+			return
+		}
+		log.Printf("[lo-pool] worker %d failed to start: %v, retrying in %s", w.id, err, loWorkerRetryInterval)
+		time.Sleep(loWorkerRetryInterval)
+	}
+}
+
+// recycle 下线 w 并在后台重新拉起。
+func (p *loPool) recycle(w *loWorker, reason string) {
+	p.alive.Add(-1)
+	p.restarts.Add(1)
+	log.Printf("[lo-pool] restarting worker %d: %s", w.id, reason)
+	go func() {
+		w.stop()
+		p.bringUp(w)
+	}()
+}
+
+func freeLocalPort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (w *loWorker) addr() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(w.port))
+}
+
+func (w *loWorker) start() error {
+	var err error
+	if w.port, err = freeLocalPort(); err != nil {
+		return err
+	}
+	if w.unoPort, err = freeLocalPort(); err != nil {
+		return err
+	}
+	if w.profileDir, err = os.MkdirTemp("", "lo-worker-"); err != nil {
+		return err
+	}
+	w.jobs = 0
+	w.exited = make(chan struct{})
+	w.cmd = exec.Command("unoserver",
+		"--interface", "127.0.0.1", "--port", strconv.Itoa(w.port),
+		"--uno-interface", "127.0.0.1", "--uno-port", strconv.Itoa(w.unoPort),
+		"--user-installation", (&url.URL{Scheme: "file", Path: w.profileDir}).String(),
+	)
+	w.cmd.Env = append(os.Environ(), "LANG=zh_CN.UTF-8", "LC_ALL=zh_CN.UTF-8")
+	// 与单次转换同样的内存、文件大小上限；常驻进程的 CPU 时间会一直累积，不能限。
+	lim := converterRlimitsFor(converterLibreOffice)
+	lim.CPUSeconds = 0
+	sandboxCommand(w.cmd, lim)
+	if err := w.cmd.Start(); err != nil {
+		_ = os.RemoveAll(w.profileDir)
+		return err
+	}
+	go func(cmd *exec.Cmd, exited chan struct{}) {
+		_ = cmd.Wait()
+		close(exited)
+	}(w.cmd, w.exited)
+
+	deadline := time.Now().Add(loWorkerStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-w.exited:
+			_ = os.RemoveAll(w.profileDir)
+			return fmt.Errorf("unoserver exited during startup")
+		default:
+		}
+		if w.healthy() {
+			log.Printf("[lo-pool] worker %d ready on port %d", w.id, w.port)
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	w.stop()
+	return fmt.Errorf("unoserver not listening after %s", loWorkerStartTimeout)
+}
+
+// healthy 检查进程仍在且端口可连。
+func (w *loWorker) healthy() bool {
+	select {
+	case <-w.exited:
+		return false
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", w.addr(), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// stop 先让 unoserver 自己关掉 soffice，超时再强杀整个进程组。
+func (w *loWorker) stop() {
+	if w.cmd != nil && w.cmd.Process != nil {
+		_ = w.cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-w.exited:
+		case <-time.After(loWorkerStopGrace):
+			_ = w.cmd.Process.Kill()
+			<-w.exited
+		}
+		_ = killProcessGroup(w.cmd)
+	}
+	if w.profileDir != "" {
+		_ = os.RemoveAll(w.profileDir)
+	}
+}
+
+// convert 把 inputPath 交给一个空闲实例转成 PDF，返回值约定同 runLibreOfficeConvert。
+// 池未启用、没有存活实例或 idleWait 内等不到空闲实例时返回 errLOPoolUnavailable。
+func (p *loPool) convert(ctx context.Context, inputPath string) (string, func(), error) {
+	if p == nil || p.alive.Load() == 0 {
+		return "", nil, errLOPoolUnavailable
+	}
+	release, err := sharedConverterScheduler().acquire(ctx, converterLibreOffice)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
+	var w *loWorker
+	timer := time.NewTimer(p.idleWait)
+	defer timer.Stop()
+	select {
+	case w = <-p.idle:
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	case <-timer.C:
+		return "", nil, errLOPoolUnavailable
+	}
+	if !w.healthy() {
+		p.recycle(w, "not responding")
+		return "", nil, errLOPoolUnavailable
+	}
+
+	tmpDir, err := os.MkdirTemp("", "convert-")
+	if err != nil {
+		p.idle <- w
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(tmpDir) }
+	base := filepath.Base(inputPath)
+	outPath := filepath.Join(tmpDir, strings.TrimSuffix(base, filepath.Ext(base))+".pdf")
+
+	out, err := execConverterCmd(ctx, converterLibreOffice, func(ctx context.Context, _ string) *exec.Cmd {
+		return exec.CommandContext(ctx, "unoconvert",
+			"--host", "127.0.0.1", "--port", strconv.Itoa(w.port),
+			"--convert-to", "pdf", inputPath, outPath)
+	})
+	if err == nil {
+		if st, statErr := os.Stat(outPath); statErr != nil || st.Size() == 0 {
+			err = fmt.Errorf("conversion produced no PDF")
+		}
+	}
+	if err != nil {
+		cleanup()
+		// 超时的实例可能已经卡在这个文档上，不响应的已经崩了，都换新的；
+		// 只是文档转换失败时实例完好，放回去继续用。
+		var ce *converterError
+		switch {
+		case errors.As(err, &ce) && ce.TimedOut:
+			p.recycle(w, "conversion timed out")
+		case !w.healthy():
+			p.recycle(w, "crashed during conversion")
+		default:
+			p.idle <- w
+		}
+		return "", nil, fmt.Errorf("worker %d: %w - %s", w.id, err, string(out))
+	}
+
+	w.jobs++
+	if w.jobs >= p.maxJobs {
+		p.recycle(w, fmt.Sprintf("reached %d conversions", w.jobs))
+	} else {
+		p.idle <- w
+	}
+	return outPath, cleanup, nil
+}
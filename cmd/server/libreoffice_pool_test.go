@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLibreOfficePoolUnavailable(t *testing.T) {
+	// 未启用，或实例都还没就绪/已下线时，立即让调用方回退到一次性 CLI，而不是干等。
+	var disabled *loPool
+	if _, _, err := disabled.convert(context.Background(), "in.docx"); !errors.Is(err, errLOPoolUnavailable) {
+		t.Errorf("nil pool err = %v", err)
+	}
+	starting := &loPool{maxJobs: 1, idle: make(chan *loWorker, 2)}
+	if _, _, err := starting.convert(context.Background(), "in.docx"); !errors.Is(err, errLOPoolUnavailable) {
+		t.Errorf("pool without live workers err = %v", err)
+	}
+
+	// 实例都忙或都在重启时，等不到空闲实例也要回退，而不是一直卡住。
+	busy := &loPool{maxJobs: 1, idleWait: 20 * time.Millisecond, idle: make(chan *loWorker, 1)}
+	busy.alive.Store(1)
+	if _, _, err := busy.convert(context.Background(), "in.docx"); !errors.Is(err, errLOPoolUnavailable) {
+		t.Errorf("pool without idle workers err = %v", err)
+	}
+}
+
+func TestEnvNonNegativeInt(t *testing.T) {
+	for _, tt := range []struct {
+		v    string
+		want int
+	}{{"", 7}, {"3", 3}, {"0", 0}, {"-1", 7}, {"x", 7}} {
+		t.Setenv("LO_TEST_INT", tt.v)
+		if got := envNonNegativeInt("LO_TEST_INT", 7); got != tt.want {
+			t.Errorf("envNonNegativeInt(%q) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -32,18 +35,98 @@ var (
 	lastSweep       time.Time
 )
 
-// clientIP 提取请求来源 IP。优先取 X-Forwarded-For 的第一跳（部署在反向代理后
-// 时 RemoteAddr 会是代理地址），否则回退到 RemoteAddr。
-func clientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		if first, _, ok := strings.Cut(xff, ","); ok {
-			return strings.TrimSpace(first)
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxiesInst []netip.Prefix
+)
+
+// defaultTrustedProxies 是未设置 TRUSTED_PROXIES 时信任的代理网段：本机与私有地址，
+// 覆盖同机或容器网络里的反向代理这类常见部署。
+const defaultTrustedProxies = "127.0.0.0/8,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+// trustedProxies 返回 TRUSTED_PROXIES 配置的反向代理地址（逗号分隔的 IP 或 CIDR）。
+// 未设置时信任 defaultTrustedProxies，设为 none 时不信任任何代理。
+func trustedProxies() []netip.Prefix {
+	trustedProxiesOnce.Do(func() {
+		v := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+		if v == "" {
+			v = defaultTrustedProxies
+		}
+		trustedProxiesInst = parseTrustedProxies(v)
+	})
+	return trustedProxiesInst
+}
+
+// parseTrustedProxies 解析 "10.0.0.1,172.16.0.0/12" 形式的列表，非法项记日志后忽略；
+// "none" 表示空列表。
+func parseTrustedProxies(v string) []netip.Prefix {
+	var out []netip.Prefix
+	if strings.EqualFold(strings.TrimSpace(v), "none") {
+		return out
+	}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				log.Printf("invalid TRUSTED_PROXIES entry %q, ignored", item)
+				continue
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			log.Printf("invalid TRUSTED_PROXIES entry %q, ignored", item)
+			continue
 		}
-		return strings.TrimSpace(xff)
+		out = append(out, prefix.Masked())
 	}
+	return out
+}
+
+func isTrustedProxy(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 提取请求来源 IP，用作限流键。X-Forwarded-For 可由客户端任意伪造，
+// 只有连接直接来自 TRUSTED_PROXIES 里的反向代理时才采信：从右往左跳过受信任的
+// 代理，取第一个不受信任的地址。连接不是来自受信任代理时使用 RemoteAddr。
+func clientIP(r *http.Request) string {
+	return clientIPWith(r, trustedProxies())
+}
+
+func clientIPWith(r *http.Request, proxies []netip.Prefix) string {
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return r.RemoteAddr
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(proxies, host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(proxies, hop) {
+			return hop
+		}
+		host = hop
 	}
 	return host
 }
@@ -71,6 +154,11 @@ func loginAllowed(key string) (bool, time.Duration) {
 
 // registerLoginFailure 记录一次失败，达到阈值则锁定。
 func registerLoginFailure(key string) {
+	registerFailure(key, maxLoginFailures)
+}
+
+// registerFailure 记录一次失败，窗口内达到 limit 次则锁定。
+func registerFailure(key string, limit int) {
 	now := time.Now()
 	loginAttemptsMu.Lock()
 	defer loginAttemptsMu.Unlock()
@@ -81,11 +169,34 @@ func registerLoginFailure(key string) {
 		loginAttempts[key] = a
 	}
 	a.failures++
-	if a.failures >= maxLoginFailures {
+	if a.failures >= limit {
 		a.lockUntil = now.Add(loginLockout)
 	}
 }
 
+// registerBackoffFailure 记录一次失败，窗口内超过 after 次后每次失败把下一次允许
+// 尝试的时间依次推迟 1s、2s、4s……，最长 maxDelay。与 registerFailure 的长时间锁定
+// 不同，只是放慢尝试速度，别人无法借此把该键长期锁死。
+func registerBackoffFailure(key string, after int, maxDelay time.Duration) {
+	now := time.Now()
+	loginAttemptsMu.Lock()
+	defer loginAttemptsMu.Unlock()
+
+	a := loginAttempts[key]
+	if a == nil || now.After(a.windowEnd) {
+		a = &loginAttempt{windowEnd: now.Add(loginFailWindow)}
+		loginAttempts[key] = a
+	}
+	a.failures++
+	if extra := a.failures - after; extra > 0 {
+		delay := maxDelay
+		if extra <= 16 {
+			delay = min(time.Second<<(extra-1), maxDelay)
+		}
+		a.lockUntil = now.Add(delay)
+	}
+}
+
 // clearLoginFailures 在登录成功后清除计数。
 func clearLoginFailures(key string) {
 	loginAttemptsMu.Lock()
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -13,7 +12,6 @@ import (
 
 	"cups-web/frontend"
 	"cups-web/internal/auth"
-	"cups-web/internal/ipp"
 	"cups-web/internal/middleware"
 	"cups-web/internal/server"
 	"cups-web/internal/store"
@@ -59,6 +57,10 @@ func main() {
 		log.Fatal("failed to create uploads dir: ", err)
 	}
 
+	if err := configureCUPSClient(); err != nil {
+		log.Fatal("failed to configure cups client: ", err)
+	}
+
 	if err := auth.SetupSecureCookie(appStore.DB); err != nil {
 		log.Fatal("failed to setup secure cookie: ", err)
 	}
@@ -78,36 +80,33 @@ func main() {
 	// 公开的版本接口：前端在登录页与主界面 footer 上展示，
 	// 用户二进制覆盖升级后无需登录即可确认当前运行版本（Issue #26）。
 	api.HandleFunc("/version", VersionHandler).Methods("GET")
+	// 安全打印释放页（打印机旁的公共终端）凭 PIN 释放挂起作业，无需登录。
+	api.HandleFunc("/release", pinReleaseHandler).Methods("POST")
 
 	protected := api.PathPrefix("").Subrouter()
 	protected.Use(middleware.RequireSession)
 	protected.Use(middleware.ValidateCSRF)
 	protected.HandleFunc("/me", MeHandler).Methods("GET")
-	protected.HandleFunc("/printers", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-
-		cupsHost := os.Getenv("CUPS_HOST")
-		if cupsHost == "" {
-			cupsHost = "localhost"
-		}
-
-		printers, err := ipp.ListPrinters(cupsHost)
-		if err != nil {
-			log.Printf("[printers] list failed: %v", err)
-			http.Error(w, "failed to list printers", http.StatusInternalServerError)
-			return
-		}
-
-		json.NewEncoder(w).Encode(printers)
-	}).Methods("GET")
+	protected.HandleFunc("/me/ledger", myLedgerHandler).Methods("GET")
+	protected.HandleFunc("/printers", listPrintersHandler).Methods("GET")
+	protected.HandleFunc("/printers/capabilities", printerCapabilitiesHandler).Methods("GET")
+	protected.HandleFunc("/printers/{name}/jobs", printerJobsHandler).Methods("GET")
 	protected.HandleFunc("/print", printHandler).Methods("POST")
+	// 打印在工作池里异步执行，/print 回 202 + taskId，这里轮询进度与结果。
+	protected.HandleFunc("/print-tasks/{id:[A-Za-z0-9]+}", printTaskHandler).Methods("GET")
 	protected.HandleFunc("/convert", convertHandler).Methods("POST")
 	protected.HandleFunc("/compose", composeHandler).Methods("POST")
 	protected.HandleFunc("/estimate", estimateHandler).Methods("POST")
 	protected.HandleFunc("/print-records", printRecordsHandler).Methods("GET")
 	protected.HandleFunc("/print-records/{id:[0-9]+}/file", printRecordFileHandler).Methods("GET")
 	protected.HandleFunc("/print-records/{id:[0-9]+}/reprint", reprintHandler).Methods("POST")
+	protected.HandleFunc("/print-records/{id:[0-9]+}/cancel", cancelPrintRecordHandler).Methods("POST")
+	protected.HandleFunc("/print-records/{id:[0-9]+}/release", releasePrintRecordHandler).Methods("POST")
+	protected.HandleFunc("/print-records/{id:[0-9]+}/schedule", updateScheduleHandler).Methods("PUT")
+	protected.HandleFunc("/held-jobs", heldJobsHandler).Methods("GET")
 	protected.HandleFunc("/printer-info", printerInfoHandler).Methods("GET")
+	// 打印机与作业事件的 SSE 推送，长连接，handler 内自行解除 WriteTimeout。
+	protected.HandleFunc("/events", eventsHandler).Methods("GET")
 
 	admin := api.PathPrefix("/admin").Subrouter()
 	admin.Use(middleware.RequireSession)
@@ -117,10 +116,36 @@ func main() {
 	admin.HandleFunc("/users", adminCreateUserHandler).Methods("POST")
 	admin.HandleFunc("/users/{id:[0-9]+}", adminUpdateUserHandler).Methods("PUT")
 	admin.HandleFunc("/users/{id:[0-9]+}", adminDeleteUserHandler).Methods("DELETE")
+	admin.HandleFunc("/users/{id:[0-9]+}/balance", adminAdjustBalanceHandler).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}/ledger", adminUserLedgerHandler).Methods("GET")
+	admin.HandleFunc("/prices", adminListPricesHandler).Methods("GET")
+	admin.HandleFunc("/prices", adminUpdatePricesHandler).Methods("PUT")
+	admin.HandleFunc("/users/{id:[0-9]+}/quota", adminUserQuotaHandler).Methods("GET")
+	admin.HandleFunc("/users/{id:[0-9]+}/quota-grants", adminGrantQuotaHandler).Methods("POST")
+	admin.HandleFunc("/quotas", adminListQuotasHandler).Methods("GET")
+	admin.HandleFunc("/quotas", adminSetQuotaHandler).Methods("PUT")
+	admin.HandleFunc("/quotas/{id:[0-9]+}", adminDeleteQuotaHandler).Methods("DELETE")
+	admin.HandleFunc("/quota-adjustments", adminQuotaAdjustmentsHandler).Methods("GET")
+	admin.HandleFunc("/policies", adminListPoliciesHandler).Methods("GET")
+	admin.HandleFunc("/policies", adminCreatePolicyHandler).Methods("POST")
+	admin.HandleFunc("/policies/{id:[0-9]+}", adminUpdatePolicyHandler).Methods("PUT")
+	admin.HandleFunc("/policies/{id:[0-9]+}", adminDeletePolicyHandler).Methods("DELETE")
+	admin.HandleFunc("/printers", adminListPrintersHandler).Methods("GET")
+	admin.HandleFunc("/printers", adminCreatePrinterHandler).Methods("POST")
+	admin.HandleFunc("/printers/{id:[0-9]+}", adminUpdatePrinterHandler).Methods("PUT")
+	admin.HandleFunc("/printers/{id:[0-9]+}", adminDeletePrinterHandler).Methods("DELETE")
+	admin.HandleFunc("/groups", adminListGroupsHandler).Methods("GET")
+	admin.HandleFunc("/groups", adminCreateGroupHandler).Methods("POST")
+	admin.HandleFunc("/groups/{id:[0-9]+}", adminUpdateGroupHandler).Methods("PUT")
+	admin.HandleFunc("/groups/{id:[0-9]+}", adminDeleteGroupHandler).Methods("DELETE")
+	admin.HandleFunc("/groups/{id:[0-9]+}/members", adminGroupMembersHandler).Methods("GET")
+	admin.HandleFunc("/groups/{id:[0-9]+}/members", adminSetGroupMembersHandler).Methods("PUT", "POST")
+	admin.HandleFunc("/groups/{id:[0-9]+}/members/{userId:[0-9]+}", adminRemoveGroupMemberHandler).Methods("DELETE")
 	admin.HandleFunc("/print-records", adminPrintRecordsHandler).Methods("GET")
 	admin.HandleFunc("/settings", adminGetSettingsHandler).Methods("GET")
 	admin.HandleFunc("/settings", adminUpdateSettingsHandler).Methods("PUT")
 	admin.HandleFunc("/cleanup", adminCleanupHandler).Methods("POST")
+	admin.HandleFunc("/converters", adminConvertersHandler).Methods("GET")
 	admin.HandleFunc("/drivers", adminListDriversHandler).Methods("GET")
 	admin.HandleFunc("/drivers/install", adminInstallDriverHandler).Methods("POST")
 	admin.HandleFunc("/drivers/remove", adminRemoveDriverHandler).Methods("POST")
@@ -149,6 +174,11 @@ func main() {
 	}
 
 	startMaintenance(appStore, uploadDir)
+	startJobTracker(appStore)
+	startScheduler(appStore)
+	startPrintWorkers(printWorkerCount())
+	startLibreOfficePool()
+	startEventNotifier(appStore)
 
 	fmt.Println("listening on", addr)
 	log.Fatal(srv.ListenAndServe())
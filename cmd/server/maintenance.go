@@ -17,6 +17,9 @@ func startMaintenance(s *store.Store, uploads string) {
 			if err := cleanupOldPrints(context.Background(), s, uploads, time.Now()); err != nil {
 				log.Println("cleanup failed:", err)
 			}
+			if err := cancelExpiredHolds(context.Background(), s, time.Now()); err != nil {
+				log.Println("cancel expired holds failed:", err)
+			}
 			time.Sleep(1 * time.Hour)
 		}
 	}()
@@ -25,7 +28,10 @@ func startMaintenance(s *store.Store, uploads string) {
 func cleanupAllPrints(ctx context.Context, s *store.Store, uploads string) (int, error) {
 	var paths []string
 	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
-		rows, err := tx.QueryContext(ctx, "SELECT stored_path FROM print_jobs")
+		// 多文档作业的成员文档、定时打印的待打印文件随记录级联删除，文件也要一并清理。
+		rows, err := tx.QueryContext(ctx, `SELECT stored_path FROM print_jobs
+			UNION SELECT stored_path FROM print_job_documents
+			UNION SELECT print_path FROM print_schedules`)
 		if err != nil {
 			return err
 		}
@@ -88,7 +94,10 @@ func cleanupOldPrints(ctx context.Context, s *store.Store, uploads string, now t
 	cutoff := now.AddDate(0, 0, -int(retentionDays)).UTC().Format(time.RFC3339)
 	var paths []string
 	err = s.WithTx(ctx, false, func(tx *sql.Tx) error {
-		rows, err := tx.QueryContext(ctx, "SELECT stored_path FROM print_jobs WHERE created_at < ?", cutoff)
+		// 尚未执行的定时打印不受保留期限影响。
+		rows, err := tx.QueryContext(ctx, `SELECT stored_path FROM print_jobs WHERE created_at < ? AND status <> 'scheduled'
+			UNION SELECT d.stored_path FROM print_job_documents d
+			JOIN print_jobs p ON p.id = d.print_job_id WHERE p.created_at < ? AND p.status <> 'scheduled'`, cutoff, cutoff)
 		if err != nil {
 			return err
 		}
@@ -104,7 +113,7 @@ func cleanupOldPrints(ctx context.Context, s *store.Store, uploads string, now t
 			return err
 		}
 		rows.Close()
-		_, err = tx.ExecContext(ctx, "DELETE FROM print_jobs WHERE created_at < ?", cutoff)
+		_, err = tx.ExecContext(ctx, "DELETE FROM print_jobs WHERE created_at < ? AND status <> 'scheduled'", cutoff)
 		return err
 	})
 	if err != nil {
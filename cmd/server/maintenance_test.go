@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cups-web/internal/store"
+)
+
+func openTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	s, err := store.Open(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestCleanupKeepsQuotaUsage(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.Local)
+
+	var user store.User
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		var err error
+		if user, err = store.CreateUser(ctx, tx, store.CreateUserInput{Username: "dave", PasswordHash: "x", Role: store.RoleUser}); err != nil {
+			return err
+		}
+		if err := store.SetSettingInt(ctx, tx, store.SettingRetentionDays, 7); err != nil {
+			return err
+		}
+		// 本月内但早于保留期限的记录：清理后已用配额不能被退回。
+		old := now.AddDate(0, 0, -10).UTC().Format(time.RFC3339)
+		for _, rec := range []store.PrintRecord{
+			{IsColor: true, Impressions: 4, Status: store.PrintStatusPrinted, CreatedAt: old},
+			{IsColor: false, Impressions: 6, Status: store.PrintStatusPrinted, CreatedAt: old},
+			{IsColor: false, Impressions: 9, Status: store.PrintStatusCancelled, CreatedAt: old},
+		} {
+			rec.UserID = user.ID
+			if _, err := store.InsertPrintRecord(ctx, tx, &rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkUsed := func(stage string) {
+		t.Helper()
+		var st store.QuotaStatus
+		if err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+			var err error
+			st, err = store.GetQuotaStatus(ctx, tx, user, store.QuotaPeriodMonth, now, 0)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if st.ColorUsed != 4 || st.MonoUsed != 6 {
+			t.Errorf("%s: used color=%d mono=%d, want 4 / 6", stage, st.ColorUsed, st.MonoUsed)
+		}
+	}
+
+	checkUsed("before cleanup")
+	if err := cleanupOldPrints(ctx, s, t.TempDir(), now); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM print_jobs`).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("records after cleanup = %d, %v, want 0", left, err)
+	}
+	checkUsed("after retention cleanup")
+
+	if _, err := cleanupAllPrints(ctx, s, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	checkUsed("after clearing history")
+}
@@ -512,8 +512,10 @@ func renderPDFToImages(ctx context.Context, pdfPath string, numPages int, tmpDir
 			"-sOutputFile=" + outPath,
 			pdfPath,
 		}
-		cmd := exec.CommandContext(ctx, gsBin, args...)
-		if out, err := cmd.CombinedOutput(); err != nil {
+		out, err := runConverterCmd(ctx, converterGhostscript, func(ctx context.Context, _ string) *exec.Cmd {
+			return exec.CommandContext(ctx, gsBin, args...)
+		})
+		if err != nil {
 			return nil, fmt.Errorf("gs render page %d failed: %w - %s", p, err, string(out))
 		}
 
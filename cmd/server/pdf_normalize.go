@@ -207,9 +207,11 @@ func tryGhostscriptRun(ctx context.Context, gsBin string, extraArgs []string, in
 	args = append(args, "-sOutputFile="+outPath, inputPath)
 
 	start := time.Now()
-	cmd := exec.CommandContext(ctx, gsBin, args...)
-	cmd.Env = append(os.Environ(), "LANG=C.UTF-8", "LC_ALL=C.UTF-8")
-	out, err := cmd.CombinedOutput()
+	out, err := runConverterCmd(ctx, converterGhostscript, func(ctx context.Context, _ string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, gsBin, args...)
+		cmd.Env = append(os.Environ(), "LANG=C.UTF-8", "LC_ALL=C.UTF-8")
+		return cmd
+	})
 	combinedStr := string(out)
 	if err != nil {
 		cleanup()
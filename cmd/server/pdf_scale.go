@@ -96,8 +96,9 @@ func scalePDFByPercent(ctx context.Context, inputPath, outputPath string, percen
 		"-f", inputPath,
 	}
 
-	cmd := exec.CommandContext(ctx, "gs", args...)
-	out, err := cmd.CombinedOutput()
+	out, err := runConverterCmd(ctx, converterGhostscript, func(ctx context.Context, _ string) *exec.Cmd {
+		return exec.CommandContext(ctx, "gs", args...)
+	})
 	if err != nil {
 		log.Printf("[scale] ghostscript failed: %v, output: %s", err, out)
 		return err
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// ── 打印策略 ──────────────────────────────────────────────────────────────────
+//
+// 管理员按全体用户、角色、用户组或单个用户（可再限定打印机）配置策略：强制双面、强制黑白、
+// 份数上限、页数上限、强制带用户名水印。打印与重打在预检之前套用命中的全部策略：
+// action 为 override 的规则直接改写选项并在 printResp.policyOverrides 里说明，
+// action 为 reject 的规则在违反时拒绝（403）。页数要等转换完才知道，超限时无论哪种
+// action 都拒绝，在建记录与计费之前检查。
+
+// policyOverride 描述一次被策略改写或拒绝的选项，Field 为 /api/print 的表单字段名。
+type policyOverride struct {
+	Field   string `json:"field"`
+	Policy  string `json:"policy"`
+	Message string `json:"message"`
+}
+
+// printPolicyResult 是套用策略后的结果：被改写的选项，以及转换后还要检查的页数上限。
+// RequireWatermark 为 true 时水印是强制的，流水线加不上水印就让作业失败。
+type printPolicyResult struct {
+	Overrides        []policyOverride
+	MaxPages         int
+	RequireWatermark bool
+	maxPolicy        string
+}
+
+// checkPages 检查文档总页数是否超过策略的页数上限。
+func (p printPolicyResult) checkPages(pages int) error {
+	if p.MaxPages > 0 && pages > p.MaxPages {
+		return &printDocumentError{http.StatusForbidden, fmt.Sprintf(
+			"print policy %q: document has %d pages, at most %d allowed", p.maxPolicy, pages, p.MaxPages)}
+	}
+	return nil
+}
+
+func policyLabel(p store.PrintPolicy) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return "#" + strconv.FormatInt(p.ID, 10)
+}
+
+// applyPrintPolicies 依次套用策略，改写 opts 与 watermark，返回改写记录与违反 reject
+// 规则的项。份数取所有规则里最小的上限；水印要求包含用户名，不含时追加。
+func applyPrintPolicies(policies []store.PrintPolicy, username string, opts *ipp.PrintJobOptions, watermark *string) (result printPolicyResult, violations []policyOverride) {
+	for _, p := range policies {
+		label := policyLabel(p)
+		enforce := func(field, violated, overridden string, fix func()) {
+			if p.Action == store.PolicyActionReject {
+				violations = append(violations, policyOverride{Field: field, Policy: label, Message: violated})
+				return
+			}
+			fix()
+			result.Overrides = append(result.Overrides, policyOverride{Field: field, Policy: label, Message: overridden})
+		}
+		if p.ForceDuplex && !opts.IsDuplex {
+			enforce("duplex", "duplex printing is required", "switched to duplex", func() { opts.IsDuplex = true })
+		}
+		if p.ForceMono && opts.IsColor {
+			enforce("color", "color printing is not allowed", "switched to monochrome", func() { opts.IsColor = false })
+		}
+		if p.MaxCopies > 0 && opts.Copies > p.MaxCopies {
+			enforce("copies", fmt.Sprintf("at most %d copies allowed", p.MaxCopies),
+				fmt.Sprintf("copies capped at %d", p.MaxCopies), func() { opts.Copies = p.MaxCopies })
+		}
+		if p.RequireWatermark {
+			result.RequireWatermark = true
+			if !strings.Contains(*watermark, username) {
+				enforce("watermark_text", "a watermark with your username is required", "username watermark added", func() {
+					*watermark = strings.TrimSpace(*watermark + " " + username)
+				})
+			}
+		}
+		if p.MaxPages > 0 && (result.MaxPages == 0 || p.MaxPages < result.MaxPages) {
+			result.MaxPages, result.maxPolicy = p.MaxPages, label
+		}
+	}
+	return result, violations
+}
+
+// enforcePrintPolicies 查出对用户与打印机生效的策略并套用到 opts 与 watermark 上。
+// 违反 reject 规则时返回 403 的 printDocumentError。
+func enforcePrintPolicies(ctx context.Context, sess auth.Session, printer string, opts *ipp.PrintJobOptions, watermark *string) (printPolicyResult, error) {
+	var policies []store.PrintPolicy
+	err := appStore.WithTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		policies, err = store.MatchingPrintPolicies(ctx, tx, sess.UserID, sess.Role, printer)
+		return err
+	})
+	if err != nil {
+		return printPolicyResult{}, &printDocumentError{http.StatusInternalServerError, "failed to load print policies"}
+	}
+	result, violations := applyPrintPolicies(policies, sess.Username, opts, watermark)
+	if len(violations) > 0 {
+		msgs := make([]string, 0, len(violations))
+		for _, v := range violations {
+			msgs = append(msgs, fmt.Sprintf("%s (%s)", v.Message, v.Policy))
+		}
+		return result, &printDocumentError{http.StatusForbidden, "rejected by print policy: " + strings.Join(msgs, "; ")}
+	}
+	return result, nil
+}
+
+// applyPolicyCopies 在修改定时打印份数时套用策略的份数上限（其余选项已在提交时处理）。
+func applyPolicyCopies(ctx context.Context, tx *sql.Tx, owner store.User, printer string, opts *ipp.PrintJobOptions) error {
+	policies, err := store.MatchingPrintPolicies(ctx, tx, owner.ID, owner.Role, printer)
+	if err != nil {
+		return err
+	}
+	checked, watermark := *opts, owner.Username
+	_, violations := applyPrintPolicies(policies, owner.Username, &checked, &watermark)
+	for _, v := range violations {
+		if v.Field == "copies" {
+			return &printDocumentError{http.StatusForbidden, fmt.Sprintf("rejected by print policy: %s (%s)", v.Message, v.Policy)}
+		}
+	}
+	opts.Copies = checked.Copies
+	return nil
+}
+
+type printPolicyPayload struct {
+	ID               int64  `json:"id,omitempty"`
+	Name             string `json:"name"`
+	TargetType       string `json:"targetType"`
+	Target           string `json:"target"`
+	TargetName       string `json:"targetName,omitempty"` // 用户、组策略附带名称，便于展示
+	PrinterURI       string `json:"printerUri"`
+	Action           string `json:"action"`
+	ForceDuplex      bool   `json:"forceDuplex"`
+	ForceMono        bool   `json:"forceMono"`
+	MaxCopies        int    `json:"maxCopies"`
+	MaxPages         int    `json:"maxPages"`
+	RequireWatermark bool   `json:"requireWatermark"`
+	Enabled          bool   `json:"enabled"`
+	CreatedAt        string `json:"createdAt,omitempty"`
+}
+
+var errInvalidPolicy = errors.New("invalid policy")
+
+// toPrintPolicy 校验并规范化管理员提交的策略。
+func (p printPolicyPayload) toPrintPolicy(ctx context.Context, tx *sql.Tx) (store.PrintPolicy, error) {
+	policy := store.PrintPolicy{
+		ID:               p.ID,
+		Name:             strings.TrimSpace(p.Name),
+		TargetType:       p.TargetType,
+		Target:           strings.TrimSpace(p.Target),
+		PrinterURI:       strings.TrimSpace(p.PrinterURI),
+		Action:           p.Action,
+		ForceDuplex:      p.ForceDuplex,
+		ForceMono:        p.ForceMono,
+		MaxCopies:        p.MaxCopies,
+		MaxPages:         p.MaxPages,
+		RequireWatermark: p.RequireWatermark,
+		Enabled:          p.Enabled,
+	}
+	switch policy.TargetType {
+	case store.PolicyTargetAll:
+		policy.Target = ""
+	case store.PolicyTargetRole:
+		role := normalizeRole(policy.Target)
+		if policy.Target == "" || role == "" {
+			return policy, fmt.Errorf("%w: unknown role", errInvalidPolicy)
+		}
+		policy.Target = role
+	case store.PolicyTargetUser:
+		id, err := strconv.ParseInt(policy.Target, 10, 64)
+		if err != nil {
+			return policy, fmt.Errorf("%w: unknown user", errInvalidPolicy)
+		}
+		if _, err := store.GetUserByID(ctx, tx, id); err != nil {
+			return policy, fmt.Errorf("%w: unknown user", errInvalidPolicy)
+		}
+	case store.PolicyTargetGroup:
+		if err := checkGroupTarget(ctx, tx, policy.Target); err != nil {
+			return policy, fmt.Errorf("%w: %w", errInvalidPolicy, err)
+		}
+	default:
+		return policy, fmt.Errorf("%w: unknown target type", errInvalidPolicy)
+	}
+	if policy.Action != store.PolicyActionOverride && policy.Action != store.PolicyActionReject {
+		return policy, fmt.Errorf("%w: action must be override or reject", errInvalidPolicy)
+	}
+	if policy.MaxCopies < 0 || policy.MaxPages < 0 {
+		return policy, fmt.Errorf("%w: limits must be 0 (unlimited) or more", errInvalidPolicy)
+	}
+	if !policy.ForceDuplex && !policy.ForceMono && !policy.RequireWatermark && policy.MaxCopies == 0 && policy.MaxPages == 0 {
+		return policy, fmt.Errorf("%w: policy has no rules", errInvalidPolicy)
+	}
+	return policy, nil
+}
+
+// GET /api/admin/policies
+func adminListPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	var policies []store.PrintPolicy
+	var names targetNames
+	err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		if policies, err = store.ListPrintPolicies(r.Context(), tx); err != nil {
+			return err
+		}
+		names, err = loadTargetNames(r.Context(), tx)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load policies")
+		return
+	}
+	resp := make([]printPolicyPayload, 0, len(policies))
+	for _, p := range policies {
+		item := printPolicyPayload{
+			ID:               p.ID,
+			Name:             p.Name,
+			TargetType:       p.TargetType,
+			Target:           p.Target,
+			PrinterURI:       p.PrinterURI,
+			Action:           p.Action,
+			ForceDuplex:      p.ForceDuplex,
+			ForceMono:        p.ForceMono,
+			MaxCopies:        p.MaxCopies,
+			MaxPages:         p.MaxPages,
+			RequireWatermark: p.RequireWatermark,
+			Enabled:          p.Enabled,
+			CreatedAt:        p.CreatedAt,
+			TargetName:       names.name(p.TargetType, p.Target),
+		}
+		resp = append(resp, item)
+	}
+	writeJSON(w, resp)
+}
+
+// POST /api/admin/policies
+func adminCreatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload printPolicyPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	var id int64
+	err := appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		policy, err := payload.toPrintPolicy(r.Context(), tx)
+		if err != nil {
+			return err
+		}
+		id, err = store.CreatePrintPolicy(r.Context(), tx, &policy)
+		return err
+	})
+	switch {
+	case errors.Is(err, errInvalidPolicy):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to create policy")
+	default:
+		writeJSON(w, map[string]any{"ok": true, "id": id})
+	}
+}
+
+// PUT /api/admin/policies/{id}
+func adminUpdatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var payload printPolicyPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	payload.ID = id
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		policy, err := payload.toPrintPolicy(r.Context(), tx)
+		if err != nil {
+			return err
+		}
+		return store.UpdatePrintPolicy(r.Context(), tx, policy)
+	})
+	switch {
+	case errors.Is(err, errInvalidPolicy):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "policy not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to update policy")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
+
+// DELETE /api/admin/policies/{id}
+func adminDeletePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		return store.DeletePrintPolicy(r.Context(), tx, id)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "policy not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete policy")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+func TestApplyPrintPolicies(t *testing.T) {
+	policies := []store.PrintPolicy{
+		{ID: 1, Name: "students", Action: store.PolicyActionOverride, ForceDuplex: true, ForceMono: true, MaxCopies: 10, RequireWatermark: true},
+		{ID: 2, Action: store.PolicyActionOverride, MaxCopies: 5, MaxPages: 200},
+		{ID: 3, Action: store.PolicyActionReject, MaxPages: 100},
+	}
+	opts := ipp.PrintJobOptions{IsColor: true, Copies: 20}
+	watermark := ""
+	result, violations := applyPrintPolicies(policies, "alice", &opts, &watermark)
+	if len(violations) != 0 {
+		t.Fatalf("violations = %+v", violations)
+	}
+	if !opts.IsDuplex || opts.IsColor || opts.Copies != 5 || watermark != "alice" {
+		t.Errorf("opts = %+v, watermark = %q", opts, watermark)
+	}
+	if len(result.Overrides) != 5 || result.MaxPages != 100 || !result.RequireWatermark {
+		t.Errorf("result = %+v", result)
+	}
+	if err := result.checkPages(100); err != nil {
+		t.Errorf("checkPages(100) = %v", err)
+	}
+	if err := result.checkPages(101); err == nil {
+		t.Error("checkPages(101) should fail")
+	}
+
+	// reject 规则只报告，不改写选项；已含用户名的水印不再追加。
+	opts = ipp.PrintJobOptions{IsColor: true, Copies: 3}
+	watermark = "draft alice"
+	reject := []store.PrintPolicy{{ID: 4, Action: store.PolicyActionReject, ForceMono: true, MaxCopies: 2, RequireWatermark: true}}
+	_, violations = applyPrintPolicies(reject, "alice", &opts, &watermark)
+	if len(violations) != 2 || violations[0].Field != "color" || violations[1].Field != "copies" || violations[1].Policy != "#4" {
+		t.Errorf("violations = %+v", violations)
+	}
+	if !opts.IsColor || opts.Copies != 3 || watermark != "draft alice" {
+		t.Errorf("reject must not modify opts: %+v, %q", opts, watermark)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// printAccounting 汇总打印提交前要在建记录事务里完成的检查：页数配额与按页计费。
+// 两者都依赖打印记录（配额按记录统计已用页数，计费按记录退款），启用任一项时
+// 即使关闭了历史记录也要落库。
+type printAccounting struct {
+	billing     bool
+	quotaPeriod string
+}
+
+// printCharge 是一次计费的结果，未启用计费时两项都为 nil。
+type printCharge struct {
+	cost, balance *int64
+}
+
+func loadPrintAccounting(ctx context.Context) printAccounting {
+	return printAccounting{billing: billingEnabled(ctx), quotaPeriod: quotaPeriod(ctx)}
+}
+
+func (a printAccounting) needsRecord() bool {
+	return a.billing || a.quotaPeriod != ""
+}
+
+// apply 对刚插入的打印记录先检查配额、再计费扣款。任一不满足时返回 printDocumentError，
+// 调用方回滚事务即可连同记录一起撤销。
+func (a printAccounting) apply(ctx context.Context, tx *sql.Tx, userID, recordID int64, opts ipp.PrintJobOptions) (printCharge, error) {
+	var charge printCharge
+	if a.quotaPeriod != "" {
+		user, err := store.GetUserByID(ctx, tx, userID)
+		if err != nil {
+			return charge, err
+		}
+		if err := checkPrintQuota(ctx, tx, user, a.quotaPeriod, opts.IsColor, billableImpressions(opts), recordID); err != nil {
+			return charge, err
+		}
+	}
+	if a.billing {
+		cost, balance, err := chargePrintRecord(ctx, tx, userID, recordID, opts)
+		if err != nil {
+			return charge, err
+		}
+		charge.cost, charge.balance = &cost, &balance
+	}
+	return charge, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// isFinalPrintStatus 报告记录是否已处于终态（不可再取消）。
+func isFinalPrintStatus(status string) bool {
+	switch status {
+	case store.PrintStatusPrinted, store.PrintStatusFailed, store.PrintStatusCancelled, store.PrintStatusUnknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// POST /api/print-records/{id}/cancel — 对记录对应的 CUPS 作业发 Cancel-Job。
+// 普通用户只能取消自己的作业，管理员可取消任意作业。
+func cancelPrintRecordHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid record id")
+		return
+	}
+
+	var record store.PrintRecord
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		rec, err := store.GetPrintRecordByID(r.Context(), tx, id)
+		if err != nil {
+			return err
+		}
+		record = rec
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "record not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "failed to load record")
+		return
+	}
+	if sess.Role != store.RoleAdmin && record.UserID != sess.UserID {
+		writeJSONError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if isFinalPrintStatus(record.Status) {
+		writeJSONError(w, http.StatusConflict, "job already "+record.Status)
+		return
+	}
+	if record.Status == store.PrintStatusScheduled {
+		ok, err := cancelScheduledPrint(r.Context(), record)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "failed to cancel scheduled job")
+			return
+		}
+		if ok {
+			log.Printf("[cancel] record=%d scheduled job canceled by %s", record.ID, sess.Username)
+			writeJSON(w, map[string]any{"ok": true, "status": store.PrintStatusCancelled})
+			return
+		}
+		// 调度器已在此期间认领并提交，按普通作业处理。
+		if rec, err := reloadPrintRecord(r.Context(), record.ID); err == nil {
+			record = rec
+		}
+	}
+	jobID := ipp.ParseJobID(record.JobID.String)
+	if jobID == 0 {
+		writeJSONError(w, http.StatusConflict, "job has not been submitted to the printer")
+		return
+	}
+
+	// requesting-user-name 用作业所有者：CUPS 只允许所有者（或 CUPS 管理员）取消，
+	// 管理员代为取消他人作业时也以所有者身份发请求。
+	if err := ipp.CancelJob(record.PrinterURI, jobID, record.Username); err != nil {
+		if ipp.IsNotPossible(err) || ipp.IsNotFound(err) {
+			// 作业在此期间已经进入终态：顺手刷新一次记录，让前端看到真实结果。
+			refreshJobState(r.Context(), record, jobID)
+			writeJSONError(w, http.StatusConflict, "job already completed and cannot be canceled")
+			return
+		}
+		log.Printf("[cancel] record=%d job=%d: %v", record.ID, jobID, err)
+		writeJSONError(w, http.StatusBadGateway, "failed to cancel job")
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		return store.UpdatePrintJobState(r.Context(), tx, record.ID, store.JobStateUpdate{
+			Status:               store.PrintStatusCancelled,
+			JobState:             ipp.JobStateCanceled,
+			JobStateReasons:      "job-canceled-by-user",
+			ImpressionsCompleted: record.ImpressionsCompleted,
+			CompletedAt:          now,
+		})
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to update record")
+		return
+	}
+	log.Printf("[cancel] record=%d job=%d canceled by %s", record.ID, jobID, sess.Username)
+	writeJSON(w, map[string]any{"ok": true, "status": store.PrintStatusCancelled})
+}
+
+func reloadPrintRecord(ctx context.Context, id int64) (store.PrintRecord, error) {
+	var rec store.PrintRecord
+	err := appStore.WithTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		rec, err = store.GetPrintRecordByID(ctx, tx, id)
+		return err
+	})
+	return rec, err
+}
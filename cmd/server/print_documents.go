@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// 多文档打印：/api/print 带多个 files 字段时，全部文件作为一个 CUPS 作业提交
+// （Create-Job + 逐个 Send-Document），只有一张横幅页、装订覆盖全部文件、不会与
+// 别人的作业交错，落库为一条记录并在 print_job_documents 里列出成员文档。
+// 打印机不支持多文档作业时，把各文件转成的 PDF 合并成一个再走 Print-Job。
+
+// documentSource 是多文档作业的一个输入文件。
+type documentSource struct {
+	Filename string
+	Open     func() (io.ReadCloser, error)
+}
+
+func multipartSources(fhs []*multipart.FileHeader) []documentSource {
+	srcs := make([]documentSource, 0, len(fhs))
+	for _, fh := range fhs {
+		srcs = append(srcs, documentSource{
+			Filename: fh.Filename,
+			Open:     func() (io.ReadCloser, error) { return fh.Open() },
+		})
+	}
+	return srcs
+}
+
+// preparedDocument 是保存并转换好的一个成员文档。
+type preparedDocument struct {
+	Filename  string
+	StoredRel string
+	StoredAbs string
+	PrintPath string
+	Mime      string
+	Pages     int
+
+	cleanups []func()
+}
+
+func (d *preparedDocument) addCleanup(fn func()) {
+	if fn != nil {
+		d.cleanups = append(d.cleanups, fn)
+	}
+}
+
+// release 删除转换过程中的临时文件；removeStored 为 true 时连上传副本一并删除。
+func (d *preparedDocument) release(removeStored bool) {
+	for i := len(d.cleanups) - 1; i >= 0; i-- {
+		d.cleanups[i]()
+	}
+	d.cleanups = nil
+	if removeStored {
+		_ = os.Remove(d.StoredAbs)
+		if cRel := convertedRelPath(d.StoredRel); cRel != "" {
+			_ = os.Remove(filepath.Join(uploadDir, filepath.FromSlash(cRel)))
+		}
+	}
+}
+
+// printDocumentError 携带应返回给前端的状态码与错误信息。
+type printDocumentError struct {
+	status int
+	msg    string
+}
+
+func (e *printDocumentError) Error() string { return e.msg }
+
+// writePrintError 把打印流程的错误写回响应，printDocumentError 带自己的状态码。
+func writePrintError(w http.ResponseWriter, err error) {
+	var de *printDocumentError
+	if errors.As(err, &de) {
+		writeJSONError(w, de.status, de.msg)
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
+// saveDocument 把一个输入文件保存进 uploads。异步打印要求在请求结束前完成这一步：
+// multipart 的临时文件会随请求一起删除。
+func saveDocument(src documentSource) (*preparedDocument, error) {
+	rc, err := src.Open()
+	if err != nil {
+		var perr *printDocumentError
+		if errors.As(err, &perr) {
+			return nil, perr
+		}
+		return nil, &printDocumentError{http.StatusBadRequest, "failed to read file"}
+	}
+	storedRel, storedAbs, err := saveUploadedFile(rc, src.Filename, uploadDir)
+	rc.Close()
+	if err != nil {
+		return nil, &printDocumentError{http.StatusInternalServerError, "failed to save file"}
+	}
+	return &preparedDocument{Filename: src.Filename, StoredRel: storedRel, StoredAbs: storedAbs, PrintPath: storedAbs}, nil
+}
+
+// saveDocuments 逐个保存输入文件，任一失败时删除已保存的文件。
+func saveDocuments(srcs []documentSource) ([]*preparedDocument, error) {
+	docs := make([]*preparedDocument, 0, len(srcs))
+	for _, src := range srcs {
+		doc, err := saveDocument(src)
+		if err != nil {
+			for _, d := range docs {
+				d.release(true)
+			}
+			var de *printDocumentError
+			if errors.As(err, &de) {
+				return nil, &printDocumentError{de.status, fmt.Sprintf("%s: %s", src.Filename, de.msg)}
+			}
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// convertDocument 按文件类型把已保存的文档转换为可打印格式，失败时删除上传副本。
+func convertDocument(ctx context.Context, doc *preparedDocument, orientation, paperSize string) error {
+	storedRel, storedAbs := doc.StoredRel, doc.StoredAbs
+	fail := func(status int, msg string) error {
+		doc.release(true)
+		return &printDocumentError{status, msg}
+	}
+	// toPDF 把转换结果存入 uploads（与单文件打印一致，重打时可复用）。
+	toPDF := func(outPath string, cleanup func()) error {
+		doc.addCleanup(cleanup)
+		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
+		if err != nil {
+			return err
+		}
+		doc.PrintPath = convertedAbs
+		doc.Mime = "application/pdf"
+		return nil
+	}
+
+	var err error
+	kind := detectFileKind(storedAbs, doc.Filename)
+	switch kind {
+	case fileKindPDF:
+		pages, err := countPDFPages(storedAbs)
+		doc.Pages, doc.Mime = pages, "application/pdf"
+		if err != nil {
+			log.Printf("[print] countPDFPages %q failed: %v", doc.Filename, err)
+			doc.Pages, doc.Mime = 1, "application/octet-stream"
+		}
+	case fileKindOffice, fileKindOFD:
+		convert := convertOfficeToPDF
+		if kind == fileKindOFD {
+			convert = convertOFDToPDF
+		}
+		outPath, cleanup, err := convert(ctx, storedAbs)
+		if err != nil {
+			return fail(http.StatusBadRequest, "conversion failed")
+		}
+		if doc.Pages, err = countPDFPages(outPath); err != nil {
+			cleanup()
+			return fail(http.StatusBadRequest, "failed to read pages")
+		}
+		if err := toPDF(outPath, cleanup); err != nil {
+			return fail(http.StatusInternalServerError, "failed to save converted file")
+		}
+	case fileKindImage:
+		outPath, cleanup, err := convertImageToPDF(storedAbs, orientation, paperSize)
+		if err != nil {
+			return fail(http.StatusBadRequest, "conversion failed")
+		}
+		doc.Pages = 1
+		if err := toPDF(outPath, cleanup); err != nil {
+			return fail(http.StatusInternalServerError, "failed to save converted file")
+		}
+	case fileKindText:
+		if doc.Pages, err = estimateTextPages(storedAbs); err != nil {
+			return fail(http.StatusBadRequest, "failed to read pages")
+		}
+		outPath, cleanup, err := convertTextToPDF(storedAbs, orientation, paperSize)
+		if err != nil {
+			return fail(http.StatusBadRequest, "conversion failed")
+		}
+		if err := toPDF(outPath, cleanup); err != nil {
+			return fail(http.StatusInternalServerError, "failed to save converted file")
+		}
+	default:
+		if doc.Pages, _, err = countPages(ctx, storedAbs, doc.Filename); err != nil {
+			return fail(http.StatusBadRequest, "failed to read pages")
+		}
+	}
+	if doc.Pages < 1 {
+		doc.Pages = 1
+	}
+	return nil
+}
+
+// documentJobName 是多文档作业在 CUPS 与记录里显示的作业名。
+func documentJobName(docs []*preparedDocument) string {
+	if len(docs) == 1 {
+		return docs[0].Filename
+	}
+	return fmt.Sprintf("%s 等 %d 个文件", docs[0].Filename, len(docs))
+}
+
+// mergeDocumentsPDF 把全部文档合并为一个 PDF，用于不支持多文档作业的打印机。
+// 有非 PDF 文档（如直接提交的 PostScript）时无法合并。
+func mergeDocumentsPDF(docs []*preparedDocument) (string, func(), error) {
+	paths := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		if doc.Mime != "application/pdf" {
+			return "", nil, fmt.Errorf("%s is not a PDF", doc.Filename)
+		}
+		paths = append(paths, doc.PrintPath)
+	}
+	tmpDir, err := os.MkdirTemp("", "cups-web-merge-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	outPath := filepath.Join(tmpDir, "merged.pdf")
+	if err := api.MergeCreateFile(paths, outPath, false, conf); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("pdfcpu merge: %w", err)
+	}
+	return outPath, cleanup, nil
+}
+
+// documentJob 描述一次多文档打印。Options 是预检后的选项，其中 PrintScaling
+// 与 PageSet 仍是用户的原始选择（自定义百分比、even-reverse），落库时原样保存。
+type documentJob struct {
+	Printer     string
+	Docs        []*preparedDocument // 已由 saveDocuments 保存、尚未转换
+	Options     ipp.PrintJobOptions
+	Watermark   string
+	Normalize   bool
+	Warnings    []printOptionIssue
+	Policy      printPolicyResult
+	SaveHistory bool
+	LogTag      string
+}
+
+// runDocumentJob 转换并提交多文档作业。task 非 nil 时（异步打印）随处理推进阶段，
+// 重打走同步路径时传 nil。
+func runDocumentJob(ctx context.Context, sess auth.Session, job documentJob, task *printTask) (*printResp, error) {
+	opts := job.Options
+	origPrintScaling, origPageSet := opts.PrintScaling, opts.PageSet
+
+	docs := job.Docs
+	keepStored := false
+	defer func() {
+		for _, doc := range docs {
+			doc.release(!keepStored)
+		}
+	}()
+	// 每个文档各自走一遍打印流水线，规则与单文件打印相同。作业级的 print-scaling
+	// 与 page-set 只能统一：缩放取第一个文档的结果，任一文档重排失败则整单退回普通偶数页。
+	pipeline := newPrintPipeline()
+	for i, doc := range docs {
+		d := &pipelineDoc{
+			preparedDocument: doc,
+			Opts:             job.Options,
+			Watermark:        job.Watermark,
+			RequireWatermark: job.Policy.RequireWatermark,
+			Normalize:        job.Normalize,
+			LogTag:           job.LogTag,
+		}
+		if err := pipeline.run(ctx, d, task); err != nil {
+			var de *printDocumentError
+			if errors.As(err, &de) {
+				return nil, &printDocumentError{de.status, fmt.Sprintf("%s: %s", doc.Filename, de.msg)}
+			}
+			return nil, err
+		}
+		if i == 0 {
+			opts.PrintScaling, opts.PageSet = d.Opts.PrintScaling, d.Opts.PageSet
+		}
+		if d.Opts.PageSet == "even" {
+			opts.PageSet = "even"
+		}
+	}
+
+	totalPages := 0
+	for _, doc := range docs {
+		totalPages += doc.Pages
+	}
+	opts.Pages = totalPages
+	if err := job.Policy.checkPages(totalPages); err != nil {
+		return nil, err
+	}
+	jobName := documentJobName(docs)
+
+	// 打印机不支持多文档作业（或能力查不到）时合并为单个 PDF。
+	multiDoc := false
+	if caps, err := cachedPrinterCapabilities(job.Printer); err == nil {
+		multiDoc = caps.MultipleDocumentJobs
+	}
+	var mergedPath string
+	if !multiDoc {
+		path, mergeCleanup, err := mergeDocumentsPDF(docs)
+		if err != nil {
+			log.Printf("[%s] merge failed: %v", job.LogTag, err)
+			return nil, &printDocumentError{http.StatusBadRequest, "printer does not support multi-document jobs and the files cannot be merged: " + err.Error()}
+		}
+		defer mergeCleanup()
+		mergedPath = path
+	}
+
+	var recordID int64
+	var releasePIN string
+	var charge printCharge
+	acct := loadPrintAccounting(ctx)
+	if job.SaveHistory || opts.Hold || acct.needsRecord() {
+		err := appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+			rec := store.PrintRecord{
+				UserID:     sess.UserID,
+				PrinterURI: job.Printer,
+				Filename:   docs[0].Filename,
+				StoredPath: docs[0].StoredRel,
+				Pages:      totalPages,
+				Status:     store.PrintStatusQueued,
+				IsDuplex:   opts.IsDuplex,
+				IsColor:    opts.IsColor,
+
+				Copies:         opts.Copies,
+				Orientation:    opts.Orientation,
+				PaperSize:      opts.PaperSize,
+				PaperType:      opts.PaperType,
+				MediaSource:    opts.MediaSource,
+				PrintScaling:   origPrintScaling,
+				PageRange:      opts.PageRange,
+				PageSet:        origPageSet,
+				Mirror:         opts.Mirror,
+				WatermarkText:  job.Watermark,
+				NumberUp:       opts.NumberUp,
+				NumberUpLayout: opts.NumberUpLayout,
+				PageBorder:     opts.PageBorder,
+				Finishings:     strings.Join(opts.Finishings, ","),
+				OutputBin:      opts.OutputBin,
+				JobSheets:      opts.JobSheets,
+				Quality:        opts.Quality,
+				Resolution:     opts.Resolution,
+
+				Impressions: int(billableImpressions(opts)),
+				CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+			}
+			if opts.Hold {
+				pin, pinHash, err := newReleasePIN(ctx, tx, job.Printer)
+				if err != nil {
+					return err
+				}
+				releasePIN = pin
+				rec.HoldRelease = true
+				rec.ReleasePINHash = pinHash
+			}
+			id, err := store.InsertPrintRecord(ctx, tx, &rec)
+			if err != nil {
+				return err
+			}
+			members := make([]store.PrintDocument, 0, len(docs))
+			for _, doc := range docs {
+				members = append(members, store.PrintDocument{Filename: doc.Filename, StoredPath: doc.StoredRel, Pages: doc.Pages})
+			}
+			if err := store.InsertPrintDocuments(ctx, tx, id, members); err != nil {
+				return err
+			}
+			recordID = id
+			if charge, err = acct.apply(ctx, tx, sess.UserID, id, opts); err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, printRecordError(err)
+		}
+		// 记录引用着这些文件（重打、释放与退款），与单文件打印一样保留。
+		keepStored = true
+	}
+
+	task.setStage(printStageSending)
+	var jobRef string
+	var err error
+	if multiDoc {
+		jobRef, err = sendDocumentFiles(job.Printer, docs, sess.Username, jobName, opts)
+	} else {
+		var f *os.File
+		if f, err = os.Open(mergedPath); err == nil {
+			jobRef, err = ipp.SendPrintJob(job.Printer, f, "application/pdf", sess.Username, jobName, opts)
+			f.Close()
+		}
+	}
+	if err != nil {
+		if recordID > 0 {
+			_ = appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+				return store.UpdatePrintStatus(ctx, tx, recordID, store.PrintStatusFailed, "")
+			})
+		}
+		return nil, &printDocumentError{http.StatusInternalServerError, "print error: " + err.Error()}
+	}
+
+	if recordID > 0 {
+		_ = appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+			return store.UpdatePrintStatus(ctx, tx, recordID, submittedStatus(opts.Hold), jobRef)
+		})
+		watchJob(job.Printer, jobRef, recordID, sess.UserID)
+	}
+
+	return &printResp{
+		JobID:    jobRef,
+		OK:       true,
+		Pages:    totalPages,
+		IsDuplex: opts.IsDuplex,
+		IsColor:  opts.IsColor,
+		Copies:   opts.Copies,
+
+		Held:       opts.Hold,
+		ReleasePIN: releasePIN,
+		Warnings:   job.Warnings,
+
+		Documents: len(docs),
+		Merged:    !multiDoc,
+
+		CostCents:    charge.cost,
+		BalanceCents: charge.balance,
+
+		PolicyOverrides: job.Policy.Overrides,
+	}, nil
+}
+
+// sendDocumentFiles 以 Create-Job + Send-Document 提交全部文档。
+func sendDocumentFiles(printer string, docs []*preparedDocument, username, jobName string, opts ipp.PrintJobOptions) (string, error) {
+	members := make([]ipp.Document, 0, len(docs))
+	for _, doc := range docs {
+		f, err := os.Open(doc.PrintPath)
+		if err != nil {
+			return "", fmt.Errorf("open %s: %w", doc.Filename, err)
+		}
+		defer f.Close()
+		members = append(members, ipp.Document{Name: doc.Filename, Mime: doc.Mime, Data: f})
+	}
+	return ipp.SendDocuments(printer, members, username, jobName, opts)
+}
+
+// printOptionsFromForm 读取 /api/print 表单里的打印选项（与 printHandler 的解析规则一致）。
+func printOptionsFromForm(r *http.Request) (opts ipp.PrintJobOptions, watermark string) {
+	opts = ipp.PrintJobOptions{
+		IsDuplex:       r.FormValue("duplex") == "true",
+		IsColor:        r.FormValue("color") == "true",
+		Copies:         1,
+		Orientation:    r.FormValue("orientation"),
+		PaperSize:      r.FormValue("paper_size"),
+		PaperType:      r.FormValue("paper_type"),
+		PrintScaling:   r.FormValue("print_scaling"),
+		MediaSource:    r.FormValue("media_source"),
+		PageRange:      r.FormValue("page_range"),
+		PageSet:        r.FormValue("page_set"),
+		Mirror:         r.FormValue("mirror") == "true",
+		NumberUp:       1,
+		NumberUpLayout: r.FormValue("number_up_layout"),
+		PageBorder:     r.FormValue("page_border"),
+		Hold:           r.FormValue("hold") == "true",
+		Finishings:     parseFinishings(r.Form["finishings"]),
+		OutputBin:      r.FormValue("output_bin"),
+		JobSheets:      r.FormValue("job_sheets"),
+		Quality:        r.FormValue("print_quality"),
+		Resolution:     r.FormValue("resolution"),
+	}
+	if n, err := strconv.Atoi(r.FormValue("copies")); err == nil && n > 0 {
+		opts.Copies = n
+	}
+	if n, err := strconv.Atoi(r.FormValue("number_up")); err == nil {
+		switch n {
+		case 1, 2, 4, 6, 9, 16:
+			opts.NumberUp = n
+		}
+	}
+	return opts, strings.TrimSpace(r.FormValue("watermark_text"))
+}
+
+// printDocumentsHandler 处理带多个 files 字段的 /api/print 请求。
+func printDocumentsHandler(w http.ResponseWriter, r *http.Request, fhs []*multipart.FileHeader) {
+	printer := r.FormValue("printer")
+	if printer == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing printer field")
+		return
+	}
+	if r.FormValue("print_at") != "" {
+		writeJSONError(w, http.StatusBadRequest, "scheduled printing is not supported for multi-file jobs")
+		return
+	}
+	if len(fhs) > maxPrintDocuments {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("too many files (max %d)", maxPrintDocuments))
+		return
+	}
+	sess, _ := auth.GetSession(r)
+	if err := checkPrinterAccess(r.Context(), sess, printer); err != nil {
+		writePrintError(w, err)
+		return
+	}
+	opts, watermark := printOptionsFromForm(r)
+	policy, err := enforcePrintPolicies(r.Context(), sess, printer, &opts, &watermark)
+	if err != nil {
+		writePrintError(w, err)
+		return
+	}
+
+	// 与单文件打印一样，page-set 与镜像不参与预检（even-reverse 由服务端重排实现）。
+	pageSet, mirror := opts.PageSet, opts.Mirror
+	opts.PageSet, opts.Mirror = "", false
+	warnings, optionErrs := preflightPrintOptions(printer, sess.Username, &opts)
+	if len(optionErrs) > 0 {
+		writePreflightErrors(w, optionErrs)
+		return
+	}
+	opts.PageSet, opts.Mirror = pageSet, mirror
+
+	docs, err := saveDocuments(multipartSources(fhs))
+	if err != nil {
+		writePrintError(w, err)
+		return
+	}
+	job := documentJob{
+		Printer:     printer,
+		Docs:        docs,
+		Options:     opts,
+		Watermark:   watermark,
+		Normalize:   r.FormValue("normalize") == "true",
+		Warnings:    warnings,
+		Policy:      policy,
+		SaveHistory: saveHistoryEnabled(r.Context()),
+		LogTag:      "print-docs",
+	}
+	submitPrintTaskOrFail(w, sess, func(ctx context.Context, t *printTask) (*printResp, error) {
+		return runDocumentJob(ctx, sess, job, t)
+	}, func() {
+		for _, doc := range docs {
+			doc.release(true)
+		}
+	})
+}
+
+// maxPrintDocuments 限制一个多文档作业的文件数。
+const maxPrintDocuments = 50
+
+// saveHistoryEnabled 读取「保存打印历史」设置，读取失败时按开启处理。
+func saveHistoryEnabled(ctx context.Context) bool {
+	saveHistory := true
+	_ = appStore.WithTx(ctx, true, func(tx *sql.Tx) error {
+		v, err := store.GetSettingInt(ctx, tx, store.SettingSaveHistory, 1)
+		if err != nil {
+			return err
+		}
+		saveHistory = v != 0
+		return nil
+	})
+	return saveHistory
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"io"
-	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,6 +22,29 @@ type printResp struct {
 	IsDuplex bool   `json:"isDuplex"`
 	IsColor  bool   `json:"isColor"`
 	Copies   int    `json:"copies"`
+
+	// 安全打印：作业挂起在队列中，ReleasePIN 仅在此处返回一次（库里只存哈希）。
+	Held       bool   `json:"held,omitempty"`
+	ReleasePIN string `json:"releasePin,omitempty"`
+
+	// Warnings 列出预检时按打印机能力降级的选项，前端据此提示用户实际打印效果。
+	Warnings []printOptionIssue `json:"warnings,omitempty"`
+
+	// 多文档作业：Documents 为成员文档数；Merged 表示打印机不支持多文档作业，
+	// 已合并为单个 PDF 提交。
+	Documents int  `json:"documents,omitempty"`
+	Merged    bool `json:"merged,omitempty"`
+
+	// 定时打印：作业已保存，到 ScheduledAt 才由调度器提交，此时还没有 JobID。
+	Scheduled   bool   `json:"scheduled,omitempty"`
+	ScheduledAt string `json:"scheduledAt,omitempty"`
+
+	// 计费：本次扣费与扣费后的余额（分），未开启计费时不返回。
+	CostCents    *int64 `json:"costCents,omitempty"`
+	BalanceCents *int64 `json:"balanceCents,omitempty"`
+
+	// PolicyOverrides 列出被管理员打印策略改写的选项（强制双面、黑白、份数上限、水印）。
+	PolicyOverrides []policyOverride `json:"policyOverrides,omitempty"`
 }
 
 func printHandler(w http.ResponseWriter, r *http.Request) {
@@ -32,6 +53,11 @@ func printHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "invalid multipart form")
 		return
 	}
+	// 多个 files 字段：作为一个多文档作业提交（见 print_documents.go）。
+	if fhs := r.MultipartForm.File["files"]; len(fhs) > 0 {
+		printDocumentsHandler(w, r, fhs)
+		return
+	}
 	file, fh, err := r.FormFile("file")
 	if err != nil {
 		writeJSONError(w, http.StatusBadRequest, "missing file field")
@@ -44,344 +70,268 @@ func printHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "missing printer field")
 		return
 	}
-
-	isDuplex := r.FormValue("duplex") == "true"
-	isColor := r.FormValue("color") == "true"
-
-	// Extended print options
-	copiesStr := r.FormValue("copies")
-	copies := 1
-	if copiesStr != "" {
-		if n, err := strconv.Atoi(copiesStr); err == nil && n > 0 {
-			copies = n
-		}
+	sess, _ := auth.GetSession(r)
+	if err := checkPrinterAccess(r.Context(), sess, printer); err != nil {
+		writePrintError(w, err)
+		return
 	}
-	orientation := r.FormValue("orientation")
-	paperSize := r.FormValue("paper_size")
-	paperType := r.FormValue("paper_type")
-	printScaling := r.FormValue("print_scaling")
-	mediaSource := r.FormValue("media_source")
-	pageRange := r.FormValue("page_range")
-	pageSet := r.FormValue("page_set")
-	// even-reverse / custom-scale 分支下方会改写 pageSet/printScaling，落库要保留用户的原始选择。
-	origPageSet := pageSet
-	origPrintScaling := printScaling
-	mirror := r.FormValue("mirror") == "true"
-	watermarkText := strings.TrimSpace(r.FormValue("watermark_text"))
-
-	// N-up (multiple pages per sheet). Default numberUp=1 means off; invalid or
-	// unsupported values fall back to 1 (Issue #78).
-	numberUp := 1
-	if n, err := strconv.Atoi(r.FormValue("number_up")); err == nil {
-		switch n {
-		case 1, 2, 4, 6, 9, 16:
-			numberUp = n
-		}
+	// 定时打印：print_at 为 RFC3339 时间，到点由调度器提交。
+	printAt, err := parsePrintAt(r.FormValue("print_at"), time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	numberUpLayout := r.FormValue("number_up_layout")
-	pageBorder := r.FormValue("page_border")
 
-	var saveHistory bool
-	if err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
-		v, err := store.GetSettingInt(r.Context(), tx, store.SettingSaveHistory, 1)
-		if err != nil {
-			return err
-		}
-		saveHistory = v != 0
-		return nil
-	}); err != nil {
-		saveHistory = true
+	// 先套用打印策略，再按打印机能力预检，都在保存和转换文件之前就失败。
+	// page-set 与镜像不参与预检（even-reverse 由服务端重排实现）。
+	opts, watermark := printOptionsFromForm(r)
+	policy, err := enforcePrintPolicies(r.Context(), sess, printer, &opts, &watermark)
+	if err != nil {
+		writePrintError(w, err)
+		return
 	}
+	pageSet, mirror := opts.PageSet, opts.Mirror
+	opts.PageSet, opts.Mirror = "", false
+	warnings, optionErrs := preflightPrintOptions(printer, sess.Username, &opts)
+	if len(optionErrs) > 0 {
+		writePreflightErrors(w, optionErrs)
+		return
+	}
+	opts.PageSet, opts.Mirror = pageSet, mirror
 
-	storedRel, storedAbs, err := saveUploadedFile(file, fh.Filename, uploadDir)
+	doc, err := saveDocument(documentSource{
+		Filename: fh.Filename,
+		Open:     func() (io.ReadCloser, error) { return file, nil },
+	})
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "failed to save file")
+		writePrintError(w, err)
 		return
 	}
-
-	countCtx, cancel := convertTimeoutContext(r.Context())
-	defer cancel()
-	printPath := storedAbs
-	var printCleanup func()
-	printMime := ""
-	var pages int
-	kind := detectFileKind(storedAbs, fh.Filename)
-	switch kind {
-	case fileKindPDF:
-		// 默认不再对上传 PDF 走 gs 规范化，直接打印原始字节。
-		// 如客户端有需要（例如 CJK 字体乱码），可先调用 /api/convert?normalize=true
-		// 拿到规范化后的字节再回传到本接口。
-		var cerr error
-		pages, cerr = countPDFPages(storedAbs)
-		if cerr != nil {
-			log.Printf("[print] countPDFPages failed: %v", cerr)
-			pages = 1
-		}
-		printPath = storedAbs
-		printMime = "application/pdf"
-		// 解析失败时降级 MIME，让 CUPS/IPP 自行识别
-		if cerr != nil {
-			printMime = "application/octet-stream"
-		}
-	case fileKindOffice:
-		outPath, cleanup, err := convertOfficeToPDF(countCtx, storedAbs)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
-			return
-		}
-		pages, err = countPDFPages(outPath)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
-			return
-		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-	case fileKindOFD:
-		outPath, cleanup, err := convertOFDToPDF(countCtx, storedAbs)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
-			return
-		}
-		pages, err = countPDFPages(outPath)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
-			return
-		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-	case fileKindImage:
-		outPath, cleanup, err := convertImageToPDF(storedAbs, orientation, paperSize)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
-			return
-		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
-			return
-		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-		pages = 1
-	case fileKindText:
-		var err error
-		pages, err = estimateTextPages(storedAbs)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
-		outPath, cleanup, err := convertTextToPDF(storedAbs, orientation, paperSize)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
-			return
-		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
-			return
-		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-	default:
-		var err error
-		pages, _, err = countPages(countCtx, storedAbs, fh.Filename)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
+	job := &printUpload{
+		sess:        sess,
+		printer:     printer,
+		doc:         doc,
+		headerMime:  fh.Header.Get("Content-Type"),
+		opts:        opts,
+		watermark:   watermark,
+		normalize:   r.FormValue("normalize") == "true",
+		warnings:    warnings,
+		policy:      policy,
+		saveHistory: saveHistoryEnabled(r.Context()),
+		printAt:     printAt,
+		logTag:      "print",
 	}
-	if pages < 1 {
-		pages = 1
+	submitPrintTaskOrFail(w, sess, job.run, func() { doc.release(true) })
+}
+
+// printUpload 是已保存、已预检，等待工作池处理的单文件打印。
+// opts 中的 PrintScaling 与 PageSet 仍是用户的原始选择，落库时原样保存。
+type printUpload struct {
+	sess        auth.Session
+	printer     string
+	doc         *preparedDocument
+	headerMime  string
+	opts        ipp.PrintJobOptions
+	watermark   string
+	normalize   bool
+	warnings    []printOptionIssue
+	policy      printPolicyResult
+	saveHistory bool
+	printAt     time.Time
+	logTag      string
+}
+
+// run 让文档走完打印流水线，然后提交（或排期）单文件打印。
+func (u *printUpload) run(ctx context.Context, task *printTask) (*printResp, error) {
+	doc, opts := u.doc, u.opts
+	origPrintScaling, origPageSet := opts.PrintScaling, opts.PageSet
+	scheduled := !u.printAt.IsZero()
+	// 落了记录就保留原始文件：重打、释放与退款都要用 StoredPath。没有记录时
+	//（关闭了历史记录且无需计费、挂起或排期）处理完即删除。
+	keepStored := false
+	defer func() { doc.release(!keepStored) }()
+
+	d := &pipelineDoc{
+		preparedDocument: doc,
+		Opts:             opts,
+		Watermark:        u.watermark,
+		RequireWatermark: u.policy.RequireWatermark,
+		Normalize:        u.normalize,
+		LogTag:           u.logTag,
 	}
-	if printCleanup != nil {
-		defer printCleanup()
+	if err := newPrintPipeline().run(ctx, d, task); err != nil {
+		return nil, err
 	}
-
-	if watermarkText != "" && printMime == "application/pdf" {
-		wmPath, wmCleanup, wmErr := applyWatermarkToPDF(printPath, watermarkText)
-		if wmErr != nil {
-			log.Printf("[print] watermark failed: %v", wmErr)
-		} else {
-			defer wmCleanup()
-			printPath = wmPath
-		}
+	opts, pages := d.Opts, doc.Pages
+	opts.Pages = pages
+	if err := u.policy.checkPages(pages); err != nil {
+		return nil, err
 	}
 
-	// 自定义百分比缩放：先用 gs 把 PDF 内容预缩放，再把 print-scaling 换成合法 keyword
-	scaledPath, scaleCleanup, effectiveScaling := resolveCustomScaling(printPath, printScaling, printMime, "print")
-	printPath = scaledPath
-	printScaling = effectiveScaling
-	if scaleCleanup != nil {
-		defer scaleCleanup()
+	mime := doc.Mime
+	if mime == "" {
+		mime = u.headerMime
 	}
 
-	// Handle even-reverse: reorder PDF pages for manual duplex (even pages
-	// in reverse order, with a blank page prepended when total is odd).
-	if pageSet == "even-reverse" && printMime == "application/pdf" && pages > 1 {
-		reorderedPath, reorderCleanup, err := reorderPDFForManualDuplex(printPath, pages, paperSize)
+	var schedule *store.PrintSchedule
+	if scheduled {
+		var err error
+		schedule, err = newPrintSchedule(doc.PrintPath, mime, doc.Filename, u.printAt, opts)
 		if err != nil {
-			log.Printf("[print] even-reverse reorder failed: %v, falling back to normal even", err)
-			pageSet = "even"
-		} else {
-			defer reorderCleanup()
-			printPath = reorderedPath
-			reorderedPages, _ := countPDFPages(reorderedPath)
-			if reorderedPages > 0 {
-				pages = reorderedPages
-			}
-			pageSet = ""
+			return nil, &printDocumentError{http.StatusInternalServerError, "failed to save scheduled file"}
 		}
 	}
 
-	sess, _ := auth.GetSession(r)
 	var recordID int64
+	var releasePIN string
+	var charge printCharge
+	acct := loadPrintAccounting(ctx)
 
-	if saveHistory {
-		err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
-			user, err := store.GetUserByID(r.Context(), tx, sess.UserID)
+	// 安全打印依赖记录来释放与超时取消，定时打印依赖记录来调度，配额与计费依赖记录来
+	// 统计和退款，即使关闭了历史记录也要落库。
+	if u.saveHistory || opts.Hold || scheduled || acct.needsRecord() {
+		err := appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+			user, err := store.GetUserByID(ctx, tx, u.sess.UserID)
 			if err != nil {
 				return err
 			}
 
 			rec := store.PrintRecord{
 				UserID:     user.ID,
-				PrinterURI: printer,
-				Filename:   fh.Filename,
-				StoredPath: storedRel,
+				PrinterURI: u.printer,
+				Filename:   doc.Filename,
+				StoredPath: doc.StoredRel,
 				Pages:      pages,
-				Status:     "queued",
-				IsDuplex:   isDuplex,
-				IsColor:    isColor,
+				Status:     store.PrintStatusQueued,
+				IsDuplex:   opts.IsDuplex,
+				IsColor:    opts.IsColor,
 
-				Copies:         copies,
-				Orientation:    orientation,
-				PaperSize:      paperSize,
-				PaperType:      paperType,
-				MediaSource:    mediaSource,
+				Copies:         opts.Copies,
+				Orientation:    opts.Orientation,
+				PaperSize:      opts.PaperSize,
+				PaperType:      opts.PaperType,
+				MediaSource:    opts.MediaSource,
 				PrintScaling:   origPrintScaling,
-				PageRange:      pageRange,
+				PageRange:      opts.PageRange,
 				PageSet:        origPageSet,
-				Mirror:         mirror,
-				WatermarkText:  watermarkText,
-				NumberUp:       numberUp,
-				NumberUpLayout: numberUpLayout,
-				PageBorder:     pageBorder,
+				Mirror:         opts.Mirror,
+				WatermarkText:  u.watermark,
+				NumberUp:       opts.NumberUp,
+				NumberUpLayout: opts.NumberUpLayout,
+				PageBorder:     opts.PageBorder,
+				Finishings:     strings.Join(opts.Finishings, ","),
+				OutputBin:      opts.OutputBin,
+				JobSheets:      opts.JobSheets,
+				Quality:        opts.Quality,
+				Resolution:     opts.Resolution,
 
-				CreatedAt: time.Now().UTC().Format(time.RFC3339),
+				Impressions: int(billableImpressions(opts)),
+				CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+			}
+			if scheduled {
+				rec.Status = store.PrintStatusScheduled
+				rec.ScheduledAt = schedule.RunAt
 			}
-			id, err := store.InsertPrintRecord(r.Context(), tx, &rec)
+			if opts.Hold {
+				pin, pinHash, err := newReleasePIN(ctx, tx, u.printer)
+				if err != nil {
+					return err
+				}
+				releasePIN = pin
+				rec.HoldRelease = true
+				rec.ReleasePINHash = pinHash
+			}
+			id, err := store.InsertPrintRecord(ctx, tx, &rec)
 			if err != nil {
 				return err
 			}
 			recordID = id
+			if charge, err = acct.apply(ctx, tx, user.ID, id, opts); err != nil {
+				return err
+			}
+			if scheduled {
+				schedule.PrintJobID = id
+				return store.InsertPrintSchedule(ctx, tx, schedule)
+			}
 			return nil
 		})
 		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to create print record")
-			return
+			if scheduled {
+				removeUpload(schedule.PrintPath)
+			}
+			return nil, printRecordError(err)
 		}
+		keepStored = true
 	}
 
-	f, err := os.Open(printPath)
-	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "failed to open file")
-		return
+	resp := &printResp{
+		OK:       true,
+		Pages:    pages,
+		IsDuplex: opts.IsDuplex,
+		IsColor:  opts.IsColor,
+		Copies:   opts.Copies,
+
+		Held:       opts.Hold,
+		ReleasePIN: releasePIN,
+		Warnings:   u.warnings,
+
+		CostCents:    charge.cost,
+		BalanceCents: charge.balance,
+
+		PolicyOverrides: u.policy.Overrides,
+	}
+	if scheduled {
+		resp.Scheduled, resp.ScheduledAt = true, schedule.RunAt
+		return resp, nil
 	}
-	defer f.Close()
 
-	mime := printMime
-	if mime == "" {
-		mime = fh.Header.Get("Content-Type")
+	task.setStage(printStageSending)
+	f, err := os.Open(doc.PrintPath)
+	if err != nil {
+		return nil, &printDocumentError{http.StatusInternalServerError, "failed to open file"}
 	}
+	defer f.Close()
 	if mime == "" {
 		buf := make([]byte, 512)
 		if n, _ := f.Read(buf); n > 0 {
 			mime = http.DetectContentType(buf[:n])
 			if _, err := f.Seek(0, io.SeekStart); err != nil {
-				writeJSONError(w, http.StatusInternalServerError, "failed to read file")
-				return
+				return nil, &printDocumentError{http.StatusInternalServerError, "failed to read file"}
 			}
 		}
 	}
 
-	printOpts := ipp.PrintJobOptions{
-		IsDuplex:     isDuplex,
-		IsColor:      isColor,
-		Copies:       copies,
-		Orientation:  orientation,
-		PaperSize:    paperSize,
-		PaperType:    paperType,
-		PrintScaling: printScaling,
-		MediaSource:  mediaSource,
-		PageRange:    pageRange,
-		PageSet:      pageSet,
-		Mirror:       mirror,
-		Pages:        pages,
-
-		NumberUp:       numberUp,
-		NumberUpLayout: numberUpLayout,
-		PageBorder:     pageBorder,
-	}
-
-	job, err := ipp.SendPrintJob(printer, f, mime, sess.Username, fh.Filename, printOpts)
+	job, err := ipp.SendPrintJob(u.printer, f, mime, u.sess.Username, doc.Filename, opts)
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "print error: "+err.Error())
-		return
+		if recordID > 0 {
+			_ = appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+				return store.UpdatePrintStatus(ctx, tx, recordID, store.PrintStatusFailed, "")
+			})
+		}
+		return nil, &printDocumentError{http.StatusInternalServerError, "print error: " + err.Error()}
 	}
 
-	if saveHistory && recordID > 0 {
-		_ = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
-			return store.UpdatePrintStatus(r.Context(), tx, recordID, "printed", job)
+	if recordID > 0 {
+		_ = appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+			return store.UpdatePrintStatus(ctx, tx, recordID, submittedStatus(opts.Hold), job)
 		})
+		watchJob(u.printer, job, recordID, u.sess.UserID)
 	}
-	if !saveHistory {
-		_ = os.Remove(storedAbs)
-		cRel := convertedRelPath(storedRel)
-		if cRel != "" {
-			_ = os.Remove(filepath.Join(uploadDir, filepath.FromSlash(cRel)))
+	resp.JobID = job
+	return resp, nil
+}
+
+// parseFinishings 收集 finishings 表单值，兼容多个同名字段与逗号分隔两种写法，去重并去掉 "none"。
+func parseFinishings(vals []string) []string {
+	var out []string
+	for _, v := range vals {
+		for _, kw := range strings.Split(v, ",") {
+			kw = strings.TrimSpace(kw)
+			if kw == "" || kw == "none" || slices.Contains(out, kw) {
+				continue
+			}
+			out = append(out, kw)
 		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(printResp{
-		JobID:    job,
-		OK:       true,
-		Pages:    pages,
-		IsDuplex: isDuplex,
-		IsColor:  isColor,
-		Copies:   copies,
-	})
+	return out
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"cups-web/internal/ipp"
+)
+
+// 打印流水线：一个已保存的文档变成可提交文件要经过的有序阶段。单文件打印、
+// 多文档作业与重打（以及以后的邮件打印、热文件夹等入口）都跑同一条流水线，
+// 转换、水印、缩放、重排的规则只在这里写一遍。
+//
+// 默认阶段依次为 convert → normalize → watermark → scale → reorder，每个阶段
+// 自行判断是否适用；入口可以用 insertBefore / insertAfter 插入自定义阶段。
+
+// pipelineDoc 是在各阶段间传递的文档与它的有效打印选项。
+// scale 与 reorder 阶段会把 Opts.PrintScaling、Opts.PageSet 改写成实际发给 CUPS 的值，
+// 调用方落库时应使用进入流水线之前的原始选择。
+type pipelineDoc struct {
+	*preparedDocument
+	Opts      ipp.PrintJobOptions
+	Watermark string
+	// RequireWatermark 为 true 时水印由打印策略强制：无法加水印的文档与水印失败都让作业失败。
+	RequireWatermark bool
+	// Normalize 为 true 时对 PDF 走 Ghostscript 规范化（解决未嵌入字体的乱码），
+	// 与 /api/convert?normalize=true 相同，默认关闭。
+	Normalize bool
+	LogTag    string
+}
+
+// pipelineStage 是流水线的一个阶段。Name 同时作为异步打印任务的阶段名。
+type pipelineStage struct {
+	Name    string
+	Applies func(d *pipelineDoc) bool // nil 表示总是执行
+	Run     func(ctx context.Context, d *pipelineDoc) error
+}
+
+type printPipeline struct {
+	stages []pipelineStage
+}
+
+func newPrintPipeline() *printPipeline {
+	return &printPipeline{stages: []pipelineStage{
+		convertStage, normalizeStage, watermarkStage, scaleStage, reorderStage,
+	}}
+}
+
+// insertBefore 在名为 name 的阶段之前插入 s，找不到时追加到末尾。
+func (p *printPipeline) insertBefore(name string, s pipelineStage) *printPipeline {
+	i := slices.IndexFunc(p.stages, func(st pipelineStage) bool { return st.Name == name })
+	if i < 0 {
+		i = len(p.stages)
+	}
+	p.stages = slices.Insert(p.stages, i, s)
+	return p
+}
+
+// insertAfter 在名为 name 的阶段之后插入 s，找不到时追加到末尾。
+func (p *printPipeline) insertAfter(name string, s pipelineStage) *printPipeline {
+	i := slices.IndexFunc(p.stages, func(st pipelineStage) bool { return st.Name == name })
+	if i < 0 {
+		i = len(p.stages) - 1
+	}
+	p.stages = slices.Insert(p.stages, i+1, s)
+	return p
+}
+
+// run 依次执行适用的阶段，task 非 nil 时同步推进异步任务的阶段。
+// 出错即停止；convert 失败时上传副本已被删除，其余情况由调用方 release。
+func (p *printPipeline) run(ctx context.Context, d *pipelineDoc, task *printTask) error {
+	for _, s := range p.stages {
+		if s.Applies != nil && !s.Applies(d) {
+			continue
+		}
+		task.setStage(s.Name)
+		if err := s.Run(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *pipelineDoc) isPDF() bool { return d.Mime == "application/pdf" }
+
+var convertStage = pipelineStage{
+	Name: printStageConverting,
+	Run: func(ctx context.Context, d *pipelineDoc) error {
+		// 不再套 convertTimeoutContext：外部转换排队可能较久，单次运行时限由转换器调度负责。
+		return convertDocument(ctx, d.preparedDocument, d.Opts.Orientation, d.Opts.PaperSize)
+	},
+}
+
+var normalizeStage = pipelineStage{
+	Name:    printStageNormalizing,
+	Applies: func(d *pipelineDoc) bool { return d.Normalize && d.isPDF() },
+	Run: func(ctx context.Context, d *pipelineDoc) error {
+		// 规范化失败会自动回退为原样（passthrough），只有输入不可读才报错。
+		res, err := normalizePDF(ctx, d.PrintPath)
+		if err != nil {
+			return err
+		}
+		d.addCleanup(res.Cleanup)
+		d.PrintPath = res.OutputPath
+		return nil
+	},
+}
+
+var watermarkStage = pipelineStage{
+	Name: printStageWatermarking,
+	Applies: func(d *pipelineDoc) bool {
+		return d.Watermark != "" && (d.isPDF() || d.RequireWatermark)
+	},
+	Run: func(ctx context.Context, d *pipelineDoc) error {
+		if !d.isPDF() {
+			return &printDocumentError{http.StatusForbidden, fmt.Sprintf(
+				"print policy requires a watermark, but %s documents cannot be watermarked", d.Mime)}
+		}
+		wmPath, wmCleanup, err := applyWatermarkToPDF(d.PrintPath, d.Watermark)
+		if err != nil {
+			log.Printf("[%s] watermark %q failed: %v", d.LogTag, d.Filename, err)
+			if d.RequireWatermark {
+				return &printDocumentError{http.StatusInternalServerError, "failed to apply the watermark required by print policy"}
+			}
+			// 水印不是策略强制的，失败不阻断打印，照常提交原文件。
+			return nil
+		}
+		d.addCleanup(wmCleanup)
+		d.PrintPath = wmPath
+		return nil
+	},
+}
+
+// scaleStage 处理自定义百分比缩放：先用 gs 把 PDF 内容预缩放，再把 print-scaling 换成合法 keyword。
+var scaleStage = pipelineStage{
+	Name: printStageScaling,
+	Applies: func(d *pipelineDoc) bool {
+		_, isCustom := parseScalePercent(d.Opts.PrintScaling)
+		return isCustom
+	},
+	Run: func(ctx context.Context, d *pipelineDoc) error {
+		scaledPath, scaleCleanup, scaling := resolveCustomScaling(d.PrintPath, d.Opts.PrintScaling, d.Mime, d.LogTag)
+		d.addCleanup(scaleCleanup)
+		d.PrintPath = scaledPath
+		d.Opts.PrintScaling = scaling
+		return nil
+	},
+}
+
+// reorderStage 实现 even-reverse（手动双面）：偶数页倒序输出，总页数为奇数时前面补一张空白页。
+// CUPS 不认识 even-reverse，无论是否重排都要把它从 page-set 里去掉；重排失败时退回普通偶数页。
+var reorderStage = pipelineStage{
+	Name:    printStageReordering,
+	Applies: func(d *pipelineDoc) bool { return d.Opts.PageSet == "even-reverse" },
+	Run: func(ctx context.Context, d *pipelineDoc) error {
+		d.Opts.PageSet = ""
+		if !d.isPDF() || d.Pages < 2 {
+			return nil
+		}
+		reorderedPath, reorderCleanup, err := reorderPDFForManualDuplex(d.PrintPath, d.Pages, d.Opts.PaperSize)
+		if err != nil {
+			log.Printf("[%s] even-reverse reorder %q failed: %v, falling back to normal even", d.LogTag, d.Filename, err)
+			d.Opts.PageSet = "even"
+			return nil
+		}
+		d.addCleanup(reorderCleanup)
+		d.PrintPath = reorderedPath
+		if pages, _ := countPDFPages(reorderedPath); pages > 0 {
+			d.Pages = pages
+		}
+		return nil
+	},
+}
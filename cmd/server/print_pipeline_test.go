@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"cups-web/internal/ipp"
+
+	"github.com/phpdave11/gofpdf"
+)
+
+// writeTestPDF 生成一个 pages 页的 A4 PDF。
+func writeTestPDF(t *testing.T, dir string, pages int) string {
+	t.Helper()
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetFont("Helvetica", "", 16)
+	for i := 0; i < pages; i++ {
+		pdf.AddPage()
+		pdf.Text(20, 20, "page")
+	}
+	path := filepath.Join(dir, "in.pdf")
+	if err := pdf.OutputFileAndClose(path); err != nil {
+		t.Fatalf("write test pdf: %v", err)
+	}
+	return path
+}
+
+// newTestPipelineDoc 准备一个已保存的文档，pages 为 0 时写入纯文本文件。
+func newTestPipelineDoc(t *testing.T, pages int) *pipelineDoc {
+	t.Helper()
+	dir := t.TempDir()
+	oldUploadDir := uploadDir
+	uploadDir = dir
+	t.Cleanup(func() { uploadDir = oldUploadDir })
+	doc := &preparedDocument{Filename: "in.pdf", StoredRel: "in.pdf"}
+	if pages > 0 {
+		doc.StoredAbs = writeTestPDF(t, dir, pages)
+		doc.Mime, doc.Pages = "application/pdf", pages
+	} else {
+		doc.Filename, doc.StoredRel = "in.txt", "in.txt"
+		doc.StoredAbs = filepath.Join(dir, "in.txt")
+		if err := os.WriteFile(doc.StoredAbs, []byte("hello\nworld\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc.PrintPath = doc.StoredAbs
+	t.Cleanup(func() { doc.release(false) })
+	return &pipelineDoc{preparedDocument: doc, Opts: ipp.PrintJobOptions{PaperSize: "A4"}, LogTag: "test"}
+}
+
+func TestPrintPipelineStages(t *testing.T) {
+	tests := []struct {
+		name        string
+		stage       pipelineStage
+		pages       int // 0 表示文本文件
+		setup       func(d *pipelineDoc)
+		needsFont   bool // 文本转换与水印依赖内嵌字体，源码快照里可能是空文件
+		wantApplies bool
+		check       func(t *testing.T, d *pipelineDoc, origPath string)
+	}{
+		{
+			name: "convert pdf counts pages", stage: convertStage, pages: 3,
+			setup:       func(d *pipelineDoc) { d.Mime, d.Pages = "", 0 },
+			wantApplies: true,
+			check: func(t *testing.T, d *pipelineDoc, origPath string) {
+				if d.Pages != 3 || d.Mime != "application/pdf" || d.PrintPath != origPath {
+					t.Errorf("pages=%d mime=%q path changed=%v", d.Pages, d.Mime, d.PrintPath != origPath)
+				}
+			},
+		},
+		{
+			name: "convert text to pdf", stage: convertStage, pages: 0,
+			needsFont: true, wantApplies: true,
+			check: func(t *testing.T, d *pipelineDoc, origPath string) {
+				if d.Mime != "application/pdf" || d.PrintPath == origPath || d.Pages < 1 {
+					t.Errorf("mime=%q pages=%d path=%q", d.Mime, d.Pages, d.PrintPath)
+				}
+			},
+		},
+		{
+			name: "normalize off by default", stage: normalizeStage, pages: 1,
+		},
+		{
+			name: "normalize skips non-pdf", stage: normalizeStage, pages: 1,
+			setup: func(d *pipelineDoc) { d.Normalize, d.Mime = true, "application/postscript" },
+		},
+		{
+			name: "watermark without text", stage: watermarkStage, pages: 1,
+		},
+		{
+			name: "watermark pdf", stage: watermarkStage, pages: 2,
+			setup:     func(d *pipelineDoc) { d.Watermark = "CONFIDENTIAL" },
+			needsFont: true, wantApplies: true,
+			check: func(t *testing.T, d *pipelineDoc, origPath string) {
+				if d.PrintPath == origPath {
+					t.Fatal("watermark did not produce a new file")
+				}
+				if n, err := countPDFPages(d.PrintPath); err != nil || n != 2 {
+					t.Errorf("watermarked pages = %d, %v", n, err)
+				}
+			},
+		},
+		{
+			name: "scale keyword untouched", stage: scaleStage, pages: 1,
+			setup: func(d *pipelineDoc) { d.Opts.PrintScaling = "fit" },
+		},
+		{
+			name: "scale 100 percent becomes none", stage: scaleStage, pages: 1,
+			setup:       func(d *pipelineDoc) { d.Opts.PrintScaling = "100" },
+			wantApplies: true,
+			check: func(t *testing.T, d *pipelineDoc, origPath string) {
+				if d.Opts.PrintScaling != "none" || d.PrintPath != origPath {
+					t.Errorf("scaling=%q path changed=%v", d.Opts.PrintScaling, d.PrintPath != origPath)
+				}
+			},
+		},
+		{
+			name: "scale out of range ignored", stage: scaleStage, pages: 1,
+			setup:       func(d *pipelineDoc) { d.Opts.PrintScaling = "900" },
+			wantApplies: true,
+			check: func(t *testing.T, d *pipelineDoc, origPath string) {
+				if d.Opts.PrintScaling != "" {
+					t.Errorf("scaling = %q, want empty", d.Opts.PrintScaling)
+				}
+			},
+		},
+		{
+			name: "reorder only for even-reverse", stage: reorderStage, pages: 4,
+			setup: func(d *pipelineDoc) { d.Opts.PageSet = "even" },
+		},
+		{
+			name: "reorder odd page count", stage: reorderStage, pages: 5,
+			setup:       func(d *pipelineDoc) { d.Opts.PageSet = "even-reverse" },
+			wantApplies: true,
+			check: func(t *testing.T, d *pipelineDoc, origPath string) {
+				// 5 页：空白页 + 第 4、2 页。
+				if d.Opts.PageSet != "" || d.PrintPath == origPath || d.Pages != 3 {
+					t.Errorf("pageSet=%q pages=%d path changed=%v", d.Opts.PageSet, d.Pages, d.PrintPath != origPath)
+				}
+			},
+		},
+		{
+			name: "reorder single page just drops page-set", stage: reorderStage, pages: 1,
+			setup:       func(d *pipelineDoc) { d.Opts.PageSet = "even-reverse" },
+			wantApplies: true,
+			check: func(t *testing.T, d *pipelineDoc, origPath string) {
+				if d.Opts.PageSet != "" || d.PrintPath != origPath {
+					t.Errorf("pageSet=%q path changed=%v", d.Opts.PageSet, d.PrintPath != origPath)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsFont && len(notoSansSCFont) == 0 {
+				t.Skip("embedded font is missing")
+			}
+			d := newTestPipelineDoc(t, tt.pages)
+			if tt.setup != nil {
+				tt.setup(d)
+			}
+			applies := tt.stage.Applies == nil || tt.stage.Applies(d)
+			if applies != tt.wantApplies {
+				t.Fatalf("Applies = %v, want %v", applies, tt.wantApplies)
+			}
+			if !applies {
+				return
+			}
+			origPath := d.PrintPath
+			if err := tt.stage.Run(context.Background(), d); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if tt.check != nil {
+				tt.check(t, d, origPath)
+			}
+		})
+	}
+}
+
+func TestWatermarkStageRequiredByPolicy(t *testing.T) {
+	// 策略强制水印时，无法加水印的格式直接拒绝。
+	d := newTestPipelineDoc(t, 1)
+	d.Mime, d.Watermark, d.RequireWatermark = "application/postscript", "alice", true
+	if !watermarkStage.Applies(d) {
+		t.Fatal("required watermark must apply to non-pdf documents")
+	}
+	var docErr *printDocumentError
+	if err := watermarkStage.Run(context.Background(), d); !errors.As(err, &docErr) || docErr.status != http.StatusForbidden {
+		t.Errorf("non-pdf with required watermark: err = %v, want 403", err)
+	}
+
+	// 水印失败：非强制时照常提交原文件，强制时作业失败。
+	d = newTestPipelineDoc(t, 1)
+	if err := os.WriteFile(d.PrintPath, []byte("not a pdf"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.Watermark = "alice"
+	origPath := d.PrintPath
+	if err := watermarkStage.Run(context.Background(), d); err != nil || d.PrintPath != origPath {
+		t.Errorf("optional watermark failure: err = %v, path changed = %v", err, d.PrintPath != origPath)
+	}
+	d.RequireWatermark = true
+	if err := watermarkStage.Run(context.Background(), d); !errors.As(err, &docErr) || docErr.status != http.StatusInternalServerError {
+		t.Errorf("required watermark failure: err = %v, want 500", err)
+	}
+}
+
+func TestPrintPipelineCustomStages(t *testing.T) {
+	var ran []string
+	stage := func(name string, applies bool, err error) pipelineStage {
+		return pipelineStage{
+			Name:    name,
+			Applies: func(d *pipelineDoc) bool { return applies },
+			Run: func(ctx context.Context, d *pipelineDoc) error {
+				ran = append(ran, name)
+				return err
+			},
+		}
+	}
+	p := &printPipeline{stages: []pipelineStage{stage("a", true, nil), stage("c", true, nil)}}
+	p.insertBefore("c", stage("b", true, nil)).
+		insertAfter("c", stage("skipped", false, nil)).
+		insertAfter("missing", stage("d", true, nil))
+
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.Name)
+	}
+	if want := []string{"a", "b", "c", "skipped", "d"}; !slices.Equal(names, want) {
+		t.Fatalf("stages = %v, want %v", names, want)
+	}
+	if err := p.run(context.Background(), &pipelineDoc{}, nil); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := []string{"a", "b", "c", "d"}; !slices.Equal(ran, want) {
+		t.Errorf("ran = %v, want %v", ran, want)
+	}
+
+	// 出错即停止，后续阶段不再执行。
+	ran = nil
+	boom := errors.New("boom")
+	p = &printPipeline{stages: []pipelineStage{stage("a", true, boom), stage("b", true, nil)}}
+	if err := p.run(context.Background(), &pipelineDoc{}, nil); !errors.Is(err, boom) {
+		t.Errorf("run err = %v, want boom", err)
+	}
+	if !slices.Equal(ran, []string{"a"}) {
+		t.Errorf("ran = %v after error", ran)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"cups-web/internal/ipp"
+)
+
+// 打印前预检：按打印机上报的 *-supported 能力检查打印选项，能安全降级的（双面→
+// 单面、彩色→黑白、纸盒→自动等）降级并在 printResp.warnings 里说明；不能降级的
+// （份数超限、页码范围、挂起）直接 422 返回字段级错误。本地检查通过后再发一次
+// Validate-Job，让 CUPS 对最终要发送的属性做权威校验，避免上传完文档才被拒。
+
+// printOptionFields 把 IPP 属性名映射为 /api/print 的表单字段名，前端据此定位到具体控件。
+var printOptionFields = map[string]string{
+	"sides":                 "duplex",
+	"print-color-mode":      "color",
+	"copies":                "copies",
+	"media":                 "paper_size",
+	"media-type":            "paper_type",
+	"media-source":          "media_source",
+	"print-scaling":         "print_scaling",
+	"orientation-requested": "orientation",
+	"number-up":             "number_up",
+	"number-up-layout":      "number_up_layout",
+	"page-border":           "page_border",
+	"page-ranges":           "page_range",
+	"page-set":              "page_set",
+	"mirror":                "mirror",
+	"job-hold-until":        "hold",
+	"finishings":            "finishings",
+	"finishings-col":        "finishings",
+	"output-bin":            "output_bin",
+	"job-sheets":            "job_sheets",
+	"print-quality":         "print_quality",
+	"printer-resolution":    "resolution",
+}
+
+type printOptionIssue struct {
+	Field string `json:"field"`
+	ipp.OptionIssue
+}
+
+func toPrintOptionIssues(issues []ipp.OptionIssue) []printOptionIssue {
+	out := make([]printOptionIssue, 0, len(issues))
+	for _, issue := range issues {
+		out = append(out, printOptionIssue{Field: fieldForAttribute(issue.Attribute), OptionIssue: issue})
+	}
+	return out
+}
+
+// preflightPrintOptions 对 opts 做能力预检与 Validate-Job，降级结果直接写回 opts。
+// 打印机暂时查不到能力或不支持 Validate-Job 时不阻塞打印，交给 Print-Job 自己报错。
+func preflightPrintOptions(printerURI, username string, opts *ipp.PrintJobOptions) (warnings, errs []printOptionIssue) {
+	// 数字形态的 print-scaling 是自定义百分比，由 resolveCustomScaling 落地，不是 IPP keyword。
+	customScaling := ""
+	if _, ok := parseScalePercent(opts.PrintScaling); ok {
+		customScaling, opts.PrintScaling = opts.PrintScaling, ""
+	}
+	defer func() {
+		if customScaling != "" {
+			opts.PrintScaling = customScaling
+		}
+	}()
+
+	caps, err := cachedPrinterCapabilities(printerURI)
+	if err != nil {
+		log.Printf("[preflight] capabilities unavailable for %q: %v", printerURI, err)
+		return nil, nil
+	}
+	adjusted, w, e := caps.Preflight(*opts)
+	if len(e) > 0 {
+		return toPrintOptionIssues(w), toPrintOptionIssues(e)
+	}
+	*opts = adjusted
+	warnings = toPrintOptionIssues(w)
+
+	ignored, err := ipp.ValidateJob(printerURI, "", username, "", adjusted)
+	if err != nil {
+		var se *ipp.StatusError
+		if errors.As(err, &se) && ipp.IsUnsupportedAttributes(err) {
+			for _, name := range se.Unsupported {
+				errs = append(errs, printOptionIssue{Field: fieldForAttribute(name), OptionIssue: ipp.OptionIssue{
+					Attribute: name, Message: "rejected by printer"}})
+			}
+			if len(errs) == 0 {
+				errs = append(errs, printOptionIssue{OptionIssue: ipp.OptionIssue{Message: "print options rejected by printer"}})
+			}
+			return warnings, errs
+		}
+		log.Printf("[preflight] validate-job on %q: %v", printerURI, err)
+		return warnings, nil
+	}
+	for _, name := range ignored {
+		warnings = append(warnings, printOptionIssue{Field: fieldForAttribute(name), OptionIssue: ipp.OptionIssue{
+			Attribute: name, Message: "ignored or substituted by printer"}})
+	}
+	return warnings, nil
+}
+
+func fieldForAttribute(name string) string {
+	if field := printOptionFields[name]; field != "" {
+		return field
+	}
+	return name
+}
+
+// writePreflightErrors 以 422 返回字段级错误。
+func writePreflightErrors(w http.ResponseWriter, errs []printOptionIssue) {
+	writeJSONStatus(w, http.StatusUnprocessableEntity, map[string]any{
+		"error":  "unsupported print options",
+		"fields": errs,
+	})
+}
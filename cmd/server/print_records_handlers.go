@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
 	"mime"
 	"net/http"
 	"os"
@@ -48,9 +48,38 @@ type printRecordResponse struct {
 	NumberUpLayout string `json:"numberUpLayout"`
 	PageBorder     string `json:"pageBorder"`
 
+	Finishings []string `json:"finishings,omitempty"`
+	OutputBin  string   `json:"outputBin"`
+	JobSheets  string   `json:"jobSheets"`
+	Quality    string   `json:"quality"`
+	Resolution string   `json:"resolution"`
+
+	// 定时打印的预定时间；status 为 scheduled 时尚未提交。
+	ScheduledAt string `json:"scheduledAt,omitempty"`
+
+	// CUPS 作业实时状态（作业状态跟踪器回填），completedAt 仅终态时有值。
+	JobState             string   `json:"jobState"`
+	JobStateReasons      []string `json:"jobStateReasons"`
+	ImpressionsCompleted int      `json:"impressionsCompleted"`
+	CompletedAt          string   `json:"completedAt,omitempty"`
+
+	HoldRelease bool   `json:"holdRelease"`
+	ReleasedAt  string `json:"releasedAt,omitempty"`
+
+	// 计费金额（分），取消/失败退款后仍保留原值，前端结合 status 展示。
+	CostCents int64 `json:"costCents,omitempty"`
+
+	// Documents 列出多文档作业的成员文档，单文档记录省略。
+	Documents []printDocumentResponse `json:"documents,omitempty"`
+
 	CreatedAt string `json:"createdAt"`
 }
 
+type printDocumentResponse struct {
+	Filename string `json:"filename"`
+	Pages    int    `json:"pages"`
+}
+
 func printRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	sess, err := auth.GetSession(r)
 	if err != nil {
@@ -77,7 +106,11 @@ func printRecordsHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return err
 		}
-		resp = mapPrintRecords(records)
+		docs, err := listRecordDocuments(r.Context(), tx, records)
+		if err != nil {
+			return err
+		}
+		resp = mapPrintRecords(records, docs)
 		return nil
 	})
 	if err != nil {
@@ -94,18 +127,31 @@ func adminPrintRecordsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username := r.URL.Query().Get("username")
+	// group 按用户组筛选（组 ID），用于按部门/班级统计。
+	var groupID int64
+	if v := r.URL.Query().Get("group"); v != "" {
+		if groupID, err = strconv.ParseInt(v, 10, 64); err != nil || groupID <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid group")
+			return
+		}
+	}
 
 	var resp []printRecordResponse
 	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
 		records, err := store.ListPrintRecords(r.Context(), tx, store.PrintFilter{
 			Username: username,
+			GroupID:  groupID,
 			StartAt:  startAt,
 			EndAt:    endAt,
 		})
 		if err != nil {
 			return err
 		}
-		resp = mapPrintRecords(records)
+		docs, err := listRecordDocuments(r.Context(), tx, records)
+		if err != nil {
+			return err
+		}
+		resp = mapPrintRecords(records, docs)
 		return nil
 	})
 	if err != nil {
@@ -115,6 +161,16 @@ func adminPrintRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, resp)
 }
 
+func listRecordDocuments(ctx context.Context, tx *sql.Tx, records []store.PrintRecord) (map[int64][]store.PrintDocument, error) {
+	ids := make([]int64, 0, len(records))
+	for _, rec := range records {
+		ids = append(ids, rec.ID)
+	}
+	return store.ListPrintDocumentsByJobs(ctx, tx, ids)
+}
+
+// GET /api/print-records/{id}/file?doc=<n> — 下载记录的原始文件；多文档记录用
+// doc（从 0 开始）选择成员文档，缺省为第一个。
 func printRecordFileHandler(w http.ResponseWriter, r *http.Request) {
 	sess, err := auth.GetSession(r)
 	if err != nil {
@@ -129,13 +185,15 @@ func printRecordFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var record store.PrintRecord
+	var docs []store.PrintDocument
 	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
 		rec, err := store.GetPrintRecordByID(r.Context(), tx, id)
 		if err != nil {
 			return err
 		}
 		record = rec
-		return nil
+		docs, err = store.ListPrintDocuments(r.Context(), tx, id)
+		return err
 	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -149,6 +207,16 @@ func printRecordFileHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusForbidden, "forbidden")
 		return
 	}
+	if v := r.URL.Query().Get("doc"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || (n > 0 && n >= len(docs)) {
+			writeJSONError(w, http.StatusNotFound, "document not found")
+			return
+		}
+		if n < len(docs) {
+			record.Filename, record.StoredPath = docs[n].Filename, docs[n].StoredPath
+		}
+	}
 
 	// os.OpenInRoot 将文件访问限制在 uploadDir 目录树内，即便 StoredPath 被污染
 	// 成 ../ 逃逸路径也会被 OS 层拒绝（纵深防御，Go 1.24+）。
@@ -195,9 +263,13 @@ func parseDateRange(r *http.Request) (string, string, error) {
 	return startAt, endAt, nil
 }
 
-func mapPrintRecords(records []store.PrintRecord) []printRecordResponse {
+func mapPrintRecords(records []store.PrintRecord, docs map[int64][]store.PrintDocument) []printRecordResponse {
 	resp := make([]printRecordResponse, 0, len(records))
 	for _, rec := range records {
+		var members []printDocumentResponse
+		for _, doc := range docs[rec.ID] {
+			members = append(members, printDocumentResponse{Filename: doc.Filename, Pages: doc.Pages})
+		}
 		jobID := ""
 		if rec.JobID.Valid {
 			jobID = rec.JobID.String
@@ -228,17 +300,45 @@ func mapPrintRecords(records []store.PrintRecord) []printRecordResponse {
 			NumberUpLayout: rec.NumberUpLayout,
 			PageBorder:     rec.PageBorder,
 
+			Finishings: parseFinishings([]string{rec.Finishings}),
+			OutputBin:  rec.OutputBin,
+			JobSheets:  rec.JobSheets,
+			Quality:    rec.Quality,
+			Resolution: rec.Resolution,
+
+			ScheduledAt: rec.ScheduledAt,
+
+			JobState:             rec.JobState,
+			JobStateReasons:      splitJobStateReasons(rec.JobStateReasons),
+			ImpressionsCompleted: rec.ImpressionsCompleted,
+			CompletedAt:          rec.CompletedAt,
+
+			HoldRelease: rec.HoldRelease,
+			ReleasedAt:  rec.ReleasedAt,
+
+			CostCents: rec.CostCents,
+
+			Documents: members,
+
 			CreatedAt: rec.CreatedAt,
 		})
 	}
 	return resp
 }
 
+// splitJobStateReasons 把落库的逗号分隔 job-state-reasons 还原成数组，空串返回空数组。
+func splitJobStateReasons(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
 type reprintRequest struct {
-	Printer      string `json:"printer"`
-	Duplex       bool   `json:"duplex"`
-	Color        bool   `json:"color"`
-	Copies       int    `json:"copies"`
+	Printer       string `json:"printer"`
+	Duplex        bool   `json:"duplex"`
+	Color         bool   `json:"color"`
+	Copies        int    `json:"copies"`
 	Orientation   string `json:"orientation"`
 	PaperSize     string `json:"paperSize"`
 	PaperType     string `json:"paperType"`
@@ -252,6 +352,14 @@ type reprintRequest struct {
 	NumberUp       int    `json:"numberUp"`
 	NumberUpLayout string `json:"numberUpLayout"`
 	PageBorder     string `json:"pageBorder"`
+
+	Finishings []string `json:"finishings"`
+	OutputBin  string   `json:"outputBin"`
+	JobSheets  string   `json:"jobSheets"`
+	Quality    string   `json:"quality"`
+	Resolution string   `json:"resolution"`
+
+	Hold bool `json:"hold"`
 }
 
 func reprintHandler(w http.ResponseWriter, r *http.Request) {
@@ -277,6 +385,10 @@ func reprintHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "missing printer field")
 		return
 	}
+	if err := checkPrinterAccess(r.Context(), sess, req.Printer); err != nil {
+		writePrintError(w, err)
+		return
+	}
 	if req.Copies < 1 {
 		req.Copies = 1
 	}
@@ -288,13 +400,15 @@ func reprintHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var record store.PrintRecord
+	var docs []store.PrintDocument
 	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
 		rec, err := store.GetPrintRecordByID(r.Context(), tx, id)
 		if err != nil {
 			return err
 		}
 		record = rec
-		return nil
+		docs, err = store.ListPrintDocuments(r.Context(), tx, id)
+		return err
 	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -310,273 +424,104 @@ func reprintHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	origFile, err := os.OpenInRoot(uploadDir, filepath.FromSlash(record.StoredPath))
+	checked := ipp.PrintJobOptions{
+		IsDuplex:       req.Duplex,
+		IsColor:        req.Color,
+		Copies:         req.Copies,
+		Orientation:    req.Orientation,
+		PaperSize:      req.PaperSize,
+		PaperType:      req.PaperType,
+		PrintScaling:   req.PrintScaling,
+		MediaSource:    req.MediaSource,
+		PageRange:      req.PageRange,
+		NumberUp:       req.NumberUp,
+		NumberUpLayout: req.NumberUpLayout,
+		PageBorder:     req.PageBorder,
+		Hold:           req.Hold,
+		Finishings:     parseFinishings(req.Finishings),
+		OutputBin:      req.OutputBin,
+		JobSheets:      req.JobSheets,
+		Quality:        req.Quality,
+		Resolution:     req.Resolution,
+	}
+	watermark := strings.TrimSpace(req.WatermarkText)
+	policy, err := enforcePrintPolicies(r.Context(), sess, req.Printer, &checked, &watermark)
 	if err != nil {
-		writeJSONError(w, http.StatusNotFound, "original file not found, may have been cleaned up")
+		writePrintError(w, err)
 		return
 	}
-	defer origFile.Close()
-
-	storedRel, storedAbs, err := saveUploadedFile(origFile, record.Filename, uploadDir)
-	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "failed to copy file")
+	warnings, optionErrs := preflightPrintOptions(req.Printer, sess.Username, &checked)
+	if len(optionErrs) > 0 {
+		writePreflightErrors(w, optionErrs)
 		return
 	}
+	checked.PageSet, checked.Mirror = req.PageSet, req.Mirror
 
-	countCtx, cancel := convertTimeoutContext(r.Context())
-	defer cancel()
-	printPath := storedAbs
-	var printCleanup func()
-	printMime := ""
-	var pages int
-	kind := detectFileKind(storedAbs, record.Filename)
-	switch kind {
-	case fileKindPDF:
-		var cerr error
-		pages, cerr = countPDFPages(storedAbs)
-		if cerr != nil {
-			log.Printf("[reprint] countPDFPages failed: %v", cerr)
-			pages = 1
-		}
-		printPath = storedAbs
-		printMime = "application/pdf"
-		if cerr != nil {
-			printMime = "application/octet-stream"
-		}
-	case fileKindOffice:
-		outPath, cleanup, err := convertOfficeToPDF(countCtx, storedAbs)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
-			return
-		}
-		pages, err = countPDFPages(outPath)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
-			return
-		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-	case fileKindOFD:
-		outPath, cleanup, err := convertOFDToPDF(countCtx, storedAbs)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
-			return
-		}
-		pages, err = countPDFPages(outPath)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
-			return
+	// 多文档记录整体重打，仍作为一个作业提交。
+	if len(docs) > 1 {
+		srcs := make([]documentSource, 0, len(docs))
+		for _, doc := range docs {
+			srcs = append(srcs, storedSource(doc.Filename, doc.StoredPath))
 		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-	case fileKindImage:
-		outPath, cleanup, err := convertImageToPDF(storedAbs, req.Orientation, req.PaperSize)
+		saved, err := saveDocuments(srcs)
 		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
+			writePrintError(w, err)
 			return
 		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
+		resp, err := runDocumentJob(r.Context(), sess, documentJob{
+			Printer:     req.Printer,
+			Docs:        saved,
+			Options:     checked,
+			Watermark:   watermark,
+			Warnings:    warnings,
+			Policy:      policy,
+			SaveHistory: true,
+			LogTag:      "reprint",
+		}, nil)
 		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
+			writePrintError(w, err)
 			return
 		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-		pages = 1
-	case fileKindText:
-		pages, err = estimateTextPages(storedAbs)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
-		outPath, cleanup, err := convertTextToPDF(storedAbs, req.Orientation, req.PaperSize)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "conversion failed")
-			return
-		}
-		_, convertedAbs, err := saveConvertedPDFToUploads(outPath, storedRel, uploadDir)
-		if err != nil {
-			cleanup()
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusInternalServerError, "failed to save converted file")
-			return
-		}
-		printPath = convertedAbs
-		printCleanup = cleanup
-		printMime = "application/pdf"
-	default:
-		pages, _, err = countPages(countCtx, storedAbs, record.Filename)
-		if err != nil {
-			_ = os.Remove(storedAbs)
-			writeJSONError(w, http.StatusBadRequest, "failed to read pages")
-			return
-		}
-	}
-	if pages < 1 {
-		pages = 1
-	}
-	if printCleanup != nil {
-		defer printCleanup()
-	}
-
-	if watermark := strings.TrimSpace(req.WatermarkText); watermark != "" && printMime == "application/pdf" {
-		wmPath, wmCleanup, wmErr := applyWatermarkToPDF(printPath, watermark)
-		if wmErr != nil {
-			log.Printf("[reprint] watermark failed: %v", wmErr)
-		} else {
-			defer wmCleanup()
-			printPath = wmPath
-		}
-	}
-
-	// 重打同样要走自定义百分比缩放：记录里存的是用户原始选择（自定义时为纯数字）。
-	scaledPath, scaleCleanup, printScaling := resolveCustomScaling(printPath, req.PrintScaling, printMime, "reprint")
-	printPath = scaledPath
-	if scaleCleanup != nil {
-		defer scaleCleanup()
-	}
-
-	pageSet := req.PageSet
-	if pageSet == "even-reverse" && printMime == "application/pdf" && pages > 1 {
-		reorderedPath, reorderCleanup, rerr := reorderPDFForManualDuplex(printPath, pages, req.PaperSize)
-		if rerr != nil {
-			log.Printf("[reprint] even-reverse reorder failed: %v, falling back to normal even", rerr)
-			pageSet = "even"
-		} else {
-			defer reorderCleanup()
-			printPath = reorderedPath
-			reorderedPages, _ := countPDFPages(reorderedPath)
-			if reorderedPages > 0 {
-				pages = reorderedPages
-			}
-			pageSet = ""
-		}
+		writeJSON(w, resp)
+		return
 	}
 
-	var recordID int64
-	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
-		rec := store.PrintRecord{
-			UserID:     sess.UserID,
-			PrinterURI: req.Printer,
-			Filename:   record.Filename,
-			StoredPath: storedRel,
-			Pages:      pages,
-			Status:     "queued",
-			IsDuplex:   req.Duplex,
-			IsColor:    req.Color,
-
-			Copies:         req.Copies,
-			Orientation:    req.Orientation,
-			PaperSize:      req.PaperSize,
-			PaperType:      req.PaperType,
-			MediaSource:    req.MediaSource,
-			PrintScaling:   req.PrintScaling,
-			PageRange:      req.PageRange,
-			PageSet:        req.PageSet,
-			Mirror:         req.Mirror,
-			WatermarkText:  req.WatermarkText,
-			NumberUp:       req.NumberUp,
-			NumberUpLayout: req.NumberUpLayout,
-			PageBorder:     req.PageBorder,
-
-			CreatedAt: time.Now().UTC().Format(time.RFC3339),
-		}
-		rid, err := store.InsertPrintRecord(r.Context(), tx, &rec)
-		if err != nil {
-			return err
-		}
-		recordID = rid
-		return nil
-	})
+	// 单文件重打与 /api/print 走同一条流水线，只是同步执行：原文件已在服务器上，
+	// 不必再等上传。重打总是落库。
+	doc, err := saveDocument(storedSource(record.Filename, record.StoredPath))
 	if err != nil {
-		_ = os.Remove(storedAbs)
-		writeJSONError(w, http.StatusInternalServerError, "failed to create print record")
+		writePrintError(w, err)
 		return
 	}
-
-	f, err := os.Open(printPath)
+	job := &printUpload{
+		sess:        sess,
+		printer:     req.Printer,
+		doc:         doc,
+		opts:        checked,
+		watermark:   watermark,
+		warnings:    warnings,
+		policy:      policy,
+		saveHistory: true,
+		logTag:      "reprint",
+	}
+	resp, err := job.run(r.Context(), nil)
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "failed to open file")
+		writePrintError(w, err)
 		return
 	}
-	defer f.Close()
+	writeJSON(w, resp)
+}
 
-	mimeType := printMime
-	if mimeType == "" {
-		buf := make([]byte, 512)
-		if n, _ := f.Read(buf); n > 0 {
-			mimeType = http.DetectContentType(buf[:n])
-			if _, err := f.Seek(0, io.SeekStart); err != nil {
-				writeJSONError(w, http.StatusInternalServerError, "failed to read file")
-				return
+// storedSource 以 uploads 里已保存的文件作为输入，用于重打。
+func storedSource(filename, storedPath string) documentSource {
+	return documentSource{
+		Filename: filename,
+		Open: func() (io.ReadCloser, error) {
+			f, err := os.OpenInRoot(uploadDir, filepath.FromSlash(storedPath))
+			if err != nil {
+				return nil, &printDocumentError{http.StatusNotFound, "original file not found, may have been cleaned up"}
 			}
-		}
-	}
-
-	printOpts := ipp.PrintJobOptions{
-		IsDuplex:     req.Duplex,
-		IsColor:      req.Color,
-		Copies:       req.Copies,
-		Orientation:  req.Orientation,
-		PaperSize:    req.PaperSize,
-		PaperType:    req.PaperType,
-		MediaSource:  req.MediaSource,
-		PrintScaling: printScaling,
-		PageRange:    req.PageRange,
-		PageSet:      pageSet,
-		Mirror:       req.Mirror,
-		Pages:        pages,
-
-		NumberUp:       req.NumberUp,
-		NumberUpLayout: req.NumberUpLayout,
-		PageBorder:     req.PageBorder,
-	}
-
-	job, err := ipp.SendPrintJob(req.Printer, f, mimeType, sess.Username, record.Filename, printOpts)
-	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "print error: "+err.Error())
-		return
+			return f, nil
+		},
 	}
-
-	_ = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
-		return store.UpdatePrintStatus(r.Context(), tx, recordID, "printed", job)
-	})
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(printResp{
-		JobID:    job,
-		OK:       true,
-		Pages:    pages,
-		IsDuplex: req.Duplex,
-		IsColor:  req.Color,
-		Copies:   req.Copies,
-	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// ── 异步打印任务 ───────────────────────────────────────────────────────────────
+//
+// 与驱动任务同样的原因：全局 WriteTimeout=120s，而大 Office 文件的转换加上
+// 水印、缩放、IPP 上传在移动端很容易超时。/api/print 在请求内只做解析、预检与
+// 保存上传文件（multipart 临时文件随请求删除，必须先落盘），随即 202 返回 taskId；
+// 其余处理交给有界的工作池，前端轮询 GET /api/print-tasks/{id} 查看阶段进度。
+
+const (
+	// 工作池大小默认值，可用 PRINT_WORKERS 调整。LibreOffice 转换很吃内存，
+	// 小主机上不宜并发太多。
+	defaultPrintWorkers = 2
+	// 排队上限，超过后直接 503，避免上传文件在磁盘上无限堆积。
+	printTaskQueueSize = 64
+	// 单个任务的硬超时，包含排队后的转换与提交。
+	printTaskTimeout = 10 * time.Minute
+	// 已完成任务在内存里的保留时长。
+	printTaskRetention = time.Hour
+
+	printTaskPending   = "pending"
+	printTaskRunning   = "running"
+	printTaskSucceeded = "succeeded"
+	printTaskFailed    = "failed"
+
+	// 处理阶段：waiting 为排队等待工作池，queued 表示作业已进入 CUPS 队列。
+	printStageWaiting      = "waiting"
+	printStageConverting   = "converting"
+	printStageNormalizing  = "normalizing"
+	printStageWatermarking = "watermarking"
+	printStageScaling      = "scaling"
+	printStageReordering   = "reordering"
+	printStageSending      = "sending"
+	printStageQueued       = "queued"
+	printStageScheduled    = "scheduled"
+)
+
+var errPrintQueueFull = errors.New("print queue is full")
+
+// printTask 的字段并发访问统一由 printTasksMu 保护，对外先快照成 printTaskView。
+type printTask struct {
+	id         string
+	userID     int64
+	status     string
+	stage      string
+	errMsg     string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	result     *printResp
+	// convertWait 为等待外部转换器名额时的排队位置（从 1 开始），0 表示没在等。
+	convertWait int
+	run         func(ctx context.Context, t *printTask) (*printResp, error)
+}
+
+type printTaskView struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Stage      string     `json:"stage"`
+	Position   int        `json:"position,omitempty"` // 排队中为前面还有几个任务，运行中为等待转换器的位置
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  string     `json:"createdAt"`
+	StartedAt  string     `json:"startedAt,omitempty"`
+	FinishedAt string     `json:"finishedAt,omitempty"`
+	Result     *printResp `json:"result,omitempty"`
+}
+
+var (
+	printTasksMu   sync.Mutex
+	printTasks     = map[string]*printTask{}
+	printTaskQueue chan *printTask
+)
+
+// viewLocked 必须在持有 printTasksMu 时调用。
+func (t *printTask) viewLocked() *printTaskView {
+	v := &printTaskView{
+		ID:        t.id,
+		Status:    t.status,
+		Stage:     t.stage,
+		Error:     t.errMsg,
+		CreatedAt: t.createdAt.Format(time.RFC3339),
+		Result:    t.result,
+	}
+	if t.status == printTaskRunning {
+		v.Position = t.convertWait
+	}
+	if t.status == printTaskPending {
+		for _, o := range printTasks {
+			if o.status == printTaskPending && o.createdAt.Before(t.createdAt) {
+				v.Position++
+			}
+		}
+	}
+	if !t.startedAt.IsZero() {
+		v.StartedAt = t.startedAt.Format(time.RFC3339)
+	}
+	if !t.finishedAt.IsZero() {
+		v.FinishedAt = t.finishedAt.Format(time.RFC3339)
+	}
+	return v
+}
+
+// setStage 推进处理阶段。t 为 nil（重打等同步路径）时什么也不做。
+func (t *printTask) setStage(stage string) {
+	if t == nil {
+		return
+	}
+	printTasksMu.Lock()
+	t.stage = stage
+	printTasksMu.Unlock()
+}
+
+// prunePrintTasksLocked 清理完成时间超过 printTaskRetention 的任务。
+func prunePrintTasksLocked() {
+	cutoff := time.Now().Add(-printTaskRetention)
+	for id, t := range printTasks {
+		if !t.finishedAt.IsZero() && t.finishedAt.Before(cutoff) {
+			delete(printTasks, id)
+		}
+	}
+}
+
+func printWorkerCount() int {
+	if v := strings.TrimSpace(os.Getenv("PRINT_WORKERS")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("invalid PRINT_WORKERS %q, using %d", v, defaultPrintWorkers)
+	}
+	return defaultPrintWorkers
+}
+
+func startPrintWorkers(n int) {
+	printTaskQueue = make(chan *printTask, printTaskQueueSize)
+	for range n {
+		go func() {
+			for t := range printTaskQueue {
+				runPrintTask(t)
+			}
+		}()
+	}
+}
+
+// submitPrintTask 登记任务并放进工作池队列，队列已满时返回 errPrintQueueFull。
+func submitPrintTask(userID int64, run func(ctx context.Context, t *printTask) (*printResp, error)) (*printTask, error) {
+	t := &printTask{
+		id:        randomToken(),
+		userID:    userID,
+		status:    printTaskPending,
+		stage:     printStageWaiting,
+		createdAt: time.Now(),
+		run:       run,
+	}
+	printTasksMu.Lock()
+	defer printTasksMu.Unlock()
+	prunePrintTasksLocked()
+	select {
+	case printTaskQueue <- t:
+		printTasks[t.id] = t
+		return t, nil
+	default:
+		return nil, errPrintQueueFull
+	}
+}
+
+// submitPrintTaskOrFail 提交任务并回 202；失败时调用 cleanup 删除已保存的上传文件。
+func submitPrintTaskOrFail(w http.ResponseWriter, sess auth.Session, run func(ctx context.Context, t *printTask) (*printResp, error), cleanup func()) {
+	t, err := submitPrintTask(sess.UserID, run)
+	if err != nil {
+		cleanup()
+		writeJSONError(w, http.StatusServiceUnavailable, errPrintQueueFull.Error()+", try again later")
+		return
+	}
+	printTasksMu.Lock()
+	view := t.viewLocked()
+	printTasksMu.Unlock()
+	writeJSONStatus(w, http.StatusAccepted, map[string]any{
+		"taskId":   t.id,
+		"status":   view.Status,
+		"stage":    view.Stage,
+		"position": view.Position,
+	})
+}
+
+func runPrintTask(t *printTask) {
+	printTasksMu.Lock()
+	t.status = printTaskRunning
+	t.startedAt = time.Now()
+	printTasksMu.Unlock()
+
+	// 用 context.Background() 派生：提交任务的请求早已结束。
+	ctx, cancel := context.WithTimeout(context.Background(), printTaskTimeout)
+	defer cancel()
+	ctx = withConverterQueueObserver(ctx, func(pos int) {
+		printTasksMu.Lock()
+		t.convertWait = pos
+		printTasksMu.Unlock()
+	})
+
+	var result *printResp
+	var err error
+	func() {
+		// 后台 goroutine 里的 panic 没有 net/http 兜底，会带崩整个进程。
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("internal error: %v", p)
+			}
+		}()
+		result, err = t.run(ctx, t)
+	}()
+
+	printTasksMu.Lock()
+	defer printTasksMu.Unlock()
+	t.finishedAt = time.Now()
+	t.convertWait = 0
+	if err != nil {
+		t.status = printTaskFailed
+		t.errMsg = err.Error()
+		log.Printf("[print-task] %s 失败（阶段 %s）: %v", t.id, t.stage, err)
+		return
+	}
+	t.status = printTaskSucceeded
+	t.result = result
+	t.stage = printStageQueued
+	if result != nil && result.Scheduled {
+		t.stage = printStageScheduled
+	}
+}
+
+// GET /api/print-tasks/{id} — 查询异步打印任务的状态、阶段与结果。
+// 只有提交者与管理员能看到，其他人一律 404，不暴露任务是否存在。
+func printTaskHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	printTasksMu.Lock()
+	var view *printTaskView
+	if t, ok := printTasks[id]; ok && (t.userID == sess.UserID || sess.Role == store.RoleAdmin) {
+		view = t.viewLocked()
+	}
+	printTasksMu.Unlock()
+
+	if view == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown task id")
+		return
+	}
+	writeJSON(w, view)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitPrintTask(t *testing.T, task *printTask) *printTaskView {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		printTasksMu.Lock()
+		v := task.viewLocked()
+		printTasksMu.Unlock()
+		if v.Status == printTaskSucceeded || v.Status == printTaskFailed {
+			return v
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not finish", task.id)
+	return nil
+}
+
+func TestPrintTaskLifecycle(t *testing.T) {
+	startPrintWorkers(1)
+	t.Cleanup(func() { close(printTaskQueue); printTaskQueue = nil })
+
+	ok, err := submitPrintTask(1, func(ctx context.Context, task *printTask) (*printResp, error) {
+		task.setStage(printStageConverting)
+		return &printResp{OK: true, JobID: "7", Pages: 3}, nil
+	})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	v := waitPrintTask(t, ok)
+	if v.Status != printTaskSucceeded || v.Stage != printStageQueued || v.Result == nil || v.Result.JobID != "7" {
+		t.Errorf("succeeded task view = %+v", v)
+	}
+
+	failed, err := submitPrintTask(1, func(ctx context.Context, task *printTask) (*printResp, error) {
+		task.setStage(printStageConverting)
+		return nil, &printDocumentError{400, "conversion failed"}
+	})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	v = waitPrintTask(t, failed)
+	// 失败时保留出错的阶段，前端据此提示是哪一步失败。
+	if v.Status != printTaskFailed || v.Stage != printStageConverting || v.Error != "conversion failed" {
+		t.Errorf("failed task view = %+v", v)
+	}
+
+	panicked, _ := submitPrintTask(1, func(ctx context.Context, task *printTask) (*printResp, error) {
+		panic("boom")
+	})
+	if v := waitPrintTask(t, panicked); v.Status != printTaskFailed {
+		t.Errorf("panicking task status = %q", v.Status)
+	}
+}
+
+func TestSubmitPrintTaskQueueFull(t *testing.T) {
+	// 没有工作池消费时队列装满即拒绝。
+	printTaskQueue = make(chan *printTask, 1)
+	t.Cleanup(func() { printTaskQueue = nil })
+
+	noop := func(ctx context.Context, task *printTask) (*printResp, error) { return nil, nil }
+	if _, err := submitPrintTask(1, noop); err != nil {
+		t.Fatalf("first submit: %v", err)
+	}
+	if _, err := submitPrintTask(1, noop); !errors.Is(err, errPrintQueueFull) {
+		t.Errorf("second submit err = %v, want errPrintQueueFull", err)
+	}
+}
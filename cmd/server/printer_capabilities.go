@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+)
+
+// ── 打印机能力缓存 ─────────────────────────────────────────────────────────────
+//
+// 选项界面每切换一次打印机、每次打印预检都要用到 *-supported 能力，而这些能力
+// 只在改驱动/改队列配置时才变。按打印机 URI 缓存，TTL 兜底；收到 CUPS 的
+// printer-config-changed / printer-added / printer-deleted 事件时整体失效。
+// URI 来自客户端，只查询、缓存已配置的 CUPS 服务器上报的打印机，条目数也有上限。
+
+const (
+	printerCapsCacheTTL = 5 * time.Minute
+	printerCapsCacheMax = 256
+)
+
+type printerCapsEntry struct {
+	caps     *ipp.PrinterCapabilities
+	loadedAt time.Time
+}
+
+type printerCapsCache struct {
+	mu      sync.Mutex
+	entries map[string]printerCapsEntry
+}
+
+var printerCaps = printerCapsCache{entries: make(map[string]printerCapsEntry)}
+
+// cachedPrinterCapabilities 返回 uri 的能力，缓存缺失或过期时查询打印机。
+// 查询不持锁：一台打印机离线不该拖住其他打印机的请求，偶发的重复查询无害。
+func cachedPrinterCapabilities(uri string) (*ipp.PrinterCapabilities, error) {
+	printerCaps.mu.Lock()
+	entry, ok := printerCaps.entries[uri]
+	printerCaps.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < printerCapsCacheTTL {
+		return entry.caps, nil
+	}
+
+	if err := lookupPrinterURI(uri); err != nil {
+		return nil, err
+	}
+	caps, err := ipp.GetPrinterCapabilities(uri)
+	if err != nil {
+		return nil, err
+	}
+	printerCaps.mu.Lock()
+	printerCaps.storeLocked(uri, printerCapsEntry{caps: caps, loadedAt: time.Now()})
+	printerCaps.mu.Unlock()
+	return caps, nil
+}
+
+// storeLocked 写入条目，先清掉过期条目，仍然满了就淘汰最早加载的一条。调用方须持锁。
+func (c *printerCapsCache) storeLocked(uri string, entry printerCapsEntry) {
+	if _, ok := c.entries[uri]; !ok && len(c.entries) >= printerCapsCacheMax {
+		var oldest string
+		for k, e := range c.entries {
+			if time.Since(e.loadedAt) >= printerCapsCacheTTL {
+				delete(c.entries, k)
+			} else if oldest == "" || e.loadedAt.Before(c.entries[oldest].loadedAt) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= printerCapsCacheMax {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[uri] = entry
+}
+
+// invalidatePrinterCapabilities 使 uri 的缓存失效，uri 为空时清空全部。
+func invalidatePrinterCapabilities(uri string) {
+	printerCaps.mu.Lock()
+	defer printerCaps.mu.Unlock()
+	if uri == "" {
+		clear(printerCaps.entries)
+		return
+	}
+	delete(printerCaps.entries, uri)
+}
+
+// GET /api/printers/capabilities?uri=<printer_uri>[&refresh=1]
+// 返回结构化的打印机能力，供前端按打印机裁剪可选项。
+func printerCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("uri")
+	if uri == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing uri parameter")
+		return
+	}
+	sess, _ := auth.GetSession(r)
+	if err := checkPrinterAccess(r.Context(), sess, uri); err != nil {
+		writePrintError(w, err)
+		return
+	}
+	if r.URL.Query().Get("refresh") == "1" {
+		invalidatePrinterCapabilities(uri)
+	}
+	caps, err := cachedPrinterCapabilities(uri)
+	if errors.Is(err, errPrinterNotFound) {
+		writeJSONError(w, http.StatusNotFound, "printer not found")
+		return
+	}
+	if err != nil {
+		// 与 printer-info 一致：底层错误只记日志，不回显给客户端。
+		log.Printf("[printer-caps] uri=%q: %v", uri, err)
+		writeJSONError(w, http.StatusBadGateway, "failed to get printer capabilities")
+		return
+	}
+	writeJSON(w, caps)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPrinterCapsCacheBounded(t *testing.T) {
+	c := printerCapsCache{entries: make(map[string]printerCapsEntry)}
+	now := time.Now()
+	c.storeLocked("expired", printerCapsEntry{loadedAt: now.Add(-2 * printerCapsCacheTTL)})
+	for i := range printerCapsCacheMax - 1 {
+		c.storeLocked(fmt.Sprintf("p%d", i), printerCapsEntry{loadedAt: now.Add(time.Duration(i) * time.Millisecond)})
+	}
+	// 满了：过期条目先被清掉，新条目直接放得下。
+	c.storeLocked("new", printerCapsEntry{loadedAt: now.Add(time.Second)})
+	if _, ok := c.entries["expired"]; ok || len(c.entries) != printerCapsCacheMax {
+		t.Fatalf("expired kept=%v, len=%d", ok, len(c.entries))
+	}
+	// 仍然满时淘汰最早加载的。
+	c.storeLocked("newer", printerCapsEntry{loadedAt: now.Add(2 * time.Second)})
+	if _, ok := c.entries["p0"]; ok || len(c.entries) != printerCapsCacheMax {
+		t.Errorf("oldest kept=%v, len=%d", ok, len(c.entries))
+	}
+}
+
+func TestLookupPrinterURIUnknownHost(t *testing.T) {
+	// 不属于任何已配置服务器的 URI 不会发出请求。
+	if err := lookupPrinterURI("ipp://attacker.example:631/printers/x"); !errors.Is(err, errPrinterNotFound) {
+		t.Errorf("lookupPrinterURI = %v, want %v", err, errPrinterNotFound)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 
+	"cups-web/internal/auth"
 	"cups-web/internal/ipp"
 )
 
@@ -18,6 +19,12 @@ func printerInfoHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "missing uri parameter")
 		return
 	}
+	sess, _ := auth.GetSession(r)
+	if err := checkPrinterAccess(r.Context(), sess, uri); err != nil {
+		log.Printf("[printer-info] access denied for %q: %v", uri, err)
+		writePrintError(w, err)
+		return
+	}
 
 	log.Printf("[printer-info] calling GetPrinterAttributes for uri=%q", uri)
 	info, err := ipp.GetPrinterAttributes(uri)
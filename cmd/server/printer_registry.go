@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// ── 打印机登记与授权 ──────────────────────────────────────────────────────────
+//
+// 管理员把 CUPS 上的队列登记到 printers 表，设置展示名、位置、说明、是否隐藏/启用，
+// 以及允许使用的角色、用户组与用户（不设则所有人可用，管理员总是可用）。登记限制启用后，
+// /api/printers 只返回当前用户可用且未隐藏的打印机，打印、重打与 printer-info 拒绝
+// 未登记或未授权的 URI。系统设置 printer_registry 默认为 auto：登记了至少一台打印机
+// 即启用，一台都没登记时不限制，新部署装好就能打印；on 为始终启用，off 为不限制。
+
+var (
+	errPrinterNotRegistered = errors.New("printer is not registered")
+	errPrinterNotPermitted  = errors.New("printer is not available to this user")
+)
+
+// printerRegistry 是某次请求看到的登记表快照，groups 为当前用户所在的用户组。
+// enforced 为 false 表示未开启登记限制。
+type printerRegistry struct {
+	enforced bool
+	byURI    map[string]store.RegisteredPrinter
+	groups   []int64
+}
+
+func loadPrinterRegistry(ctx context.Context, userID int64) (printerRegistry, error) {
+	reg := printerRegistry{byURI: map[string]store.RegisteredPrinter{}}
+	err := appStore.WithTx(ctx, true, func(tx *sql.Tx) error {
+		mode, err := store.GetSettingString(ctx, tx, store.SettingPrinterRegistry, store.PrinterRegistryAuto)
+		if err != nil {
+			return err
+		}
+		printers, err := store.ListRegisteredPrinters(ctx, tx)
+		if err != nil {
+			return err
+		}
+		reg.enforced = printerRegistryEnforced(normalizePrinterRegistryMode(mode), len(printers))
+		if reg.groups, err = store.ListUserGroupIDs(ctx, tx, userID); err != nil {
+			return err
+		}
+		for _, p := range printers {
+			reg.byURI[p.URI] = p
+		}
+		return nil
+	})
+	return reg, err
+}
+
+// normalizePrinterRegistryMode 把设置值规整为 PrinterRegistry* 之一，无法识别时按 auto 处理。
+func normalizePrinterRegistryMode(v string) string {
+	switch v {
+	case store.PrinterRegistryOn, store.PrinterRegistryOff:
+		return v
+	}
+	return store.PrinterRegistryAuto
+}
+
+// printerRegistryEnforced 报告登记限制是否生效：auto 模式下登记了打印机才生效。
+func printerRegistryEnforced(mode string, registered int) bool {
+	switch mode {
+	case store.PrinterRegistryOn:
+		return true
+	case store.PrinterRegistryOff:
+		return false
+	}
+	return registered > 0
+}
+
+// check 报告用户能否使用 uri 指向的打印机。
+func (reg printerRegistry) check(sess auth.Session, uri string) error {
+	if !reg.enforced {
+		return nil
+	}
+	p, ok := reg.byURI[uri]
+	if !ok {
+		return errPrinterNotRegistered
+	}
+	if !p.Enabled || !p.Allows(sess.UserID, sess.Role, reg.groups) {
+		return errPrinterNotPermitted
+	}
+	return nil
+}
+
+// checkPrinterAccess 在打印、重打与查询打印机属性前检查登记与授权，不通过时返回 403。
+func checkPrinterAccess(ctx context.Context, sess auth.Session, uri string) error {
+	reg, err := loadPrinterRegistry(ctx, sess.UserID)
+	if err != nil {
+		return &printDocumentError{http.StatusInternalServerError, "failed to load printers"}
+	}
+	if err := reg.check(sess, uri); err != nil {
+		return &printDocumentError{http.StatusForbidden, err.Error()}
+	}
+	return nil
+}
+
+// printerListEntry 是 /api/printers 返回的打印机：CUPS 上报的属性加上登记的展示信息。
+type printerListEntry struct {
+	ipp.Printer
+	DisplayName string `json:"displayName,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// visiblePrinters 按登记表过滤 CUPS 打印机列表，并用登记的位置覆盖 CUPS 上报的位置。
+func (reg printerRegistry) visiblePrinters(sess auth.Session, printers []ipp.Printer) []printerListEntry {
+	out := make([]printerListEntry, 0, len(printers))
+	for _, p := range printers {
+		if !reg.enforced {
+			out = append(out, printerListEntry{Printer: p})
+			continue
+		}
+		rp, ok := reg.byURI[p.URI]
+		if !ok || rp.Hidden || reg.check(sess, p.URI) != nil {
+			continue
+		}
+		if rp.Location != "" {
+			p.Location = rp.Location
+		}
+		out = append(out, printerListEntry{Printer: p, DisplayName: rp.DisplayName, Description: rp.Description})
+	}
+	return out
+}
+
+// allowsPrinterEvent 报告用户能否收到 host 服务器上名为 name 的打印机事件。事件只带
+// 队列名，按登记的 URI 反查服务器与队列名；只推给能在列表里看到该打印机的用户。
+func (reg printerRegistry) allowsPrinterEvent(sess auth.Session, host, name string) bool {
+	if !reg.enforced || name == "" {
+		return true
+	}
+	for uri, p := range reg.byURI {
+		u, err := url.Parse(uri)
+		if err != nil || p.Hidden || path.Base(u.Path) != name {
+			continue
+		}
+		if srv, ok := cupsServerForURI(uri); !ok || srv.Label != host {
+			continue
+		}
+		if reg.check(sess, uri) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+type printerAccessPayload struct {
+	Type    string `json:"type"`
+	Subject string `json:"subject"`
+	Name    string `json:"name,omitempty"` // 用户、组授权附带名称，便于展示
+}
+
+type registeredPrinterPayload struct {
+	ID          int64                  `json:"id,omitempty"`
+	URI         string                 `json:"uri"`
+	DisplayName string                 `json:"displayName"`
+	Location    string                 `json:"location"`
+	Description string                 `json:"description"`
+	Hidden      bool                   `json:"hidden"`
+	Enabled     bool                   `json:"enabled"`
+	Access      []printerAccessPayload `json:"access"`
+	CreatedAt   string                 `json:"createdAt,omitempty"`
+}
+
+var errInvalidPrinter = errors.New("invalid printer")
+
+// toRegisteredPrinter 校验并规范化管理员提交的打印机登记。
+func (p registeredPrinterPayload) toRegisteredPrinter(ctx context.Context, tx *sql.Tx) (store.RegisteredPrinter, error) {
+	rp := store.RegisteredPrinter{
+		ID:          p.ID,
+		URI:         strings.TrimSpace(p.URI),
+		DisplayName: strings.TrimSpace(p.DisplayName),
+		Location:    strings.TrimSpace(p.Location),
+		Description: strings.TrimSpace(p.Description),
+		Hidden:      p.Hidden,
+		Enabled:     p.Enabled,
+	}
+	u, err := url.Parse(rp.URI)
+	if err != nil || u.Host == "" {
+		return rp, fmt.Errorf("%w: uri must be an absolute printer URI", errInvalidPrinter)
+	}
+	switch u.Scheme {
+	case "http", "https", "ipp", "ipps":
+	default:
+		return rp, fmt.Errorf("%w: unsupported uri scheme", errInvalidPrinter)
+	}
+	for _, a := range p.Access {
+		subject := strings.TrimSpace(a.Subject)
+		switch a.Type {
+		case store.PrinterAccessRole:
+			role := normalizeRole(subject)
+			if subject == "" || role == "" {
+				return rp, fmt.Errorf("%w: unknown role", errInvalidPrinter)
+			}
+			subject = role
+		case store.PrinterAccessUser:
+			id, err := strconv.ParseInt(subject, 10, 64)
+			if err != nil {
+				return rp, fmt.Errorf("%w: unknown user", errInvalidPrinter)
+			}
+			if _, err := store.GetUserByID(ctx, tx, id); err != nil {
+				return rp, fmt.Errorf("%w: unknown user", errInvalidPrinter)
+			}
+		case store.PrinterAccessGroup:
+			if err := checkGroupTarget(ctx, tx, subject); err != nil {
+				return rp, fmt.Errorf("%w: %w", errInvalidPrinter, err)
+			}
+		default:
+			return rp, fmt.Errorf("%w: unknown access type", errInvalidPrinter)
+		}
+		rp.Access = append(rp.Access, store.PrinterAccess{SubjectType: a.Type, Subject: subject})
+	}
+	return rp, nil
+}
+
+// GET /api/admin/printers — 已登记的打印机，以及 CUPS 上尚未登记的队列（供登记时选择）。
+func adminListPrintersHandler(w http.ResponseWriter, r *http.Request) {
+	var registered []store.RegisteredPrinter
+	var names targetNames
+	err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		if registered, err = store.ListRegisteredPrinters(r.Context(), tx); err != nil {
+			return err
+		}
+		names, err = loadTargetNames(r.Context(), tx)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load printers")
+		return
+	}
+	known := make(map[string]bool, len(registered))
+	resp := make([]registeredPrinterPayload, 0, len(registered))
+	for _, p := range registered {
+		known[p.URI] = true
+		item := registeredPrinterPayload{
+			ID:          p.ID,
+			URI:         p.URI,
+			DisplayName: p.DisplayName,
+			Location:    p.Location,
+			Description: p.Description,
+			Hidden:      p.Hidden,
+			Enabled:     p.Enabled,
+			Access:      []printerAccessPayload{},
+			CreatedAt:   p.CreatedAt,
+		}
+		for _, a := range p.Access {
+			item.Access = append(item.Access, printerAccessPayload{
+				Type:    a.SubjectType,
+				Subject: a.Subject,
+				Name:    names.name(a.SubjectType, a.Subject),
+			})
+		}
+		resp = append(resp, item)
+	}
+
+	cupsPrinters, hosts := listAllPrinters()
+	unregistered := []ipp.Printer{}
+	for _, p := range cupsPrinters {
+		if !known[p.URI] {
+			unregistered = append(unregistered, p)
+		}
+	}
+	writeJSON(w, map[string]any{"printers": resp, "unregistered": unregistered, "hosts": hosts})
+}
+
+// POST /api/admin/printers
+func adminCreatePrinterHandler(w http.ResponseWriter, r *http.Request) {
+	var payload registeredPrinterPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	var id int64
+	err := appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		rp, err := payload.toRegisteredPrinter(r.Context(), tx)
+		if err != nil {
+			return err
+		}
+		if _, err := store.GetRegisteredPrinterByURI(r.Context(), tx, rp.URI); err == nil {
+			return errPrinterAlreadyRegistered
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		id, err = store.CreateRegisteredPrinter(r.Context(), tx, &rp)
+		return err
+	})
+	switch {
+	case errors.Is(err, errInvalidPrinter):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errPrinterAlreadyRegistered):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to register printer")
+	default:
+		writeJSON(w, map[string]any{"ok": true, "id": id})
+	}
+}
+
+var errPrinterAlreadyRegistered = errors.New("printer is already registered")
+
+// PUT /api/admin/printers/{id} — 修改展示信息、开关与授权，URI 不可改。
+func adminUpdatePrinterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var payload registeredPrinterPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	payload.ID = id
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		rp, err := payload.toRegisteredPrinter(r.Context(), tx)
+		if err != nil {
+			return err
+		}
+		return store.UpdateRegisteredPrinter(r.Context(), tx, rp)
+	})
+	switch {
+	case errors.Is(err, errInvalidPrinter):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "printer not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to update printer")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
+
+// DELETE /api/admin/printers/{id}
+func adminDeletePrinterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		return store.DeleteRegisteredPrinter(r.Context(), tx, id)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "printer not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete printer")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
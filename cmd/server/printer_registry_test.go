@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+func TestPrinterRegistry(t *testing.T) {
+	const (
+		lab    = "http://cups:631/printers/lab"
+		office = "http://cups:631/printers/office"
+		secret = "http://cups:631/printers/secret"
+		off    = "http://cups:631/printers/off"
+		stray  = "http://cups:631/printers/stray"
+		class  = "http://cups:631/printers/class"
+	)
+	staff := []store.PrinterAccess{{SubjectType: store.PrinterAccessRole, Subject: store.RoleAdmin}}
+	reg := printerRegistry{enforced: true, byURI: map[string]store.RegisteredPrinter{
+		lab:    {URI: lab, DisplayName: "Lab", Location: "B101", Enabled: true},
+		office: {URI: office, Enabled: true, Access: staff},
+		secret: {URI: secret, Enabled: true, Hidden: true},
+		off:    {URI: off},
+		class:  {URI: class, Enabled: true, Access: []store.PrinterAccess{{SubjectType: store.PrinterAccessGroup, Subject: "3"}}},
+	}, groups: []int64{3}}
+	user := auth.Session{UserID: 2, Role: store.RoleUser}
+
+	for uri, want := range map[string]error{
+		lab:    nil,
+		secret: nil, // 隐藏只影响列表
+		office: errPrinterNotPermitted,
+		off:    errPrinterNotPermitted,
+		stray:  errPrinterNotRegistered,
+		class:  nil, // 按用户组授权
+	} {
+		if err := reg.check(user, uri); !errors.Is(err, want) {
+			t.Errorf("check(%s) = %v, want %v", uri, err, want)
+		}
+	}
+
+	cups := []ipp.Printer{{URI: lab, Location: "2F"}, {URI: office}, {URI: secret}, {URI: off}, {URI: stray}, {URI: class}}
+	got := reg.visiblePrinters(user, cups)
+	if len(got) != 2 || got[0].URI != lab || got[0].DisplayName != "Lab" || got[0].Location != "B101" || got[1].URI != class {
+		t.Errorf("visiblePrinters = %+v", got)
+	}
+
+	// 未开启登记限制时不做限制。
+	if err := (printerRegistry{}).check(user, stray); err != nil {
+		t.Errorf("unenforced check = %v", err)
+	}
+	if got := (printerRegistry{}).visiblePrinters(user, cups); len(got) != len(cups) {
+		t.Errorf("unenforced visiblePrinters = %d printers", len(got))
+	}
+
+	// 开启限制但一台都没登记时拒绝一切，管理员也不例外。
+	empty := printerRegistry{enforced: true, byURI: map[string]store.RegisteredPrinter{}}
+	admin := auth.Session{UserID: 1, Role: store.RoleAdmin}
+	if err := empty.check(admin, lab); !errors.Is(err, errPrinterNotRegistered) {
+		t.Errorf("empty registry check = %v, want %v", err, errPrinterNotRegistered)
+	}
+	if got := empty.visiblePrinters(user, cups); len(got) != 0 {
+		t.Errorf("empty registry visiblePrinters = %d printers, want 0", len(got))
+	}
+}
+
+func TestPrinterRegistryEnforced(t *testing.T) {
+	for _, tt := range []struct {
+		setting    string
+		registered int
+		want       bool
+	}{
+		{store.PrinterRegistryAuto, 0, false}, // 新部署一台都没登记时照常可用
+		{store.PrinterRegistryAuto, 1, true},
+		{"", 2, true}, // 无法识别的值按 auto
+		{store.PrinterRegistryOn, 0, true},
+		{store.PrinterRegistryOff, 3, false},
+	} {
+		if got := printerRegistryEnforced(normalizePrinterRegistryMode(tt.setting), tt.registered); got != tt.want {
+			t.Errorf("enforced(%q, %d) = %v, want %v", tt.setting, tt.registered, got, tt.want)
+		}
+	}
+}
+
+func TestPrinterRegistryEvents(t *testing.T) {
+	srv := cupsServers()[0]
+	root, err := ipp.ServerURI(srv.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lab, office, secret := root+"printers/lab", root+"printers/office", root+"printers/secret"
+	reg := printerRegistry{enforced: true, byURI: map[string]store.RegisteredPrinter{
+		lab:    {URI: lab, Enabled: true},
+		office: {URI: office, Enabled: true, Access: []store.PrinterAccess{{SubjectType: store.PrinterAccessRole, Subject: store.RoleAdmin}}},
+		secret: {URI: secret, Enabled: true, Hidden: true},
+	}}
+	user := auth.Session{UserID: 2, Role: store.RoleUser}
+
+	for name, want := range map[string]bool{
+		"lab":     true,
+		"office":  false, // 未授权
+		"secret":  false, // 隐藏的打印机不在列表里
+		"stray":   false, // 未登记
+		"":        true,  // 不针对具体打印机的事件
+		"lab-new": false,
+	} {
+		if got := reg.allowsPrinterEvent(user, srv.Label, name); got != want {
+			t.Errorf("allowsPrinterEvent(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if reg.allowsPrinterEvent(user, srv.Label+"-other", "lab") {
+		t.Error("event from another server matched by queue name")
+	}
+	if !(printerRegistry{}).allowsPrinterEvent(user, srv.Label, "stray") {
+		t.Error("unenforced registry filtered an event")
+	}
+}
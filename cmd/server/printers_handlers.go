@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// recentCompletedJobs 是队列视图中附带展示的最近完成作业条数。
+const recentCompletedJobs = 10
+
+// cupsHost 返回 CUPS 服务器地址（CUPS_HOST 环境变量，默认 localhost）。
+func cupsHost() string {
+	if h := os.Getenv("CUPS_HOST"); h != "" {
+		return h
+	}
+	return "localhost"
+}
+
+// GET /api/printers — 通过 CUPS-Get-Printers 列出全部服务器上的打印机与打印机类，
+// 按登记表只保留当前用户可用的（见 printer_registry.go）。
+// hosts 给出每台服务器的可达情况；只有全部服务器都不可达时才返回错误。
+func listPrintersHandler(w http.ResponseWriter, r *http.Request) {
+	sess, _ := auth.GetSession(r)
+	reg, err := loadPrinterRegistry(r.Context(), sess.UserID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load printers")
+		return
+	}
+	printers, hosts := listAllPrinters()
+	available := 0
+	for _, h := range hosts {
+		if h.Available {
+			available++
+		}
+	}
+	if available == 0 {
+		writeJSONError(w, http.StatusInternalServerError, "failed to list printers")
+		return
+	}
+	writeJSON(w, map[string]any{"printers": reg.visiblePrinters(sess, printers), "hosts": hosts})
+}
+
+var errPrinterNotFound = errors.New("printer not found")
+
+// findPrinterByName 在 CUPS-Get-Printers 结果里按队列名查找打印机，
+// 让按名称访问的接口拿到与 /api/printers 一致的 URI。host 为服务器标签，
+// 为空时在全部服务器里按配置顺序取第一个同名队列。
+func findPrinterByName(host, name string) (ipp.Printer, error) {
+	if host != "" {
+		srv, err := cupsServerByLabel(host)
+		if err != nil {
+			return ipp.Printer{}, errPrinterNotFound
+		}
+		printers, err := ipp.ListPrinters(srv.Host)
+		if err != nil {
+			return ipp.Printer{}, err
+		}
+		for _, p := range printers {
+			if p.Name == name {
+				p.Host = srv.Label
+				return p, nil
+			}
+		}
+		return ipp.Printer{}, errPrinterNotFound
+	}
+
+	printers, hosts := listAllPrinters()
+	for _, p := range printers {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	// 没找到时若有服务器不可达，不能断定队列不存在。
+	for _, h := range hosts {
+		if !h.Available {
+			return ipp.Printer{}, fmt.Errorf("cups host %q unreachable", h.Label)
+		}
+	}
+	return ipp.Printer{}, errPrinterNotFound
+}
+
+// lookupPrinterURI 确认 uri 是某台已配置的 CUPS 服务器上报的打印机，避免按客户端
+// 传来的任意 URI 向外发 IPP 请求。不是时返回 errPrinterNotFound。
+func lookupPrinterURI(uri string) error {
+	srv, ok := cupsServerForURI(uri)
+	if !ok {
+		return errPrinterNotFound
+	}
+	printers, err := ipp.ListPrinters(srv.Host)
+	if err != nil {
+		return err
+	}
+	for _, p := range printers {
+		if p.URI == uri {
+			return nil
+		}
+	}
+	return errPrinterNotFound
+}
+
+type printerQueueEntry struct {
+	JobID          int      `json:"jobId"`
+	Name           string   `json:"name"`
+	Owner          string   `json:"owner"`
+	State          string   `json:"state"`
+	StateReasons   []string `json:"stateReasons"`
+	Pages          int      `json:"pages"`
+	PagesCompleted int      `json:"pagesCompleted"`
+	SubmittedAt    string   `json:"submittedAt,omitempty"`
+	CompletedAt    string   `json:"completedAt,omitempty"`
+	// Position 是未完成作业在队列中的名次（从 1 开始），已完成作业为 0。
+	Position int  `json:"position,omitempty"`
+	Mine     bool `json:"mine"`
+	Redacted bool `json:"redacted"`
+	// RecordID 是对应的本地打印记录，仅对作业所有者和管理员返回。
+	RecordID int64 `json:"recordId,omitempty"`
+}
+
+// GET /api/printers/{name}/jobs — 通过 Get-Jobs 查看打印机队列：全部未完成作业
+// 加最近完成的若干条。作业与 print_jobs 记录按 job-id 对应，用来标出「我的」作业；
+// 非管理员看不到其他用户的作业名。
+func printerJobsHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	printer, err := findPrinterByName(r.URL.Query().Get("host"), name)
+	if err != nil {
+		if errors.Is(err, errPrinterNotFound) {
+			writeJSONError(w, http.StatusNotFound, "printer not found")
+			return
+		}
+		log.Printf("[printer-jobs] list printers failed: %v", err)
+		writeJSONError(w, http.StatusBadGateway, "failed to list printers")
+		return
+	}
+	printerURI := printer.URI
+	if err := checkPrinterAccess(r.Context(), sess, printerURI); err != nil {
+		writePrintError(w, err)
+		return
+	}
+
+	active, err := ipp.GetJobs(printerURI, ipp.WhichJobsNotCompleted, sess.Username, 0)
+	if err != nil {
+		log.Printf("[printer-jobs] get active jobs on %q: %v", printerURI, err)
+		writeJSONError(w, http.StatusBadGateway, "failed to get printer jobs")
+		return
+	}
+	completed, err := ipp.GetJobs(printerURI, ipp.WhichJobsCompleted, sess.Username, 0)
+	if err != nil {
+		log.Printf("[printer-jobs] get completed jobs on %q: %v", printerURI, err)
+		writeJSONError(w, http.StatusBadGateway, "failed to get printer jobs")
+		return
+	}
+	// CUPS 按 job-id 升序返回，即排队顺序；已完成作业只保留最近的若干条。
+	sort.Slice(active, func(i, j int) bool { return active[i].JobID < active[j].JobID })
+	sort.Slice(completed, func(i, j int) bool { return completed[i].JobID > completed[j].JobID })
+	if len(completed) > recentCompletedJobs {
+		completed = completed[:recentCompletedJobs]
+	}
+
+	since := time.Now().Add(-jobTrackMaxAge).UTC().Format(time.RFC3339)
+	var records []store.PrintRecord
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		records, err = store.ListPrintRecordsByPrinter(r.Context(), tx, printerURI, since)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load records")
+		return
+	}
+	byJobID := make(map[int]store.PrintRecord, len(records))
+	for _, rec := range records {
+		if id := ipp.ParseJobID(rec.JobID.String); id > 0 {
+			byJobID[id] = rec
+		}
+	}
+
+	isAdmin := sess.Role == store.RoleAdmin
+	entries := make([]printerQueueEntry, 0, len(active)+len(completed))
+	for i, job := range append(active, completed...) {
+		entry := printerQueueEntry{
+			JobID:          job.JobID,
+			Name:           job.Name,
+			Owner:          job.Owner,
+			State:          job.State,
+			StateReasons:   job.StateReasons,
+			Pages:          job.Pages,
+			PagesCompleted: job.PagesCompleted,
+		}
+		if i < len(active) {
+			entry.Position = i + 1
+		}
+		if !job.SubmittedAt.IsZero() {
+			entry.SubmittedAt = job.SubmittedAt.Format(time.RFC3339)
+		}
+		if !job.CompletedAt.IsZero() {
+			entry.CompletedAt = job.CompletedAt.Format(time.RFC3339)
+		}
+		// CUPS 的 JobPrivateValues 可能已隐去他人作业的名称和所有者，
+		// 本地记录里有的以本地为准。
+		if rec, ok := byJobID[job.JobID]; ok {
+			entry.Owner = rec.Username
+			if entry.Name == "" {
+				entry.Name = rec.Filename
+			}
+			if entry.Pages == 0 {
+				entry.Pages = rec.Pages
+			}
+			entry.Mine = rec.UserID == sess.UserID
+			if entry.Mine || isAdmin {
+				entry.RecordID = rec.ID
+			}
+		} else {
+			entry.Mine = job.Owner != "" && job.Owner == sess.Username
+		}
+		if !entry.Mine && !isAdmin {
+			entry.Name = ""
+			entry.Redacted = true
+		}
+		entries = append(entries, entry)
+	}
+
+	writeJSON(w, map[string]any{"printer": name, "host": printer.Host, "jobs": entries})
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/store"
+)
+
+// ── 页数配额 ──────────────────────────────────────────────────────────────────
+//
+// 管理员在设置里选择按月或按周重置后启用配额。配额规则按角色设默认值、按用户组或
+// 用户单独覆盖（用户 > 组 > 角色），彩色与黑白分开计；管理员还可以给某个用户
+// 本周期一次性追加页数，规则变更与追加都记入 quota_adjustments。
+// 已用页数按 quota_usage 用量流水汇总，失败与取消的作业冲回，不随打印记录清理。
+// 打印与重打在提交到 CUPS 之前（建记录的事务里）检查，超出时拒绝（403）。
+
+// quotaPeriod 读取配额周期设置，未启用或读取失败时返回空字符串。
+func quotaPeriod(ctx context.Context) string {
+	period := ""
+	_ = appStore.WithTx(ctx, true, func(tx *sql.Tx) error {
+		v, err := store.GetSettingString(ctx, tx, store.SettingQuotaPeriod, "")
+		if err != nil {
+			return err
+		}
+		period = normalizeQuotaPeriod(v)
+		return nil
+	})
+	return period
+}
+
+func normalizeQuotaPeriod(v string) string {
+	switch v {
+	case store.QuotaPeriodMonth, store.QuotaPeriodWeek:
+		return v
+	}
+	return ""
+}
+
+func quotaPeriodText(period string) string {
+	if period == store.QuotaPeriodWeek {
+		return "weekly"
+	}
+	return "monthly"
+}
+
+// checkPrintQuota 检查用户本周期的剩余配额够不够打 impressions 面，excludeID 为
+// 已插入的本次记录（不计入已用）。
+func checkPrintQuota(ctx context.Context, tx *sql.Tx, user store.User, period string, isColor bool, impressions int64, excludeID int64) error {
+	st, err := store.GetQuotaStatus(ctx, tx, user, period, time.Now(), excludeID)
+	if err != nil {
+		return err
+	}
+	remaining := st.Remaining(isColor)
+	if remaining == store.QuotaUnlimited || impressions <= remaining {
+		return nil
+	}
+	kind := "mono"
+	if isColor {
+		kind = "color"
+	}
+	return &printDocumentError{http.StatusForbidden, fmt.Sprintf(
+		"%s %s page quota exceeded: this job needs %d pages, %d remaining", quotaPeriodText(period), kind, impressions, remaining)}
+}
+
+// quotaStatusResponse 是 /api/me 与管理接口返回的配额使用情况，Remaining 为 -1 表示不限。
+type quotaStatusResponse struct {
+	Period         string `json:"period"`
+	PeriodStart    string `json:"periodStart"`
+	ColorLimit     int64  `json:"colorLimit"`
+	MonoLimit      int64  `json:"monoLimit"`
+	ColorGranted   int64  `json:"colorGranted"`
+	MonoGranted    int64  `json:"monoGranted"`
+	ColorUsed      int64  `json:"colorUsed"`
+	MonoUsed       int64  `json:"monoUsed"`
+	ColorRemaining int64  `json:"colorRemaining"`
+	MonoRemaining  int64  `json:"monoRemaining"`
+}
+
+func mapQuotaStatus(st store.QuotaStatus) *quotaStatusResponse {
+	return &quotaStatusResponse{
+		Period:         st.Period,
+		PeriodStart:    st.PeriodStart,
+		ColorLimit:     st.ColorLimit,
+		MonoLimit:      st.MonoLimit,
+		ColorGranted:   st.ColorGranted,
+		MonoGranted:    st.MonoGranted,
+		ColorUsed:      st.ColorUsed,
+		MonoUsed:       st.MonoUsed,
+		ColorRemaining: st.Remaining(true),
+		MonoRemaining:  st.Remaining(false),
+	}
+}
+
+// userQuotaStatus 返回用户当前周期的配额情况，未启用配额时返回 nil。
+func userQuotaStatus(ctx context.Context, tx *sql.Tx, user store.User) (*quotaStatusResponse, error) {
+	v, err := store.GetSettingString(ctx, tx, store.SettingQuotaPeriod, "")
+	if err != nil {
+		return nil, err
+	}
+	period := normalizeQuotaPeriod(v)
+	if period == "" {
+		return nil, nil
+	}
+	st, err := store.GetQuotaStatus(ctx, tx, user, period, time.Now(), 0)
+	if err != nil {
+		return nil, err
+	}
+	return mapQuotaStatus(st), nil
+}
+
+type printQuotaPayload struct {
+	ID         int64  `json:"id,omitempty"`
+	TargetType string `json:"targetType"`
+	Target     string `json:"target"`
+	TargetName string `json:"targetName,omitempty"` // 用户、组规则附带名称，便于展示
+	ColorPages int64  `json:"colorPages"`
+	MonoPages  int64  `json:"monoPages"`
+	Note       string `json:"note,omitempty"`
+}
+
+// GET /api/admin/quotas — 配额周期与全部配额规则。
+func adminListQuotasHandler(w http.ResponseWriter, r *http.Request) {
+	var quotas []store.PrintQuota
+	var names targetNames
+	err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		if quotas, err = store.ListPrintQuotas(r.Context(), tx); err != nil {
+			return err
+		}
+		names, err = loadTargetNames(r.Context(), tx)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load quotas")
+		return
+	}
+	rules := make([]printQuotaPayload, 0, len(quotas))
+	for _, q := range quotas {
+		rules = append(rules, printQuotaPayload{
+			ID:         q.ID,
+			TargetType: q.TargetType,
+			Target:     q.Target,
+			TargetName: names.name(q.TargetType, q.Target),
+			ColorPages: q.ColorPages,
+			MonoPages:  q.MonoPages,
+		})
+	}
+	writeJSON(w, map[string]any{"period": quotaPeriod(r.Context()), "rules": rules})
+}
+
+// PUT /api/admin/quotas — 新增或修改一条配额规则（按 targetType + target 覆盖）。
+func adminSetQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	var payload printQuotaPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	payload.Target = strings.TrimSpace(payload.Target)
+	if payload.ColorPages < store.QuotaUnlimited || payload.MonoPages < store.QuotaUnlimited {
+		writeJSONError(w, http.StatusBadRequest, "pages must be -1 (unlimited) or more")
+		return
+	}
+	sess, _ := auth.GetSession(r)
+	err := appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		switch payload.TargetType {
+		case store.QuotaTargetRole:
+			role := normalizeRole(payload.Target)
+			if payload.Target == "" || role == "" {
+				return errInvalidQuotaTarget
+			}
+			payload.Target = role
+		case store.QuotaTargetUser:
+			id, err := strconv.ParseInt(payload.Target, 10, 64)
+			if err != nil {
+				return errInvalidQuotaTarget
+			}
+			if _, err := store.GetUserByID(r.Context(), tx, id); err != nil {
+				return errInvalidQuotaTarget
+			}
+		case store.QuotaTargetGroup:
+			if err := checkGroupTarget(r.Context(), tx, payload.Target); err != nil {
+				return errInvalidQuotaTarget
+			}
+		default:
+			return errInvalidQuotaTarget
+		}
+		return store.SetPrintQuota(r.Context(), tx, store.PrintQuota{
+			TargetType: payload.TargetType,
+			Target:     payload.Target,
+			ColorPages: payload.ColorPages,
+			MonoPages:  payload.MonoPages,
+		}, sess.UserID, strings.TrimSpace(payload.Note))
+	})
+	switch {
+	case errors.Is(err, errInvalidQuotaTarget):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to save quota")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
+
+var errInvalidQuotaTarget = errors.New("invalid quota target")
+
+// DELETE /api/admin/quotas/{id}
+func adminDeleteQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	sess, _ := auth.GetSession(r)
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		return store.DeletePrintQuota(r.Context(), tx, id, sess.UserID, "")
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "quota not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete quota")
+	default:
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
+
+type quotaGrantPayload struct {
+	ColorPages int64  `json:"colorPages"`
+	MonoPages  int64  `json:"monoPages"`
+	Note       string `json:"note"`
+}
+
+// POST /api/admin/users/{id}/quota-grants — 给用户本周期一次性追加页数（负数为扣减）。
+func adminGrantQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var payload quotaGrantPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || (payload.ColorPages == 0 && payload.MonoPages == 0) {
+		writeJSONError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	period := quotaPeriod(r.Context())
+	if period == "" {
+		writeJSONError(w, http.StatusConflict, "quotas are not enabled")
+		return
+	}
+	sess, _ := auth.GetSession(r)
+	var status *quotaStatusResponse
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		user, err := store.GetUserByID(r.Context(), tx, id)
+		if err != nil {
+			return err
+		}
+		periodStart := store.QuotaPeriodStart(period, time.Now())
+		if err := store.GrantQuota(r.Context(), tx, id, periodStart, payload.ColorPages, payload.MonoPages, sess.UserID, strings.TrimSpace(payload.Note)); err != nil {
+			return err
+		}
+		status, err = userQuotaStatus(r.Context(), tx, user)
+		return err
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "user not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to grant quota")
+	default:
+		writeJSON(w, map[string]any{"ok": true, "quota": status})
+	}
+}
+
+// GET /api/admin/users/{id}/quota — 用户当前周期的配额情况与调整流水。
+func adminUserQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var status *quotaStatusResponse
+	var adjustments []store.QuotaAdjustment
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		user, err := store.GetUserByID(r.Context(), tx, id)
+		if err != nil {
+			return err
+		}
+		if status, err = userQuotaStatus(r.Context(), tx, user); err != nil {
+			return err
+		}
+		adjustments, err = store.ListQuotaAdjustments(r.Context(), tx, store.QuotaAdjustmentFilter{UserID: id, Limit: maxLedgerEntries})
+		return err
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, "user not found")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to load quota")
+	default:
+		writeJSON(w, map[string]any{"quota": status, "adjustments": mapQuotaAdjustments(adjustments)})
+	}
+}
+
+// GET /api/admin/quota-adjustments — 全部配额调整流水。
+func adminQuotaAdjustmentsHandler(w http.ResponseWriter, r *http.Request) {
+	var adjustments []store.QuotaAdjustment
+	err := appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		adjustments, err = store.ListQuotaAdjustments(r.Context(), tx, store.QuotaAdjustmentFilter{Limit: ledgerLimit(r)})
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load quota adjustments")
+		return
+	}
+	writeJSON(w, mapQuotaAdjustments(adjustments))
+}
+
+type quotaAdjustmentResponse struct {
+	ID          int64  `json:"id"`
+	Kind        string `json:"kind"`
+	UserID      int64  `json:"userId,omitempty"`
+	TargetType  string `json:"targetType,omitempty"`
+	Target      string `json:"target,omitempty"`
+	ColorPages  int64  `json:"colorPages"`
+	MonoPages   int64  `json:"monoPages"`
+	PeriodStart string `json:"periodStart,omitempty"`
+	OperatorID  int64  `json:"operatorId,omitempty"`
+	Note        string `json:"note"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+func mapQuotaAdjustments(adjustments []store.QuotaAdjustment) []quotaAdjustmentResponse {
+	resp := make([]quotaAdjustmentResponse, 0, len(adjustments))
+	for _, a := range adjustments {
+		resp = append(resp, quotaAdjustmentResponse{
+			ID:          a.ID,
+			Kind:        a.Kind,
+			UserID:      a.UserID.Int64,
+			TargetType:  a.TargetType,
+			Target:      a.Target,
+			ColorPages:  a.ColorPages,
+			MonoPages:   a.MonoPages,
+			PeriodStart: a.PeriodStart,
+			OperatorID:  a.OperatorID.Int64,
+			Note:        a.Note,
+			CreatedAt:   a.CreatedAt,
+		})
+	}
+	return resp
+}
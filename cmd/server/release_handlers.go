@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// 安全打印（挂起释放）：作业以 job-hold-until=indefinite 提交到 CUPS，停在队列里
+// 不出纸；用户走到打印机旁，在释放页登录或输入 PIN 后才发 Release-Job。
+// 超过 hold_timeout_hours 仍未释放的作业由维护任务自动取消，避免队列里堆积。
+
+const (
+	releasePINDigits = 6
+
+	// 单台打印机在失败窗口内的 PIN 错误总次数超过 releasePrinterBackoffAfter 后，
+	// 该打印机的 PIN 释放按指数退避放慢（最长 releasePrinterBackoffMax），防止换来源
+	// 地址分布式穷举，同时不会被匿名请求长期锁死。
+	releasePrinterBackoffAfter = 20
+	releasePrinterBackoffMax   = 30 * time.Second
+)
+
+var errNotHeld = errors.New("job is no longer held")
+
+// hashReleasePIN 计算 PIN 的存储形式 "salt:sha256(salt+pin)"，每条记录独立加盐，
+// 相同 PIN 的哈希各不相同。6 位数字离线穷举仍不难，哈希只是避免明文落库；
+// 真正的防护是释放接口的在线失败限流。
+func hashReleasePIN(pin string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return saltedPINHash(hex.EncodeToString(salt), pin), nil
+}
+
+func saltedPINHash(salt, pin string) string {
+	sum := sha256.Sum256([]byte(salt + pin))
+	return salt + ":" + hex.EncodeToString(sum[:])
+}
+
+// releasePINMatches 校验 PIN 是否与存储的哈希一致。兼容升级前未加盐的 sha256(pin)。
+func releasePINMatches(stored, pin string) bool {
+	if stored == "" {
+		return false
+	}
+	want := ""
+	if salt, _, ok := strings.Cut(stored, ":"); ok {
+		want = saltedPINHash(salt, pin)
+	} else {
+		sum := sha256.Sum256([]byte(pin))
+		want = hex.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(want)) == 1
+}
+
+// newReleasePIN 生成一个在 printerURI 的挂起作业中不重复的 PIN：同一台打印机
+// 上 PIN 碰撞会让一个人的 PIN 释放出另一个人的机密文件。
+func newReleasePIN(ctx context.Context, tx *sql.Tx, printerURI string) (pin string, pinHash string, err error) {
+	held, err := store.ListHeldPrintRecords(ctx, tx, store.HeldFilter{PrinterURI: printerURI})
+	if err != nil {
+		return "", "", err
+	}
+	limit := big.NewInt(1)
+	for i := 0; i < releasePINDigits; i++ {
+		limit.Mul(limit, big.NewInt(10))
+	}
+	for attempt := 0; attempt < 20; attempt++ {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", "", err
+		}
+		pin = fmt.Sprintf("%0*d", releasePINDigits, n)
+		if !slices.ContainsFunc(held, func(rec store.PrintRecord) bool {
+			return releasePINMatches(rec.ReleasePINHash, pin)
+		}) {
+			pinHash, err = hashReleasePIN(pin)
+			return pin, pinHash, err
+		}
+	}
+	return "", "", errors.New("failed to allocate a unique release pin")
+}
+
+// submittedStatus 返回作业提交成功后记录应处的 status。
+func submittedStatus(hold bool) string {
+	if hold {
+		return store.PrintStatusHeld
+	}
+	return store.PrintStatusSubmitted
+}
+
+// releaseHeldRecord 对挂起记录发 Release-Job 并把记录推进到 submitted。CUPS 侧
+// 作业已不在挂起状态（被取消、被管理员直接释放）时刷新一次真实状态并返回 errNotHeld。
+func releaseHeldRecord(ctx context.Context, rec store.PrintRecord) error {
+	jobID := ipp.ParseJobID(rec.JobID.String)
+	if jobID == 0 {
+		return errNotHeld
+	}
+	if err := ipp.ReleaseJob(rec.PrinterURI, jobID, rec.Username); err != nil {
+		if ipp.IsNotPossible(err) || ipp.IsNotFound(err) {
+			refreshJobState(ctx, rec, jobID)
+			return errNotHeld
+		}
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	return appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+		return store.MarkPrintReleased(ctx, tx, rec.ID, now)
+	})
+}
+
+type heldJobResponse struct {
+	ID         int64  `json:"id"`
+	Username   string `json:"username"`
+	PrinterURI string `json:"printerUri"`
+	Filename   string `json:"filename"`
+	Pages      int    `json:"pages"`
+	Copies     int    `json:"copies"`
+	IsColor    bool   `json:"isColor"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+func mapHeldJobs(records []store.PrintRecord) []heldJobResponse {
+	resp := make([]heldJobResponse, 0, len(records))
+	for _, rec := range records {
+		resp = append(resp, heldJobResponse{
+			ID:         rec.ID,
+			Username:   rec.Username,
+			PrinterURI: rec.PrinterURI,
+			Filename:   rec.Filename,
+			Pages:      rec.Pages,
+			Copies:     rec.Copies,
+			IsColor:    rec.IsColor,
+			CreatedAt:  rec.CreatedAt,
+		})
+	}
+	return resp
+}
+
+// GET /api/held-jobs?printer=<name>&host=<label> — 当前用户挂起中的作业，可按打印机过滤。
+func heldJobsHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	filter := store.HeldFilter{UserID: sess.UserID}
+	if name := r.URL.Query().Get("printer"); name != "" {
+		printer, err := findPrinterByName(r.URL.Query().Get("host"), name)
+		if err != nil {
+			if errors.Is(err, errPrinterNotFound) {
+				writeJSONError(w, http.StatusNotFound, "printer not found")
+				return
+			}
+			log.Printf("[release] list printers failed: %v", err)
+			writeJSONError(w, http.StatusBadGateway, "failed to list printers")
+			return
+		}
+		if err := checkPrinterAccess(r.Context(), sess, printer.URI); err != nil {
+			writePrintError(w, err)
+			return
+		}
+		filter.PrinterURI = printer.URI
+	}
+
+	var records []store.PrintRecord
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		records, err = store.ListHeldPrintRecords(r.Context(), tx, filter)
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load held jobs")
+		return
+	}
+	writeJSON(w, mapHeldJobs(records))
+}
+
+// POST /api/print-records/{id}/release — 已登录用户释放自己的挂起作业（管理员可释放任意作业）。
+func releasePrintRecordHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid record id")
+		return
+	}
+
+	var record store.PrintRecord
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		rec, err := store.GetPrintRecordByID(r.Context(), tx, id)
+		if err != nil {
+			return err
+		}
+		record = rec
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "record not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "failed to load record")
+		return
+	}
+	if sess.Role != store.RoleAdmin && record.UserID != sess.UserID {
+		writeJSONError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if record.Status != store.PrintStatusHeld {
+		writeJSONError(w, http.StatusConflict, "job is not held")
+		return
+	}
+
+	if err := releaseHeldRecord(r.Context(), record); err != nil {
+		if errors.Is(err, errNotHeld) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		log.Printf("[release] record=%d: %v", record.ID, err)
+		writeJSONError(w, http.StatusBadGateway, "failed to release job")
+		return
+	}
+	log.Printf("[release] record=%d released by %s", record.ID, sess.Username)
+	writeJSON(w, map[string]any{"ok": true, "status": store.PrintStatusSubmitted})
+}
+
+type pinReleaseRequest struct {
+	Printer string `json:"printer"`
+	Host    string `json:"host"` // 多 CUPS 服务器时的服务器标签，可省略
+	PIN     string `json:"pin"`
+}
+
+// POST /api/release — 释放页（免登录）凭 PIN 释放指定打印机上的挂起作业。
+// 与登录共用失败限流：同一来源 IP 连续输错会被临时锁定；同一台打印机上的错误
+// 总数超限时该打印机也会锁定，防止伪造来源地址穷举 PIN。
+func pinReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	var req pinReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.PIN = strings.TrimSpace(req.PIN)
+	if req.Printer == "" || len(req.PIN) != releasePINDigits {
+		writeJSONError(w, http.StatusBadRequest, "missing printer or pin")
+		return
+	}
+
+	key := "release|" + clientIP(r)
+	if ok, _ := loginAllowed(key); !ok {
+		log.Printf("[release] rate limited: key=%q", key)
+		writeJSONError(w, http.StatusTooManyRequests, "too many attempts, please try again later")
+		return
+	}
+
+	printer, err := findPrinterByName(req.Host, req.Printer)
+	if err != nil {
+		if errors.Is(err, errPrinterNotFound) {
+			writeJSONError(w, http.StatusNotFound, "printer not found")
+			return
+		}
+		log.Printf("[release] list printers failed: %v", err)
+		writeJSONError(w, http.StatusBadGateway, "failed to list printers")
+		return
+	}
+	printerKey := "release-printer|" + printer.URI
+	if ok, wait := loginAllowed(printerKey); !ok {
+		log.Printf("[release] printer backing off after repeated failures: %q", printer.Name)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		writeJSONError(w, http.StatusTooManyRequests, "too many attempts, please try again later")
+		return
+	}
+
+	var records []store.PrintRecord
+	err = appStore.WithTx(r.Context(), true, func(tx *sql.Tx) error {
+		var err error
+		records, err = store.ListHeldPrintRecords(r.Context(), tx, store.HeldFilter{PrinterURI: printer.URI})
+		return err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load held jobs")
+		return
+	}
+	var match *store.PrintRecord
+	for i := range records {
+		if releasePINMatches(records[i].ReleasePINHash, req.PIN) {
+			match = &records[i]
+			break
+		}
+	}
+	if match == nil {
+		registerLoginFailure(key)
+		registerBackoffFailure(printerKey, releasePrinterBackoffAfter, releasePrinterBackoffMax)
+		writeJSONError(w, http.StatusUnauthorized, "invalid pin")
+		return
+	}
+	clearLoginFailures(key)
+
+	if err := releaseHeldRecord(r.Context(), *match); err != nil {
+		if errors.Is(err, errNotHeld) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		log.Printf("[release] record=%d: %v", match.ID, err)
+		writeJSONError(w, http.StatusBadGateway, "failed to release job")
+		return
+	}
+	log.Printf("[release] record=%d released by pin on %q", match.ID, printer.Name)
+	writeJSON(w, map[string]any{"ok": true, "filename": match.Filename, "pages": match.Pages})
+}
+
+// cancelExpiredHolds 取消超过 hold_timeout_hours 仍未释放的挂起作业，由维护任务调用。
+func cancelExpiredHolds(ctx context.Context, s *store.Store, now time.Time) error {
+	var timeoutHours int64
+	err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+		val, err := store.GetSettingInt(ctx, tx, store.SettingHoldTimeoutHours, 24)
+		if err != nil {
+			return err
+		}
+		timeoutHours = val
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if timeoutHours <= 0 {
+		return nil
+	}
+
+	cutoff := now.Add(-time.Duration(timeoutHours) * time.Hour).UTC().Format(time.RFC3339)
+	var expired []store.PrintRecord
+	err = s.WithTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		expired, err = store.ListHeldPrintRecords(ctx, tx, store.HeldFilter{SubmittedBefore: cutoff})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range expired {
+		jobID := ipp.ParseJobID(rec.JobID.String)
+		if jobID > 0 {
+			if err := ipp.CancelJob(rec.PrinterURI, jobID, rec.Username); err != nil {
+				if ipp.IsNotPossible(err) || ipp.IsNotFound(err) {
+					refreshJobState(ctx, rec, jobID)
+					continue
+				}
+				log.Printf("[release] cancel expired hold record=%d: %v", rec.ID, err)
+				continue
+			}
+		}
+		err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+			return store.UpdatePrintJobState(ctx, tx, rec.ID, store.JobStateUpdate{
+				Status:          store.PrintStatusCancelled,
+				JobState:        ipp.JobStateCanceled,
+				JobStateReasons: "job-hold-expired",
+				CompletedAt:     now.UTC().Format(time.RFC3339),
+			})
+		})
+		if err != nil {
+			log.Printf("[release] update expired hold record=%d: %v", rec.ID, err)
+			continue
+		}
+		log.Printf("[release] record=%d hold expired, job canceled", rec.ID)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cups-web/internal/store"
+)
+
+func TestReleasePINHash(t *testing.T) {
+	a, err := hashReleasePIN("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hashReleasePIN("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Errorf("same pin hashed to the same value %q, want per-record salt", a)
+	}
+	if !releasePINMatches(a, "123456") || !releasePINMatches(b, "123456") {
+		t.Error("salted hash does not match its pin")
+	}
+	if releasePINMatches(a, "654321") || releasePINMatches("", "") {
+		t.Error("wrong pin matched")
+	}
+
+	// 升级前写入的未加盐哈希仍可释放。
+	sum := sha256.Sum256([]byte("000042"))
+	if !releasePINMatches(hex.EncodeToString(sum[:]), "000042") {
+		t.Error("legacy unsalted hash does not match")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := parseTrustedProxies("10.0.0.1, 172.16.0.0/12, bogus")
+	if len(proxies) != 2 {
+		t.Fatalf("parsed %d proxies, want 2", len(proxies))
+	}
+	for _, tc := range []struct {
+		remote, xff string
+		proxies     bool
+		want        string
+	}{
+		{"203.0.113.7:5000", "1.2.3.4", false, "203.0.113.7"}, // 未配置代理时忽略 XFF
+		{"203.0.113.7:5000", "1.2.3.4", true, "203.0.113.7"},  // 不是受信任代理发来的
+		{"10.0.0.1:5000", "1.2.3.4", true, "1.2.3.4"},
+		{"10.0.0.1:5000", "9.9.9.9, 1.2.3.4, 172.16.5.5", true, "1.2.3.4"}, // 左侧的伪造值不采信
+		{"10.0.0.1:5000", "", true, "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("POST", "/api/release", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		p := proxies
+		if !tc.proxies {
+			p = nil
+		}
+		if got := clientIPWith(r, p); got != tc.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tc.remote, tc.xff, got, tc.want)
+		}
+	}
+
+	// 默认信任本机与私有网段的代理；none 表示不信任任何代理。
+	defaults := parseTrustedProxies(defaultTrustedProxies)
+	r := httptest.NewRequest("POST", "/api/release", nil)
+	r.RemoteAddr = "172.18.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	if got := clientIPWith(r, defaults); got != "203.0.113.9" {
+		t.Errorf("default proxies: clientIP = %s, want 203.0.113.9", got)
+	}
+	if got := clientIPWith(r, parseTrustedProxies("none")); got != "172.18.0.2" {
+		t.Errorf("TRUSTED_PROXIES=none: clientIP = %s, want 172.18.0.2", got)
+	}
+}
+
+func TestRegisterBackoffFailure(t *testing.T) {
+	key := "test-backoff|" + t.Name()
+	t.Cleanup(func() { clearLoginFailures(key) })
+	for range 3 {
+		registerBackoffFailure(key, 3, 5*time.Second)
+	}
+	if ok, _ := loginAllowed(key); !ok {
+		t.Fatal("should not back off before the threshold is exceeded")
+	}
+	for range 10 {
+		registerBackoffFailure(key, 3, 5*time.Second)
+	}
+	ok, wait := loginAllowed(key)
+	if ok || wait <= 0 || wait > 5*time.Second {
+		t.Errorf("after threshold: allowed=%v wait=%v, want backoff capped at 5s", ok, wait)
+	}
+}
+
+func TestCancelExpiredHoldsFromSubmission(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	now := time.Now()
+
+	// 两天前预约、刚由调度器提交的挂起作业：超时从提交算起，不能立刻被取消。
+	var id int64
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		user, err := store.CreateUser(ctx, tx, store.CreateUserInput{Username: "erin", PasswordHash: "x", Role: store.RoleUser})
+		if err != nil {
+			return err
+		}
+		rec := store.PrintRecord{
+			UserID:      user.ID,
+			Status:      store.PrintStatusScheduled,
+			HoldRelease: true,
+			CreatedAt:   now.Add(-48 * time.Hour).UTC().Format(time.RFC3339),
+		}
+		if id, err = store.InsertPrintRecord(ctx, tx, &rec); err != nil {
+			return err
+		}
+		return store.UpdatePrintStatus(ctx, tx, id, store.PrintStatusHeld, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := func() string {
+		t.Helper()
+		var rec store.PrintRecord
+		if err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+			var err error
+			rec, err = store.GetPrintRecordByID(ctx, tx, id)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Status
+	}
+	if err := cancelExpiredHolds(ctx, s, now); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got != store.PrintStatusHeld {
+		t.Fatalf("status right after submission = %q, want held", got)
+	}
+	if err := cancelExpiredHolds(ctx, s, now.Add(25*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got != store.PrintStatusCancelled {
+		t.Errorf("status 25h after submission = %q, want cancelled", got)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cups-web/internal/auth"
+	"cups-web/internal/ipp"
+	"cups-web/internal/store"
+)
+
+// 定时打印：/api/print 带 print_at 时照常转换、加水印、缩放、重排，但不立即提交，
+// 而是把处理好的文件存进 uploads、最终 IPP 选项存进 print_schedules，记录停在
+// scheduled。调度器定期提交到点的作业；调度行在 SQLite 里，重启后照常执行，
+// 停机期间错过的作业在启动后立即补打。
+const (
+	scheduleInterval = 30 * time.Second
+	// 太近的时间直接打印即可，太远的时间大概率是误操作（文件也会一直占着磁盘）。
+	minScheduleLead = time.Minute
+	maxScheduleLead = 30 * 24 * time.Hour
+)
+
+var (
+	errInvalidPrintAt = errors.New("invalid print_at")
+	errForbidden      = errors.New("forbidden")
+)
+
+// parsePrintAt 解析 RFC3339 的 print_at，空串返回零值表示立即打印。
+func parsePrintAt(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: expected RFC3339 time", errInvalidPrintAt)
+	}
+	if t.Before(now.Add(minScheduleLead)) {
+		return time.Time{}, fmt.Errorf("%w: must be at least %s in the future", errInvalidPrintAt, minScheduleLead)
+	}
+	if t.After(now.Add(maxScheduleLead)) {
+		return time.Time{}, fmt.Errorf("%w: must be within %d days", errInvalidPrintAt, int(maxScheduleLead.Hours()/24))
+	}
+	return t.UTC(), nil
+}
+
+// newPrintSchedule 把处理好的待打印文件复制进 uploads，生成尚未关联记录的调度行。
+// 原 printPath 多是请求结束即删的临时文件，所以必须复制一份。
+func newPrintSchedule(printPath, mime, jobName string, runAt time.Time, opts ipp.PrintJobOptions) (*store.PrintSchedule, error) {
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(printPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if mime == "" {
+		buf := make([]byte, 512)
+		n, _ := f.Read(buf)
+		mime = http.DetectContentType(buf[:n])
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	rel, _, err := saveUploadedFile(f, "scheduled_"+filepath.Base(jobName), uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	return &store.PrintSchedule{
+		RunAt:     runAt.Format(time.RFC3339),
+		PrintPath: rel,
+		Mime:      mime,
+		Options:   string(optsJSON),
+		JobName:   jobName,
+	}, nil
+}
+
+func removeUpload(rel string) {
+	if rel != "" {
+		_ = os.Remove(filepath.Join(uploadDir, filepath.FromSlash(rel)))
+	}
+}
+
+func startScheduler(s *store.Store) {
+	go func() {
+		ticker := time.NewTicker(scheduleInterval)
+		defer ticker.Stop()
+		for {
+			runDueSchedules(context.Background(), s, time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// runDueSchedules 逐个认领并提交到点的定时打印。认领（scheduled → queued 并删除调度行）
+// 与提交分开：进程在两者之间崩溃时记录停在 queued，宁可漏打也不重复打印。
+func runDueSchedules(ctx context.Context, s *store.Store, now time.Time) {
+	var due []store.PrintSchedule
+	err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+		list, err := store.ListDuePrintSchedules(ctx, tx, now.UTC().Format(time.RFC3339))
+		due = list
+		return err
+	})
+	if err != nil {
+		log.Println("list due schedules failed:", err)
+		return
+	}
+	for _, sched := range due {
+		var rec store.PrintRecord
+		var claimed bool
+		err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+			var err error
+			if rec, err = store.GetPrintRecordByID(ctx, tx, sched.PrintJobID); err != nil {
+				return err
+			}
+			claimed, err = store.ClaimPrintSchedule(ctx, tx, sched.PrintJobID, store.PrintStatusQueued)
+			return err
+		})
+		if err != nil {
+			log.Printf("[schedule] claim record=%d: %v", sched.PrintJobID, err)
+			continue
+		}
+		if claimed {
+			submitScheduledPrint(ctx, s, rec, sched)
+		}
+	}
+}
+
+func submitScheduledPrint(ctx context.Context, s *store.Store, rec store.PrintRecord, sched store.PrintSchedule) {
+	defer removeUpload(sched.PrintPath)
+
+	fail := func(err error) {
+		log.Printf("[schedule] record=%d: %v", rec.ID, err)
+		_ = s.WithTx(ctx, false, func(tx *sql.Tx) error {
+			return store.UpdatePrintStatus(ctx, tx, rec.ID, store.PrintStatusFailed, "")
+		})
+	}
+	var opts ipp.PrintJobOptions
+	if err := json.Unmarshal([]byte(sched.Options), &opts); err != nil {
+		fail(fmt.Errorf("decode options: %w", err))
+		return
+	}
+	f, err := os.OpenInRoot(uploadDir, filepath.FromSlash(sched.PrintPath))
+	if err != nil {
+		fail(fmt.Errorf("open prepared file: %w", err))
+		return
+	}
+	defer f.Close()
+
+	job, err := ipp.SendPrintJob(rec.PrinterURI, f, sched.Mime, rec.Username, sched.JobName, opts)
+	if err != nil {
+		fail(err)
+		return
+	}
+	_ = s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		return store.UpdatePrintStatus(ctx, tx, rec.ID, submittedStatus(opts.Hold), job)
+	})
+	watchJob(rec.PrinterURI, job, rec.ID, rec.UserID)
+	log.Printf("[schedule] record=%d submitted as job %s (scheduled at %s)", rec.ID, job, sched.RunAt)
+}
+
+// cancelScheduledPrint 取消尚未提交的定时打印。返回 false 表示调度器已抢先认领。
+func cancelScheduledPrint(ctx context.Context, rec store.PrintRecord) (bool, error) {
+	var sched store.PrintSchedule
+	var claimed bool
+	err := appStore.WithTx(ctx, false, func(tx *sql.Tx) error {
+		var err error
+		if sched, err = store.GetPrintSchedule(ctx, tx, rec.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		if claimed, err = store.ClaimPrintSchedule(ctx, tx, rec.ID, store.PrintStatusCancelled); err != nil || !claimed {
+			return err
+		}
+		return store.UpdatePrintJobState(ctx, tx, rec.ID, store.JobStateUpdate{
+			JobState:        ipp.JobStateCanceled,
+			JobStateReasons: "job-canceled-by-user",
+			CompletedAt:     time.Now().UTC().Format(time.RFC3339),
+		})
+	})
+	if err != nil || !claimed {
+		return false, err
+	}
+	removeUpload(sched.PrintPath)
+	return true, nil
+}
+
+type updateScheduleRequest struct {
+	PrintAt string `json:"printAt"`
+	Copies  int    `json:"copies"`
+}
+
+// PUT /api/print-records/{id}/schedule — 修改尚未提交的定时打印的时间与份数。
+func updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := auth.GetSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid record id")
+		return
+	}
+	var req updateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	runAt, err := parsePrintAt(req.PrintAt, time.Now())
+	if err != nil || runAt.IsZero() {
+		msg := "missing printAt"
+		if err != nil {
+			msg = err.Error()
+		}
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	acct := loadPrintAccounting(r.Context())
+	var rec store.PrintRecord
+	err = appStore.WithTx(r.Context(), false, func(tx *sql.Tx) error {
+		var err error
+		if rec, err = store.GetPrintRecordByID(r.Context(), tx, id); err != nil {
+			return err
+		}
+		if sess.Role != store.RoleAdmin && rec.UserID != sess.UserID {
+			return errForbidden
+		}
+		sched, err := store.GetPrintSchedule(r.Context(), tx, id)
+		if err != nil {
+			return err
+		}
+		var opts ipp.PrintJobOptions
+		if err := json.Unmarshal([]byte(sched.Options), &opts); err != nil {
+			return err
+		}
+		if req.Copies > 0 {
+			opts.Copies = req.Copies
+		}
+		owner, err := store.GetUserByID(r.Context(), tx, rec.UserID)
+		if err != nil {
+			return err
+		}
+		if err := applyPolicyCopies(r.Context(), tx, owner, rec.PrinterURI, &opts); err != nil {
+			return err
+		}
+		optsJSON, err := json.Marshal(opts)
+		if err != nil {
+			return err
+		}
+		rec.ScheduledAt, rec.Copies = runAt.Format(time.RFC3339), opts.Copies
+		impressions := billableImpressions(opts)
+		if err := store.UpdatePrintSchedule(r.Context(), tx, id, rec.ScheduledAt, string(optsJSON), opts.Copies, int(impressions)); err != nil {
+			return err
+		}
+		// 份数增加后按记录所有者重新检查配额。
+		if acct.quotaPeriod != "" && impressions > int64(rec.Impressions) {
+			if err := checkPrintQuota(r.Context(), tx, owner, acct.quotaPeriod, opts.IsColor, impressions, id); err != nil {
+				return err
+			}
+		}
+		// 启用计费时按新份数重新计价、多退少补。按当前设置判断而不是看记录上的金额：
+		// 启用计费前预约或当时没有匹配价目的作业记录金额为 0，改份数后同样要计费。
+		if acct.billing {
+			cost, err := quotePrint(r.Context(), tx, opts)
+			if err != nil {
+				return err
+			}
+			return store.RechargePrint(r.Context(), tx, rec.UserID, id, cost)
+		}
+		return nil
+	})
+	var de *printDocumentError
+	switch {
+	case errors.As(err, &de):
+		writeJSONError(w, de.status, de.msg)
+	case errors.Is(err, store.ErrInsufficientBalance):
+		writeJSONError(w, http.StatusPaymentRequired, "insufficient balance for the new number of copies")
+	case errors.Is(err, errForbidden):
+		writeJSONError(w, http.StatusForbidden, "forbidden")
+	case errors.Is(err, sql.ErrNoRows) && rec.ID == 0:
+		writeJSONError(w, http.StatusNotFound, "record not found")
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusConflict, "job is no longer scheduled")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "failed to update schedule")
+	default:
+		writeJSON(w, map[string]any{"ok": true, "scheduledAt": rec.ScheduledAt, "copies": rec.Copies})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePrintAt(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"2026-03-01T18:30:00+08:00", time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2026-03-01 10:30", time.Time{}, true},
+		// 太近或已经过去的时间直接打印即可。
+		{"2026-03-01T08:00:30Z", time.Time{}, true},
+		{"2026-02-28T08:00:00Z", time.Time{}, true},
+		{"2026-04-15T08:00:00Z", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePrintAt(tt.in, now)
+		if tt.wantErr {
+			if !errors.Is(err, errInvalidPrintAt) {
+				t.Errorf("parsePrintAt(%q) err = %v, want errInvalidPrintAt", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parsePrintAt(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
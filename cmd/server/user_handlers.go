@@ -13,6 +13,12 @@ type meResponse struct {
 	ID       int64  `json:"id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+
+	BillingEnabled bool  `json:"billingEnabled"`
+	BalanceCents   int64 `json:"balanceCents"`
+
+	// 本周期剩余配额，未启用配额时省略。
+	Quota *quotaStatusResponse `json:"quota,omitempty"`
 }
 
 func MeHandler(w http.ResponseWriter, r *http.Request) {
@@ -28,12 +34,20 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return err
 		}
+		billing, err := store.GetSettingInt(r.Context(), tx, store.SettingBillingEnabled, 0)
+		if err != nil {
+			return err
+		}
 		resp = meResponse{
 			ID:       user.ID,
 			Username: user.Username,
 			Role:     user.Role,
+
+			BillingEnabled: billing != 0,
+			BalanceCents:   user.BalanceCents,
 		}
-		return nil
+		resp.Quota, err = userQuotaStatus(r.Context(), tx, user)
+		return err
 	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -7,6 +7,13 @@ const routes = [
   { path: '/', redirect: '/login' },
   { path: '/login', name: 'login', component: LoginView, meta: { requiresAuth: false } },
   { path: '/print', name: 'print', component: PrintView, meta: { requiresAuth: true } },
+  // 安全打印释放页：放在打印机旁的公共终端上，凭 PIN 或登录释放挂起作业
+  {
+    path: '/release',
+    name: 'release',
+    component: () => import('../views/ReleaseView.vue'),
+    meta: { requiresAuth: false, kiosk: true }
+  },
   { path: '/admin', name: 'admin', component: AdminView, meta: { requiresAuth: true, requiresAdmin: true } },
   {
     path: '/drivers',
@@ -1,3 +1,5 @@
+import { describeOptionIssues } from './format'
+
 // 从 Cookie 提取 CSRF 令牌
 export function getCSRF() {
   const m = document.cookie.match('(^|;)\\s*csrf_token\\s*=\\s*([^;]+)')
@@ -8,6 +10,10 @@ export function getCSRF() {
 export async function readError(resp) {
   try {
     const data = await resp.json()
+    // 打印预检失败时附带字段级错误，一并展示
+    if (Array.isArray(data.fields) && data.fields.length > 0) {
+      return `${data.error}（${describeOptionIssues(data.fields)}）`
+    }
     return data.error || resp.statusText
   } catch (e) {
     try {
@@ -51,3 +57,23 @@ export async function apiFetch(url, options = {}, onUnauthorized = null) {
 
   return resp
 }
+
+// 提交打印：/api/print 回 202 + taskId 后在后台处理，这里轮询任务直到结束，
+// 返回值与任务的 result 相同（jobId、pages、warnings 等）。
+// onProgress(task) 在每次轮询后调用，可用于展示转换、发送等阶段。
+export async function submitPrint(form, onProgress = null, onUnauthorized = null) {
+  const resp = await apiFetch('/api/print', { method: 'POST', body: form }, onUnauthorized)
+  if (!resp.ok) throw new Error(await readError(resp))
+  const accepted = await resp.json()
+  if (resp.status !== 202) return accepted
+  onProgress?.(accepted)
+  for (;;) {
+    await new Promise(resolve => setTimeout(resolve, 1000))
+    const tr = await apiFetch(`/api/print-tasks/${accepted.taskId}`, {}, onUnauthorized)
+    if (!tr.ok) throw new Error(await readError(tr))
+    const task = await tr.json()
+    onProgress?.(task)
+    if (task.status === 'succeeded') return task.result
+    if (task.status === 'failed') throw new Error(task.error || '打印失败')
+  }
+}
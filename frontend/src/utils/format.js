@@ -5,6 +5,12 @@ export function formatFileSize(bytes) {
   return (bytes / (1024 * 1024)).toFixed(1) + ' MB'
 }
 
+// formatCents 把以分为单位的金额格式化为 ¥1.23。
+export function formatCents(cents) {
+  const v = Number(cents) || 0
+  return (v < 0 ? '-¥' : '¥') + (Math.abs(v) / 100).toFixed(2)
+}
+
 export function formatTime(iso) {
   if (!iso) return ''
   try {
@@ -18,6 +24,21 @@ export function formatPrinterName(uri) {
   return parts[parts.length - 1] || uri
 }
 
+// printerLabel 生成打印机下拉文案：优先展示管理员登记的名称，其次 printer-info 描述，
+// 附带位置与状态，都没有时退回队列名。
+export function printerLabel(p) {
+  if (!p) return ''
+  const parts = [p.displayName || p.info || p.name]
+  if (p.location) parts.push(p.location)
+  let label = parts.join(' · ')
+  // 多 CUPS 服务器时以服务器标签分组
+  if (p.host) label = `[${p.host}] ${label}`
+  if (p.isDefault) label += '（默认）'
+  if (p.state === 'stopped') label += ' [已停止]'
+  else if (p.accepting === false) label += ' [暂停接收]'
+  return label
+}
+
 export function formatDurationSeconds(totalSeconds) {
   if (!totalSeconds || totalSeconds < 0) return '未知'
   const d = Math.floor(totalSeconds / 86400)
@@ -47,15 +68,24 @@ export function formatStateDuration(isoStr) {
 }
 
 export function statusColor(status) {
-  const map = { queued: 'info', printed: 'success', failed: 'error', cancelled: 'neutral' }
+  const map = { queued: 'info', submitted: 'info', held: 'warning', scheduled: 'info', printed: 'success', failed: 'error', cancelled: 'neutral', unknown: 'neutral' }
   return map[status] || 'neutral'
 }
 
 export function statusText(status) {
-  const map = { queued: '排队中', printed: '已打印', failed: '失败', cancelled: '已取消' }
+  const map = { queued: '排队中', submitted: '打印中', held: '待释放', scheduled: '定时', printed: '已打印', failed: '失败', cancelled: '已取消', unknown: '未知' }
   return map[status] || status
 }
 
+// 异步打印任务的处理阶段
+export function printStageText(stage, position = 0) {
+  if (stage === 'waiting' && position > 0) return `排队中（前面 ${position} 个）`
+  // 运行中的 position 是等待转换器（LibreOffice/Ghostscript 等）名额的位置。
+  if (stage !== 'waiting' && position > 0) return `等待转换（第 ${position} 位）`
+  const map = { waiting: '排队中', converting: '转换中', normalizing: '规范化 PDF', watermarking: '添加水印', scaling: '缩放处理', reordering: '页面重排', sending: '发送到打印机', queued: '已进入打印队列', scheduled: '已排期' }
+  return map[stage] || stage
+}
+
 export function printerStateColor(state) {
   const map = { idle: 'success', processing: 'warning', stopped: 'error' }
   return map[state] || 'neutral'
@@ -66,6 +96,16 @@ export function printerStateText(state) {
   return map[state] || state || '未知'
 }
 
+export function jobStateColor(state) {
+  const map = { pending: 'info', held: 'warning', processing: 'warning', stopped: 'error', canceled: 'neutral', aborted: 'error', completed: 'success' }
+  return map[state] || 'neutral'
+}
+
+export function jobStateText(state) {
+  const map = { pending: '等待中', held: '已挂起', processing: '打印中', stopped: '已暂停', canceled: '已取消', aborted: '已中止', completed: '已完成' }
+  return map[state] || state || '未知'
+}
+
 export function markerLevelColor(level) {
   if (level === undefined || level === null) return 'text-muted'
   if (level <= 10) return 'text-error font-bold'
@@ -79,3 +119,19 @@ export function markerBarColor(level) {
   if (level <= 25) return 'bg-warning'
   return 'bg-success'
 }
+
+// 打印预检返回的字段名 → 选项名称
+export function printOptionLabel(field) {
+  const map = {
+    duplex: '双面', color: '彩色', copies: '份数', paper_size: '纸张大小', paper_type: '纸张类型',
+    media_source: '纸盒', print_scaling: '缩放', orientation: '方向', number_up: '每张页数',
+    number_up_layout: '多页排列', page_border: '页边框', page_range: '页码范围', page_set: '奇偶页',
+    mirror: '镜像', hold: '安全打印'
+  }
+  return map[field] || field
+}
+
+// 把预检的 warnings / fields 拼成一行说明
+export function describeOptionIssues(issues) {
+  return (issues || []).map(i => `${printOptionLabel(i.field)}：${i.message}`).join('；')
+}
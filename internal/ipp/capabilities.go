@@ -0,0 +1,632 @@
+package ipp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+// PrinterCapabilities is the typed subset of a printer's *-supported and
+// *-default attributes that PrintJobOptions can set. An empty supported list
+// means the printer did not report the attribute; Preflight then accepts any
+// value and leaves the decision to the printer.
+type PrinterCapabilities struct {
+	ColorSupported bool `json:"colorSupported"`
+
+	SidesSupported []string `json:"sidesSupported"`
+	SidesDefault   string   `json:"sidesDefault"`
+
+	ColorModesSupported []string `json:"colorModesSupported"`
+	ColorModeDefault    string   `json:"colorModeDefault"`
+
+	MediaSupported []string `json:"mediaSupported"`
+	MediaDefault   string   `json:"mediaDefault"`
+	MediaReady     []string `json:"mediaReady"`
+	// Media holds the sizes decoded from the self-describing media-supported
+	// keywords; CustomMedia is the custom size range, nil when the printer
+	// accepts no custom sizes.
+	Media       []MediaSize `json:"media"`
+	CustomMedia *MediaRange `json:"customMedia,omitempty"`
+
+	MediaTypesSupported []string `json:"mediaTypesSupported"`
+	MediaTypeDefault    string   `json:"mediaTypeDefault"`
+
+	MediaSourcesSupported []string `json:"mediaSourcesSupported"`
+	MediaSourceDefault    string   `json:"mediaSourceDefault"`
+
+	PrintScalingSupported []string `json:"printScalingSupported"`
+	PrintScalingDefault   string   `json:"printScalingDefault"`
+
+	// OrientationsSupported holds orientation-requested enums (3 = portrait,
+	// 4 = landscape, 5 = reverse-landscape, 6 = reverse-portrait).
+	OrientationsSupported []int `json:"orientationsSupported"`
+	OrientationDefault    int   `json:"orientationDefault"`
+
+	NumberUpSupported []int `json:"numberUpSupported"`
+	NumberUpDefault   int   `json:"numberUpDefault"`
+
+	QualitiesSupported []string `json:"qualitiesSupported"` // "draft" | "normal" | "high"
+	QualityDefault     string   `json:"qualityDefault"`
+
+	ResolutionsSupported []Resolution `json:"resolutionsSupported"`
+	ResolutionDefault    *Resolution  `json:"resolutionDefault,omitempty"`
+
+	FinishingsSupported []Finishing `json:"finishingsSupported"`
+	// FinishingTemplatesSupported lists the finishings-col templates of
+	// printers that describe finishing as collections (PWG 5100.1).
+	FinishingTemplatesSupported []string `json:"finishingTemplatesSupported"`
+	OutputBinsSupported         []string `json:"outputBinsSupported"`
+	OutputBinDefault            string   `json:"outputBinDefault"`
+
+	JobSheetsSupported []string `json:"jobSheetsSupported"`
+	JobSheetsDefault   string   `json:"jobSheetsDefault"`
+
+	CopiesMax     int `json:"copiesMax"` // 0 when unknown
+	CopiesDefault int `json:"copiesDefault"`
+
+	PageRangesSupported      bool     `json:"pageRangesSupported"`
+	JobHoldUntilSupported    []string `json:"jobHoldUntilSupported"`
+	DocumentFormatsSupported []string `json:"documentFormatsSupported"`
+
+	// MultipleDocumentJobs reports whether the printer accepts Create-Job plus
+	// several Send-Document requests as one job (see SendDocuments).
+	MultipleDocumentJobs bool `json:"multipleDocumentJobs"`
+}
+
+// capabilityAttributes is the requested-attributes list for GetPrinterCapabilities.
+var capabilityAttributes = []string{
+	"color-supported",
+	"sides-supported", "sides-default",
+	"print-color-mode-supported", "print-color-mode-default",
+	"media-supported", "media-default", "media-ready",
+	"media-type-supported", "media-type-default",
+	"media-source-supported", "media-source-default",
+	"print-scaling-supported", "print-scaling-default",
+	"orientation-requested-supported", "orientation-requested-default",
+	"number-up-supported", "number-up-default",
+	"media-size-supported",
+	"print-quality-supported", "print-quality-default",
+	"printer-resolution-supported", "printer-resolution-default",
+	"finishings-supported", "finishing-template-supported",
+	"output-bin-supported", "output-bin-default",
+	"job-sheets-supported", "job-sheets-default",
+	"copies-supported", "copies-default",
+	"page-ranges-supported",
+	"job-hold-until-supported",
+	"document-format-supported",
+	"multiple-document-jobs-supported", "operations-supported",
+}
+
+// GetPrinterCapabilities queries the printer's supported and default job
+// template values via Get-Printer-Attributes.
+func GetPrinterCapabilities(printerURI string) (*PrinterCapabilities, error) {
+	req := newRequest(goipp.OpGetPrinterAttributes, printerURI)
+	req.Operation.Add(requestedAttributes(capabilityAttributes...))
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("get-printer-attributes: %w", err)
+	}
+	return parseCapabilities(rsp.Printer), nil
+}
+
+func parseCapabilities(attrs goipp.Attributes) *PrinterCapabilities {
+	c := &PrinterCapabilities{
+		ColorSupported: attrBool(attrs, "color-supported"),
+
+		SidesSupported:        attrStrings(attrs, "sides-supported"),
+		SidesDefault:          attrString(attrs, "sides-default"),
+		ColorModesSupported:   attrStrings(attrs, "print-color-mode-supported"),
+		ColorModeDefault:      attrString(attrs, "print-color-mode-default"),
+		MediaSupported:        attrStrings(attrs, "media-supported"),
+		MediaDefault:          attrString(attrs, "media-default"),
+		MediaReady:            attrStrings(attrs, "media-ready"),
+		MediaTypesSupported:   attrStrings(attrs, "media-type-supported"),
+		MediaTypeDefault:      attrString(attrs, "media-type-default"),
+		MediaSourcesSupported: attrStrings(attrs, "media-source-supported"),
+		MediaSourceDefault:    attrString(attrs, "media-source-default"),
+		PrintScalingSupported: attrStrings(attrs, "print-scaling-supported"),
+		PrintScalingDefault:   attrString(attrs, "print-scaling-default"),
+
+		OrientationsSupported: attrInts(attrs, "orientation-requested-supported"),
+		OrientationDefault:    attrInt(attrs, "orientation-requested-default"),
+		NumberUpSupported:     attrInts(attrs, "number-up-supported"),
+		NumberUpDefault:       attrInt(attrs, "number-up-default"),
+		CopiesDefault:         attrInt(attrs, "copies-default"),
+
+		FinishingTemplatesSupported: attrStrings(attrs, "finishing-template-supported"),
+		OutputBinsSupported:         attrStrings(attrs, "output-bin-supported"),
+		OutputBinDefault:            attrString(attrs, "output-bin-default"),
+		JobSheetsSupported:          attrStrings(attrs, "job-sheets-supported"),
+		JobSheetsDefault:            attrString(attrs, "job-sheets-default"),
+
+		PageRangesSupported:      attrBool(attrs, "page-ranges-supported"),
+		JobHoldUntilSupported:    attrStrings(attrs, "job-hold-until-supported"),
+		DocumentFormatsSupported: attrStrings(attrs, "document-format-supported"),
+	}
+	for _, a := range attrs {
+		if len(a.Values) == 0 {
+			continue
+		}
+		switch a.Name {
+		case "copies-supported":
+			// rangeOfInteger (1:9999); only the upper bound matters.
+			if r, ok := a.Values[0].V.(goipp.Range); ok {
+				c.CopiesMax = r.Upper
+			}
+		case "media-size-supported":
+			if c.CustomMedia == nil {
+				c.CustomMedia = customMediaFromSizes(a.Values)
+			}
+		case "print-quality-supported":
+			for _, v := range a.Values {
+				if n, ok := v.V.(goipp.Integer); ok {
+					c.QualitiesSupported = append(c.QualitiesSupported, qualityFromEnum(int(n)))
+				}
+			}
+		case "print-quality-default":
+			if n, ok := a.Values[0].V.(goipp.Integer); ok {
+				c.QualityDefault = qualityFromEnum(int(n))
+			}
+		case "printer-resolution-supported":
+			for _, v := range a.Values {
+				if res, ok := v.V.(goipp.Resolution); ok {
+					c.ResolutionsSupported = append(c.ResolutionsSupported, resolutionFromIPP(res))
+				}
+			}
+		case "printer-resolution-default":
+			if res, ok := a.Values[0].V.(goipp.Resolution); ok {
+				r := resolutionFromIPP(res)
+				c.ResolutionDefault = &r
+			}
+		case "finishings-supported":
+			for _, v := range a.Values {
+				if n, ok := v.V.(goipp.Integer); ok {
+					c.FinishingsSupported = append(c.FinishingsSupported, Finishing{Value: int(n), Keyword: finishingKeyword(int(n))})
+				}
+			}
+		}
+	}
+	// 自描述尺寸关键字优先：custom_min_/custom_max_ 比 media-size-supported 的范围更常见。
+	media, custom := parseMediaKeywords(c.MediaSupported)
+	c.Media = media
+	if custom != nil {
+		c.CustomMedia = custom
+	}
+	// page-ranges-supported 缺省视为支持：没上报不等于不支持，否则页码范围会被误拒。
+	if attrStrings(attrs, "page-ranges-supported") == nil {
+		c.PageRangesSupported = true
+	}
+	// 多文档作业要求同时实现 Create-Job 与 Send-Document，只看 multiple-document-jobs-supported 不够。
+	ops := attrInts(attrs, "operations-supported")
+	c.MultipleDocumentJobs = attrBool(attrs, "multiple-document-jobs-supported") &&
+		slices.Contains(ops, int(goipp.OpCreateJob)) && slices.Contains(ops, int(goipp.OpSendDocument))
+	// 没有 color-supported 的打印机按 print-color-mode-supported 判断。
+	if !c.ColorSupported && slices.Contains(c.ColorModesSupported, "color") {
+		c.ColorSupported = true
+	}
+	return c
+}
+
+// MediaSize is a named media size decoded from a PWG 5101.1 self-describing
+// keyword such as "iso_a4_210x297mm" or "na_letter_8.5x11in".
+type MediaSize struct {
+	Keyword  string  `json:"keyword"`
+	WidthMM  float64 `json:"widthMm"`
+	HeightMM float64 `json:"heightMm"`
+}
+
+// MediaRange is the custom media size range a printer accepts.
+type MediaRange struct {
+	MinWidthMM  float64 `json:"minWidthMm"`
+	MinHeightMM float64 `json:"minHeightMm"`
+	MaxWidthMM  float64 `json:"maxWidthMm"`
+	MaxHeightMM float64 `json:"maxHeightMm"`
+}
+
+// Resolution is a printer-resolution value.
+type Resolution struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Units string `json:"units"` // "dpi" | "dpcm"
+}
+
+// String formats r the way ParseResolution reads it: "600dpi" when both
+// axes match, "1200x600dpi" otherwise.
+func (r Resolution) String() string {
+	if r.X == r.Y {
+		return fmt.Sprintf("%d%s", r.X, r.Units)
+	}
+	return fmt.Sprintf("%dx%d%s", r.X, r.Y, r.Units)
+}
+
+// ParseResolution parses "600dpi", "1200x600dpi" or "236dpcm".
+func ParseResolution(s string) (Resolution, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var units string
+	switch {
+	case strings.HasSuffix(s, "dpi"):
+		units = "dpi"
+	case strings.HasSuffix(s, "dpcm"):
+		units = "dpcm"
+	default:
+		return Resolution{}, false
+	}
+	xs, ys, found := strings.Cut(strings.TrimSuffix(s, units), "x")
+	if !found {
+		ys = xs
+	}
+	x, err1 := strconv.Atoi(xs)
+	y, err2 := strconv.Atoi(ys)
+	if err1 != nil || err2 != nil || x <= 0 || y <= 0 {
+		return Resolution{}, false
+	}
+	return Resolution{X: x, Y: y, Units: units}, true
+}
+
+func (r Resolution) toIPP() goipp.Resolution {
+	units := goipp.UnitsDpi
+	if r.Units == "dpcm" {
+		units = goipp.UnitsDpcm
+	}
+	return goipp.Resolution{Xres: r.X, Yres: r.Y, Units: units}
+}
+
+// Finishing is a finishings enum value with its keyword name.
+type Finishing struct {
+	Value   int    `json:"value"`
+	Keyword string `json:"keyword"`
+}
+
+// parseMediaKeywords decodes self-describing media keywords. Keywords that do
+// not carry dimensions (e.g. "photo" on some drivers) are skipped; the
+// custom_min_/custom_max_ pair becomes the custom size range.
+func parseMediaKeywords(keywords []string) ([]MediaSize, *MediaRange) {
+	var sizes []MediaSize
+	var min, max *MediaSize
+	for _, kw := range keywords {
+		w, h, ok := mediaDimensions(kw)
+		if !ok {
+			continue
+		}
+		size := MediaSize{Keyword: kw, WidthMM: w, HeightMM: h}
+		switch {
+		case strings.HasPrefix(kw, "custom_min_"):
+			min = &size
+		case strings.HasPrefix(kw, "custom_max_"):
+			max = &size
+		default:
+			sizes = append(sizes, size)
+		}
+	}
+	if min == nil || max == nil {
+		return sizes, nil
+	}
+	return sizes, &MediaRange{MinWidthMM: min.WidthMM, MinHeightMM: min.HeightMM, MaxWidthMM: max.WidthMM, MaxHeightMM: max.HeightMM}
+}
+
+// mediaDimensions extracts the "WxHunit" suffix of a media keyword in mm.
+func mediaDimensions(kw string) (w, h float64, ok bool) {
+	idx := strings.LastIndex(kw, "_")
+	if idx < 0 {
+		return 0, 0, false
+	}
+	dim := kw[idx+1:]
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(dim, "mm"):
+		dim = strings.TrimSuffix(dim, "mm")
+	case strings.HasSuffix(dim, "in"):
+		dim = strings.TrimSuffix(dim, "in")
+		scale = 25.4
+	default:
+		return 0, 0, false
+	}
+	ws, hs, found := strings.Cut(dim, "x")
+	if !found {
+		return 0, 0, false
+	}
+	wv, err1 := strconv.ParseFloat(ws, 64)
+	hv, err2 := strconv.ParseFloat(hs, 64)
+	if err1 != nil || err2 != nil || wv <= 0 || hv <= 0 {
+		return 0, 0, false
+	}
+	return roundMM(wv * scale), roundMM(hv * scale), true
+}
+
+func roundMM(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// customMediaFromSizes derives the custom size range from media-size-supported
+// collections whose x-/y-dimension are ranges (hundredths of millimeters).
+func customMediaFromSizes(vals goipp.Values) *MediaRange {
+	for _, v := range vals {
+		col, ok := v.V.(goipp.Collection)
+		if !ok {
+			continue
+		}
+		var xr, yr *goipp.Range
+		for _, a := range col {
+			if len(a.Values) == 0 {
+				continue
+			}
+			if r, ok := a.Values[0].V.(goipp.Range); ok {
+				switch a.Name {
+				case "x-dimension":
+					xr = &r
+				case "y-dimension":
+					yr = &r
+				}
+			}
+		}
+		if xr != nil && yr != nil {
+			return &MediaRange{
+				MinWidthMM: float64(xr.Lower) / 100, MinHeightMM: float64(yr.Lower) / 100,
+				MaxWidthMM: float64(xr.Upper) / 100, MaxHeightMM: float64(yr.Upper) / 100,
+			}
+		}
+	}
+	return nil
+}
+
+// qualityFromEnum maps a print-quality enum to its keyword.
+func qualityFromEnum(v int) string {
+	switch v {
+	case 3:
+		return "draft"
+	case 4:
+		return "normal"
+	case 5:
+		return "high"
+	default:
+		return strconv.Itoa(v)
+	}
+}
+
+// qualityEnum is the inverse of qualityFromEnum; 0 for unknown keywords.
+func qualityEnum(q string) int {
+	switch q {
+	case "draft":
+		return 3
+	case "normal":
+		return 4
+	case "high":
+		return 5
+	default:
+		return 0
+	}
+}
+
+func resolutionFromIPP(r goipp.Resolution) Resolution {
+	units := "dpi"
+	if r.Units == goipp.UnitsDpcm {
+		units = "dpcm"
+	}
+	return Resolution{X: r.Xres, Y: r.Yres, Units: units}
+}
+
+// finishingKeywords names the common finishings enums (PWG 5100.1).
+var finishingKeywords = map[int]string{
+	3: "none", 4: "staple", 5: "punch", 6: "cover", 7: "bind", 8: "saddle-stitch",
+	9: "edge-stitch", 10: "fold", 11: "trim", 12: "bale", 13: "booklet-maker", 14: "jog-offset",
+	20: "staple-top-left", 21: "staple-bottom-left", 22: "staple-top-right", 23: "staple-bottom-right",
+	24: "edge-stitch-left", 25: "edge-stitch-top", 26: "edge-stitch-right", 27: "edge-stitch-bottom",
+	28: "staple-dual-left", 29: "staple-dual-top", 30: "staple-dual-right", 31: "staple-dual-bottom",
+	50: "bind-left", 51: "bind-top", 52: "bind-right", 53: "bind-bottom",
+	70: "punch-top-left", 71: "punch-bottom-left", 72: "punch-top-right", 73: "punch-bottom-right",
+	74: "punch-dual-left", 75: "punch-dual-top", 76: "punch-dual-right", 77: "punch-dual-bottom",
+	78: "punch-triple-left", 79: "punch-triple-top", 80: "punch-triple-right", 81: "punch-triple-bottom",
+	82: "punch-quad-left", 83: "punch-quad-top", 84: "punch-quad-right", 85: "punch-quad-bottom",
+	93: "fold-half", 96: "fold-letter", 100: "fold-z",
+}
+
+func finishingKeyword(v int) string {
+	if kw, ok := finishingKeywords[v]; ok {
+		return kw
+	}
+	return strconv.Itoa(v)
+}
+
+// finishingEnum is the inverse of finishingKeyword; numeric strings pass
+// through so vendor enums reported by finishings-supported stay usable.
+func finishingEnum(kw string) (int, bool) {
+	for v, name := range finishingKeywords {
+		if name == kw {
+			return v, true
+		}
+	}
+	if n, err := strconv.Atoi(kw); err == nil && n > 0 {
+		return n, true
+	}
+	return 0, false
+}
+
+// supportsFinishing reports whether kw is one of finishings-supported.
+func (c *PrinterCapabilities) supportsFinishing(kw string) bool {
+	return slices.ContainsFunc(c.FinishingsSupported, func(f Finishing) bool { return f.Keyword == kw })
+}
+
+// maxExpandedRange bounds how many integers a rangeOfInteger value expands to.
+const maxExpandedRange = 64
+
+// attrInts returns all integer values of the named attribute. rangeOfInteger
+// values (e.g. number-up-supported 1:16) are expanded, up to maxExpandedRange
+// members.
+func attrInts(attrs goipp.Attributes, name string) []int {
+	var out []int
+	for _, a := range attrs {
+		if a.Name != name {
+			continue
+		}
+		for _, v := range a.Values {
+			switch x := v.V.(type) {
+			case goipp.Integer:
+				out = append(out, int(x))
+			case goipp.Range:
+				for i := x.Lower; i <= x.Upper && i-x.Lower < maxExpandedRange; i++ {
+					out = append(out, i)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// OptionIssue describes a print option the printer cannot honor. Attribute
+// is the IPP attribute name; Fallback is set when Preflight replaced the
+// value instead of rejecting it.
+type OptionIssue struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value,omitempty"`
+	Fallback  string `json:"fallback,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Preflight checks opts against the capabilities. Options with a harmless
+// substitute are downgraded and reported in warnings; options whose
+// substitute would change what the user gets in a way they did not ask for
+// (too many copies, page ranges ignored, a secure-release job printed
+// immediately) are reported in errs. The returned options carry the
+// downgrades and are meaningful only when errs is empty.
+func (c *PrinterCapabilities) Preflight(opts PrintJobOptions) (adjusted PrintJobOptions, warnings, errs []OptionIssue) {
+	adjusted = opts
+	unsupported := func(list []string, v string) bool {
+		return len(list) > 0 && !slices.Contains(list, v)
+	}
+
+	if opts.IsDuplex && unsupported(c.SidesSupported, "two-sided-long-edge") {
+		adjusted.IsDuplex = false
+		warnings = append(warnings, OptionIssue{Attribute: "sides", Value: "two-sided-long-edge", Fallback: "one-sided",
+			Message: "printer does not support duplex, printing one-sided"})
+	}
+	if opts.IsColor && unsupported(c.ColorModesSupported, "color") {
+		adjusted.IsColor = false
+		warnings = append(warnings, OptionIssue{Attribute: "print-color-mode", Value: "color", Fallback: "monochrome",
+			Message: "printer does not support color, printing monochrome"})
+	}
+	if c.CopiesMax > 0 && opts.Copies > c.CopiesMax {
+		errs = append(errs, OptionIssue{Attribute: "copies", Value: strconv.Itoa(opts.Copies),
+			Message: fmt.Sprintf("printer accepts at most %d copies", c.CopiesMax)})
+	}
+	if media := paperSizeToIPP(opts.PaperSize); media != "" && unsupported(c.MediaSupported, media) {
+		adjusted.PaperSize = ""
+		warnings = append(warnings, OptionIssue{Attribute: "media", Value: media, Fallback: c.MediaDefault,
+			Message: "paper size not supported, using the printer default"})
+	}
+	if opts.PaperType != "" && opts.PaperType != "auto" {
+		if mt := paperTypeToIPP(opts.PaperType); mt != "" && unsupported(c.MediaTypesSupported, mt) {
+			adjusted.PaperType = ""
+			warnings = append(warnings, OptionIssue{Attribute: "media-type", Value: mt, Fallback: c.MediaTypeDefault,
+				Message: "paper type not supported, using the printer default"})
+		}
+	}
+	if opts.MediaSource != "" && opts.MediaSource != "auto" && unsupported(c.MediaSourcesSupported, opts.MediaSource) {
+		adjusted.MediaSource = "auto"
+		warnings = append(warnings, OptionIssue{Attribute: "media-source", Value: opts.MediaSource, Fallback: "auto",
+			Message: "input tray not available, letting the printer choose"})
+	}
+	if opts.PrintScaling != "" && unsupported(c.PrintScalingSupported, opts.PrintScaling) {
+		adjusted.PrintScaling = ""
+		warnings = append(warnings, OptionIssue{Attribute: "print-scaling", Value: opts.PrintScaling, Fallback: c.PrintScalingDefault,
+			Message: "scaling mode not supported, using the printer default"})
+	}
+	if opts.Orientation == "landscape" && len(c.OrientationsSupported) > 0 && !slices.Contains(c.OrientationsSupported, 4) {
+		adjusted.Orientation = "portrait"
+		warnings = append(warnings, OptionIssue{Attribute: "orientation-requested", Value: "landscape", Fallback: "portrait",
+			Message: "printer does not support landscape, printing portrait"})
+	}
+	if opts.NumberUp > 1 && len(c.NumberUpSupported) > 0 && !slices.Contains(c.NumberUpSupported, opts.NumberUp) {
+		adjusted.NumberUp = 1
+		warnings = append(warnings, OptionIssue{Attribute: "number-up", Value: strconv.Itoa(opts.NumberUp), Fallback: "1",
+			Message: "pages per sheet not supported, printing one page per sheet"})
+	}
+	if opts.PageRange != "" && len(parsePageRange(opts.PageRange)) > 0 && !c.PageRangesSupported {
+		errs = append(errs, OptionIssue{Attribute: "page-ranges", Value: opts.PageRange,
+			Message: "printer does not support page ranges"})
+	}
+	if opts.Hold && unsupported(c.JobHoldUntilSupported, "indefinite") {
+		errs = append(errs, OptionIssue{Attribute: "job-hold-until", Value: "indefinite",
+			Message: "printer does not support held jobs"})
+	}
+	if opts.Quality != "" {
+		if qualityEnum(opts.Quality) == 0 {
+			errs = append(errs, OptionIssue{Attribute: "print-quality", Value: opts.Quality,
+				Message: "print quality must be draft, normal or high"})
+		} else if unsupported(c.QualitiesSupported, opts.Quality) {
+			adjusted.Quality = ""
+			warnings = append(warnings, OptionIssue{Attribute: "print-quality", Value: opts.Quality, Fallback: c.QualityDefault,
+				Message: "print quality not supported, using the printer default"})
+		}
+	}
+	if opts.Resolution != "" {
+		res, ok := ParseResolution(opts.Resolution)
+		switch {
+		case !ok:
+			errs = append(errs, OptionIssue{Attribute: "printer-resolution", Value: opts.Resolution,
+				Message: "invalid resolution, expected e.g. 600dpi or 1200x600dpi"})
+		case len(c.ResolutionsSupported) > 0 && !slices.Contains(c.ResolutionsSupported, res):
+			adjusted.Resolution = ""
+			fallback := ""
+			if c.ResolutionDefault != nil {
+				fallback = c.ResolutionDefault.String()
+			}
+			warnings = append(warnings, OptionIssue{Attribute: "printer-resolution", Value: opts.Resolution, Fallback: fallback,
+				Message: "resolution not supported, using the printer default"})
+		default:
+			adjusted.Resolution = res.String()
+		}
+	}
+	// 装订、打孔被悄悄去掉用户拿到的就不是想要的成品，所以不支持时报错而不是降级。
+	adjusted.Finishings, adjusted.FinishingsCol = nil, false
+	reported := len(c.FinishingsSupported) > 0 || len(c.FinishingTemplatesSupported) > 0
+	for _, kw := range opts.Finishings {
+		switch {
+		case kw == "" || kw == "none":
+		case !reported || c.supportsFinishing(kw):
+			adjusted.Finishings = append(adjusted.Finishings, kw)
+		case slices.Contains(c.FinishingTemplatesSupported, kw):
+			adjusted.Finishings = append(adjusted.Finishings, kw)
+			adjusted.FinishingsCol = true
+		default:
+			errs = append(errs, OptionIssue{Attribute: "finishings", Value: kw,
+				Message: "finishing not supported by printer"})
+		}
+	}
+	if opts.OutputBin != "" && unsupported(c.OutputBinsSupported, opts.OutputBin) {
+		adjusted.OutputBin = ""
+		warnings = append(warnings, OptionIssue{Attribute: "output-bin", Value: opts.OutputBin, Fallback: c.OutputBinDefault,
+			Message: "output bin not available, using the printer default"})
+	}
+	if opts.JobSheets != "" && unsupported(c.JobSheetsSupported, opts.JobSheets) {
+		adjusted.JobSheets = ""
+		warnings = append(warnings, OptionIssue{Attribute: "job-sheets", Value: opts.JobSheets, Fallback: c.JobSheetsDefault,
+			Message: "banner page not supported, using the printer default"})
+	}
+	return adjusted, warnings, errs
+}
+
+// ValidateJob sends a Validate-Job request carrying the same attributes
+// SendPrintJob would send, without the document. On success it returns the
+// attributes the printer will ignore or substitute. When the printer rejects
+// the job the error is a *StatusError whose Unsupported field names the
+// offending attributes.
+func ValidateJob(printerURI, mime, username, jobName string, opts PrintJobOptions) ([]string, error) {
+	req := newPrintJobRequest(goipp.OpValidateJob, printerURI, mime, username, jobName, opts)
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return attrNames(rsp.Unsupported), nil
+}
+
+// IsUnsupportedAttributes reports whether err is an IPP
+// client-error-attributes-or-values-not-supported response.
+func IsUnsupportedAttributes(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Status == goipp.StatusErrorAttributesOrValues
+}
@@ -0,0 +1,253 @@
+package ipp
+
+import (
+	"slices"
+	"testing"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+func keywords(name string, vals ...string) goipp.Attribute {
+	a := goipp.MakeAttribute(name, goipp.TagKeyword, goipp.String(vals[0]))
+	for _, v := range vals[1:] {
+		a.Values.Add(goipp.TagKeyword, goipp.String(v))
+	}
+	return a
+}
+
+func TestParseCapabilities(t *testing.T) {
+	var attrs goipp.Attributes
+	attrs.Add(keywords("sides-supported", "one-sided", "two-sided-long-edge"))
+	attrs.Add(keywords("print-color-mode-supported", "monochrome", "color"))
+	attrs.Add(keywords("media-supported", "iso_a4_210x297mm", "na_letter_8.5x11in"))
+	attrs.Add(goipp.MakeAttribute("media-default", goipp.TagKeyword, goipp.String("iso_a4_210x297mm")))
+	attrs.Add(goipp.MakeAttribute("copies-supported", goipp.TagRange, goipp.Range{Lower: 1, Upper: 99}))
+	attrs.Add(goipp.MakeAttribute("number-up-supported", goipp.TagRange, goipp.Range{Lower: 1, Upper: 4}))
+	attrs.Add(goipp.MakeAttribute("page-ranges-supported", goipp.TagBoolean, goipp.Boolean(false)))
+
+	c := parseCapabilities(attrs)
+	if !c.ColorSupported {
+		t.Errorf("ColorSupported should follow print-color-mode-supported")
+	}
+	if c.CopiesMax != 99 || c.MediaDefault != "iso_a4_210x297mm" {
+		t.Errorf("unexpected capabilities: %+v", c)
+	}
+	if len(c.NumberUpSupported) != 4 || c.NumberUpSupported[3] != 4 {
+		t.Errorf("NumberUpSupported = %v, want [1 2 3 4]", c.NumberUpSupported)
+	}
+	if c.PageRangesSupported {
+		t.Errorf("PageRangesSupported should be false when reported false")
+	}
+	if !parseCapabilities(nil).PageRangesSupported {
+		t.Errorf("PageRangesSupported should default to true when not reported")
+	}
+}
+
+// TestPreflight 覆盖降级（警告）与拒绝（错误）两类结果：能力未上报时一律放行。
+func TestPreflight(t *testing.T) {
+	mono := &PrinterCapabilities{
+		SidesSupported:        []string{"one-sided"},
+		ColorModesSupported:   []string{"monochrome"},
+		MediaSupported:        []string{"iso_a4_210x297mm"},
+		MediaSourcesSupported: []string{"auto", "tray-1"},
+		NumberUpSupported:     []int{1, 2, 4},
+		CopiesMax:             10,
+		PageRangesSupported:   true,
+		JobHoldUntilSupported: []string{"no-hold", "indefinite"},
+	}
+
+	cases := []struct {
+		name      string
+		caps      *PrinterCapabilities
+		opts      PrintJobOptions
+		warnAttrs []string
+		errAttrs  []string
+		check     func(PrintJobOptions) bool
+	}{
+		{
+			name: "supported options pass untouched",
+			caps: mono,
+			opts: PrintJobOptions{Copies: 2, PaperSize: "A4", MediaSource: "tray-1", NumberUp: 4, Hold: true},
+		},
+		{
+			name:      "duplex and color downgrade",
+			caps:      mono,
+			opts:      PrintJobOptions{IsDuplex: true, IsColor: true, Copies: 1},
+			warnAttrs: []string{"sides", "print-color-mode"},
+			check:     func(o PrintJobOptions) bool { return !o.IsDuplex && !o.IsColor },
+		},
+		{
+			name:      "media, tray and n-up downgrade",
+			caps:      mono,
+			opts:      PrintJobOptions{PaperSize: "A3", MediaSource: "manual", NumberUp: 9},
+			warnAttrs: []string{"media", "media-source", "number-up"},
+			check: func(o PrintJobOptions) bool {
+				return o.PaperSize == "" && o.MediaSource == "auto" && o.NumberUp == 1
+			},
+		},
+		{
+			name:     "too many copies is an error",
+			caps:     mono,
+			opts:     PrintJobOptions{Copies: 11},
+			errAttrs: []string{"copies"},
+		},
+		{
+			name:     "page ranges and hold unsupported are errors",
+			caps:     &PrinterCapabilities{JobHoldUntilSupported: []string{"no-hold"}},
+			opts:     PrintJobOptions{PageRange: "1-3", Hold: true},
+			errAttrs: []string{"page-ranges", "job-hold-until"},
+		},
+		{
+			name: "finishings, output bin and banner",
+			caps: &PrinterCapabilities{
+				FinishingsSupported: []Finishing{{3, "none"}, {20, "staple-top-left"}},
+				OutputBinsSupported: []string{"face-down"},
+				JobSheetsSupported:  []string{"none", "standard"},
+			},
+			opts:      PrintJobOptions{Finishings: []string{"none", "staple-top-left"}, OutputBin: "stacker-1", JobSheets: "standard"},
+			warnAttrs: []string{"output-bin"},
+			check: func(o PrintJobOptions) bool {
+				return slices.Equal(o.Finishings, []string{"staple-top-left"}) && !o.FinishingsCol && o.OutputBin == "" && o.JobSheets == "standard"
+			},
+		},
+		{
+			name:     "unsupported finishing is an error",
+			caps:     &PrinterCapabilities{FinishingsSupported: []Finishing{{3, "none"}}},
+			opts:     PrintJobOptions{Finishings: []string{"punch-dual-left"}},
+			errAttrs: []string{"finishings"},
+		},
+		{
+			name:  "template-only finishing goes out as finishings-col",
+			caps:  &PrinterCapabilities{FinishingTemplatesSupported: []string{"fold-half"}},
+			opts:  PrintJobOptions{Finishings: []string{"fold-half"}},
+			check: func(o PrintJobOptions) bool { return o.FinishingsCol },
+		},
+		{
+			name: "quality and resolution",
+			caps: &PrinterCapabilities{
+				QualitiesSupported:   []string{"normal", "high"},
+				ResolutionsSupported: []Resolution{{300, 300, "dpi"}, {600, 600, "dpi"}},
+				ResolutionDefault:    &Resolution{600, 600, "dpi"},
+			},
+			opts:      PrintJobOptions{Quality: "draft", Resolution: "600X600DPI"},
+			warnAttrs: []string{"print-quality"},
+			check:     func(o PrintJobOptions) bool { return o.Quality == "" && o.Resolution == "600dpi" },
+		},
+		{
+			name:      "unsupported resolution falls back, bad values are errors",
+			caps:      &PrinterCapabilities{ResolutionsSupported: []Resolution{{300, 300, "dpi"}}},
+			opts:      PrintJobOptions{Quality: "best", Resolution: "1200dpi"},
+			warnAttrs: []string{"printer-resolution"},
+			errAttrs:  []string{"print-quality"},
+		},
+		{
+			name: "unknown capabilities accept everything",
+			caps: parseCapabilities(nil),
+			opts: PrintJobOptions{IsDuplex: true, IsColor: true, Copies: 500, PaperSize: "A3", PageRange: "2", Hold: true},
+		},
+	}
+	attrsOf := func(issues []OptionIssue) []string {
+		var out []string
+		for _, i := range issues {
+			out = append(out, i.Attribute)
+		}
+		return out
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, warns, errs := c.caps.Preflight(c.opts)
+			if w := attrsOf(warns); !slices.Equal(w, c.warnAttrs) {
+				t.Errorf("warnings = %v, want %v", w, c.warnAttrs)
+			}
+			if e := attrsOf(errs); !slices.Equal(e, c.errAttrs) {
+				t.Errorf("errors = %v, want %v", e, c.errAttrs)
+			}
+			if c.check != nil && !c.check(got) {
+				t.Errorf("adjusted options = %+v", got)
+			}
+		})
+	}
+}
+
+func TestParseMediaKeywords(t *testing.T) {
+	sizes, custom := parseMediaKeywords([]string{
+		"iso_a4_210x297mm",
+		"na_letter_8.5x11in",
+		"photo",
+		"custom_min_3x5in",
+		"custom_max_8.5x14in",
+	})
+	if len(sizes) != 2 {
+		t.Fatalf("sizes = %+v", sizes)
+	}
+	if sizes[1].WidthMM != 215.9 || sizes[1].HeightMM != 279.4 {
+		t.Errorf("letter = %+v", sizes[1])
+	}
+	if custom == nil || custom.MinWidthMM != 76.2 || custom.MaxHeightMM != 355.6 {
+		t.Errorf("custom = %+v", custom)
+	}
+	if _, custom := parseMediaKeywords([]string{"custom_min_3x5in"}); custom != nil {
+		t.Errorf("a lone custom_min_ must not produce a range")
+	}
+}
+
+func TestParseCapabilitiesExtended(t *testing.T) {
+	var attrs goipp.Attributes
+	q := goipp.MakeAttribute("print-quality-supported", goipp.TagEnum, goipp.Integer(3))
+	q.Values.Add(goipp.TagEnum, goipp.Integer(5))
+	attrs.Add(q)
+	attrs.Add(goipp.MakeAttribute("printer-resolution-default", goipp.TagResolution,
+		goipp.Resolution{Xres: 600, Yres: 600, Units: goipp.UnitsDpi}))
+	f := goipp.MakeAttribute("finishings-supported", goipp.TagEnum, goipp.Integer(3))
+	f.Values.Add(goipp.TagEnum, goipp.Integer(20))
+	attrs.Add(f)
+	attrs.Add(keywords("output-bin-supported", "face-down", "face-up"))
+
+	var size goipp.Collection
+	size.Add(goipp.MakeAttribute("x-dimension", goipp.TagRange, goipp.Range{Lower: 7620, Upper: 21590}))
+	size.Add(goipp.MakeAttribute("y-dimension", goipp.TagRange, goipp.Range{Lower: 12700, Upper: 35560}))
+	attrs.Add(goipp.MakeAttribute("media-size-supported", goipp.TagBeginCollection, size))
+
+	c := parseCapabilities(attrs)
+	if !slices.Equal(c.QualitiesSupported, []string{"draft", "high"}) {
+		t.Errorf("QualitiesSupported = %v", c.QualitiesSupported)
+	}
+	if c.ResolutionDefault == nil || *c.ResolutionDefault != (Resolution{X: 600, Y: 600, Units: "dpi"}) {
+		t.Errorf("ResolutionDefault = %+v", c.ResolutionDefault)
+	}
+	if len(c.FinishingsSupported) != 2 || c.FinishingsSupported[1].Keyword != "staple-top-left" {
+		t.Errorf("FinishingsSupported = %+v", c.FinishingsSupported)
+	}
+	if len(c.OutputBinsSupported) != 2 {
+		t.Errorf("OutputBinsSupported = %v", c.OutputBinsSupported)
+	}
+	if c.CustomMedia == nil || c.CustomMedia.MinWidthMM != 76.2 || c.CustomMedia.MaxHeightMM != 355.6 {
+		t.Errorf("CustomMedia = %+v", c.CustomMedia)
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	cases := map[string]*Resolution{
+		"600dpi":      {600, 600, "dpi"},
+		"1200x600dpi": {1200, 600, "dpi"},
+		" 236DPCM ":   {236, 236, "dpcm"},
+		"600":         nil,
+		"0dpi":        nil,
+		"axbdpi":      nil,
+	}
+	for in, want := range cases {
+		got, ok := ParseResolution(in)
+		if want == nil {
+			if ok {
+				t.Errorf("ParseResolution(%q) = %+v, want failure", in, got)
+			}
+			continue
+		}
+		if !ok || got != *want {
+			t.Errorf("ParseResolution(%q) = %+v, %v; want %+v", in, got, ok, *want)
+		}
+		if back, _ := ParseResolution(got.String()); back != got {
+			t.Errorf("String() round-trip of %+v = %+v", got, back)
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package ipp
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net"
 	"net/url"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,6 +33,23 @@ type PrintJobOptions struct {
 	NumberUp       int    // 1/2/4/6/9/16; 0 or 1 means off (one page per sheet)
 	NumberUpLayout string // "lrtb" | "rltb" | "tblr" | "tbrl" (page ordering across the grid)
 	PageBorder     string // "single" | "none" – border drawn around each sub-page
+
+	// Hold submits the job with job-hold-until=indefinite so it waits in the
+	// queue until released with ReleaseJob (secure release printing).
+	Hold bool
+
+	// Finishing on printers with a stapler, puncher or folder. Finishings holds
+	// finishings keywords such as "staple-top-left", "punch-dual-left" or
+	// "fold-half"; they are sent as finishings enums, or as finishings-col
+	// templates when FinishingsCol is set (Preflight sets it for printers that
+	// only advertise finishing-template-supported).
+	Finishings    []string
+	FinishingsCol bool
+	OutputBin     string // output-bin keyword, e.g. "face-down" | "top" | "stacker-1"; empty = printer default
+	JobSheets     string // banner page, e.g. "none" | "standard"; empty = printer default
+
+	Quality    string // print-quality: "draft" | "normal" | "high"; empty = printer default
+	Resolution string // printer-resolution, e.g. "600dpi" | "1200x600dpi"; empty = printer default
 }
 
 // SendPrintJob sends data to the printer via IPP using goipp to build the
@@ -43,24 +59,54 @@ func SendPrintJob(printerURI string, r io.Reader, mime string, username string,
 	if err := validatePrinterURI(printerURI); err != nil {
 		return "", err
 	}
-	// IPP attribute must use ipp:// scheme; HTTP transport uses http://
-	ippURI := httpToIppURI(printerURI)
+	log.Printf("[ipp] SendPrintJob: uri=%q user=%q job=%q mime=%q", printerURI, username, jobName, mime)
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+	req := newPrintJobRequest(goipp.OpPrintJob, printerURI, mime, username, jobName, opts)
+
+	// 打印任务可能上传较大文档，整体超时放宽到 120s；连接层仍受 SSRF 校验。
+	log.Printf("[ipp] SendPrintJob: sending HTTP POST to %q", printerURI)
+	rsp, err := roundTrip(printerURI, req, r, 120*time.Second)
+	if err != nil {
+		log.Printf("[ipp] SendPrintJob: %v", err)
+		return "", err
+	}
+	log.Printf("[ipp] SendPrintJob: IPP response code: %d (%s)", rsp.Code, goipp.Status(rsp.Code).String())
 
-	req := goipp.NewRequest(goipp.DefaultVersion, goipp.OpPrintJob, 1)
-	req.Operation.Add(goipp.MakeAttribute("attributes-charset", goipp.TagCharset, goipp.String("utf-8")))
-	req.Operation.Add(goipp.MakeAttribute("attributes-natural-language", goipp.TagLanguage, goipp.String("en-US")))
-	req.Operation.Add(goipp.MakeAttribute("printer-uri", goipp.TagURI, goipp.String(ippURI)))
+	job := jobIdentifier(rsp)
+	log.Printf("[ipp] SendPrintJob: success, job=%s", job)
+	return job, nil
+}
+
+// jobIdentifier returns the job-uri or job-id of a job creation response, or
+// "ok" when the server reported neither.
+func jobIdentifier(rsp *goipp.Message) string {
+	for _, a := range rsp.Job {
+		if a.Name == "job-uri" || a.Name == "job-id" {
+			if len(a.Values) > 0 {
+				return a.Values[0].V.String()
+			}
+		}
+	}
+	return "ok"
+}
+
+// newPrintJobRequest builds a Print-Job, Validate-Job or Create-Job request
+// carrying the job template attributes derived from opts. The three take the
+// same attributes, so the preflight sees exactly what will be printed. mime is
+// left empty for Create-Job, whose documents carry their own format.
+func newPrintJobRequest(op goipp.Op, printerURI, mime, username, jobName string, opts PrintJobOptions) *goipp.Message {
+	req := newRequest(op, printerURI)
 	if username != "" {
 		req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(username)))
 	}
-	log.Printf("[ipp] SendPrintJob: uri=%q user=%q job=%q mime=%q", printerURI, username, jobName, mime)
 	if jobName != "" {
 		req.Operation.Add(goipp.MakeAttribute("job-name", goipp.TagName, goipp.String(jobName)))
 	}
-	if mime == "" {
-		mime = "application/octet-stream"
+	if mime != "" {
+		req.Operation.Add(goipp.MakeAttribute("document-format", goipp.TagMimeType, goipp.String(mime)))
 	}
-	req.Operation.Add(goipp.MakeAttribute("document-format", goipp.TagMimeType, goipp.String(mime)))
 
 	// Duplex
 	if opts.IsDuplex {
@@ -169,70 +215,69 @@ func SendPrintJob(printerURI string, r io.Reader, mime string, username string,
 		}
 	}
 
-	// Job impressions hint – tells CUPS the expected page count so that its
-	// job-accounting display matches the actual document instead of relying
-	// on the filter-reported count (which may be off by one).
-	if opts.Pages > 0 {
-		impressions := opts.Pages
-		if copies > 1 {
-			impressions = opts.Pages * copies
-		}
-		req.Job.Add(goipp.MakeAttribute("job-impressions", goipp.TagInteger, goipp.Integer(impressions)))
+	// Secure release – keep the job held in the CUPS queue until the owner
+	// releases it at the printer (Release-Job).
+	if opts.Hold {
+		req.Job.Add(goipp.MakeAttribute("job-hold-until", goipp.TagKeyword, goipp.String("indefinite")))
 	}
 
-	payload, err := req.EncodeBytes()
-	if err != nil {
-		return "", fmt.Errorf("encode ipp request: %w", err)
+	// Finishings – stapling, punching, folding. "none" is the default and is
+	// not sent. Keywords without a known enum can only go out as templates.
+	if len(opts.Finishings) > 0 {
+		var vals goipp.Values
+		for _, kw := range opts.Finishings {
+			if kw == "" || kw == "none" {
+				continue
+			}
+			if opts.FinishingsCol {
+				var col goipp.Collection
+				col.Add(goipp.MakeAttribute("finishing-template", goipp.TagKeyword, goipp.String(kw)))
+				vals.Add(goipp.TagBeginCollection, col)
+			} else if n, ok := finishingEnum(kw); ok {
+				vals.Add(goipp.TagEnum, goipp.Integer(n))
+			}
+		}
+		if len(vals) > 0 {
+			name := "finishings"
+			if opts.FinishingsCol {
+				name = "finishings-col"
+			}
+			req.Job.Add(goipp.Attribute{Name: name, Values: vals})
+		}
 	}
 
-	body := io.MultiReader(bytes.NewBuffer(payload), r)
-
-	httpReq, err := http.NewRequest(http.MethodPost, printerURI, body)
-	if err != nil {
-		return "", fmt.Errorf("create http request: %w", err)
+	// Output bin – which tray of the finisher receives the sheets.
+	if opts.OutputBin != "" {
+		req.Job.Add(goipp.MakeAttribute("output-bin", goipp.TagKeyword, goipp.String(opts.OutputBin)))
 	}
-	httpReq.Header.Set("Content-Type", goipp.ContentType)
-	httpReq.Header.Set("Accept", goipp.ContentType)
 
-	// 打印任务可能上传较大文档，整体超时放宽到 120s；连接层仍受 SSRF 校验。
-	client := newSafeClient(120 * time.Second)
-	log.Printf("[ipp] SendPrintJob: sending HTTP POST to %q", printerURI)
-	resp, err := client.Do(httpReq)
-	if resp != nil {
-		defer resp.Body.Close()
-	}
-	if err != nil {
-		log.Printf("[ipp] SendPrintJob: http error: %v", err)
-		return "", fmt.Errorf("http post: %w", err)
-	}
-	log.Printf("[ipp] SendPrintJob: HTTP response status: %s", resp.Status)
-	if resp.StatusCode/100 != 2 {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("[ipp] SendPrintJob: non-2xx body: %s", string(body))
-		return "", fmt.Errorf("http status: %s", resp.Status)
+	// Banner page. CUPS takes job-sheets as "start[,end]"; only the start
+	// sheet is selectable here.
+	if opts.JobSheets != "" {
+		req.Job.Add(goipp.MakeAttribute("job-sheets", goipp.TagKeyword, goipp.String(opts.JobSheets)))
 	}
 
-	var rsp goipp.Message
-	if err := rsp.Decode(limitedBody(resp.Body)); err != nil {
-		log.Printf("[ipp] SendPrintJob: decode error: %v", err)
-		return "", fmt.Errorf("decode ipp response: %w", err)
+	// Print quality and resolution. Preflight has already checked both
+	// against the printer; unparseable values are dropped here.
+	if n := qualityEnum(opts.Quality); n > 0 {
+		req.Job.Add(goipp.MakeAttribute("print-quality", goipp.TagEnum, goipp.Integer(n)))
 	}
-	log.Printf("[ipp] SendPrintJob: IPP response code: %d (%s)", rsp.Code, goipp.Status(rsp.Code).String())
-	if goipp.Status(rsp.Code) != goipp.StatusOk {
-		return "", fmt.Errorf("ipp error: %s", goipp.Status(rsp.Code).String())
+	if res, ok := ParseResolution(opts.Resolution); ok {
+		req.Job.Add(goipp.MakeAttribute("printer-resolution", goipp.TagResolution, res.toIPP()))
 	}
 
-	for _, a := range rsp.Job {
-		if a.Name == "job-uri" || a.Name == "job-id" {
-			if len(a.Values) > 0 {
-				log.Printf("[ipp] SendPrintJob: success, %s=%s", a.Name, a.Values[0].V.String())
-				return a.Values[0].V.String(), nil
-			}
+	// Job impressions hint – tells CUPS the expected page count so that its
+	// job-accounting display matches the actual document instead of relying
+	// on the filter-reported count (which may be off by one).
+	if opts.Pages > 0 {
+		impressions := opts.Pages
+		if copies > 1 {
+			impressions = opts.Pages * copies
 		}
+		req.Job.Add(goipp.MakeAttribute("job-impressions", goipp.TagInteger, goipp.Integer(impressions)))
 	}
 
-	log.Printf("[ipp] SendPrintJob: success (no job-id in response)")
-	return "ok", nil
+	return req
 }
 
 // paperSizeToIPP converts a paper size name to an IPP media keyword.
@@ -339,6 +384,33 @@ func parsePageRange(s string) [][2]int {
 	return result
 }
 
+// SelectedPages returns how many of a document's total pages are printed per
+// copy once opts.PageRange and opts.PageSet are applied, mirroring pdftopdf:
+// the range is applied first, then odd/even is taken from what remains.
+func SelectedPages(opts PrintJobOptions, total int) int {
+	ranges := parsePageRange(opts.PageRange)
+	n := 0
+	for p := 1; p <= total; p++ {
+		in := len(ranges) == 0
+		for _, rng := range ranges {
+			if p >= rng[0] && p <= rng[1] {
+				in = true
+				break
+			}
+		}
+		if in {
+			n++
+		}
+	}
+	switch normalizePageSet(opts.PageSet) {
+	case "odd":
+		return (n + 1) / 2
+	case "even", "even-reverse":
+		return n / 2
+	}
+	return n
+}
+
 // PrinterInfo holds information about a printer retrieved via IPP Get-Printer-Attributes.
 type PrinterInfo struct {
 	Name                 string            `json:"name"`
@@ -374,55 +446,14 @@ func httpToIppURI(uri string) string {
 // GetPrinterAttributes queries a printer via IPP Get-Printer-Attributes and returns structured info.
 func GetPrinterAttributes(printerURI string) (*PrinterInfo, error) {
 	log.Printf("[ipp] GetPrinterAttributes start, uri=%q", printerURI)
-	if err := validatePrinterURI(printerURI); err != nil {
-		return nil, err
-	}
-
-	// IPP attribute must use ipp:// scheme; HTTP transport uses http://
-	ippURI := httpToIppURI(printerURI)
-	log.Printf("[ipp] using ipp-scheme uri for attribute: %q", ippURI)
-
-	req := goipp.NewRequest(goipp.DefaultVersion, goipp.OpGetPrinterAttributes, 1)
-	req.Operation.Add(goipp.MakeAttribute("attributes-charset", goipp.TagCharset, goipp.String("utf-8")))
-	req.Operation.Add(goipp.MakeAttribute("attributes-natural-language", goipp.TagLanguage, goipp.String("en-US")))
-	req.Operation.Add(goipp.MakeAttribute("printer-uri", goipp.TagURI, goipp.String(ippURI)))
-	req.Operation.Add(goipp.MakeAttribute("requested-attributes", goipp.TagKeyword, goipp.String("all")))
-
-	payload, err := req.EncodeBytes()
-	if err != nil {
-		log.Printf("[ipp] encode error: %v", err)
-		return nil, fmt.Errorf("encode ipp request: %w", err)
-	}
-	log.Printf("[ipp] encoded payload, %d bytes", len(payload))
-
-	httpReq, err := http.NewRequest(http.MethodPost, printerURI, bytes.NewBuffer(payload))
-	if err != nil {
-		log.Printf("[ipp] create http request error: %v", err)
-		return nil, fmt.Errorf("create http request: %w", err)
-	}
-	httpReq.Header.Set("Content-Type", goipp.ContentType)
-	httpReq.Header.Set("Accept", goipp.ContentType)
+	req := newRequest(goipp.OpGetPrinterAttributes, printerURI)
+	req.Operation.Add(requestedAttributes("all"))
 
 	log.Printf("[ipp] sending HTTP POST to %q", printerURI)
-	resp, err := newSafeClient(dialTimeout).Do(httpReq)
-	if resp != nil {
-		defer resp.Body.Close()
-		log.Printf("[ipp] HTTP response status: %s", resp.Status)
-	}
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
 	if err != nil {
-		log.Printf("[ipp] http post error: %v", err)
-		return nil, fmt.Errorf("http post: %w", err)
-	}
-	if resp.StatusCode/100 != 2 {
-		log.Printf("[ipp] non-2xx status: %s", resp.Status)
-		return nil, fmt.Errorf("http status: %s", resp.Status)
-	}
-
-	log.Printf("[ipp] decoding IPP response")
-	var rsp goipp.Message
-	if err := rsp.Decode(limitedBody(resp.Body)); err != nil {
-		log.Printf("[ipp] decode error: %v", err)
-		return nil, fmt.Errorf("decode ipp response: %w", err)
+		log.Printf("[ipp] GetPrinterAttributes error: %v", err)
+		return nil, err
 	}
 	log.Printf("[ipp] IPP response code: %d, printer attrs count: %d", rsp.Code, len(rsp.Printer))
 
@@ -443,14 +474,9 @@ func GetPrinterAttributes(printerURI string) (*PrinterInfo, error) {
 		case "printer-state":
 			raw := a.Values[0].V.String()
 			log.Printf("[ipp] printer-state raw=%q (tag=%v)", raw, a.Values[0].T)
-			switch raw {
-			case "3":
-				info.State = "idle"
-			case "4":
-				info.State = "processing"
-			case "5":
-				info.State = "stopped"
-			default:
+			if v, ok := a.Values[0].V.(goipp.Integer); ok {
+				info.State = printerStateFromEnum(int(v))
+			} else {
 				info.State = raw
 			}
 		case "printer-state-message":
@@ -512,57 +538,160 @@ func GetPrinterAttributes(printerURI string) (*PrinterInfo, error) {
 	return info, nil
 }
 
-// Printer represents a CUPS printer.
+// Printer represents a CUPS queue as reported by CUPS-Get-Printers.
 type Printer struct {
-	Name string `json:"name"`
-	URI  string `json:"uri"`
+	Name      string `json:"name"`      // queue name (printer-name)
+	URI       string `json:"uri"`       // HTTP transport URI used for all later IPP requests
+	Info      string `json:"info"`      // printer-info, the human readable description
+	Location  string `json:"location"`  // printer-location
+	MakeModel string `json:"makeModel"` // printer-make-and-model
+	State     string `json:"state"`     // "idle" | "processing" | "stopped"
+	Accepting bool   `json:"accepting"` // printer-is-accepting-jobs
+	Shared    bool   `json:"shared"`    // printer-is-shared
+	IsDefault bool   `json:"isDefault"` // server default destination
+	IsClass   bool   `json:"isClass"`   // printer class rather than a single printer
+	// Host labels the CUPS server the queue belongs to when several servers
+	// are aggregated; ListPrinters leaves it empty.
+	Host string `json:"host,omitempty"`
 }
 
-// ListPrinters fetches the CUPS /printers HTML page on the given host and extracts printers.
+// CUPS printer-type bits (cups/cups.h) used by ListPrinters.
+const (
+	cupsPrinterClass   = 0x0001
+	cupsPrinterDefault = 0x20000
+)
+
+// listPrinterAttributes is the requested-attributes list for CUPS-Get-Printers.
+var listPrinterAttributes = []string{
+	"printer-name",
+	"printer-info",
+	"printer-location",
+	"printer-make-and-model",
+	"printer-state",
+	"printer-is-accepting-jobs",
+	"printer-is-shared",
+	"printer-type",
+}
+
+// ListPrinters queries the CUPS server on host via the CUPS-Get-Printers
+// operation and returns every printer and class it knows about.
 func ListPrinters(host string) ([]Printer, error) {
-	u := host
-	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
-		u = "http://" + u
-	}
-	parsed, err := url.Parse(u)
+	scheme, hostOnly, err := cupsServer(host)
 	if err != nil {
-		return nil, fmt.Errorf("invalid host: %w", err)
+		return nil, err
 	}
+	serverURL := (&url.URL{Scheme: scheme, Host: hostOnly, Path: "/"}).String()
 
-	hostOnly := parsed.Host
-	if !strings.Contains(hostOnly, ":") {
-		hostOnly = hostOnly + ":631"
+	req := newServerRequest(goipp.OpCupsGetPrinters)
+	req.Operation.Add(requestedAttributes(listPrinterAttributes...))
+
+	rsp, err := roundTrip(serverURL, req, nil, dialTimeout)
+	if err != nil {
+		// 没有任何队列时 CUPS 回 client-error-not-found，对前端来说就是空列表。
+		if IsNotFound(err) {
+			return []Printer{}, nil
+		}
+		return nil, fmt.Errorf("cups-get-printers: %w", err)
 	}
+	printers := parsePrinters(rsp, scheme, hostOnly)
+	log.Printf("[ipp] ListPrinters: host=%q printers=%d", hostOnly, len(printers))
+	return printers, nil
+}
 
-	listURL := (&url.URL{Scheme: "http", Host: hostOnly, Path: "/printers"}).String()
+// ServerURI returns the root URI of the CUPS server at host, which is the
+// target of server-wide operations such as printer subscriptions.
+func ServerURI(host string) (string, error) {
+	scheme, hostOnly, err := cupsServer(host)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: scheme, Host: hostOnly, Path: "/"}).String(), nil
+}
 
-	if err := validatePrinterURI(listURL); err != nil {
-		return nil, err
+// cupsServer normalizes a CUPS_HOST value ("host", "host:port" or a full
+// URL) to the transport scheme and host:port, defaulting to the IPP port 631.
+// https:// and ipps:// select TLS; everything else is plain http.
+func cupsServer(host string) (scheme, hostOnly string, err error) {
+	u := strings.TrimSpace(host)
+	scheme = "http"
+	switch {
+	case strings.HasPrefix(u, "https://"), strings.HasPrefix(u, "ipps://"):
+		scheme = "https"
+	case strings.HasPrefix(u, "http://"), strings.HasPrefix(u, "ipp://"):
+	default:
+		u = "http://" + u
 	}
-	resp, err := newSafeClient(dialTimeout).Get(listURL)
+	parsed, err := url.Parse(u)
 	if err != nil {
-		return nil, fmt.Errorf("fetch printers page: %w", err)
+		return "", "", fmt.Errorf("invalid host: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("http status: %s", resp.Status)
+	hostOnly = parsed.Host
+	if parsed.Port() == "" {
+		hostOnly = hostOnly + ":631"
 	}
+	return scheme, hostOnly, nil
+}
 
-	body, err := io.ReadAll(limitedBody(resp.Body))
+// CUPSHostname returns the bare hostname of a CUPS_HOST value, the key under
+// which SetCredentials expects its credentials.
+func CUPSHostname(host string) string {
+	_, hostOnly, err := cupsServer(host)
+	if err != nil {
+		return ""
+	}
+	h, _, err := net.SplitHostPort(hostOnly)
 	if err != nil {
-		return nil, fmt.Errorf("read printers page: %w", err)
+		return hostOnly
 	}
+	return h
+}
 
-	re := regexp.MustCompile(`(?i)<a[^>]+href=["']/printers/([^"'/>]+)["'][^>]*>([^<]+)</a>`)
-	matches := re.FindAllSubmatch(body, -1)
-	printers := make([]Printer, 0, len(matches))
-	for _, m := range matches {
-		name := string(m[1])
-		display := string(m[2])
-		uri := fmt.Sprintf("http://%s/printers/%s", hostOnly, name)
-		printers = append(printers, Printer{Name: display, URI: uri})
+// parsePrinters converts the Printer groups of a CUPS-Get-Printers response.
+// The URI is rebuilt from the queue name, scheme and hostOnly instead of
+// trusting printer-uri-supported, which carries the server's own hostname
+// (often "localhost" or a container id) and is not reachable from here.
+func parsePrinters(rsp *goipp.Message, scheme, hostOnly string) []Printer {
+	printers := []Printer{}
+	for _, grp := range rsp.AttrGroups() {
+		if grp.Tag != goipp.TagPrinterGroup {
+			continue
+		}
+		name := attrString(grp.Attrs, "printer-name")
+		if name == "" {
+			continue
+		}
+		ptype := attrInt(grp.Attrs, "printer-type")
+		p := Printer{
+			Name:      name,
+			Info:      attrString(grp.Attrs, "printer-info"),
+			Location:  attrString(grp.Attrs, "printer-location"),
+			MakeModel: attrString(grp.Attrs, "printer-make-and-model"),
+			State:     printerStateFromEnum(attrInt(grp.Attrs, "printer-state")),
+			Accepting: attrBool(grp.Attrs, "printer-is-accepting-jobs"),
+			Shared:    attrBool(grp.Attrs, "printer-is-shared"),
+			IsDefault: ptype&cupsPrinterDefault != 0,
+			IsClass:   ptype&cupsPrinterClass != 0,
+		}
+		collection := "printers"
+		if p.IsClass {
+			collection = "classes"
+		}
+		p.URI = (&url.URL{Scheme: scheme, Host: hostOnly, Path: "/" + collection + "/" + name}).String()
+		printers = append(printers, p)
 	}
+	return printers
+}
 
-	return printers, nil
+// printerStateFromEnum maps the IPP printer-state enum to its keyword.
+func printerStateFromEnum(v int) string {
+	switch v {
+	case 3:
+		return "idle"
+	case 4:
+		return "processing"
+	case 5:
+		return "stopped"
+	default:
+		return strconv.Itoa(v)
+	}
 }
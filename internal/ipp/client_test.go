@@ -2,6 +2,7 @@ package ipp
 
 import (
 	"bytes"
+	"slices"
 	"strings"
 	"testing"
 
@@ -53,6 +54,25 @@ func TestParsePageRange(t *testing.T) {
 	}
 }
 
+func TestSelectedPages(t *testing.T) {
+	tests := []struct {
+		opts PrintJobOptions
+		want int
+	}{
+		{PrintJobOptions{}, 10},
+		{PrintJobOptions{PageRange: "1-3 8 12-20"}, 4}, // 超出总页数的部分不计
+		{PrintJobOptions{PageSet: "odd"}, 5},
+		{PrintJobOptions{PageRange: "1-5", PageSet: "odd"}, 3},
+		{PrintJobOptions{PageRange: "1-5", PageSet: "even"}, 2},
+		{PrintJobOptions{PageRange: "0 x"}, 10}, // 非法范围等同于不限
+	}
+	for _, tt := range tests {
+		if got := SelectedPages(tt.opts, 10); got != tt.want {
+			t.Errorf("SelectedPages(%+v, 10) = %d, want %d", tt.opts, got, tt.want)
+		}
+	}
+}
+
 // buildJobMessage 复刻 SendPrintJob 的请求构造流程（只取 Job 组），
 // 用于离线校验 PrintJobOptions → IPP 属性的映射正确性。之所以不直接
 // 跑 SendPrintJob，是因为那个函数内部需要真实的 HTTP 连接，测试中
@@ -131,3 +151,89 @@ func TestSendPrintJob_PageSetNotLeakingIntoOperation(t *testing.T) {
 		}
 	}
 }
+
+// TestNewPrintJobRequest_Finishings 验证装订选项按 finishings 枚举或
+// finishings-col 模板两种形式编码，"none" 不上线。
+func TestNewPrintJobRequest_Finishings(t *testing.T) {
+	opts := PrintJobOptions{Finishings: []string{"none", "staple-top-left", "punch-dual-left"}, OutputBin: "face-down", JobSheets: "standard"}
+	req := newPrintJobRequest(goipp.OpPrintJob, "ipp://cups/printers/mfp", "application/pdf", "", "", opts)
+	var enums []int
+	for _, a := range req.Job {
+		if a.Name == "finishings" {
+			for _, v := range a.Values {
+				enums = append(enums, int(v.V.(goipp.Integer)))
+			}
+		}
+	}
+	if !slices.Equal(enums, []int{20, 74}) {
+		t.Errorf("finishings = %v, want [20 74]", enums)
+	}
+	if got := findJobAttr(req, "output-bin"); got != "face-down" {
+		t.Errorf("output-bin = %q", got)
+	}
+	if got := findJobAttr(req, "job-sheets"); got != "standard" {
+		t.Errorf("job-sheets = %q", got)
+	}
+
+	opts.FinishingsCol = true
+	req = newPrintJobRequest(goipp.OpPrintJob, "ipp://cups/printers/mfp", "application/pdf", "", "", opts)
+	var templates []string
+	for _, a := range req.Job {
+		if a.Name == "finishings-col" {
+			for _, v := range a.Values {
+				col := v.V.(goipp.Collection)
+				templates = append(templates, attrString(goipp.Attributes(col), "finishing-template"))
+			}
+		}
+	}
+	if !slices.Equal(templates, []string{"staple-top-left", "punch-dual-left"}) {
+		t.Errorf("finishings-col templates = %v", templates)
+	}
+}
+
+// TestParsePrinters 用一份经过编解码的 CUPS-Get-Printers 响应校验多个
+// Printer 组的解析：队列/类的 URI 拼接、状态枚举映射与 printer-type 位标志。
+func TestParsePrinters(t *testing.T) {
+	printerGroup := func(name, info string, state, ptype int, accepting, shared bool) goipp.Group {
+		var attrs goipp.Attributes
+		attrs.Add(goipp.MakeAttribute("printer-name", goipp.TagName, goipp.String(name)))
+		attrs.Add(goipp.MakeAttribute("printer-info", goipp.TagText, goipp.String(info)))
+		attrs.Add(goipp.MakeAttribute("printer-location", goipp.TagText, goipp.String("2F")))
+		attrs.Add(goipp.MakeAttribute("printer-state", goipp.TagEnum, goipp.Integer(state)))
+		attrs.Add(goipp.MakeAttribute("printer-type", goipp.TagEnum, goipp.Integer(ptype)))
+		attrs.Add(goipp.MakeAttribute("printer-is-accepting-jobs", goipp.TagBoolean, goipp.Boolean(accepting)))
+		attrs.Add(goipp.MakeAttribute("printer-is-shared", goipp.TagBoolean, goipp.Boolean(shared)))
+		return goipp.Group{Tag: goipp.TagPrinterGroup, Attrs: attrs}
+	}
+	var op goipp.Attributes
+	op.Add(goipp.MakeAttribute("attributes-charset", goipp.TagCharset, goipp.String("utf-8")))
+	msg := goipp.NewMessageWithGroups(goipp.DefaultVersion, goipp.Code(goipp.StatusOk), 1, goipp.Groups{
+		{Tag: goipp.TagOperationGroup, Attrs: op},
+		printerGroup("HP_LaserJet", "Office Laser", 3, 0x20000, true, true),
+		printerGroup("all", "All printers", 5, 0x0001, false, false),
+	})
+	payload, err := msg.EncodeBytes()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var decoded goipp.Message
+	if err := decoded.Decode(bytes.NewReader(payload)); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	got := parsePrinters(&decoded, "http", "cups.local:631")
+	if len(got) != 2 {
+		t.Fatalf("len = %d, want 2; got=%+v", len(got), got)
+	}
+	want := []Printer{
+		{Name: "HP_LaserJet", URI: "http://cups.local:631/printers/HP_LaserJet", Info: "Office Laser", Location: "2F",
+			State: "idle", Accepting: true, Shared: true, IsDefault: true},
+		{Name: "all", URI: "http://cups.local:631/classes/all", Info: "All printers", Location: "2F",
+			State: "stopped", IsClass: true},
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("printer[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package ipp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+// Document is one member of a multi-document job.
+type Document struct {
+	Name string // document-name, usually the original file name
+	Mime string // document-format; empty means application/octet-stream
+	Data io.Reader
+}
+
+// SendDocuments submits docs as a single job: Create-Job carries the job
+// template attributes, then each document follows in its own Send-Document
+// request, the last one flagged last-document. Compared to one Print-Job per
+// file the printer sees a single job, so banner pages, stapling and collated
+// copies cover all documents and other users' jobs cannot interleave.
+//
+// If a Send-Document fails the half-built job is canceled. The returned value
+// has the same form as SendPrintJob's.
+func SendDocuments(printerURI string, docs []Document, username, jobName string, opts PrintJobOptions) (string, error) {
+	if len(docs) == 0 {
+		return "", errors.New("no documents")
+	}
+	log.Printf("[ipp] SendDocuments: uri=%q user=%q job=%q documents=%d", printerURI, username, jobName, len(docs))
+
+	req := newPrintJobRequest(goipp.OpCreateJob, printerURI, "", username, jobName, opts)
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
+	if err != nil {
+		return "", fmt.Errorf("create-job: %w", err)
+	}
+	jobID := attrInt(rsp.Job, "job-id")
+	if jobID <= 0 {
+		return "", errors.New("create-job: no job-id in response")
+	}
+
+	for i, doc := range docs {
+		if err := sendDocument(printerURI, jobID, username, doc, i == len(docs)-1); err != nil {
+			if cerr := CancelJob(printerURI, jobID, username); cerr != nil && !IsNotPossible(cerr) {
+				log.Printf("[ipp] SendDocuments: cancel job %d after failure: %v", jobID, cerr)
+			}
+			return "", fmt.Errorf("send-document %d/%d: %w", i+1, len(docs), err)
+		}
+	}
+	job := jobIdentifier(rsp)
+	log.Printf("[ipp] SendDocuments: success, job=%s", job)
+	return job, nil
+}
+
+func sendDocument(printerURI string, jobID int, username string, doc Document, last bool) error {
+	mime := doc.Mime
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+	req := newRequest(goipp.OpSendDocument, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("job-id", goipp.TagInteger, goipp.Integer(jobID)))
+	if username != "" {
+		req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(username)))
+	}
+	if doc.Name != "" {
+		req.Operation.Add(goipp.MakeAttribute("document-name", goipp.TagName, goipp.String(doc.Name)))
+	}
+	req.Operation.Add(goipp.MakeAttribute("document-format", goipp.TagMimeType, goipp.String(mime)))
+	req.Operation.Add(goipp.MakeAttribute("last-document", goipp.TagBoolean, goipp.Boolean(last)))
+
+	// 与 SendPrintJob 一样，上传文档的整体超时放宽到 120s。
+	_, err := roundTrip(printerURI, req, doc.Data, 120*time.Second)
+	return err
+}
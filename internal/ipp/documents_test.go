@@ -0,0 +1,89 @@
+package ipp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+// TestSendDocuments 验证 Create-Job 之后逐个 Send-Document，只有最后一个带 last-document=true。
+func TestSendDocuments(t *testing.T) {
+	var ops []goipp.Op
+	var bodies, lasts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req goipp.Message
+		if err := req.Decode(r.Body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		op := goipp.Op(req.Code)
+		ops = append(ops, op)
+		rsp := goipp.NewResponse(goipp.DefaultVersion, goipp.StatusOk, req.RequestID)
+		switch op {
+		case goipp.OpCreateJob:
+			if got := attrString(req.Job, "sides"); got != "two-sided-long-edge" {
+				t.Errorf("Create-Job sides = %q", got)
+			}
+			rsp.Job.Add(goipp.MakeAttribute("job-id", goipp.TagInteger, goipp.Integer(42)))
+			rsp.Job.Add(goipp.MakeAttribute("job-uri", goipp.TagURI, goipp.String("ipp://cups/jobs/42")))
+		case goipp.OpSendDocument:
+			if got := attrInt(req.Operation, "job-id"); got != 42 {
+				t.Errorf("Send-Document job-id = %d", got)
+			}
+			lasts = append(lasts, attrString(req.Operation, "document-name")+"="+boolString(attrBool(req.Operation, "last-document")))
+			doc, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(doc))
+		}
+		w.Header().Set("Content-Type", goipp.ContentType)
+		rsp.Encode(w)
+	}))
+	defer srv.Close()
+
+	docs := []Document{
+		{Name: "a.pdf", Mime: "application/pdf", Data: strings.NewReader("%PDF-a")},
+		{Name: "b.pdf", Mime: "application/pdf", Data: strings.NewReader("%PDF-b")},
+	}
+	job, err := SendDocuments(srv.URL+"/printers/hp", docs, "alice", "a.pdf 等 2 个文件", PrintJobOptions{IsDuplex: true, Copies: 1})
+	if err != nil {
+		t.Fatalf("SendDocuments: %v", err)
+	}
+	if job != "42" {
+		t.Errorf("job = %q", job)
+	}
+	if want := []goipp.Op{goipp.OpCreateJob, goipp.OpSendDocument, goipp.OpSendDocument}; !slices.Equal(ops, want) {
+		t.Errorf("ops = %v, want %v", ops, want)
+	}
+	if want := []string{"a.pdf=false", "b.pdf=true"}; !slices.Equal(lasts, want) {
+		t.Errorf("last-document = %v, want %v", lasts, want)
+	}
+	if want := []string{"%PDF-a", "%PDF-b"}; !slices.Equal(bodies, want) {
+		t.Errorf("documents = %q, want %q", bodies, want)
+	}
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+func TestMultipleDocumentJobsCapability(t *testing.T) {
+	var attrs goipp.Attributes
+	attrs.Add(goipp.MakeAttribute("multiple-document-jobs-supported", goipp.TagBoolean, goipp.Boolean(true)))
+	ops := goipp.MakeAttribute("operations-supported", goipp.TagEnum, goipp.Integer(goipp.OpPrintJob))
+	ops.Values.Add(goipp.TagEnum, goipp.Integer(goipp.OpCreateJob))
+	attrs.Add(ops)
+	if parseCapabilities(attrs).MultipleDocumentJobs {
+		t.Error("MultipleDocumentJobs without Send-Document should be false")
+	}
+
+	attrs[1].Values.Add(goipp.TagEnum, goipp.Integer(goipp.OpSendDocument))
+	if !parseCapabilities(attrs).MultipleDocumentJobs {
+		t.Error("MultipleDocumentJobs should be true")
+	}
+}
@@ -0,0 +1,268 @@
+package ipp
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+// Job states as reported by IPP job-state (RFC 8011 5.3.7), shortened to the
+// keywords the UI displays.
+const (
+	JobStatePending    = "pending"
+	JobStateHeld       = "held"
+	JobStateProcessing = "processing"
+	JobStateStopped    = "stopped"
+	JobStateCanceled   = "canceled"
+	JobStateAborted    = "aborted"
+	JobStateCompleted  = "completed"
+)
+
+// JobStatus holds the live state of a print job retrieved via Get-Job-Attributes.
+type JobStatus struct {
+	JobID                int       `json:"jobId"`
+	State                string    `json:"state"`
+	StateReasons         []string  `json:"stateReasons"`
+	ImpressionsCompleted int       `json:"impressionsCompleted"`
+	CompletedAt          time.Time `json:"completedAt"` // zero until the job reaches a terminal state
+}
+
+// Terminal reports whether the job reached a final state and will not change anymore.
+func (s *JobStatus) Terminal() bool {
+	return IsTerminalJobState(s.State)
+}
+
+// IsTerminalJobState reports whether state is one of canceled/aborted/completed.
+func IsTerminalJobState(state string) bool {
+	switch state {
+	case JobStateCanceled, JobStateAborted, JobStateCompleted:
+		return true
+	default:
+		return false
+	}
+}
+
+// jobStateFromEnum maps the IPP job-state enum to its keyword.
+func jobStateFromEnum(v int) string {
+	switch v {
+	case 3:
+		return JobStatePending
+	case 4:
+		return JobStateHeld
+	case 5:
+		return JobStateProcessing
+	case 6:
+		return JobStateStopped
+	case 7:
+		return JobStateCanceled
+	case 8:
+		return JobStateAborted
+	case 9:
+		return JobStateCompleted
+	default:
+		return strconv.Itoa(v)
+	}
+}
+
+// ParseJobID extracts the numeric job id from the value returned by
+// SendPrintJob, which is either a bare job-id ("42") or a job-uri
+// ("ipp://host:631/jobs/42"). Returns 0 when no id can be found.
+func ParseJobID(s string) int {
+	s = strings.TrimSpace(s)
+	if idx := strings.LastIndex(s, "/"); idx >= 0 {
+		s = s[idx+1:]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// jobStatusAttributes is the requested-attributes list for Get-Job-Attributes.
+var jobStatusAttributes = []string{
+	"job-id",
+	"job-state",
+	"job-state-reasons",
+	"job-impressions-completed",
+	"date-time-at-completed",
+}
+
+// GetJobAttributes queries the current state of jobID on printerURI. username
+// is sent as requesting-user-name so CUPS applies the owner's privacy rules.
+func GetJobAttributes(printerURI string, jobID int, username string) (*JobStatus, error) {
+	if jobID <= 0 {
+		return nil, fmt.Errorf("invalid job id %d", jobID)
+	}
+	req := newRequest(goipp.OpGetJobAttributes, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("job-id", goipp.TagInteger, goipp.Integer(jobID)))
+	if username != "" {
+		req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(username)))
+	}
+	req.Operation.Add(requestedAttributes(jobStatusAttributes...))
+
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	st := parseJobStatus(rsp.Job)
+	if st.JobID == 0 {
+		st.JobID = jobID
+	}
+	return st, nil
+}
+
+// parseJobStatus converts a Job attribute group into a JobStatus.
+func parseJobStatus(attrs goipp.Attributes) *JobStatus {
+	st := &JobStatus{
+		JobID:                attrInt(attrs, "job-id"),
+		State:                jobStateFromEnum(attrInt(attrs, "job-state")),
+		StateReasons:         attrStrings(attrs, "job-state-reasons"),
+		ImpressionsCompleted: attrInt(attrs, "job-impressions-completed"),
+	}
+	for _, a := range attrs {
+		if a.Name == "date-time-at-completed" && len(a.Values) > 0 {
+			if t, ok := a.Values[0].V.(goipp.Time); ok {
+				st.CompletedAt = t.Time
+			}
+		}
+	}
+	return st
+}
+
+// CancelJob issues Cancel-Job for jobID on printerURI. username must be the job
+// owner (CUPS only lets the owner or an operator cancel). A job that already
+// reached a terminal state yields an error for which IsNotPossible is true.
+func CancelJob(printerURI string, jobID int, username string) error {
+	if jobID <= 0 {
+		return fmt.Errorf("invalid job id %d", jobID)
+	}
+	req := newRequest(goipp.OpCancelJob, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("job-id", goipp.TagInteger, goipp.Integer(jobID)))
+	if username != "" {
+		req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(username)))
+	}
+	if _, err := roundTrip(printerURI, req, nil, dialTimeout); err != nil {
+		log.Printf("[ipp] CancelJob: uri=%q job=%d failed: %v", printerURI, jobID, err)
+		return err
+	}
+	log.Printf("[ipp] CancelJob: uri=%q job=%d canceled", printerURI, jobID)
+	return nil
+}
+
+// ReleaseJob issues Release-Job for a job submitted with PrintJobOptions.Hold.
+// username must be the job owner. A job that is no longer held yields an error
+// for which IsNotPossible is true.
+func ReleaseJob(printerURI string, jobID int, username string) error {
+	if jobID <= 0 {
+		return fmt.Errorf("invalid job id %d", jobID)
+	}
+	req := newRequest(goipp.OpReleaseJob, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("job-id", goipp.TagInteger, goipp.Integer(jobID)))
+	if username != "" {
+		req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(username)))
+	}
+	if _, err := roundTrip(printerURI, req, nil, dialTimeout); err != nil {
+		log.Printf("[ipp] ReleaseJob: uri=%q job=%d failed: %v", printerURI, jobID, err)
+		return err
+	}
+	log.Printf("[ipp] ReleaseJob: uri=%q job=%d released", printerURI, jobID)
+	return nil
+}
+
+// Which-jobs values accepted by GetJobs.
+const (
+	WhichJobsNotCompleted = "not-completed"
+	WhichJobsCompleted    = "completed"
+)
+
+// QueueJob is one entry of a printer queue as returned by Get-Jobs.
+type QueueJob struct {
+	JobID          int       `json:"jobId"`
+	Name           string    `json:"name"`
+	Owner          string    `json:"owner"`
+	State          string    `json:"state"`
+	StateReasons   []string  `json:"stateReasons"`
+	Pages          int       `json:"pages"`          // job-impressions, 0 when unknown
+	PagesCompleted int       `json:"pagesCompleted"` // job-impressions-completed
+	SubmittedAt    time.Time `json:"submittedAt"`
+	CompletedAt    time.Time `json:"completedAt"`
+}
+
+// queueJobAttributes is the requested-attributes list for Get-Jobs.
+var queueJobAttributes = []string{
+	"job-id",
+	"job-name",
+	"job-originating-user-name",
+	"job-state",
+	"job-state-reasons",
+	"job-impressions",
+	"job-impressions-completed",
+	"time-at-creation",
+	"time-at-completed",
+}
+
+// GetJobs lists the jobs of printerURI. which is WhichJobsNotCompleted or
+// WhichJobsCompleted; limit <= 0 means no limit. username is sent as
+// requesting-user-name, so CUPS may hide private attributes (job name, owner)
+// of other users' jobs depending on its JobPrivateValues policy.
+func GetJobs(printerURI, which, username string, limit int) ([]QueueJob, error) {
+	req := newRequest(goipp.OpGetJobs, printerURI)
+	if username != "" {
+		req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(username)))
+	}
+	if which != "" {
+		req.Operation.Add(goipp.MakeAttribute("which-jobs", goipp.TagKeyword, goipp.String(which)))
+	}
+	if limit > 0 {
+		req.Operation.Add(goipp.MakeAttribute("limit", goipp.TagInteger, goipp.Integer(limit)))
+	}
+	req.Operation.Add(requestedAttributes(queueJobAttributes...))
+
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
+	if err != nil {
+		// 队列为空时 CUPS 可能回 not-found，对调用方来说就是空列表。
+		if IsNotFound(err) {
+			return []QueueJob{}, nil
+		}
+		return nil, err
+	}
+	jobs := parseQueueJobs(rsp)
+	log.Printf("[ipp] GetJobs: uri=%q which=%s jobs=%d", printerURI, which, len(jobs))
+	return jobs, nil
+}
+
+// parseQueueJobs converts the Job groups of a Get-Jobs response.
+func parseQueueJobs(rsp *goipp.Message) []QueueJob {
+	jobs := []QueueJob{}
+	for _, grp := range rsp.AttrGroups() {
+		if grp.Tag != goipp.TagJobGroup {
+			continue
+		}
+		job := QueueJob{
+			JobID:          attrInt(grp.Attrs, "job-id"),
+			Name:           attrString(grp.Attrs, "job-name"),
+			Owner:          attrString(grp.Attrs, "job-originating-user-name"),
+			State:          jobStateFromEnum(attrInt(grp.Attrs, "job-state")),
+			StateReasons:   attrStrings(grp.Attrs, "job-state-reasons"),
+			Pages:          attrInt(grp.Attrs, "job-impressions"),
+			PagesCompleted: attrInt(grp.Attrs, "job-impressions-completed"),
+		}
+		if job.JobID == 0 {
+			continue
+		}
+		// time-at-* 是 Unix 秒；作业未完成时 CUPS 用 no-value 占位，attrInt 得 0。
+		if sec := attrInt(grp.Attrs, "time-at-creation"); sec > 0 {
+			job.SubmittedAt = time.Unix(int64(sec), 0).UTC()
+		}
+		if sec := attrInt(grp.Attrs, "time-at-completed"); sec > 0 {
+			job.CompletedAt = time.Unix(int64(sec), 0).UTC()
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
@@ -0,0 +1,108 @@
+package ipp
+
+import (
+	"testing"
+	"time"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+func TestParseJobID(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int
+	}{
+		{"42", 42},
+		{" 7 ", 7},
+		{"ipp://localhost:631/jobs/123", 123},
+		{"http://printer.local/jobs/9", 9},
+		{"ok", 0},
+		{"", 0},
+		{"ipp://localhost:631/jobs/", 0},
+		{"-3", 0},
+	}
+	for _, c := range cases {
+		if got := ParseJobID(c.in); got != c.want {
+			t.Errorf("ParseJobID(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+// TestParseJobStatus 验证 Get-Job-Attributes 响应中 Job 组到 JobStatus 的映射，
+// 包括 job-state 枚举 → keyword、多值 job-state-reasons 与完成时间。
+func TestParseJobStatus(t *testing.T) {
+	done := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	var attrs goipp.Attributes
+	attrs.Add(goipp.MakeAttribute("job-id", goipp.TagInteger, goipp.Integer(12)))
+	attrs.Add(goipp.MakeAttribute("job-state", goipp.TagEnum, goipp.Integer(8)))
+	reasons := goipp.MakeAttribute("job-state-reasons", goipp.TagKeyword, goipp.String("aborted-by-system"))
+	reasons.Values.Add(goipp.TagKeyword, goipp.String("media-jam"))
+	attrs.Add(reasons)
+	attrs.Add(goipp.MakeAttribute("job-impressions-completed", goipp.TagInteger, goipp.Integer(3)))
+	attrs.Add(goipp.MakeAttribute("date-time-at-completed", goipp.TagDateTime, goipp.Time{Time: done}))
+
+	st := parseJobStatus(attrs)
+	if st.JobID != 12 || st.State != JobStateAborted || st.ImpressionsCompleted != 3 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if len(st.StateReasons) != 2 || st.StateReasons[1] != "media-jam" {
+		t.Errorf("StateReasons = %v", st.StateReasons)
+	}
+	if !st.CompletedAt.Equal(done) {
+		t.Errorf("CompletedAt = %v, want %v", st.CompletedAt, done)
+	}
+	if !st.Terminal() {
+		t.Errorf("aborted job must be terminal")
+	}
+}
+
+func TestJobStateFromEnum(t *testing.T) {
+	want := map[int]string{
+		3: JobStatePending, 4: JobStateHeld, 5: JobStateProcessing, 6: JobStateStopped,
+		7: JobStateCanceled, 8: JobStateAborted, 9: JobStateCompleted, 42: "42",
+	}
+	for in, w := range want {
+		if got := jobStateFromEnum(in); got != w {
+			t.Errorf("jobStateFromEnum(%d) = %q, want %q", in, got, w)
+		}
+	}
+	for _, s := range []string{JobStatePending, JobStateHeld, JobStateProcessing, JobStateStopped} {
+		if IsTerminalJobState(s) {
+			t.Errorf("%s must not be terminal", s)
+		}
+	}
+}
+
+// TestParseQueueJobs 验证 Get-Jobs 响应里多个 Job 组的解析，以及未完成作业
+// time-at-completed 为 no-value 时不会被误解析成 1970 年。
+func TestParseQueueJobs(t *testing.T) {
+	jobGroup := func(id, state, created int, name, owner string) goipp.Group {
+		var attrs goipp.Attributes
+		attrs.Add(goipp.MakeAttribute("job-id", goipp.TagInteger, goipp.Integer(id)))
+		attrs.Add(goipp.MakeAttribute("job-name", goipp.TagName, goipp.String(name)))
+		attrs.Add(goipp.MakeAttribute("job-originating-user-name", goipp.TagName, goipp.String(owner)))
+		attrs.Add(goipp.MakeAttribute("job-state", goipp.TagEnum, goipp.Integer(state)))
+		attrs.Add(goipp.MakeAttribute("job-impressions", goipp.TagInteger, goipp.Integer(4)))
+		attrs.Add(goipp.MakeAttribute("time-at-creation", goipp.TagInteger, goipp.Integer(created)))
+		attrs.Add(goipp.MakeAttribute("time-at-completed", goipp.TagNoValue, goipp.Void{}))
+		return goipp.Group{Tag: goipp.TagJobGroup, Attrs: attrs}
+	}
+	msg := goipp.NewMessageWithGroups(goipp.DefaultVersion, goipp.Code(goipp.StatusOk), 1, goipp.Groups{
+		jobGroup(7, 5, 1714550400, "report.pdf", "alice"),
+		jobGroup(8, 3, 1714550460, "slides.pdf", "bob"),
+	})
+
+	jobs := parseQueueJobs(msg)
+	if len(jobs) != 2 {
+		t.Fatalf("len = %d, want 2", len(jobs))
+	}
+	if jobs[0].JobID != 7 || jobs[0].State != JobStateProcessing || jobs[0].Owner != "alice" || jobs[0].Pages != 4 {
+		t.Errorf("jobs[0] = %+v", jobs[0])
+	}
+	if want := time.Unix(1714550460, 0).UTC(); !jobs[1].SubmittedAt.Equal(want) {
+		t.Errorf("SubmittedAt = %v, want %v", jobs[1].SubmittedAt, want)
+	}
+	if !jobs[1].CompletedAt.IsZero() {
+		t.Errorf("CompletedAt should be zero for a pending job, got %v", jobs[1].CompletedAt)
+	}
+}
@@ -0,0 +1,205 @@
+package ipp
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Default event sets used by Notifier.
+var (
+	DefaultPrinterEvents = []string{"printer-state-changed", "printer-stopped", "printer-added", "printer-deleted", "printer-config-changed"}
+	DefaultJobEvents     = []string{"job-state-changed", "job-completed"}
+)
+
+const (
+	notifierLease        = time.Hour
+	notifierMinInterval  = 2 * time.Second
+	notifierMaxInterval  = 60 * time.Second
+	notifierRetryBackoff = 30 * time.Second
+)
+
+// Notifier keeps one server-wide printer subscription plus a job subscription
+// for every job registered with WatchJob, and pulls their events with
+// Get-Notifications. Compared to polling Get-Printer-Attributes per browser,
+// CUPS sees a single lightweight request per interval no matter how many
+// clients are watching.
+type Notifier struct {
+	// ServerURI is the CUPS root, e.g. "http://localhost:631/". Subscribing
+	// to the root delivers printer events of every queue.
+	ServerURI     string
+	PrinterEvents []string
+	JobEvents     []string
+	// OnEvent is called for every event, in delivery order, from the Run goroutine.
+	OnEvent func(Event)
+
+	mu         sync.Mutex
+	next       map[int]int  // subscription id -> next wanted sequence number
+	jobSubs    map[int]bool // subscription ids that belong to a single job
+	printerSub int
+	renewAt    time.Time
+}
+
+// WatchJob creates a job subscription for jobID so its state changes are
+// delivered through OnEvent. Failures are logged only: the job state poller
+// remains the fallback for jobs that could not be subscribed.
+func (n *Notifier) WatchJob(printerURI string, jobID int) {
+	events := n.JobEvents
+	if len(events) == 0 {
+		events = DefaultJobEvents
+	}
+	id, err := CreateJobSubscription(printerURI, jobID, events)
+	if err != nil {
+		log.Printf("[ipp] notifier: subscribe job %d on %q: %v", jobID, printerURI, err)
+		return
+	}
+	n.mu.Lock()
+	n.init()
+	n.next[id] = 1
+	n.jobSubs[id] = true
+	n.mu.Unlock()
+}
+
+func (n *Notifier) init() {
+	if n.next == nil {
+		n.next = make(map[int]int)
+		n.jobSubs = make(map[int]bool)
+	}
+}
+
+// Run pulls events until ctx is canceled, then cancels the printer subscription.
+func (n *Notifier) Run(ctx context.Context) {
+	n.mu.Lock()
+	n.init()
+	n.mu.Unlock()
+	defer n.cancelPrinterSubscription()
+
+	for {
+		wait := n.pollOnce()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// pollOnce (re)establishes the printer subscription when needed, pulls pending
+// events and returns how long to wait before the next pull.
+func (n *Notifier) pollOnce() time.Duration {
+	if err := n.ensurePrinterSubscription(); err != nil {
+		log.Printf("[ipp] notifier: printer subscription: %v", err)
+		return notifierRetryBackoff
+	}
+
+	n.mu.Lock()
+	next := make(map[int]int, len(n.next))
+	for id, seq := range n.next {
+		next[id] = seq
+	}
+	n.mu.Unlock()
+
+	events, interval, err := GetNotifications(n.ServerURI, next)
+	if IsNotFound(err) {
+		// 某个订阅已失效（作业被清理、CUPS 重启），逐个探测剔除后下轮重试。
+		n.pruneSubscriptions(next)
+		return notifierMinInterval
+	}
+	if err != nil {
+		log.Printf("[ipp] notifier: get notifications: %v", err)
+		return notifierRetryBackoff
+	}
+
+	for _, ev := range events {
+		n.mu.Lock()
+		if ev.Sequence >= n.next[ev.SubscriptionID] {
+			n.next[ev.SubscriptionID] = ev.Sequence + 1
+		}
+		// 作业进入终态后不会再有事件，不再拉取它的订阅。
+		if n.jobSubs[ev.SubscriptionID] && IsTerminalJobState(ev.JobState) {
+			delete(n.next, ev.SubscriptionID)
+			delete(n.jobSubs, ev.SubscriptionID)
+		}
+		n.mu.Unlock()
+		if n.OnEvent != nil {
+			n.OnEvent(ev)
+		}
+	}
+
+	switch {
+	case interval <= 0:
+		return 5 * time.Second
+	case interval < notifierMinInterval:
+		return notifierMinInterval
+	case interval > notifierMaxInterval:
+		return notifierMaxInterval
+	default:
+		return interval
+	}
+}
+
+func (n *Notifier) ensurePrinterSubscription() error {
+	n.mu.Lock()
+	id, renewAt := n.printerSub, n.renewAt
+	n.mu.Unlock()
+
+	if id != 0 && time.Now().Before(renewAt) {
+		return nil
+	}
+	if id != 0 {
+		err := RenewSubscription(n.ServerURI, id, notifierLease)
+		if err == nil {
+			n.mu.Lock()
+			n.renewAt = time.Now().Add(notifierLease / 2)
+			n.mu.Unlock()
+			return nil
+		}
+		log.Printf("[ipp] notifier: renew subscription %d: %v, recreating", id, err)
+	}
+
+	events := n.PrinterEvents
+	if len(events) == 0 {
+		events = DefaultPrinterEvents
+	}
+	newID, err := CreatePrinterSubscription(n.ServerURI, events, notifierLease)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	delete(n.next, id)
+	n.printerSub = newID
+	n.next[newID] = 1
+	n.renewAt = time.Now().Add(notifierLease / 2)
+	n.mu.Unlock()
+	return nil
+}
+
+// pruneSubscriptions queries every subscription on its own and forgets the
+// ones CUPS no longer knows. A lost printer subscription is recreated by the
+// next ensurePrinterSubscription call.
+func (n *Notifier) pruneSubscriptions(next map[int]int) {
+	for id, seq := range next {
+		_, _, err := GetNotifications(n.ServerURI, map[int]int{id: seq})
+		if !IsNotFound(err) {
+			continue
+		}
+		n.mu.Lock()
+		delete(n.next, id)
+		delete(n.jobSubs, id)
+		if id == n.printerSub {
+			n.printerSub = 0
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Notifier) cancelPrinterSubscription() {
+	n.mu.Lock()
+	id := n.printerSub
+	n.printerSub = 0
+	n.mu.Unlock()
+	if id != 0 {
+		_ = CancelSubscription(n.ServerURI, id)
+	}
+}
@@ -0,0 +1,71 @@
+package ipp
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// TrackedJob identifies a submitted job whose state should be followed.
+// Key is an opaque caller-side identifier (e.g. the print record id).
+type TrackedJob struct {
+	Key        int64
+	PrinterURI string
+	JobID      int
+	Username   string
+}
+
+// JobPoller periodically queries Get-Job-Attributes for jobs that have not
+// reached a terminal state yet. The poller itself is storage agnostic: Pending
+// supplies the jobs to check and Update persists every observed status.
+//
+// Update receives a nil status together with the lookup error when CUPS no
+// longer knows the job (IsNotFound), so the caller can stop tracking it.
+type JobPoller struct {
+	Interval time.Duration
+	Pending  func(ctx context.Context) ([]TrackedJob, error)
+	Update   func(ctx context.Context, job TrackedJob, st *JobStatus, err error) error
+}
+
+// Run polls until ctx is canceled. It polls once immediately so that jobs left
+// over from a previous process are picked up on startup.
+func (p *JobPoller) Run(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.PollOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollOnce checks every pending job a single time. Transient lookup errors
+// (CUPS down, network issues) are logged and retried on the next round; a
+// not-found job (purged by CUPS) is expected and handed to Update silently.
+func (p *JobPoller) PollOnce(ctx context.Context) {
+	jobs, err := p.Pending(ctx)
+	if err != nil {
+		log.Printf("[ipp] job poller: list pending jobs failed: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		st, err := GetJobAttributes(job.PrinterURI, job.JobID, job.Username)
+		if err != nil && !IsNotFound(err) {
+			log.Printf("[ipp] job poller: job %d on %q: %v", job.JobID, job.PrinterURI, err)
+			continue
+		}
+		if uerr := p.Update(ctx, job, st, err); uerr != nil {
+			log.Printf("[ipp] job poller: update job %d failed: %v", job.JobID, uerr)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package ipp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+// StatusError is returned when the IPP server answers a request with a
+// non-successful status code. Callers can inspect Status via errors.As or use
+// the IsNotFound / IsNotPossible helpers.
+type StatusError struct {
+	Op     goipp.Op
+	Status goipp.Status
+	// Unsupported lists the attributes the server returned in the Unsupported
+	// Attributes group, e.g. job template values a printer cannot honor.
+	Unsupported []string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ipp error: %s", e.Status.String())
+}
+
+// IsNotFound reports whether err is an IPP client-error-not-found response,
+// e.g. a job that CUPS already purged from its history.
+func IsNotFound(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Status == goipp.StatusErrorNotFound
+}
+
+// IsNotPossible reports whether err is an IPP client-error-not-possible
+// response, e.g. canceling a job that already reached a terminal state.
+func IsNotPossible(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Status == goipp.StatusErrorNotPossible
+}
+
+// newRequest builds an IPP request carrying the mandatory operation attributes
+// (charset, natural language, printer-uri). printerURI is the HTTP transport
+// URI; it is converted to the ipp:// form CUPS expects in the attribute.
+func newRequest(op goipp.Op, printerURI string) *goipp.Message {
+	req := newServerRequest(op)
+	req.Operation.Add(goipp.MakeAttribute("printer-uri", goipp.TagURI, goipp.String(httpToIppURI(printerURI))))
+	return req
+}
+
+// newServerRequest builds a request for server-level operations such as
+// CUPS-Get-Printers that do not target a particular printer.
+func newServerRequest(op goipp.Op) *goipp.Message {
+	req := goipp.NewRequest(goipp.DefaultVersion, op, 1)
+	req.Operation.Add(goipp.MakeAttribute("attributes-charset", goipp.TagCharset, goipp.String("utf-8")))
+	req.Operation.Add(goipp.MakeAttribute("attributes-natural-language", goipp.TagLanguage, goipp.String("en-US")))
+	return req
+}
+
+// requestedAttributes builds a requested-attributes value list.
+func requestedAttributes(names ...string) goipp.Attribute {
+	var vals goipp.Values
+	for _, name := range names {
+		vals.Add(goipp.TagKeyword, goipp.String(name))
+	}
+	return goipp.Attribute{Name: "requested-attributes", Values: vals}
+}
+
+// roundTrip validates printerURI, posts the encoded request (optionally
+// followed by document data) and decodes the IPP response. Any status outside
+// the successful-ok range is reported as *StatusError.
+//
+// ipp:// and ipps:// URIs are sent over http and https respectively. A 401
+// from a host with registered credentials is answered once with Basic or
+// Digest authentication; the document is resent only if it was not consumed
+// yet (Expect: 100-continue) or doc can seek back to where it started.
+func roundTrip(printerURI string, req *goipp.Message, doc io.Reader, timeout time.Duration) (*goipp.Message, error) {
+	if err := validatePrinterURI(printerURI); err != nil {
+		return nil, err
+	}
+	target, err := transportURL(printerURI)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid printer uri")
+	}
+	payload, err := req.EncodeBytes()
+	if err != nil {
+		return nil, fmt.Errorf("encode ipp request: %w", err)
+	}
+
+	var (
+		counted *countingReader
+		start   int64 = -1
+	)
+	if doc != nil {
+		if seeker, ok := doc.(io.Seeker); ok {
+			if pos, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+				start = pos
+			}
+		}
+		counted = &countingReader{r: doc}
+	}
+
+	client := newSafeClient(timeout)
+	authorization := cachedAuthorization(u, http.MethodPost)
+	for attempt := 0; ; attempt++ {
+		var body io.Reader = bytes.NewReader(payload)
+		if counted != nil {
+			body = io.MultiReader(body, counted)
+		}
+		httpReq, err := http.NewRequest(http.MethodPost, target, body)
+		if err != nil {
+			return nil, fmt.Errorf("create http request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", goipp.ContentType)
+		httpReq.Header.Set("Accept", goipp.ContentType)
+		if counted != nil {
+			// 认证失败时 CUPS 在读文档前就回 401，文档不会白传一遍。
+			httpReq.Header.Set("Expect", "100-continue")
+		}
+		if authorization != "" {
+			httpReq.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("http post: %w", err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			authorization, err = answerChallenge(u, http.MethodPost, resp)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("http status: %s: %w", resp.Status, err)
+			}
+			if authorization == "" {
+				return nil, fmt.Errorf("http status: %s (no credentials configured for %s)", resp.Status, u.Hostname())
+			}
+			if counted != nil && counted.n > 0 {
+				if start < 0 {
+					return nil, fmt.Errorf("http status: %s (document already sent, cannot retry)", resp.Status)
+				}
+				if _, err := doc.(io.Seeker).Seek(start, io.SeekStart); err != nil {
+					return nil, fmt.Errorf("rewind document: %w", err)
+				}
+				counted.n = 0
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("http status: %s", resp.Status)
+		}
+
+		var rsp goipp.Message
+		if err := rsp.Decode(limitedBody(resp.Body)); err != nil {
+			return nil, fmt.Errorf("decode ipp response: %w", err)
+		}
+		// 0x0000-0x00ff 都是 successful-ok-* 系列（含 ignored-or-substituted 等）。
+		if rsp.Code >= 0x0100 {
+			return nil, &StatusError{Op: goipp.Op(req.Code), Status: goipp.Status(rsp.Code), Unsupported: attrNames(rsp.Unsupported)}
+		}
+		return &rsp, nil
+	}
+}
+
+// countingReader records how many bytes of the document were consumed.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// attrNames returns the names of attrs in order.
+func attrNames(attrs goipp.Attributes) []string {
+	var names []string
+	for _, a := range attrs {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// attrString returns the first value of the named attribute, or "".
+func attrString(attrs goipp.Attributes, name string) string {
+	for _, a := range attrs {
+		if a.Name == name && len(a.Values) > 0 {
+			return a.Values[0].V.String()
+		}
+	}
+	return ""
+}
+
+// attrInt returns the first value of the named attribute as an int, or 0.
+func attrInt(attrs goipp.Attributes, name string) int {
+	for _, a := range attrs {
+		if a.Name == name && len(a.Values) > 0 {
+			if v, ok := a.Values[0].V.(goipp.Integer); ok {
+				return int(v)
+			}
+		}
+	}
+	return 0
+}
+
+// attrBool returns the first value of the named attribute as a bool, or false.
+func attrBool(attrs goipp.Attributes, name string) bool {
+	for _, a := range attrs {
+		if a.Name == name && len(a.Values) > 0 {
+			if v, ok := a.Values[0].V.(goipp.Boolean); ok {
+				return bool(v)
+			}
+		}
+	}
+	return false
+}
+
+// attrStrings returns all values of the named attribute as strings.
+func attrStrings(attrs goipp.Attributes, name string) []string {
+	for _, a := range attrs {
+		if a.Name == name {
+			vals := make([]string, 0, len(a.Values))
+			for _, v := range a.Values {
+				vals = append(vals, v.V.String())
+			}
+			return vals
+		}
+	}
+	return nil
+}
@@ -146,6 +146,7 @@ func newSafeClient(timeout time.Duration) *http.Client {
 		ExpectContinueTimeout: 1 * time.Second,
 		MaxIdleConns:          10,
 		IdleConnTimeout:       30 * time.Second,
+		TLSClientConfig:       currentTLSConfig(), // nil 时用系统默认（见 ConfigureTLS）
 	}
 	return &http.Client{
 		Timeout:   timeout,
@@ -0,0 +1,176 @@
+package ipp
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+// subscriberName is sent as requesting-user-name on all subscription
+// operations. CUPS ties a subscription to its owner, so renewing, canceling and
+// pulling notifications must use the same name that created it.
+const subscriberName = "cups-web"
+
+// Event is one notification delivered by Get-Notifications (RFC 3996). Only
+// the fields relevant to the event kind are set.
+type Event struct {
+	SubscriptionID int    `json:"-"`
+	Sequence       int    `json:"-"`
+	Event          string `json:"event"` // notify-subscribed-event, e.g. "printer-stopped"
+	Text           string `json:"text,omitempty"`
+
+	PrinterName         string   `json:"printerName,omitempty"`
+	PrinterState        string   `json:"printerState,omitempty"`
+	PrinterStateReasons []string `json:"printerStateReasons,omitempty"`
+
+	JobID                int      `json:"jobId,omitempty"`
+	JobName              string   `json:"jobName,omitempty"`
+	JobState             string   `json:"jobState,omitempty"`
+	JobStateReasons      []string `json:"jobStateReasons,omitempty"`
+	ImpressionsCompleted int      `json:"impressionsCompleted,omitempty"`
+
+	Time time.Time `json:"time"` // when the event was pulled, not when CUPS raised it
+}
+
+// IsJobEvent reports whether the event is about a job rather than a printer.
+func (e *Event) IsJobEvent() bool {
+	return e.JobID > 0
+}
+
+// CreatePrinterSubscription subscribes to events of printerURI using the
+// ippget pull method. When printerURI is the server root ("http://host:631/")
+// CUPS delivers the events of every queue. lease <= 0 requests CUPS' default
+// lease; the subscription must be renewed before it expires.
+func CreatePrinterSubscription(printerURI string, events []string, lease time.Duration) (int, error) {
+	req := newRequest(goipp.OpCreatePrinterSubscriptions, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(subscriberName)))
+	addSubscriptionTemplate(req, events)
+	if lease > 0 {
+		req.Subscription.Add(goipp.MakeAttribute("notify-lease-duration", goipp.TagInteger, goipp.Integer(int(lease/time.Second))))
+	}
+	return createSubscription(printerURI, req)
+}
+
+// CreateJobSubscription subscribes to events of a single job. Job
+// subscriptions have no lease: CUPS drops them once the job is purged.
+func CreateJobSubscription(printerURI string, jobID int, events []string) (int, error) {
+	if jobID <= 0 {
+		return 0, fmt.Errorf("invalid job id %d", jobID)
+	}
+	req := newRequest(goipp.OpCreateJobSubscriptions, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(subscriberName)))
+	addSubscriptionTemplate(req, events)
+	req.Subscription.Add(goipp.MakeAttribute("notify-job-id", goipp.TagInteger, goipp.Integer(jobID)))
+	return createSubscription(printerURI, req)
+}
+
+func addSubscriptionTemplate(req *goipp.Message, events []string) {
+	req.Subscription.Add(goipp.MakeAttribute("notify-pull-method", goipp.TagKeyword, goipp.String("ippget")))
+	var vals goipp.Values
+	for _, ev := range events {
+		vals.Add(goipp.TagKeyword, goipp.String(ev))
+	}
+	req.Subscription.Add(goipp.Attribute{Name: "notify-events", Values: vals})
+}
+
+func createSubscription(printerURI string, req *goipp.Message) (int, error) {
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
+	if err != nil {
+		return 0, err
+	}
+	id := attrInt(rsp.Subscription, "notify-subscription-id")
+	if id == 0 {
+		// 模板被逐条拒绝时整体仍是 successful-ok-ignored-or-substituted，
+		// 真正的状态在 subscription 组的 notify-status-code 里。
+		return 0, fmt.Errorf("subscription rejected: %s", attrString(rsp.Subscription, "notify-status-code"))
+	}
+	log.Printf("[ipp] %s: uri=%q subscription=%d", goipp.Op(req.Code), printerURI, id)
+	return id, nil
+}
+
+// RenewSubscription extends the lease of a printer subscription.
+func RenewSubscription(printerURI string, subID int, lease time.Duration) error {
+	req := newRequest(goipp.OpRenewSubscription, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("notify-subscription-id", goipp.TagInteger, goipp.Integer(subID)))
+	req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(subscriberName)))
+	if lease > 0 {
+		req.Operation.Add(goipp.MakeAttribute("notify-lease-duration", goipp.TagInteger, goipp.Integer(int(lease/time.Second))))
+	}
+	_, err := roundTrip(printerURI, req, nil, dialTimeout)
+	return err
+}
+
+// CancelSubscription removes a subscription.
+func CancelSubscription(printerURI string, subID int) error {
+	req := newRequest(goipp.OpCancelSubscription, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("notify-subscription-id", goipp.TagInteger, goipp.Integer(subID)))
+	req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(subscriberName)))
+	_, err := roundTrip(printerURI, req, nil, dialTimeout)
+	return err
+}
+
+// GetNotifications pulls pending events. next maps each subscription id to the
+// lowest sequence number still wanted (1 for a fresh subscription). It returns
+// the events in delivery order and the poll interval suggested by the server
+// (notify-get-interval), or 0 when the server did not suggest one.
+//
+// CUPS fails the whole request with client-error-not-found when any of the
+// subscriptions is gone, so callers should probe ids one by one on IsNotFound.
+func GetNotifications(printerURI string, next map[int]int) ([]Event, time.Duration, error) {
+	if len(next) == 0 {
+		return nil, 0, nil
+	}
+	req := newRequest(goipp.OpGetNotifications, printerURI)
+	req.Operation.Add(goipp.MakeAttribute("requesting-user-name", goipp.TagName, goipp.String(subscriberName)))
+	var ids, seqs goipp.Values
+	for id, seq := range next {
+		ids.Add(goipp.TagInteger, goipp.Integer(id))
+		seqs.Add(goipp.TagInteger, goipp.Integer(seq))
+	}
+	req.Operation.Add(goipp.Attribute{Name: "notify-subscription-ids", Values: ids})
+	req.Operation.Add(goipp.Attribute{Name: "notify-sequence-numbers", Values: seqs})
+
+	rsp, err := roundTrip(printerURI, req, nil, dialTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	interval := time.Duration(attrInt(rsp.Operation, "notify-get-interval")) * time.Second
+	return parseEvents(rsp, time.Now()), interval, nil
+}
+
+// parseEvents converts the Event Notification groups of a Get-Notifications response.
+func parseEvents(rsp *goipp.Message, now time.Time) []Event {
+	var events []Event
+	for _, grp := range rsp.AttrGroups() {
+		if grp.Tag != goipp.TagEventNotificationGroup {
+			continue
+		}
+		ev := Event{
+			SubscriptionID:      attrInt(grp.Attrs, "notify-subscription-id"),
+			Sequence:            attrInt(grp.Attrs, "notify-sequence-number"),
+			Event:               attrString(grp.Attrs, "notify-subscribed-event"),
+			Text:                attrString(grp.Attrs, "notify-text"),
+			PrinterName:         attrString(grp.Attrs, "printer-name"),
+			PrinterStateReasons: attrStrings(grp.Attrs, "printer-state-reasons"),
+			JobID:               attrInt(grp.Attrs, "notify-job-id"),
+			JobName:             attrString(grp.Attrs, "job-name"),
+			JobStateReasons:     attrStrings(grp.Attrs, "job-state-reasons"),
+
+			ImpressionsCompleted: attrInt(grp.Attrs, "job-impressions-completed"),
+			Time:                 now,
+		}
+		if v := attrInt(grp.Attrs, "printer-state"); v > 0 {
+			ev.PrinterState = printerStateFromEnum(v)
+		}
+		if v := attrInt(grp.Attrs, "job-state"); v > 0 {
+			ev.JobState = jobStateFromEnum(v)
+		}
+		if ev.JobID == 0 {
+			ev.JobID = attrInt(grp.Attrs, "job-id")
+		}
+		events = append(events, ev)
+	}
+	return events
+}
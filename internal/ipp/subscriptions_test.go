@@ -0,0 +1,61 @@
+package ipp
+
+import (
+	"testing"
+	"time"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+// TestParseEvents 验证 Get-Notifications 响应中 Event Notification 组的解析：
+// 打印机事件与作业事件分别填充各自字段，非事件组被忽略。
+func TestParseEvents(t *testing.T) {
+	var op goipp.Attributes
+	op.Add(goipp.MakeAttribute("notify-get-interval", goipp.TagInteger, goipp.Integer(10)))
+
+	var printerEv goipp.Attributes
+	printerEv.Add(goipp.MakeAttribute("notify-subscription-id", goipp.TagInteger, goipp.Integer(3)))
+	printerEv.Add(goipp.MakeAttribute("notify-sequence-number", goipp.TagInteger, goipp.Integer(5)))
+	printerEv.Add(goipp.MakeAttribute("notify-subscribed-event", goipp.TagKeyword, goipp.String("printer-stopped")))
+	printerEv.Add(goipp.MakeAttribute("printer-name", goipp.TagName, goipp.String("Office_Laser")))
+	printerEv.Add(goipp.MakeAttribute("printer-state", goipp.TagEnum, goipp.Integer(5)))
+	reasons := goipp.MakeAttribute("printer-state-reasons", goipp.TagKeyword, goipp.String("media-empty-error"))
+	reasons.Values.Add(goipp.TagKeyword, goipp.String("paused"))
+	printerEv.Add(reasons)
+
+	var jobEv goipp.Attributes
+	jobEv.Add(goipp.MakeAttribute("notify-subscription-id", goipp.TagInteger, goipp.Integer(9)))
+	jobEv.Add(goipp.MakeAttribute("notify-sequence-number", goipp.TagInteger, goipp.Integer(2)))
+	jobEv.Add(goipp.MakeAttribute("notify-subscribed-event", goipp.TagKeyword, goipp.String("job-completed")))
+	jobEv.Add(goipp.MakeAttribute("notify-job-id", goipp.TagInteger, goipp.Integer(42)))
+	jobEv.Add(goipp.MakeAttribute("job-state", goipp.TagEnum, goipp.Integer(9)))
+	jobEv.Add(goipp.MakeAttribute("job-impressions-completed", goipp.TagInteger, goipp.Integer(4)))
+
+	msg := goipp.NewMessageWithGroups(goipp.DefaultVersion, goipp.Code(goipp.StatusOk), 1, goipp.Groups{
+		{Tag: goipp.TagOperationGroup, Attrs: op},
+		{Tag: goipp.TagEventNotificationGroup, Attrs: printerEv},
+		{Tag: goipp.TagEventNotificationGroup, Attrs: jobEv},
+	})
+
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	events := parseEvents(msg, now)
+	if len(events) != 2 {
+		t.Fatalf("len = %d, want 2", len(events))
+	}
+
+	p := events[0]
+	if p.IsJobEvent() || p.SubscriptionID != 3 || p.Sequence != 5 || p.Event != "printer-stopped" {
+		t.Errorf("printer event = %+v", p)
+	}
+	if p.PrinterName != "Office_Laser" || p.PrinterState != "stopped" || len(p.PrinterStateReasons) != 2 {
+		t.Errorf("printer fields = %+v", p)
+	}
+
+	j := events[1]
+	if !j.IsJobEvent() || j.JobID != 42 || j.JobState != JobStateCompleted || j.ImpressionsCompleted != 4 {
+		t.Errorf("job event = %+v", j)
+	}
+	if !j.Time.Equal(now) {
+		t.Errorf("Time = %v, want %v", j.Time, now)
+	}
+}
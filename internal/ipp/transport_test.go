@@ -0,0 +1,153 @@
+package ipp
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	goipp "github.com/OpenPrinting/goipp"
+)
+
+func TestTransportURL(t *testing.T) {
+	cases := map[string]string{
+		"ipp://cups.local/printers/hp":       "http://cups.local:631/printers/hp",
+		"ipps://cups.local/printers/hp":      "https://cups.local:631/printers/hp",
+		"ipps://cups.local:8443/printers/hp": "https://cups.local:8443/printers/hp",
+		"http://cups.local:631/printers/hp":  "http://cups.local:631/printers/hp",
+		"https://cups.local/printers/hp":     "https://cups.local/printers/hp",
+	}
+	for in, want := range cases {
+		got, err := transportURL(in)
+		if err != nil || got != want {
+			t.Errorf("transportURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestCUPSServer(t *testing.T) {
+	cases := []struct{ in, scheme, host string }{
+		{"localhost", "http", "localhost:631"},
+		{"cups.local:8631", "http", "cups.local:8631"},
+		{"https://cups.local", "https", "cups.local:631"},
+		{"ipps://cups.local:443", "https", "cups.local:443"},
+		{"ipp://cups.local", "http", "cups.local:631"},
+	}
+	for _, c := range cases {
+		scheme, host, err := cupsServer(c.in)
+		if err != nil || scheme != c.scheme || host != c.host {
+			t.Errorf("cupsServer(%q) = %q, %q, %v; want %q, %q", c.in, scheme, host, err, c.scheme, c.host)
+		}
+	}
+	if got := CUPSHostname("https://Cups.Local:8443"); got != "Cups.Local" {
+		t.Errorf("CUPSHostname = %q", got)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	ch := parseChallenge([]string{
+		`Basic realm="CUPS"`,
+		`Digest realm="CUPS", nonce="abc, def", qop="auth,auth-int", algorithm=MD5`,
+	})
+	if ch == nil || ch.scheme != "digest" {
+		t.Fatalf("want digest challenge, got %+v", ch)
+	}
+	if ch.params["realm"] != "CUPS" || ch.params["nonce"] != "abc, def" || ch.params["algorithm"] != "MD5" {
+		t.Errorf("params = %v", ch.params)
+	}
+	if !hasQopAuth(ch.params["qop"]) {
+		t.Errorf("qop %q should include auth", ch.params["qop"])
+	}
+	if ch := parseChallenge([]string{`Negotiate`}); ch != nil {
+		t.Errorf("unsupported scheme parsed as %+v", ch)
+	}
+}
+
+// TestDigestAuthorization 按 RFC 2617 的公式重算 response，qop=auth 与 CUPS
+// 使用的无 qop 模式各验证一次。
+func TestDigestAuthorization(t *testing.T) {
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	cred := Credentials{Username: "Mufasa", Password: "Circle Of Life"}
+	ha1 := md5hex("Mufasa:testrealm@host.com:Circle Of Life")
+	ha2 := md5hex("POST:/printers/hp")
+
+	ch := &challenge{scheme: "digest", params: map[string]string{"realm": "testrealm@host.com", "nonce": "n0nce"}}
+	got, err := ch.authorization(cred, http.MethodPost, "/printers/hp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(`response="%s"`, md5hex(ha1+":n0nce:"+ha2)); !strings.Contains(got, want) {
+		t.Errorf("authorization %q missing %s", got, want)
+	}
+
+	ch.params["qop"] = "auth"
+	got, err = ch.authorization(cred, http.MethodPost, "/printers/hp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := parseAuthParams(strings.TrimPrefix(got, "Digest "))
+	want := md5hex(ha1 + ":n0nce:" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	if params["nc"] != "00000001" || params["response"] != want {
+		t.Errorf("qop=auth params = %v, want response %s", params, want)
+	}
+
+	ch.params["algorithm"] = "SHA-512-256"
+	if _, err := ch.authorization(cred, http.MethodPost, "/"); err == nil {
+		t.Error("unsupported algorithm should fail")
+	}
+}
+
+// TestRoundTripBasicAuth 验证 401 后带凭据重试一次，且可回绕的文档会完整重发。
+func TestRoundTripBasicAuth(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "print" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="CUPS"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req goipp.Message
+		if err := req.Decode(r.Body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		doc, _ := io.ReadAll(r.Body)
+		if string(doc) != "%PDF-test" {
+			t.Errorf("document = %q", doc)
+		}
+		rsp := goipp.NewResponse(goipp.DefaultVersion, goipp.StatusOk, req.RequestID)
+		w.Header().Set("Content-Type", goipp.ContentType)
+		rsp.Encode(w)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	SetCredentials(u.Hostname(), Credentials{Username: "print", Password: "secret"})
+	defer SetCredentials(u.Hostname(), Credentials{})
+
+	req := newRequest(goipp.OpPrintJob, srv.URL+"/printers/hp")
+	if _, err := roundTrip(srv.URL+"/printers/hp", req, strings.NewReader("%PDF-test"), dialTimeout); err != nil {
+		t.Fatalf("roundTrip: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+
+	// 记住了挑战后，后续请求直接带上凭据。
+	attempts = 0
+	if _, err := roundTrip(srv.URL+"/printers/hp", req, strings.NewReader("%PDF-test"), dialTimeout); err != nil {
+		t.Fatalf("roundTrip: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts with cached challenge = %d, want 1", attempts)
+	}
+}
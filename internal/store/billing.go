@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// 余额流水的 kind 取值。amount_cents 带符号：充值与退款为正，扣费为负。
+const (
+	LedgerTopUp  = "topup"  // 管理员充值（或负数扣减）
+	LedgerCharge = "charge" // 打印提交前扣费
+	LedgerRefund = "refund" // 作业取消/失败退款，或定时打印改份数后退差价
+)
+
+// ErrInsufficientBalance 表示扣款后余额会变为负数，扣款未执行。
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// PrintPrice 是价目表的一条规则：每面（impression）单价，单位为分。
+// PaperSize / PaperType 为空表示任意尺寸/类型；彩色与双面必须精确匹配。
+type PrintPrice struct {
+	ID         int64
+	PaperSize  string
+	PaperType  string
+	IsColor    bool
+	IsDuplex   bool
+	PriceCents int64
+}
+
+type LedgerEntry struct {
+	ID           int64
+	UserID       int64
+	Kind         string
+	AmountCents  int64
+	BalanceAfter int64
+	PrintJobID   sql.NullInt64
+	OperatorID   sql.NullInt64
+	Note         string
+	CreatedAt    string
+}
+
+func ListPrintPrices(ctx context.Context, tx *sql.Tx) ([]PrintPrice, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, paper_size, paper_type, is_color, is_duplex, price_cents
+		FROM print_prices ORDER BY paper_size, paper_type, is_color, is_duplex`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []PrintPrice{}
+	for rows.Next() {
+		var p PrintPrice
+		if err := rows.Scan(&p.ID, &p.PaperSize, &p.PaperType, &p.IsColor, &p.IsDuplex, &p.PriceCents); err != nil {
+			return nil, err
+		}
+		prices = append(prices, p)
+	}
+	return prices, rows.Err()
+}
+
+// ReplacePrintPrices 用 prices 整体替换价目表。
+func ReplacePrintPrices(ctx context.Context, tx *sql.Tx, prices []PrintPrice) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM print_prices`); err != nil {
+		return err
+	}
+	for _, p := range prices {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO print_prices (
+			paper_size, paper_type, is_color, is_duplex, price_cents
+		) VALUES (?, ?, ?, ?, ?)`,
+			p.PaperSize, p.PaperType, p.IsColor, p.IsDuplex, p.PriceCents,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LookupPrintPrice 在 prices 中挑出最具体的匹配规则：指定了纸张尺寸的优先于
+// 任意尺寸，其次是指定了纸张类型的。没有匹配规则时返回 false。
+func LookupPrintPrice(prices []PrintPrice, paperSize, paperType string, isColor, isDuplex bool) (int64, bool) {
+	best, bestScore := int64(0), -1
+	for _, p := range prices {
+		if p.IsColor != isColor || p.IsDuplex != isDuplex {
+			continue
+		}
+		score := 0
+		switch p.PaperSize {
+		case "":
+		case paperSize:
+			score += 2
+		default:
+			continue
+		}
+		switch p.PaperType {
+		case "":
+		case paperType:
+			score++
+		default:
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = p.PriceCents, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
+
+// AdjustBalance 把 amount（分，可为负）记入用户余额并写一条流水，返回变动后的余额。
+// 余额会变为负数时不做任何修改并返回 ErrInsufficientBalance；用户不存在返回 sql.ErrNoRows。
+// printJobID / operatorID 为 0 表示无关联。
+func AdjustBalance(ctx context.Context, tx *sql.Tx, userID int64, amount int64, kind string, printJobID, operatorID int64, note string) (int64, error) {
+	var balance int64
+	err := tx.QueryRowContext(ctx, `UPDATE users SET balance_cents = balance_cents + ?
+		WHERE id = ? AND balance_cents + ? >= 0
+		RETURNING balance_cents`, amount, userID, amount).Scan(&balance)
+	if err == sql.ErrNoRows {
+		if _, getErr := GetUserByID(ctx, tx, userID); getErr != nil {
+			return 0, getErr
+		}
+		return 0, ErrInsufficientBalance
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO balance_ledger (
+		user_id, kind, amount_cents, balance_after, print_job_id, operator_id, note, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, kind, amount, balance, nullID(printJobID), nullID(operatorID), note, nowUTC(),
+	)
+	return balance, err
+}
+
+// printJobNetCharge 返回某条打印记录当前净扣费（扣费减去已退款），不会小于 0。
+func printJobNetCharge(ctx context.Context, tx *sql.Tx, printJobID int64) (userID int64, net int64, err error) {
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(user_id), 0), COALESCE(-SUM(amount_cents), 0)
+		FROM balance_ledger WHERE print_job_id = ? AND kind IN (?, ?)`,
+		printJobID, LedgerCharge, LedgerRefund).Scan(&userID, &net)
+	return userID, max(net, 0), err
+}
+
+// ChargePrint 为打印记录扣费并把金额写到记录的 cost_cents，余额不足返回 ErrInsufficientBalance。
+func ChargePrint(ctx context.Context, tx *sql.Tx, userID, printJobID, cost int64) error {
+	if cost <= 0 {
+		return nil
+	}
+	if _, err := AdjustBalance(ctx, tx, userID, -cost, LedgerCharge, printJobID, 0, ""); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE print_jobs SET cost_cents = ? WHERE id = ?`, cost, printJobID)
+	return err
+}
+
+// RechargePrint 把打印记录的净扣费调整到 cost：多退少补（定时打印改份数时用）。
+// 补扣时余额不足返回 ErrInsufficientBalance。
+func RechargePrint(ctx context.Context, tx *sql.Tx, userID, printJobID, cost int64) error {
+	_, net, err := printJobNetCharge(ctx, tx, printJobID)
+	if err != nil {
+		return err
+	}
+	switch delta := cost - net; {
+	case delta > 0:
+		_, err = AdjustBalance(ctx, tx, userID, -delta, LedgerCharge, printJobID, 0, "")
+	case delta < 0:
+		_, err = AdjustBalance(ctx, tx, userID, -delta, LedgerRefund, printJobID, 0, "")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE print_jobs SET cost_cents = ? WHERE id = ?`, cost, printJobID)
+	return err
+}
+
+// RefundPrintCharge 退还打印记录尚未退还的扣费，按流水轧差，重复调用不会多退。
+func RefundPrintCharge(ctx context.Context, tx *sql.Tx, printJobID int64) error {
+	userID, net, err := printJobNetCharge(ctx, tx, printJobID)
+	if err != nil || net == 0 {
+		return err
+	}
+	_, err = AdjustBalance(ctx, tx, userID, net, LedgerRefund, printJobID, 0, "")
+	return err
+}
+
+// refundIfTerminal 在记录进入 failed / cancelled 时自动退款并冲回已计入的配额用量。
+func refundIfTerminal(ctx context.Context, tx *sql.Tx, printJobID int64, status string) error {
+	if status != PrintStatusFailed && status != PrintStatusCancelled {
+		return nil
+	}
+	if err := RefundPrintCharge(ctx, tx, printJobID); err != nil {
+		return err
+	}
+	return setQuotaUsage(ctx, tx, printJobID, 0)
+}
+
+// ListLedger 返回用户最近的余额流水，新的在前；limit <= 0 表示不限制。
+func ListLedger(ctx context.Context, tx *sql.Tx, userID int64, limit int) ([]LedgerEntry, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_id, kind, amount_cents, balance_after,
+		print_job_id, operator_id, note, created_at
+		FROM balance_ledger WHERE user_id = ? ORDER BY id DESC LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Kind, &e.AmountCents, &e.BalanceAfter,
+			&e.PrintJobID, &e.OperatorID, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupPrintPrice(t *testing.T) {
+	prices := []PrintPrice{
+		{PaperSize: "", PaperType: "", IsColor: false, PriceCents: 10},
+		{PaperSize: "A3", PaperType: "", IsColor: false, PriceCents: 20},
+		{PaperSize: "", PaperType: "photo", IsColor: false, PriceCents: 50},
+		{PaperSize: "A3", PaperType: "photo", IsColor: false, PriceCents: 80},
+	}
+	tests := []struct {
+		size, typ string
+		color     bool
+		want      int64
+		ok        bool
+	}{
+		{"A4", "plain", false, 10, true},
+		{"A3", "plain", false, 20, true},
+		{"A4", "photo", false, 50, true},
+		{"A3", "photo", false, 80, true},
+		{"A4", "plain", true, 0, false}, // 没有彩色规则
+	}
+	for _, tt := range tests {
+		got, ok := LookupPrintPrice(prices, tt.size, tt.typ, tt.color, false)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("LookupPrintPrice(%s, %s, color=%v) = %d, %v; want %d, %v", tt.size, tt.typ, tt.color, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestChargeAndRefund(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+
+	var userID, jobID int64
+	balance := func() int64 {
+		t.Helper()
+		var u User
+		if err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+			var err error
+			u, err = GetUserByID(ctx, tx, userID)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return u.BalanceCents
+	}
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		u, err := CreateUser(ctx, tx, CreateUserInput{Username: "alice", PasswordHash: "x", Role: RoleUser})
+		if err != nil {
+			return err
+		}
+		userID = u.ID
+		if _, err := AdjustBalance(ctx, tx, userID, 100, LedgerTopUp, 0, 0, "init"); err != nil {
+			return err
+		}
+		jobID, err = InsertPrintRecord(ctx, tx, &PrintRecord{UserID: userID, Status: PrintStatusQueued, CreatedAt: nowUTC()})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 余额不足时不扣款、不记流水。
+	err = s.WithTx(ctx, false, func(tx *sql.Tx) error { return ChargePrint(ctx, tx, userID, jobID, 150) })
+	if !errors.Is(err, ErrInsufficientBalance) || balance() != 100 {
+		t.Fatalf("overdraft: err = %v, balance = %d", err, balance())
+	}
+
+	if err := s.WithTx(ctx, false, func(tx *sql.Tx) error { return ChargePrint(ctx, tx, userID, jobID, 60) }); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(); got != 40 {
+		t.Fatalf("after charge balance = %d, want 40", got)
+	}
+
+	// 定时打印改份数：少补多退。
+	for _, tc := range []struct{ cost, want int64 }{{90, 10}, {30, 70}} {
+		if err := s.WithTx(ctx, false, func(tx *sql.Tx) error { return RechargePrint(ctx, tx, userID, jobID, tc.cost) }); err != nil {
+			t.Fatal(err)
+		}
+		if got := balance(); got != tc.want {
+			t.Fatalf("recharge to %d: balance = %d, want %d", tc.cost, got, tc.want)
+		}
+	}
+
+	// 进入 failed/cancelled 时自动退款，重复的状态更新不会多退。
+	for range 2 {
+		err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+			if err := UpdatePrintStatus(ctx, tx, jobID, PrintStatusFailed, ""); err != nil {
+				return err
+			}
+			return UpdatePrintJobState(ctx, tx, jobID, JobStateUpdate{Status: PrintStatusCancelled})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := balance(); got != 100 {
+			t.Fatalf("after refund balance = %d, want 100", got)
+		}
+	}
+
+	var entries []LedgerEntry
+	if err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		entries, err = ListLedger(ctx, tx, userID, 0)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// topup, charge 60, charge 30, refund 60, refund 30
+	if len(entries) != 5 || entries[0].Kind != LedgerRefund || entries[0].BalanceAfter != 100 {
+		t.Errorf("ledger = %+v", entries)
+	}
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// PrintDocument 是多文档作业中的一个成员文档。打印记录本身的 filename /
+// stored_path 指向第一个文档，pages 为全部文档页数之和。
+type PrintDocument struct {
+	ID         int64
+	PrintJobID int64
+	Position   int // 从 0 开始，即提交顺序
+	Filename   string
+	StoredPath string
+	Pages      int
+}
+
+func InsertPrintDocuments(ctx context.Context, tx *sql.Tx, printJobID int64, docs []PrintDocument) error {
+	for i, doc := range docs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO print_job_documents (
+			print_job_id, position, filename, stored_path, pages
+		) VALUES (?, ?, ?, ?, ?)`, printJobID, i, doc.Filename, doc.StoredPath, doc.Pages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPrintDocuments 返回一条打印记录的成员文档，单文档记录返回空。
+func ListPrintDocuments(ctx context.Context, tx *sql.Tx, printJobID int64) ([]PrintDocument, error) {
+	docs, err := ListPrintDocumentsByJobs(ctx, tx, []int64{printJobID})
+	if err != nil {
+		return nil, err
+	}
+	return docs[printJobID], nil
+}
+
+// listDocumentsChunk 限制单条 IN 查询的参数个数，远低于 SQLite 的变量上限。
+const listDocumentsChunk = 500
+
+// ListPrintDocumentsByJobs 批量查询多条打印记录的成员文档，按记录 ID 分组。
+func ListPrintDocumentsByJobs(ctx context.Context, tx *sql.Tx, printJobIDs []int64) (map[int64][]PrintDocument, error) {
+	out := make(map[int64][]PrintDocument)
+	for len(printJobIDs) > 0 {
+		chunk := printJobIDs[:min(len(printJobIDs), listDocumentsChunk)]
+		printJobIDs = printJobIDs[len(chunk):]
+
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT id, print_job_id, position, filename, stored_path, pages
+			FROM print_job_documents
+			WHERE print_job_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",")+`)
+			ORDER BY print_job_id, position`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var doc PrintDocument
+			if err := rows.Scan(&doc.ID, &doc.PrintJobID, &doc.Position, &doc.Filename, &doc.StoredPath, &doc.Pages); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[doc.PrintJobID] = append(out[doc.PrintJobID], doc)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
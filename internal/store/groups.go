@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+)
+
+// UserGroup 是一个用户组（部门、班级）。配额、打印策略与打印机授权都可以以组为对象，
+// 对象值为组 ID。
+type UserGroup struct {
+	ID          int64
+	Name        string
+	Description string
+	MemberCount int
+	CreatedAt   string
+}
+
+// GroupMember 是组成员的简要信息。
+type GroupMember struct {
+	UserID   int64
+	Username string
+}
+
+// userGroupIDsSQL 是某用户所在全部组 ID（TEXT）的子查询，参数为用户 ID，
+// 供按组匹配配额、策略等规则时使用。
+const userGroupIDsSQL = `SELECT CAST(group_id AS TEXT) FROM user_group_members WHERE user_id = ?`
+
+func ListUserGroups(ctx context.Context, tx *sql.Tx) ([]UserGroup, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT g.id, g.name, g.description, g.created_at,
+		(SELECT COUNT(*) FROM user_group_members m WHERE m.group_id = g.id)
+		FROM user_groups g ORDER BY g.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []UserGroup{}
+	for rows.Next() {
+		var g UserGroup
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt, &g.MemberCount); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+func GetUserGroup(ctx context.Context, tx *sql.Tx, id int64) (UserGroup, error) {
+	var g UserGroup
+	err := tx.QueryRowContext(ctx, `SELECT g.id, g.name, g.description, g.created_at,
+		(SELECT COUNT(*) FROM user_group_members m WHERE m.group_id = g.id)
+		FROM user_groups g WHERE g.id = ?`, id).Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt, &g.MemberCount)
+	return g, err
+}
+
+func GetUserGroupByName(ctx context.Context, tx *sql.Tx, name string) (UserGroup, error) {
+	var id int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM user_groups WHERE name = ?`, name).Scan(&id); err != nil {
+		return UserGroup{}, err
+	}
+	return GetUserGroup(ctx, tx, id)
+}
+
+func CreateUserGroup(ctx context.Context, tx *sql.Tx, g *UserGroup) (int64, error) {
+	g.CreatedAt = nowUTC()
+	res, err := tx.ExecContext(ctx, `INSERT INTO user_groups (name, description, created_at) VALUES (?, ?, ?)`,
+		g.Name, g.Description, g.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	g.ID, err = res.LastInsertId()
+	return g.ID, err
+}
+
+// UpdateUserGroup 修改组名与说明，组不存在返回 sql.ErrNoRows。
+func UpdateUserGroup(ctx context.Context, tx *sql.Tx, g UserGroup) error {
+	res, err := tx.ExecContext(ctx, `UPDATE user_groups SET name = ?, description = ? WHERE id = ?`,
+		g.Name, g.Description, g.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserGroup 删除组及其成员关系，并删除以该组为对象的配额、策略与打印机授权，
+// 配额规则的删除照常记入调整流水（操作人为 operatorID）。组不存在返回 sql.ErrNoRows。
+func DeleteUserGroup(ctx context.Context, tx *sql.Tx, id int64, operatorID int64) error {
+	res, err := tx.ExecContext(ctx, `DELETE FROM user_groups WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	target := strconv.FormatInt(id, 10)
+	var quotaID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM print_quotas WHERE target_type = ? AND target = ?`,
+		QuotaTargetGroup, target).Scan(&quotaID)
+	switch {
+	case err == nil:
+		if err := DeletePrintQuota(ctx, tx, quotaID, operatorID, "删除用户组"); err != nil {
+			return err
+		}
+	case err != sql.ErrNoRows:
+		return err
+	}
+	for _, stmt := range []struct {
+		query string
+		kind  string
+	}{
+		{`DELETE FROM print_policies WHERE target_type = ? AND target = ?`, PolicyTargetGroup},
+		{`DELETE FROM printer_access WHERE subject_type = ? AND subject = ?`, PrinterAccessGroup},
+	} {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.kind, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ListGroupMembers(ctx context.Context, tx *sql.Tx, groupID int64) ([]GroupMember, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT u.id, u.username FROM user_group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = ? ORDER BY u.username`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []GroupMember{}
+	for rows.Next() {
+		var m GroupMember
+		if err := rows.Scan(&m.UserID, &m.Username); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// AddGroupMembers 把用户加入组，已是成员的忽略。
+func AddGroupMembers(ctx context.Context, tx *sql.Tx, groupID int64, userIDs []int64) error {
+	for _, id := range userIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO user_group_members (group_id, user_id) VALUES (?, ?)`,
+			groupID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetGroupMembers 把组成员整体替换为 userIDs。
+func SetGroupMembers(ctx context.Context, tx *sql.Tx, groupID int64, userIDs []int64) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_group_members WHERE group_id = ?`, groupID); err != nil {
+		return err
+	}
+	return AddGroupMembers(ctx, tx, groupID, userIDs)
+}
+
+// RemoveGroupMember 把用户移出组，不是成员时返回 sql.ErrNoRows。
+func RemoveGroupMember(ctx context.Context, tx *sql.Tx, groupID, userID int64) error {
+	res, err := tx.ExecContext(ctx, `DELETE FROM user_group_members WHERE group_id = ? AND user_id = ?`, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListUserGroupIDs 返回用户所在的全部组 ID。
+func ListUserGroupIDs(ctx context.Context, tx *sql.Tx, userID int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT group_id FROM user_group_members WHERE user_id = ? ORDER BY group_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListGroupMemberships 返回全部用户的组 ID，键为用户 ID，用于用户列表展示。
+func ListGroupMemberships(ctx context.Context, tx *sql.Tx) (map[int64][]int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT user_id, group_id FROM user_group_members ORDER BY user_id, group_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int64][]int64{}
+	for rows.Next() {
+		var userID, groupID int64
+		if err := rows.Scan(&userID, &groupID); err != nil {
+			return nil, err
+		}
+		out[userID] = append(out[userID], groupID)
+	}
+	return out, rows.Err()
+}
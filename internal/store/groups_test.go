@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestUserGroupMembership(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		alice, err := CreateUser(ctx, tx, CreateUserInput{Username: "alice", PasswordHash: "x", Role: RoleUser})
+		if err != nil {
+			return err
+		}
+		bob, err := CreateUser(ctx, tx, CreateUserInput{Username: "bob", PasswordHash: "x", Role: RoleUser})
+		if err != nil {
+			return err
+		}
+		g := UserGroup{Name: "三年二班"}
+		if _, err := CreateUserGroup(ctx, tx, &g); err != nil {
+			return err
+		}
+		if err := AddGroupMembers(ctx, tx, g.ID, []int64{alice.ID, bob.ID, alice.ID}); err != nil {
+			return err
+		}
+		if got, err := GetUserGroup(ctx, tx, g.ID); err != nil || got.MemberCount != 2 {
+			t.Errorf("member count = %d, %v, want 2", got.MemberCount, err)
+		}
+		if err := SetGroupMembers(ctx, tx, g.ID, []int64{bob.ID}); err != nil {
+			return err
+		}
+		members, err := ListGroupMembers(ctx, tx, g.ID)
+		if err != nil {
+			return err
+		}
+		if len(members) != 1 || members[0].Username != "bob" {
+			t.Errorf("members after replace = %+v, want [bob]", members)
+		}
+		if ids, err := ListUserGroupIDs(ctx, tx, bob.ID); err != nil || !slices.Equal(ids, []int64{g.ID}) {
+			t.Errorf("bob groups = %v, %v, want [%d]", ids, err, g.ID)
+		}
+		if err := RemoveGroupMember(ctx, tx, g.ID, alice.ID); err != sql.ErrNoRows {
+			t.Errorf("removing non-member = %v, want sql.ErrNoRows", err)
+		}
+
+		// 删除组时一并删除以该组为对象的规则。
+		target := strconv.FormatInt(g.ID, 10)
+		if err := SetPrintQuota(ctx, tx, PrintQuota{TargetType: QuotaTargetGroup, Target: target, ColorPages: 1, MonoPages: 1}, 0, ""); err != nil {
+			return err
+		}
+		if _, err := CreatePrintPolicy(ctx, tx, &PrintPolicy{Name: "g", TargetType: PolicyTargetGroup, Target: target, Action: PolicyActionOverride, ForceMono: true, Enabled: true}); err != nil {
+			return err
+		}
+		if err := DeleteUserGroup(ctx, tx, g.ID, 0); err != nil {
+			return err
+		}
+		if quotas, err := ListPrintQuotas(ctx, tx); err != nil || len(quotas) != 0 {
+			t.Errorf("quotas after group delete = %v, %v, want none", quotas, err)
+		}
+		if adj, err := ListQuotaAdjustments(ctx, tx, QuotaAdjustmentFilter{}); err != nil || len(adj) != 2 || adj[0].Kind != QuotaAdjustDelete {
+			t.Errorf("quota adjustments = %+v, %v, want set + delete", adj, err)
+		}
+		if policies, err := ListPrintPolicies(ctx, tx); err != nil || len(policies) != 0 {
+			t.Errorf("policies after group delete = %v, %v, want none", policies, err)
+		}
+		if ids, err := ListUserGroupIDs(ctx, tx, bob.ID); err != nil || len(ids) != 0 {
+			t.Errorf("bob groups after delete = %v, %v, want none", ids, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupQuotaAndPolicy(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		u, err := CreateUser(ctx, tx, CreateUserInput{Username: "carol", PasswordHash: "x", Role: RoleUser})
+		if err != nil {
+			return err
+		}
+		a, b := UserGroup{Name: "a"}, UserGroup{Name: "b"}
+		for _, g := range []*UserGroup{&a, &b} {
+			if _, err := CreateUserGroup(ctx, tx, g); err != nil {
+				return err
+			}
+			if err := AddGroupMembers(ctx, tx, g.ID, []int64{u.ID}); err != nil {
+				return err
+			}
+		}
+		for _, q := range []PrintQuota{
+			{TargetType: QuotaTargetRole, Target: RoleUser, ColorPages: 1, MonoPages: 1},
+			{TargetType: QuotaTargetGroup, Target: strconv.FormatInt(a.ID, 10), ColorPages: 5, MonoPages: 20},
+			{TargetType: QuotaTargetGroup, Target: strconv.FormatInt(b.ID, 10), ColorPages: 8, MonoPages: QuotaUnlimited},
+		} {
+			if err := SetPrintQuota(ctx, tx, q, 0, ""); err != nil {
+				return err
+			}
+		}
+		// 组规则优先于角色规则，多个组取最宽松的。
+		if q, err := resolvePrintQuota(ctx, tx, u); err != nil || q.ColorPages != 8 || q.MonoPages != QuotaUnlimited {
+			t.Errorf("group quota = %+v, %v, want color 8 / mono unlimited", q, err)
+		}
+		if err := SetPrintQuota(ctx, tx, PrintQuota{TargetType: QuotaTargetUser, Target: strconv.FormatInt(u.ID, 10), ColorPages: 2, MonoPages: 3}, 0, ""); err != nil {
+			return err
+		}
+		if q, err := resolvePrintQuota(ctx, tx, u); err != nil || q.ColorPages != 2 || q.MonoPages != 3 {
+			t.Errorf("user quota = %+v, %v, want color 2 / mono 3", q, err)
+		}
+
+		if _, err := CreatePrintPolicy(ctx, tx, &PrintPolicy{Name: "group-a", TargetType: PolicyTargetGroup, Target: strconv.FormatInt(a.ID, 10), Action: PolicyActionOverride, ForceDuplex: true, Enabled: true}); err != nil {
+			return err
+		}
+		if _, err := CreatePrintPolicy(ctx, tx, &PrintPolicy{Name: "other-group", TargetType: PolicyTargetGroup, Target: "999", Action: PolicyActionReject, MaxCopies: 1, Enabled: true}); err != nil {
+			return err
+		}
+		policies, err := MatchingPrintPolicies(ctx, tx, u.ID, u.Role, "ipp://cups/printers/a")
+		if err != nil {
+			return err
+		}
+		if len(policies) != 1 || policies[0].Name != "group-a" {
+			t.Errorf("matching policies = %+v, want [group-a]", policies)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// HeldFilter 筛选挂起中的安全打印记录，零值字段表示不限制。
+type HeldFilter struct {
+	UserID          int64
+	PrinterURI      string
+	SubmittedBefore string // RFC3339，用于维护任务挑出超时的挂起作业
+}
+
+// ListHeldPrintRecords 返回 status = held 的记录，按创建时间升序。
+func ListHeldPrintRecords(ctx context.Context, tx *sql.Tx, filter HeldFilter) ([]PrintRecord, error) {
+	conds := []string{"p.status = ?"}
+	args := []interface{}{PrintStatusHeld}
+	if filter.UserID > 0 {
+		conds = append(conds, "p.user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.PrinterURI != "" {
+		conds = append(conds, "p.printer_uri = ?")
+		args = append(args, filter.PrinterURI)
+	}
+	if filter.SubmittedBefore != "" {
+		// 升级前提交的记录没有 submitted_at，按创建时间算。
+		conds = append(conds, "COALESCE(NULLIF(p.submitted_at, ''), p.created_at) < ?")
+		args = append(args, filter.SubmittedBefore)
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT `+printRecordColumns+`
+		FROM print_jobs p
+		JOIN users u ON u.id = p.user_id
+		WHERE %s
+		ORDER BY p.created_at, p.id`, strings.Join(conds, " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []PrintRecord
+	for rows.Next() {
+		rec, err := scanPrintRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// MarkPrintReleased 把挂起记录推进到 submitted 并作废 PIN，交给作业状态跟踪器继续跟踪。
+func MarkPrintReleased(ctx context.Context, tx *sql.Tx, id int64, releasedAt string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE print_jobs
+		SET status = ?, released_at = ?, release_pin_hash = ''
+		WHERE id = ? AND status = ?`,
+		PrintStatusSubmitted, releasedAt, id, PrintStatusHeld)
+	return err
+}
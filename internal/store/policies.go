@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// 打印策略的目标类型。
+const (
+	PolicyTargetAll   = "all"   // 所有用户，Target 为空
+	PolicyTargetRole  = "role"  // Target 为角色名（admin / user）
+	PolicyTargetUser  = "user"  // Target 为用户 ID
+	PolicyTargetGroup = "group" // Target 为用户组 ID
+)
+
+// 违反策略时的处理方式。页数超限无法改写，两种方式下都拒绝。
+const (
+	PolicyActionOverride = "override" // 改写选项后继续打印
+	PolicyActionReject   = "reject"   // 直接拒绝
+)
+
+// PrintPolicy 是一条打印策略。MaxCopies、MaxPages 为 0 表示不限；PrinterURI 为空
+// 表示对所有打印机生效。
+type PrintPolicy struct {
+	ID               int64
+	Name             string
+	TargetType       string
+	Target           string
+	PrinterURI       string
+	Action           string
+	ForceDuplex      bool
+	ForceMono        bool
+	MaxCopies        int
+	MaxPages         int
+	RequireWatermark bool
+	Enabled          bool
+	CreatedAt        string
+}
+
+const printPolicyColumns = `id, name, target_type, target, printer_uri, action, force_duplex, force_mono,
+	max_copies, max_pages, require_watermark, enabled, created_at`
+
+func scanPrintPolicies(rows *sql.Rows) ([]PrintPolicy, error) {
+	defer rows.Close()
+	out := []PrintPolicy{}
+	for rows.Next() {
+		var p PrintPolicy
+		if err := rows.Scan(&p.ID, &p.Name, &p.TargetType, &p.Target, &p.PrinterURI, &p.Action,
+			&p.ForceDuplex, &p.ForceMono, &p.MaxCopies, &p.MaxPages, &p.RequireWatermark,
+			&p.Enabled, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func ListPrintPolicies(ctx context.Context, tx *sql.Tx) ([]PrintPolicy, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+printPolicyColumns+` FROM print_policies ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanPrintPolicies(rows)
+}
+
+// MatchingPrintPolicies 返回对某用户在某打印机上生效的已启用策略，按 id 升序。
+func MatchingPrintPolicies(ctx context.Context, tx *sql.Tx, userID int64, role, printerURI string) ([]PrintPolicy, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+printPolicyColumns+` FROM print_policies
+		WHERE enabled = 1
+			AND (printer_uri = '' OR printer_uri = ?)
+			AND (target_type = ?
+				OR (target_type = ? AND target = ?)
+				OR (target_type = ? AND target = CAST(? AS TEXT))
+				OR (target_type = ? AND target IN (`+userGroupIDsSQL+`)))
+		ORDER BY id`,
+		printerURI, PolicyTargetAll, PolicyTargetRole, role, PolicyTargetUser, userID, PolicyTargetGroup, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanPrintPolicies(rows)
+}
+
+func CreatePrintPolicy(ctx context.Context, tx *sql.Tx, p *PrintPolicy) (int64, error) {
+	p.CreatedAt = nowUTC()
+	res, err := tx.ExecContext(ctx, `INSERT INTO print_policies (
+		name, target_type, target, printer_uri, action, force_duplex, force_mono,
+		max_copies, max_pages, require_watermark, enabled, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Name, p.TargetType, p.Target, p.PrinterURI, p.Action, p.ForceDuplex, p.ForceMono,
+		p.MaxCopies, p.MaxPages, p.RequireWatermark, p.Enabled, p.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	p.ID, err = res.LastInsertId()
+	return p.ID, err
+}
+
+// UpdatePrintPolicy 覆盖策略的全部可编辑字段，策略不存在返回 sql.ErrNoRows。
+func UpdatePrintPolicy(ctx context.Context, tx *sql.Tx, p PrintPolicy) error {
+	res, err := tx.ExecContext(ctx, `UPDATE print_policies SET
+		name = ?, target_type = ?, target = ?, printer_uri = ?, action = ?, force_duplex = ?, force_mono = ?,
+		max_copies = ?, max_pages = ?, require_watermark = ?, enabled = ?
+		WHERE id = ?`,
+		p.Name, p.TargetType, p.Target, p.PrinterURI, p.Action, p.ForceDuplex, p.ForceMono,
+		p.MaxCopies, p.MaxPages, p.RequireWatermark, p.Enabled, p.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeletePrintPolicy 删除策略，不存在返回 sql.ErrNoRows。
+func DeletePrintPolicy(ctx context.Context, tx *sql.Tx, id int64) error {
+	res, err := tx.ExecContext(ctx, `DELETE FROM print_policies WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestMatchingPrintPolicies(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+
+	var names []string
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		u, err := CreateUser(ctx, tx, CreateUserInput{Username: "bob", PasswordHash: "x", Role: RoleUser})
+		if err != nil {
+			return err
+		}
+		for _, p := range []PrintPolicy{
+			{Name: "all", TargetType: PolicyTargetAll, Action: PolicyActionOverride, ForceDuplex: true, Enabled: true},
+			{Name: "role", TargetType: PolicyTargetRole, Target: RoleUser, Action: PolicyActionOverride, ForceMono: true, Enabled: true},
+			{Name: "admins", TargetType: PolicyTargetRole, Target: RoleAdmin, Action: PolicyActionReject, MaxCopies: 1, Enabled: true},
+			{Name: "user", TargetType: PolicyTargetUser, Target: strconv.FormatInt(u.ID, 10), Action: PolicyActionReject, MaxPages: 200, Enabled: true},
+			{Name: "other-printer", TargetType: PolicyTargetAll, PrinterURI: "ipp://cups/printers/b", Action: PolicyActionReject, MaxCopies: 2, Enabled: true},
+			{Name: "disabled", TargetType: PolicyTargetAll, Action: PolicyActionReject, MaxCopies: 1},
+		} {
+			if _, err := CreatePrintPolicy(ctx, tx, &p); err != nil {
+				return err
+			}
+		}
+		policies, err := MatchingPrintPolicies(ctx, tx, u.ID, u.Role, "ipp://cups/printers/a")
+		for _, p := range policies {
+			names = append(names, p.Name)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"all", "role", "user"}; !slices.Equal(names, want) {
+		t.Errorf("matching policies = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+)
+
+// 打印机授权对象类型。
+const (
+	PrinterAccessRole  = "role"  // Subject 为角色名（admin / user）
+	PrinterAccessUser  = "user"  // Subject 为用户 ID
+	PrinterAccessGroup = "group" // Subject 为用户组 ID
+)
+
+// PrinterAccess 是一条打印机授权：允许某角色、某用户组或某用户使用打印机。
+type PrinterAccess struct {
+	SubjectType string
+	Subject     string
+}
+
+// RegisteredPrinter 是管理员登记的打印机。Hidden 的打印机不出现在打印机列表里，
+// 但已授权的用户仍可按 URI 使用；未 Enabled 的打印机拒绝一切使用。
+type RegisteredPrinter struct {
+	ID          int64
+	URI         string
+	DisplayName string
+	Location    string
+	Description string
+	Hidden      bool
+	Enabled     bool
+	CreatedAt   string
+	Access      []PrinterAccess // 为空表示所有用户可用
+}
+
+// Allows 报告用户能否使用该打印机：管理员总是可以，其余用户需按角色、所在组
+// （groupIDs）或用户本身命中授权列表（列表为空时不限制）。不检查 Enabled。
+func (p RegisteredPrinter) Allows(userID int64, role string, groupIDs []int64) bool {
+	if role == RoleAdmin || len(p.Access) == 0 {
+		return true
+	}
+	id := strconv.FormatInt(userID, 10)
+	for _, a := range p.Access {
+		switch a.SubjectType {
+		case PrinterAccessRole:
+			if a.Subject == role {
+				return true
+			}
+		case PrinterAccessUser:
+			if a.Subject == id {
+				return true
+			}
+		case PrinterAccessGroup:
+			for _, g := range groupIDs {
+				if a.Subject == strconv.FormatInt(g, 10) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+const registeredPrinterColumns = `id, uri, display_name, location, description, hidden, enabled, created_at`
+
+func scanRegisteredPrinter(row interface{ Scan(...any) error }) (RegisteredPrinter, error) {
+	var p RegisteredPrinter
+	err := row.Scan(&p.ID, &p.URI, &p.DisplayName, &p.Location, &p.Description, &p.Hidden, &p.Enabled, &p.CreatedAt)
+	return p, err
+}
+
+// ListRegisteredPrinters 返回全部登记的打印机及其授权，按 id 升序。
+func ListRegisteredPrinters(ctx context.Context, tx *sql.Tx) ([]RegisteredPrinter, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+registeredPrinterColumns+` FROM printers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	printers := []RegisteredPrinter{}
+	index := map[int64]int{}
+	for rows.Next() {
+		p, err := scanRegisteredPrinter(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[p.ID] = len(printers)
+		printers = append(printers, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT printer_id, subject_type, subject FROM printer_access
+		ORDER BY printer_id, subject_type, subject`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var a PrinterAccess
+		if err := rows.Scan(&id, &a.SubjectType, &a.Subject); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			printers[i].Access = append(printers[i].Access, a)
+		}
+	}
+	return printers, rows.Err()
+}
+
+// GetRegisteredPrinterByURI 按 URI 查登记的打印机（含授权），未登记返回 sql.ErrNoRows。
+func GetRegisteredPrinterByURI(ctx context.Context, tx *sql.Tx, uri string) (RegisteredPrinter, error) {
+	p, err := scanRegisteredPrinter(tx.QueryRowContext(ctx,
+		`SELECT `+registeredPrinterColumns+` FROM printers WHERE uri = ?`, uri))
+	if err != nil {
+		return p, err
+	}
+	p.Access, err = listPrinterAccess(ctx, tx, p.ID)
+	return p, err
+}
+
+func listPrinterAccess(ctx context.Context, tx *sql.Tx, printerID int64) ([]PrinterAccess, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT subject_type, subject FROM printer_access
+		WHERE printer_id = ? ORDER BY subject_type, subject`, printerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []PrinterAccess
+	for rows.Next() {
+		var a PrinterAccess
+		if err := rows.Scan(&a.SubjectType, &a.Subject); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// CreateRegisteredPrinter 登记打印机并写入授权，URI 已登记时返回唯一约束错误。
+func CreateRegisteredPrinter(ctx context.Context, tx *sql.Tx, p *RegisteredPrinter) (int64, error) {
+	p.CreatedAt = nowUTC()
+	res, err := tx.ExecContext(ctx, `INSERT INTO printers (
+		uri, display_name, location, description, hidden, enabled, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.URI, p.DisplayName, p.Location, p.Description, p.Hidden, p.Enabled, p.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if p.ID, err = res.LastInsertId(); err != nil {
+		return 0, err
+	}
+	return p.ID, replacePrinterAccess(ctx, tx, p.ID, p.Access)
+}
+
+// UpdateRegisteredPrinter 覆盖打印机的展示信息、开关与授权（URI 不可改），
+// 不存在返回 sql.ErrNoRows。
+func UpdateRegisteredPrinter(ctx context.Context, tx *sql.Tx, p RegisteredPrinter) error {
+	res, err := tx.ExecContext(ctx, `UPDATE printers SET
+		display_name = ?, location = ?, description = ?, hidden = ?, enabled = ?
+		WHERE id = ?`,
+		p.DisplayName, p.Location, p.Description, p.Hidden, p.Enabled, p.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return replacePrinterAccess(ctx, tx, p.ID, p.Access)
+}
+
+func replacePrinterAccess(ctx context.Context, tx *sql.Tx, printerID int64, access []PrinterAccess) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM printer_access WHERE printer_id = ?`, printerID); err != nil {
+		return err
+	}
+	for _, a := range access {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO printer_access (printer_id, subject_type, subject)
+			VALUES (?, ?, ?)`, printerID, a.SubjectType, a.Subject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRegisteredPrinter 取消登记，授权随之删除；不存在返回 sql.ErrNoRows。
+func DeleteRegisteredPrinter(ctx context.Context, tx *sql.Tx, id int64) error {
+	res, err := tx.ExecContext(ctx, `DELETE FROM printers WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestRegisteredPrinters(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		p := RegisteredPrinter{
+			URI: "http://cups:631/printers/lab", DisplayName: "Lab", Enabled: true,
+			Access: []PrinterAccess{{SubjectType: PrinterAccessUser, Subject: "7"}, {SubjectType: PrinterAccessRole, Subject: RoleUser}},
+		}
+		if _, err := CreateRegisteredPrinter(ctx, tx, &p); err != nil {
+			return err
+		}
+		got, err := GetRegisteredPrinterByURI(ctx, tx, p.URI)
+		if err != nil {
+			return err
+		}
+		if got.DisplayName != "Lab" || len(got.Access) != 2 {
+			t.Errorf("got %+v", got)
+		}
+
+		// 更新时整体替换授权。
+		got.Access = []PrinterAccess{{SubjectType: PrinterAccessUser, Subject: "7"}}
+		got.Hidden = true
+		if err := UpdateRegisteredPrinter(ctx, tx, got); err != nil {
+			return err
+		}
+		list, err := ListRegisteredPrinters(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if len(list) != 1 || !list[0].Hidden || len(list[0].Access) != 1 {
+			t.Errorf("list = %+v", list)
+		}
+		if err := DeleteRegisteredPrinter(ctx, tx, got.ID); err != nil {
+			return err
+		}
+		if _, err := GetRegisteredPrinterByURI(ctx, tx, p.URI); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("after delete err = %v", err)
+		}
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM printer_access`).Scan(&n); err != nil {
+			return err
+		}
+		if n != 0 {
+			t.Errorf("printer_access rows left after delete: %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisteredPrinterAllows(t *testing.T) {
+	open := RegisteredPrinter{}
+	restricted := RegisteredPrinter{Access: []PrinterAccess{
+		{SubjectType: PrinterAccessUser, Subject: "7"},
+		{SubjectType: PrinterAccessGroup, Subject: "3"},
+	}}
+	tests := []struct {
+		p      RegisteredPrinter
+		id     int64
+		role   string
+		groups []int64
+		want   bool
+	}{
+		{open, 1, RoleUser, nil, true},
+		{restricted, 7, RoleUser, nil, true},
+		{restricted, 8, RoleUser, []int64{1, 2}, false},
+		{restricted, 8, RoleUser, []int64{1, 3}, true},
+		{restricted, 8, RoleAdmin, nil, true},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Allows(tt.id, tt.role, tt.groups); got != tt.want {
+			t.Errorf("Allows(%d, %s) on %+v = %v, want %v", tt.id, tt.role, tt.p.Access, got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,20 @@ import (
 	"strings"
 )
 
+// 打印记录的 status 取值。queued → submitted 由打印 handler 推进，
+// submitted → printed / failed / cancelled / unknown 由作业状态跟踪器按 CUPS
+// 回报的 job-state 推进（printed 仅在作业真正 completed 后才写入）。
+const (
+	PrintStatusQueued    = "queued"    // 记录已创建，尚未提交到 CUPS
+	PrintStatusSubmitted = "submitted" // 已提交到 CUPS，等待作业进入终态
+	PrintStatusPrinted   = "printed"   // job-state = completed
+	PrintStatusFailed    = "failed"    // 提交失败或 job-state = aborted
+	PrintStatusCancelled = "cancelled" // job-state = canceled
+	PrintStatusUnknown   = "unknown"   // CUPS 已查不到该作业（历史被清理）
+	PrintStatusHeld      = "held"      // 安全打印：已提交但挂起（job-hold-until=indefinite），等待释放
+	PrintStatusScheduled = "scheduled" // 定时打印：尚未提交，等调度器到点提交（print_schedules）
+)
+
 type PrintRecord struct {
 	ID         int64
 	UserID     int64
@@ -20,6 +34,12 @@ type PrintRecord struct {
 	IsDuplex   bool
 	IsColor    bool
 
+	// 安全打印（挂起释放）：HoldRelease 标记以 job-hold-until=indefinite 提交，
+	// 释放后 status 回到 submitted，ReleasedAt 记录释放时间。PIN 只存哈希且一次性。
+	HoldRelease    bool
+	ReleasePINHash string
+	ReleasedAt     string
+
 	// 完整打印参数快照，供「重新打印」精确预填（Issue #68）。
 	Copies         int
 	Orientation    string
@@ -34,6 +54,23 @@ type PrintRecord struct {
 	NumberUp       int
 	NumberUpLayout string
 	PageBorder     string
+	Finishings     string // 逗号分隔的 finishings 关键字
+	OutputBin      string
+	JobSheets      string
+	Quality        string // print-quality：draft / normal / high，空为打印机默认
+	Resolution     string // printer-resolution，如 600dpi
+	ScheduledAt    string // 定时打印的预定时间（RFC3339），非定时为空
+
+	// CUPS 作业实时状态，由作业状态跟踪器通过 Get-Job-Attributes 回填。
+	JobState             string
+	JobStateReasons      string // 逗号分隔的 job-state-reasons
+	ImpressionsCompleted int
+	CompletedAt          string
+
+	// 计费：提交前实扣的金额（分），作业取消/失败退款后保留原值。
+	CostCents int64
+	// 计费面数：⌈页数 ÷ N-up⌉ × 份数（已扣除页码范围与奇偶页筛选），用于统计配额。
+	Impressions int
 
 	CreatedAt string
 }
@@ -44,7 +81,10 @@ const printRecordColumns = `p.id, p.user_id, u.username, p.printer_uri, p.filena
 	p.job_id, p.status, p.is_duplex, p.is_color,
 	p.copies, p.orientation, p.paper_size, p.paper_type, p.media_source, p.print_scaling,
 	p.page_range, p.page_set, p.mirror, p.watermark_text, p.number_up, p.number_up_layout, p.page_border,
-	p.created_at`
+	p.finishings, p.output_bin, p.job_sheets, p.quality, p.resolution, p.scheduled_at,
+	p.job_state, p.job_state_reasons, p.impressions_completed, p.completed_at,
+	p.hold_release, p.release_pin_hash, p.released_at,
+	p.cost_cents, p.impressions, p.created_at`
 
 // scanPrintRecord 与 printRecordColumns 的列顺序严格对应。
 // 复用 users.go 中定义的 scanner 接口（*sql.Row / *sql.Rows 通用）。
@@ -55,13 +95,17 @@ func scanPrintRecord(s scanner) (PrintRecord, error) {
 		&rec.Pages, &rec.JobID, &rec.Status, &rec.IsDuplex, &rec.IsColor,
 		&rec.Copies, &rec.Orientation, &rec.PaperSize, &rec.PaperType, &rec.MediaSource, &rec.PrintScaling,
 		&rec.PageRange, &rec.PageSet, &rec.Mirror, &rec.WatermarkText, &rec.NumberUp, &rec.NumberUpLayout, &rec.PageBorder,
-		&rec.CreatedAt,
+		&rec.Finishings, &rec.OutputBin, &rec.JobSheets, &rec.Quality, &rec.Resolution, &rec.ScheduledAt,
+		&rec.JobState, &rec.JobStateReasons, &rec.ImpressionsCompleted, &rec.CompletedAt,
+		&rec.HoldRelease, &rec.ReleasePINHash, &rec.ReleasedAt,
+		&rec.CostCents, &rec.Impressions, &rec.CreatedAt,
 	)
 	return rec, err
 }
 
 type PrintFilter struct {
 	Username string
+	GroupID  int64 // 只看该用户组成员的记录
 	StartAt  string
 	EndAt    string
 	Limit    int
@@ -73,23 +117,114 @@ func InsertPrintRecord(ctx context.Context, tx *sql.Tx, rec *PrintRecord) (int64
 		job_id, status, is_duplex, is_color,
 		copies, orientation, paper_size, paper_type, media_source, print_scaling,
 		page_range, page_set, mirror, watermark_text, number_up, number_up_layout, page_border,
-		created_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		finishings, output_bin, job_sheets, quality, resolution, scheduled_at,
+		hold_release, release_pin_hash,
+		cost_cents, impressions, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		rec.UserID, rec.PrinterURI, rec.Filename, rec.StoredPath, rec.Pages,
 		rec.JobID, rec.Status, rec.IsDuplex, rec.IsColor,
 		rec.Copies, rec.Orientation, rec.PaperSize, rec.PaperType, rec.MediaSource, rec.PrintScaling,
 		rec.PageRange, rec.PageSet, rec.Mirror, rec.WatermarkText, rec.NumberUp, rec.NumberUpLayout, rec.PageBorder,
-		rec.CreatedAt,
+		rec.Finishings, rec.OutputBin, rec.JobSheets, rec.Quality, rec.Resolution, rec.ScheduledAt,
+		rec.HoldRelease, rec.ReleasePINHash,
+		rec.CostCents, rec.Impressions, rec.CreatedAt,
 	)
 	if err != nil {
 		return 0, err
 	}
-	return res.LastInsertId()
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	// 计费面数同时记入配额用量流水，记录日后被清理也不会退回已用配额。
+	if rec.Impressions > 0 && rec.Status != PrintStatusFailed && rec.Status != PrintStatusCancelled {
+		if err := setQuotaUsage(ctx, tx, id, int64(rec.Impressions)); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
 }
 
+// UpdatePrintStatus 更新记录状态；进入 submitted / held 时记下提交时间，
+// 进入 failed / cancelled 时自动退还该作业的扣费。
 func UpdatePrintStatus(ctx context.Context, tx *sql.Tx, id int64, status string, jobID string) error {
-	_, err := tx.ExecContext(ctx, "UPDATE print_jobs SET status = ?, job_id = ? WHERE id = ?", status, jobID, id)
-	return err
+	if _, err := tx.ExecContext(ctx, `UPDATE print_jobs SET status = ?, job_id = ?,
+		submitted_at = CASE WHEN ? IN (?, ?) THEN ? ELSE submitted_at END
+		WHERE id = ?`, status, jobID, status, PrintStatusSubmitted, PrintStatusHeld, nowUTC(), id); err != nil {
+		return err
+	}
+	return refundIfTerminal(ctx, tx, id, status)
+}
+
+// JobStateUpdate 是作业状态跟踪器一次观测的结果。Status 为空时保留原 status。
+type JobStateUpdate struct {
+	Status               string
+	JobState             string
+	JobStateReasons      string
+	ImpressionsCompleted int
+	CompletedAt          string
+}
+
+func UpdatePrintJobState(ctx context.Context, tx *sql.Tx, id int64, upd JobStateUpdate) error {
+	_, err := tx.ExecContext(ctx, `UPDATE print_jobs SET
+		status = COALESCE(NULLIF(?, ''), status),
+		job_state = ?, job_state_reasons = ?, impressions_completed = ?, completed_at = ?
+		WHERE id = ?`,
+		upd.Status, upd.JobState, upd.JobStateReasons, upd.ImpressionsCompleted, upd.CompletedAt, id,
+	)
+	if err != nil {
+		return err
+	}
+	return refundIfTerminal(ctx, tx, id, upd.Status)
+}
+
+// ListSubmittedPrintRecords 返回已提交到 CUPS、尚未进入终态的记录（submitted 与
+// 挂起中的 held），供作业状态跟踪器轮询。
+// since 非空时只看该时间之后创建的记录，避免对 CUPS 早已遗忘的远古作业无限轮询。
+func ListSubmittedPrintRecords(ctx context.Context, tx *sql.Tx, since string) ([]PrintRecord, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+printRecordColumns+`
+		FROM print_jobs p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.status IN (?, ?) AND p.job_id IS NOT NULL AND p.job_id != '' AND p.created_at >= ?
+		ORDER BY p.id`, PrintStatusSubmitted, PrintStatusHeld, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []PrintRecord
+	for rows.Next() {
+		rec, err := scanPrintRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// ListPrintRecordsByPrinter 返回提交到 printerURI 且带 job_id 的记录，供队列视图
+// 把 CUPS 作业与本地记录对应起来。since 用于限定时间窗口。
+func ListPrintRecordsByPrinter(ctx context.Context, tx *sql.Tx, printerURI string, since string) ([]PrintRecord, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+printRecordColumns+`
+		FROM print_jobs p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.printer_uri = ? AND p.job_id IS NOT NULL AND p.job_id != '' AND p.created_at >= ?
+		ORDER BY p.id`, printerURI, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []PrintRecord
+	for rows.Next() {
+		rec, err := scanPrintRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
 }
 
 func GetPrintRecordByID(ctx context.Context, tx *sql.Tx, id int64) (PrintRecord, error) {
@@ -107,6 +242,10 @@ func ListPrintRecords(ctx context.Context, tx *sql.Tx, filter PrintFilter) ([]Pr
 		conds = append(conds, "u.username = ?")
 		args = append(args, filter.Username)
 	}
+	if filter.GroupID > 0 {
+		conds = append(conds, "p.user_id IN (SELECT user_id FROM user_group_members WHERE group_id = ?)")
+		args = append(args, filter.GroupID)
+	}
 	if filter.StartAt != "" {
 		conds = append(conds, "p.created_at >= ?")
 		args = append(args, filter.StartAt)
@@ -139,4 +278,4 @@ func ListPrintRecords(ctx context.Context, tx *sql.Tx, filter PrintFilter) ([]Pr
 		records = append(records, rec)
 	}
 	return records, rows.Err()
-}
\ No newline at end of file
+}
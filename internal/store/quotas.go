@@ -0,0 +1,300 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// 配额周期（settings.quota_period）。空字符串表示不启用配额。
+const (
+	QuotaPeriodMonth = "month"
+	QuotaPeriodWeek  = "week"
+)
+
+// 配额规则的目标类型。同一用户命中多条规则时，用户规则优先，其次组规则，最后角色规则。
+const (
+	QuotaTargetRole  = "role"  // Target 为角色名（admin / user）
+	QuotaTargetUser  = "user"  // Target 为用户 ID
+	QuotaTargetGroup = "group" // Target 为用户组 ID
+)
+
+// 配额调整流水的 kind 取值。
+const (
+	QuotaAdjustGrant  = "grant"  // 给某用户本周期一次性追加页数
+	QuotaAdjustSet    = "set"    // 新增或修改配额规则
+	QuotaAdjustDelete = "delete" // 删除配额规则
+)
+
+// QuotaUnlimited 表示该颜色不限页数。
+const QuotaUnlimited = -1
+
+// PrintQuota 是一条配额规则：每个周期彩色、黑白各自可打印的面数。
+type PrintQuota struct {
+	ID         int64
+	TargetType string
+	Target     string
+	ColorPages int64
+	MonoPages  int64
+}
+
+type QuotaAdjustment struct {
+	ID          int64
+	Kind        string
+	UserID      sql.NullInt64
+	TargetType  string
+	Target      string
+	ColorPages  int64
+	MonoPages   int64
+	PeriodStart string
+	OperatorID  sql.NullInt64
+	Note        string
+	CreatedAt   string
+}
+
+// QuotaStatus 是某用户当前周期的配额使用情况，Limit 为 QuotaUnlimited 时不限。
+type QuotaStatus struct {
+	Period       string
+	PeriodStart  string
+	ColorLimit   int64
+	MonoLimit    int64
+	ColorGranted int64
+	MonoGranted  int64
+	ColorUsed    int64
+	MonoUsed     int64
+}
+
+// Remaining 返回彩色或黑白剩余页数，不限时返回 QuotaUnlimited。
+func (q QuotaStatus) Remaining(color bool) int64 {
+	limit, granted, used := q.MonoLimit, q.MonoGranted, q.MonoUsed
+	if color {
+		limit, granted, used = q.ColorLimit, q.ColorGranted, q.ColorUsed
+	}
+	if limit == QuotaUnlimited {
+		return QuotaUnlimited
+	}
+	return max(limit+granted-used, 0)
+}
+
+// QuotaPeriodStart 返回 now 所在周期的起点（本地时间的月初或周一零点），格式同 created_at。
+func QuotaPeriodStart(period string, now time.Time) string {
+	now = now.Local()
+	var start time.Time
+	switch period {
+	case QuotaPeriodWeek:
+		offset := (int(now.Weekday()) + 6) % 7 // 周一为 0
+		start = time.Date(now.Year(), now.Month(), now.Day()-offset, 0, 0, 0, 0, now.Location())
+	default:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	return start.UTC().Format(time.RFC3339)
+}
+
+func ListPrintQuotas(ctx context.Context, tx *sql.Tx) ([]PrintQuota, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, target_type, target, color_pages, mono_pages
+		FROM print_quotas ORDER BY target_type, target`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotas := []PrintQuota{}
+	for rows.Next() {
+		var q PrintQuota
+		if err := rows.Scan(&q.ID, &q.TargetType, &q.Target, &q.ColorPages, &q.MonoPages); err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, rows.Err()
+}
+
+// SetPrintQuota 新增或覆盖 (TargetType, Target) 的配额规则并记一条调整流水。
+func SetPrintQuota(ctx context.Context, tx *sql.Tx, q PrintQuota, operatorID int64, note string) error {
+	if _, err := tx.ExecContext(ctx, `INSERT INTO print_quotas (target_type, target, color_pages, mono_pages)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(target_type, target) DO UPDATE SET color_pages = excluded.color_pages, mono_pages = excluded.mono_pages`,
+		q.TargetType, q.Target, q.ColorPages, q.MonoPages,
+	); err != nil {
+		return err
+	}
+	return insertQuotaAdjustment(ctx, tx, QuotaAdjustment{
+		Kind: QuotaAdjustSet, TargetType: q.TargetType, Target: q.Target,
+		ColorPages: q.ColorPages, MonoPages: q.MonoPages,
+		OperatorID: nullID(operatorID), Note: note,
+	})
+}
+
+// DeletePrintQuota 删除配额规则并记一条调整流水，规则不存在返回 sql.ErrNoRows。
+func DeletePrintQuota(ctx context.Context, tx *sql.Tx, id int64, operatorID int64, note string) error {
+	var q PrintQuota
+	err := tx.QueryRowContext(ctx, `DELETE FROM print_quotas WHERE id = ?
+		RETURNING target_type, target, color_pages, mono_pages`, id).Scan(&q.TargetType, &q.Target, &q.ColorPages, &q.MonoPages)
+	if err != nil {
+		return err
+	}
+	return insertQuotaAdjustment(ctx, tx, QuotaAdjustment{
+		Kind: QuotaAdjustDelete, TargetType: q.TargetType, Target: q.Target,
+		ColorPages: q.ColorPages, MonoPages: q.MonoPages,
+		OperatorID: nullID(operatorID), Note: note,
+	})
+}
+
+// GrantQuota 给用户在 periodStart 开始的周期内追加页数（可为负）并记流水。
+func GrantQuota(ctx context.Context, tx *sql.Tx, userID int64, periodStart string, colorPages, monoPages int64, operatorID int64, note string) error {
+	return insertQuotaAdjustment(ctx, tx, QuotaAdjustment{
+		Kind: QuotaAdjustGrant, UserID: nullID(userID),
+		ColorPages: colorPages, MonoPages: monoPages, PeriodStart: periodStart,
+		OperatorID: nullID(operatorID), Note: note,
+	})
+}
+
+func insertQuotaAdjustment(ctx context.Context, tx *sql.Tx, a QuotaAdjustment) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO quota_adjustments (
+		kind, user_id, target_type, target, color_pages, mono_pages, period_start, operator_id, note, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.Kind, a.UserID, a.TargetType, a.Target, a.ColorPages, a.MonoPages, a.PeriodStart, a.OperatorID, a.Note, nowUTC(),
+	)
+	return err
+}
+
+// QuotaAdjustmentFilter 筛选配额调整流水，零值字段表示不限制。
+type QuotaAdjustmentFilter struct {
+	UserID int64
+	Limit  int
+}
+
+// ListQuotaAdjustments 返回配额调整流水，新的在前。按用户筛选时包含该用户的追加与
+// 针对该用户的规则变更。
+func ListQuotaAdjustments(ctx context.Context, tx *sql.Tx, filter QuotaAdjustmentFilter) ([]QuotaAdjustment, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	query := `SELECT id, kind, user_id, target_type, target, color_pages, mono_pages, period_start, operator_id, note, created_at
+		FROM quota_adjustments`
+	args := []interface{}{}
+	if filter.UserID > 0 {
+		query += ` WHERE user_id = ? OR (target_type = ? AND target = CAST(? AS TEXT))`
+		args = append(args, filter.UserID, QuotaTargetUser, filter.UserID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []QuotaAdjustment{}
+	for rows.Next() {
+		var a QuotaAdjustment
+		if err := rows.Scan(&a.ID, &a.Kind, &a.UserID, &a.TargetType, &a.Target, &a.ColorPages, &a.MonoPages,
+			&a.PeriodStart, &a.OperatorID, &a.Note, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// resolvePrintQuota 找出对用户生效的配额：用户规则优先，其次组规则，最后角色规则。
+// 用户在多个组且各组都有规则时，彩色、黑白各取最宽松的一条。都没有时返回不限。
+func resolvePrintQuota(ctx context.Context, tx *sql.Tx, user User) (PrintQuota, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, target_type, target, color_pages, mono_pages
+		FROM print_quotas
+		WHERE (target_type = ? AND target = CAST(? AS TEXT))
+			OR (target_type = ? AND target IN (`+userGroupIDsSQL+`))
+			OR (target_type = ? AND target = ?)`,
+		QuotaTargetUser, user.ID, QuotaTargetGroup, user.ID, QuotaTargetRole, user.Role,
+	)
+	if err != nil {
+		return PrintQuota{}, err
+	}
+	defer rows.Close()
+
+	byType := map[string][]PrintQuota{}
+	for rows.Next() {
+		var q PrintQuota
+		if err := rows.Scan(&q.ID, &q.TargetType, &q.Target, &q.ColorPages, &q.MonoPages); err != nil {
+			return PrintQuota{}, err
+		}
+		byType[q.TargetType] = append(byType[q.TargetType], q)
+	}
+	if err := rows.Err(); err != nil {
+		return PrintQuota{}, err
+	}
+	for _, typ := range []string{QuotaTargetUser, QuotaTargetGroup, QuotaTargetRole} {
+		quotas := byType[typ]
+		if len(quotas) == 0 {
+			continue
+		}
+		q := quotas[0]
+		for _, other := range quotas[1:] {
+			q.ColorPages = looserQuota(q.ColorPages, other.ColorPages)
+			q.MonoPages = looserQuota(q.MonoPages, other.MonoPages)
+		}
+		return q, nil
+	}
+	return PrintQuota{ColorPages: QuotaUnlimited, MonoPages: QuotaUnlimited}, nil
+}
+
+// looserQuota 返回两个页数上限中更宽松的一个，QuotaUnlimited 最宽松。
+func looserQuota(a, b int64) int64 {
+	if a == QuotaUnlimited || b == QuotaUnlimited {
+		return QuotaUnlimited
+	}
+	return max(a, b)
+}
+
+// setQuotaUsage 把打印记录计入配额的面数调整到 impressions：按用量流水轧差补记
+// 差额，重复调用不会多记。差额记在记录创建时所在的周期。
+func setQuotaUsage(ctx context.Context, tx *sql.Tx, printJobID int64, impressions int64) error {
+	var (
+		userID  int64
+		isColor bool
+		usedAt  string
+		net     int64
+	)
+	err := tx.QueryRowContext(ctx, `SELECT p.user_id, p.is_color, p.created_at,
+		COALESCE((SELECT SUM(u.impressions) FROM quota_usage u WHERE u.print_job_id = p.id), 0)
+		FROM print_jobs p WHERE p.id = ?`, printJobID).Scan(&userID, &isColor, &usedAt, &net)
+	if err != nil {
+		return err
+	}
+	if impressions == net {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO quota_usage (
+		user_id, print_job_id, is_color, impressions, used_at, created_at
+	) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, printJobID, isColor, impressions-net, usedAt, nowUTC(),
+	)
+	return err
+}
+
+// GetQuotaStatus 汇总用户在 now 所在周期的配额、追加与已用页数。
+// 已用页数按用量流水统计，失败与取消的作业已冲回；打印记录被清理后用量仍在。
+// excludeID 非 0 时不统计该记录（检查刚插入的记录本身时用）。
+func GetQuotaStatus(ctx context.Context, tx *sql.Tx, user User, period string, now time.Time, excludeID int64) (QuotaStatus, error) {
+	st := QuotaStatus{Period: period, PeriodStart: QuotaPeriodStart(period, now)}
+	q, err := resolvePrintQuota(ctx, tx, user)
+	if err != nil {
+		return st, err
+	}
+	st.ColorLimit, st.MonoLimit = q.ColorPages, q.MonoPages
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(color_pages), 0), COALESCE(SUM(mono_pages), 0)
+		FROM quota_adjustments WHERE kind = ? AND user_id = ? AND period_start = ?`,
+		QuotaAdjustGrant, user.ID, st.PeriodStart,
+	).Scan(&st.ColorGranted, &st.MonoGranted); err != nil {
+		return st, err
+	}
+	err = tx.QueryRowContext(ctx, `SELECT
+		COALESCE(SUM(CASE WHEN is_color THEN impressions ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN is_color THEN 0 ELSE impressions END), 0)
+		FROM quota_usage
+		WHERE user_id = ? AND used_at >= ? AND print_job_id != ?`,
+		user.ID, st.PeriodStart, excludeID,
+	).Scan(&st.ColorUsed, &st.MonoUsed)
+	return st, err
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQuotaPeriodStart(t *testing.T) {
+	// 2026-03-12 是周四。
+	now := time.Date(2026, 3, 12, 15, 4, 5, 0, time.Local)
+	if got, want := QuotaPeriodStart(QuotaPeriodMonth, now), time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local).UTC().Format(time.RFC3339); got != want {
+		t.Errorf("month start = %s, want %s", got, want)
+	}
+	if got, want := QuotaPeriodStart(QuotaPeriodWeek, now), time.Date(2026, 3, 9, 0, 0, 0, 0, time.Local).UTC().Format(time.RFC3339); got != want {
+		t.Errorf("week start = %s, want %s", got, want)
+	}
+}
+
+func TestQuotaStatus(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	now := time.Now()
+
+	var user User
+	status := func() QuotaStatus {
+		t.Helper()
+		var st QuotaStatus
+		if err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+			var err error
+			st, err = GetQuotaStatus(ctx, tx, user, QuotaPeriodMonth, now, 0)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	err := s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		var err error
+		if user, err = CreateUser(ctx, tx, CreateUserInput{Username: "bob", PasswordHash: "x", Role: RoleUser}); err != nil {
+			return err
+		}
+		for _, rec := range []PrintRecord{
+			{IsColor: true, Impressions: 3, Status: PrintStatusPrinted},
+			{IsColor: false, Impressions: 5, Status: PrintStatusSubmitted},
+			{IsColor: false, Impressions: 7, Status: PrintStatusCancelled}, // 取消的不计
+			{IsColor: false, Impressions: 11, Status: PrintStatusPrinted, CreatedAt: "2000-01-01T00:00:00Z"},
+		} {
+			rec.UserID = user.ID
+			if rec.CreatedAt == "" {
+				rec.CreatedAt = nowUTC()
+			}
+			if _, err := InsertPrintRecord(ctx, tx, &rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st := status(); st.Remaining(true) != QuotaUnlimited || st.Remaining(false) != QuotaUnlimited {
+		t.Fatalf("no rules should mean unlimited, got %+v", st)
+	}
+
+	// 角色默认值，再被用户规则覆盖。
+	err = s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		if err := SetPrintQuota(ctx, tx, PrintQuota{TargetType: QuotaTargetRole, Target: RoleUser, ColorPages: 10, MonoPages: 100}, 0, ""); err != nil {
+			return err
+		}
+		return GrantQuota(ctx, tx, user.ID, QuotaPeriodStart(QuotaPeriodMonth, now), 5, 0, 0, "exam week")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status(); st.ColorUsed != 3 || st.MonoUsed != 5 || st.Remaining(true) != 12 || st.Remaining(false) != 95 {
+		t.Fatalf("role quota status = %+v", st)
+	}
+	err = s.WithTx(ctx, false, func(tx *sql.Tx) error {
+		return SetPrintQuota(ctx, tx, PrintQuota{TargetType: QuotaTargetUser, Target: strconv.FormatInt(user.ID, 10), ColorPages: 0, MonoPages: QuotaUnlimited}, 0, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status(); st.Remaining(true) != 2 || st.Remaining(false) != QuotaUnlimited {
+		t.Fatalf("user quota status = %+v", st)
+	}
+
+	var adjustments []QuotaAdjustment
+	if err := s.WithTx(ctx, true, func(tx *sql.Tx) error {
+		adjustments, err = ListQuotaAdjustments(ctx, tx, QuotaAdjustmentFilter{UserID: user.ID})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// 用户规则 + 追加；角色规则不属于该用户。
+	if len(adjustments) != 2 || adjustments[0].Kind != QuotaAdjustSet || adjustments[1].Kind != QuotaAdjustGrant {
+		t.Errorf("adjustments = %+v", adjustments)
+	}
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PrintSchedule 是一条待提交的定时打印。PrintPath 为 uploads 下处理好的待打印
+// 文件（已加水印、缩放、重排），Options 为最终 ipp.PrintJobOptions 的 JSON。
+type PrintSchedule struct {
+	PrintJobID int64
+	RunAt      string // RFC3339 UTC
+	PrintPath  string
+	Mime       string
+	Options    string
+	JobName    string
+}
+
+func InsertPrintSchedule(ctx context.Context, tx *sql.Tx, s *PrintSchedule) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO print_schedules (
+		print_job_id, run_at, print_path, mime, options, job_name
+	) VALUES (?, ?, ?, ?, ?, ?)`, s.PrintJobID, s.RunAt, s.PrintPath, s.Mime, s.Options, s.JobName)
+	return err
+}
+
+func GetPrintSchedule(ctx context.Context, tx *sql.Tx, printJobID int64) (PrintSchedule, error) {
+	var s PrintSchedule
+	err := tx.QueryRowContext(ctx, `SELECT print_job_id, run_at, print_path, mime, options, job_name
+		FROM print_schedules WHERE print_job_id = ?`, printJobID).Scan(
+		&s.PrintJobID, &s.RunAt, &s.PrintPath, &s.Mime, &s.Options, &s.JobName)
+	return s, err
+}
+
+// ListDuePrintSchedules 返回 run_at 不晚于 now 的定时打印，按预定时间升序。
+func ListDuePrintSchedules(ctx context.Context, tx *sql.Tx, now string) ([]PrintSchedule, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT print_job_id, run_at, print_path, mime, options, job_name
+		FROM print_schedules WHERE run_at <= ? ORDER BY run_at, print_job_id`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PrintSchedule
+	for rows.Next() {
+		var s PrintSchedule
+		if err := rows.Scan(&s.PrintJobID, &s.RunAt, &s.PrintPath, &s.Mime, &s.Options, &s.JobName); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// UpdatePrintSchedule 修改尚未提交的定时打印的时间与选项，同时更新记录上的
+// scheduled_at、份数与计费面数（配额用量随之调整）。记录已不是 scheduled 时返回 sql.ErrNoRows。
+func UpdatePrintSchedule(ctx context.Context, tx *sql.Tx, printJobID int64, runAt, options string, copies, impressions int) error {
+	res, err := tx.ExecContext(ctx, `UPDATE print_jobs SET scheduled_at = ?, copies = ?, impressions = ?
+		WHERE id = ? AND status = ?`, runAt, copies, impressions, printJobID, PrintStatusScheduled)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE print_schedules SET run_at = ?, options = ? WHERE print_job_id = ?`,
+		runAt, options, printJobID); err != nil {
+		return err
+	}
+	return setQuotaUsage(ctx, tx, printJobID, int64(impressions))
+}
+
+// ClaimPrintSchedule 把定时打印从 scheduled 推进到 status（queued 表示交给调度器提交，
+// cancelled 表示用户取消）并删除调度行。返回 false 表示已被别处认领（调度器与取消并发）。
+func ClaimPrintSchedule(ctx context.Context, tx *sql.Tx, printJobID int64, status string) (bool, error) {
+	res, err := tx.ExecContext(ctx, `UPDATE print_jobs SET status = ? WHERE id = ? AND status = ?`,
+		status, printJobID, PrintStatusScheduled)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM print_schedules WHERE print_job_id = ?`, printJobID); err != nil {
+		return false, err
+	}
+	if err := refundIfTerminal(ctx, tx, printJobID, status); err != nil {
+		return false, err
+	}
+	return true, nil
+}
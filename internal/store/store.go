@@ -18,6 +18,22 @@ const (
 const (
 	SettingRetentionDays = "retention_days"
 	SettingSaveHistory   = "save_history"
+	// SettingHoldTimeoutHours 是安全打印挂起作业的最长等待时长（小时），
+	// 超时未释放由维护任务自动取消；0 表示不自动取消。
+	SettingHoldTimeoutHours = "hold_timeout_hours"
+	// SettingBillingEnabled 为 1 时打印前按价目表计费并从用户余额扣款。
+	SettingBillingEnabled = "billing_enabled"
+	// SettingQuotaPeriod 是页数配额的重置周期（month / week），空表示不启用配额。
+	SettingQuotaPeriod = "quota_period"
+	// SettingPrinterRegistry 决定是否只允许使用已登记并授权的打印机，取值见 PrinterRegistryAuto 等。
+	SettingPrinterRegistry = "printer_registry"
+)
+
+// 打印机登记限制（settings.printer_registry）的取值。启用时未登记的 URI 一律拒绝。
+const (
+	PrinterRegistryAuto = "auto" // 登记了至少一台打印机即启用（默认）
+	PrinterRegistryOn   = "on"   // 始终启用，一台都没登记时谁也不能打印
+	PrinterRegistryOff  = "off"  // 不限制，CUPS 上的打印机都可用
 )
 
 type Store struct {
@@ -88,6 +104,7 @@ func (s *Store) migrate(ctx context.Context) error {
 			contact_name TEXT,
 			phone TEXT,
 			email TEXT,
+			balance_cents INTEGER NOT NULL DEFAULT 0,
 			created_at TEXT NOT NULL,
 			updated_at TEXT NOT NULL
 		)`,
@@ -119,9 +136,163 @@ func (s *Store) migrate(ctx context.Context) error {
 			number_up INTEGER NOT NULL DEFAULT 1,
 			number_up_layout TEXT NOT NULL DEFAULT 'lrtb',
 			page_border TEXT NOT NULL DEFAULT 'none',
+			job_state TEXT NOT NULL DEFAULT '',
+			job_state_reasons TEXT NOT NULL DEFAULT '',
+			impressions_completed INTEGER NOT NULL DEFAULT 0,
+			completed_at TEXT NOT NULL DEFAULT '',
+			hold_release INTEGER NOT NULL DEFAULT 0,
+			release_pin_hash TEXT NOT NULL DEFAULT '',
+			released_at TEXT NOT NULL DEFAULT '',
+			finishings TEXT NOT NULL DEFAULT '',
+			output_bin TEXT NOT NULL DEFAULT '',
+			job_sheets TEXT NOT NULL DEFAULT '',
+			quality TEXT NOT NULL DEFAULT '',
+			resolution TEXT NOT NULL DEFAULT '',
+			scheduled_at TEXT NOT NULL DEFAULT '',
+			cost_cents INTEGER NOT NULL DEFAULT 0,
+			impressions INTEGER NOT NULL DEFAULT 0,
+			submitted_at TEXT NOT NULL DEFAULT '',
 			created_at TEXT NOT NULL,
 			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
 		)`,
+		// 多文档作业（Create-Job + Send-Document）的成员文档，一条打印记录对应多行。
+		`CREATE TABLE IF NOT EXISTS print_job_documents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			print_job_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			filename TEXT NOT NULL,
+			stored_path TEXT NOT NULL,
+			pages INTEGER NOT NULL,
+			FOREIGN KEY(print_job_id) REFERENCES print_jobs(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_print_job_documents_job ON print_job_documents(print_job_id, position)`,
+		// 定时打印：到点前保存处理好的待打印文件与最终 IPP 选项，提交后删除。
+		`CREATE TABLE IF NOT EXISTS print_schedules (
+			print_job_id INTEGER PRIMARY KEY,
+			run_at TEXT NOT NULL,
+			print_path TEXT NOT NULL,
+			mime TEXT NOT NULL,
+			options TEXT NOT NULL,
+			job_name TEXT NOT NULL,
+			FOREIGN KEY(print_job_id) REFERENCES print_jobs(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_print_schedules_run_at ON print_schedules(run_at)`,
+		// 计费价目：按纸张尺寸/类型、彩色、双面匹配每面单价，空字符串表示任意。
+		`CREATE TABLE IF NOT EXISTS print_prices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			paper_size TEXT NOT NULL DEFAULT '',
+			paper_type TEXT NOT NULL DEFAULT '',
+			is_color INTEGER NOT NULL DEFAULT 0,
+			is_duplex INTEGER NOT NULL DEFAULT 0,
+			price_cents INTEGER NOT NULL,
+			UNIQUE(paper_size, paper_type, is_color, is_duplex)
+		)`,
+		// 余额流水：充值、扣费、退款，amount_cents 带符号。
+		`CREATE TABLE IF NOT EXISTS balance_ledger (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			amount_cents INTEGER NOT NULL,
+			balance_after INTEGER NOT NULL,
+			print_job_id INTEGER,
+			operator_id INTEGER,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY(print_job_id) REFERENCES print_jobs(id) ON DELETE SET NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_balance_ledger_user ON balance_ledger(user_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_balance_ledger_job ON balance_ledger(print_job_id)`,
+		// 页数配额规则：按角色或用户设定每周期彩色/黑白面数，-1 表示不限。
+		`CREATE TABLE IF NOT EXISTS print_quotas (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_type TEXT NOT NULL,
+			target TEXT NOT NULL,
+			color_pages INTEGER NOT NULL,
+			mono_pages INTEGER NOT NULL,
+			UNIQUE(target_type, target)
+		)`,
+		// 配额调整流水：管理员对规则的增删改与给用户的一次性追加。
+		`CREATE TABLE IF NOT EXISTS quota_adjustments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			user_id INTEGER,
+			target_type TEXT NOT NULL DEFAULT '',
+			target TEXT NOT NULL DEFAULT '',
+			color_pages INTEGER NOT NULL DEFAULT 0,
+			mono_pages INTEGER NOT NULL DEFAULT 0,
+			period_start TEXT NOT NULL DEFAULT '',
+			operator_id INTEGER,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_quota_adjustments_user ON quota_adjustments(user_id, period_start)`,
+		// 配额用量流水：打印记录计入的面数，作业失败/取消或改份数时记差额冲回。
+		// 不随打印记录删除，保留期限清理与清空历史不会退回已用配额。
+		`CREATE TABLE IF NOT EXISTS quota_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			print_job_id INTEGER NOT NULL,
+			is_color INTEGER NOT NULL,
+			impressions INTEGER NOT NULL,
+			used_at TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_quota_usage_user ON quota_usage(user_id, used_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_quota_usage_job ON quota_usage(print_job_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_print_jobs_user_created ON print_jobs(user_id, created_at)`,
+		// 打印策略：按用户/角色/全体与打印机匹配，强制双面、黑白、水印，限制份数与页数。
+		`CREATE TABLE IF NOT EXISTS print_policies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL DEFAULT '',
+			target_type TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			printer_uri TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			force_duplex INTEGER NOT NULL DEFAULT 0,
+			force_mono INTEGER NOT NULL DEFAULT 0,
+			max_copies INTEGER NOT NULL DEFAULT 0,
+			max_pages INTEGER NOT NULL DEFAULT 0,
+			require_watermark INTEGER NOT NULL DEFAULT 0,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at TEXT NOT NULL
+		)`,
+		// 打印机登记：管理员登记后才允许使用，按 URI 匹配 CUPS 上的队列。
+		`CREATE TABLE IF NOT EXISTS printers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			uri TEXT NOT NULL UNIQUE,
+			display_name TEXT NOT NULL DEFAULT '',
+			location TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			hidden INTEGER NOT NULL DEFAULT 0,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at TEXT NOT NULL
+		)`,
+		// 用户组（部门、班级）：可作为配额、打印策略、打印机授权的对象与报表筛选条件。
+		`CREATE TABLE IF NOT EXISTS user_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_group_members (
+			group_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY(group_id, user_id),
+			FOREIGN KEY(group_id) REFERENCES user_groups(id) ON DELETE CASCADE,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_group_members_user ON user_group_members(user_id)`,
+		// 打印机授权：没有任何授权行的打印机对所有用户开放。
+		`CREATE TABLE IF NOT EXISTS printer_access (
+			printer_id INTEGER NOT NULL,
+			subject_type TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			PRIMARY KEY(printer_id, subject_type, subject),
+			FOREIGN KEY(printer_id) REFERENCES printers(id) ON DELETE CASCADE
+		)`,
 	}
 
 	for _, stmt := range stmts {
@@ -132,6 +303,9 @@ func (s *Store) migrate(ctx context.Context) error {
 	if err := addColumnIfMissing(ctx, s.DB, "users", "protected INTEGER NOT NULL DEFAULT 0"); err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
+	if err := addColumnIfMissing(ctx, s.DB, "users", "balance_cents INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
 	if err := addColumnIfMissing(ctx, s.DB, "print_jobs", "is_duplex INTEGER NOT NULL DEFAULT 0"); err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
@@ -154,12 +328,45 @@ func (s *Store) migrate(ctx context.Context) error {
 		"number_up INTEGER NOT NULL DEFAULT 1",
 		"number_up_layout TEXT NOT NULL DEFAULT 'lrtb'",
 		"page_border TEXT NOT NULL DEFAULT 'none'",
+		// CUPS 作业实时状态（作业状态跟踪器回填）。
+		"job_state TEXT NOT NULL DEFAULT ''",
+		"job_state_reasons TEXT NOT NULL DEFAULT ''",
+		"impressions_completed INTEGER NOT NULL DEFAULT 0",
+		"completed_at TEXT NOT NULL DEFAULT ''",
+		// 安全打印（挂起释放）。
+		"hold_release INTEGER NOT NULL DEFAULT 0",
+		"release_pin_hash TEXT NOT NULL DEFAULT ''",
+		"released_at TEXT NOT NULL DEFAULT ''",
+		// 装订/出纸口/横幅页。
+		"finishings TEXT NOT NULL DEFAULT ''",
+		"output_bin TEXT NOT NULL DEFAULT ''",
+		"job_sheets TEXT NOT NULL DEFAULT ''",
+		// 打印质量与分辨率。
+		"quality TEXT NOT NULL DEFAULT ''",
+		"resolution TEXT NOT NULL DEFAULT ''",
+		// 定时打印的预定时间，提交后保留作为历史。
+		"scheduled_at TEXT NOT NULL DEFAULT ''",
+		// 计费：本次作业实扣金额（分），退款后保留原值作历史。
+		"cost_cents INTEGER NOT NULL DEFAULT 0",
+		// 配额：本次作业的计费面数（⌈页数 ÷ N-up⌉ × 份数），按周期汇总即为已用配额。
+		"impressions INTEGER NOT NULL DEFAULT 0",
+		// 提交到 CUPS 的时间；定时打印的 created_at 是预约时间，挂起超时要从提交算起。
+		"submitted_at TEXT NOT NULL DEFAULT ''",
 	}
 	for _, col := range printJobOptionCols {
 		if err := addColumnIfMissing(ctx, s.DB, "print_jobs", col); err != nil {
 			return fmt.Errorf("migrate: %w", err)
 		}
 	}
+	// 旧库热升级：已有记录的用量补记进 quota_usage，已有流水的记录不重复补。
+	if _, err := s.DB.ExecContext(ctx, `INSERT INTO quota_usage (user_id, print_job_id, is_color, impressions, used_at, created_at)
+		SELECT p.user_id, p.id, p.is_color, p.impressions, p.created_at, p.created_at FROM print_jobs p
+		WHERE p.impressions > 0 AND p.status NOT IN (?, ?)
+			AND NOT EXISTS (SELECT 1 FROM quota_usage u WHERE u.print_job_id = p.id)`,
+		PrintStatusFailed, PrintStatusCancelled,
+	); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
 
 	if _, err := s.DB.ExecContext(ctx, `INSERT OR IGNORE INTO settings(key, value) VALUES (?, ?)`,
 		SettingRetentionDays, "0",
@@ -171,6 +378,26 @@ func (s *Store) migrate(ctx context.Context) error {
 	); err != nil {
 		return fmt.Errorf("seed settings: %w", err)
 	}
+	if _, err := s.DB.ExecContext(ctx, `INSERT OR IGNORE INTO settings(key, value) VALUES (?, ?)`,
+		SettingHoldTimeoutHours, "24",
+	); err != nil {
+		return fmt.Errorf("seed settings: %w", err)
+	}
+	if _, err := s.DB.ExecContext(ctx, `INSERT OR IGNORE INTO settings(key, value) VALUES (?, ?)`,
+		SettingBillingEnabled, "0",
+	); err != nil {
+		return fmt.Errorf("seed settings: %w", err)
+	}
+	if _, err := s.DB.ExecContext(ctx, `INSERT OR IGNORE INTO settings(key, value) VALUES (?, ?)`,
+		SettingQuotaPeriod, "",
+	); err != nil {
+		return fmt.Errorf("seed settings: %w", err)
+	}
+	if _, err := s.DB.ExecContext(ctx, `INSERT OR IGNORE INTO settings(key, value) VALUES (?, ?)`,
+		SettingPrinterRegistry, PrinterRegistryAuto,
+	); err != nil {
+		return fmt.Errorf("seed settings: %w", err)
+	}
 
 	return nil
 }